@@ -0,0 +1,106 @@
+// Command mapgen 按种子程序化生成前哨场景并写入数据库，-dry-run 时只输出预览。
+//
+//	go run ./cmd/mapgen -scene outpost_42 -seed 42 -width 96 -height 64 -buildings central_dome=1,habitat_block=3
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"eeo/backend/internal/config"
+	gameservice "eeo/backend/internal/service/game"
+)
+
+func main() {
+	var (
+		baseScene = flag.String("base", "mars_outpost_min", "读取模板时加载的现有场景")
+		sceneID   = flag.String("scene", "", "新场景 ID（必填）")
+		name      = flag.String("name", "", "新场景名称，缺省与 ID 相同")
+		seed      = flag.Int64("seed", 1, "随机种子")
+		width     = flag.Int("width", 64, "地图宽度（格）")
+		height    = flag.Int("height", 64, "地图高度（格）")
+		tileSize  = flag.Int("tile-size", 0, "格子像素尺寸，缺省 32")
+		buildings = flag.String("buildings", "", "建筑组合，如 central_dome=1,habitat_block=3；缺省使用默认开局")
+		agents    = flag.String("agents", "", "Agent 组合，如 ares=2；缺省每个模板一个")
+		dryRun    = flag.Bool("dry-run", false, "只输出生成结果，不写库")
+	)
+	flag.Parse()
+
+	buildingMix, err := parseTemplateCounts(*buildings)
+	if err != nil {
+		log.Fatalf("parse -buildings failed: %v", err)
+	}
+	agentMix, err := parseTemplateCounts(*agents)
+	if err != nil {
+		log.Fatalf("parse -agents failed: %v", err)
+	}
+
+	cfg := config.Load()
+	db, err := sql.Open("postgres", cfg.Database.URL)
+	if err != nil {
+		log.Fatalf("open database failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("close database failed: %v", err)
+		}
+	}()
+
+	gameSvc, err := gameservice.New(db, *baseScene)
+	if err != nil {
+		log.Fatalf("load scene failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := gameSvc.GenerateScene(ctx, gameservice.GenerateSceneInput{
+		SceneID:   *sceneID,
+		Name:      *name,
+		Seed:      *seed,
+		Width:     *width,
+		Height:    *height,
+		TileSize:  *tileSize,
+		Buildings: buildingMix,
+		Agents:    agentMix,
+		DryRun:    *dryRun,
+	})
+	if err != nil {
+		log.Fatalf("generate scene failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatalf("encode result failed: %v", err)
+	}
+}
+
+// parseTemplateCounts 解析 id=count 逗号分隔列表，保留输入顺序以保证生成结果可复现。
+func parseTemplateCounts(value string) ([]gameservice.TemplateCount, error) {
+	var counts []gameservice.TemplateCount
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, raw, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be formatted as id=count", item)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("%q has an invalid count", item)
+		}
+		counts = append(counts, gameservice.TemplateCount{TemplateID: strings.TrimSpace(id), Count: count})
+	}
+	return counts, nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"eeo/backend/internal/config"
@@ -47,7 +50,28 @@ func main() {
 
 	srv := server.New(cfg, gameSvc, actionSvc)
 
-	if err := srv.Run(); err != nil {
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- srv.Run()
+	}()
+
+	select {
+	case err := <-runErr:
+		// Run 只在 Shutdown 后返回 nil，此处一定是监听失败。
 		log.Fatalf("server stopped: %v", err)
+	case <-signalCtx.Done():
+		log.Printf("shutting down")
+	}
+	// 再次收到信号时按默认行为立即退出。
+	stop()
+
+	// 依次关闭场景流、HTTP 服务与模拟，数据库连接池由 defer 最后关闭。
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown failed: %v", err)
 	}
 }
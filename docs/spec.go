@@ -27,10 +27,154 @@ const specJSON = `{
       "get": {
         "tags": ["Game"],
         "summary": "订阅火星场景 WebSocket 流",
-        "produces": ["application/json"],
+        "description": "连接后为每个订阅主题先下发 snapshot，之后主题视图每次变化下发 patch（RFC 6902 JSON Patch，作用于该主题上一状态）；场景的任何修改（/v1/game 与 /v1/system 接口、WebSocket 命令、模拟 tick）都会立即推送；服务端只计算有订阅者的主题。消息格式见 server.SceneStreamMessage：seq 为场景序号，场景每次变化加 1；prev 为该主题上一次变化的 seq，大于客户端该主题最后收到的 seq 时说明有遗漏，应发送 {\"type\":\"resync\",\"topic\":\"energy\"}（省略 topic 时重发全部主题），服务端回复当前 snapshot。补丁不比视图小时直接下发 snapshot。\n\n主题：scene（完整场景，快照在 scene 字段）、energy（game.EnergySummary）、building:<id>（单个建筑，不存在时为 null）、agent:<id>（单个 Agent）、viewport:x,y,w,h（server.ViewportView）、events（只下发 event 消息，events 为移动事件与任务状态变化）、presence（快照 data 为 server.StreamPresence 数组，之后连接加入、离开、订阅或操控的 Agent 变化时下发 event 消息，kind 为 presence，action 为 join/leave/update；presence 事件不参与回放，重连时改发快照）。非 scene 主题的快照在 data 字段。每个连接最多 32 个主题。\n\n客户端读取过慢、发送队列写满时，服务端先下发 {\"type\":\"close\",\"reason\":\"slow-consumer\",\"error\":{\"code\":1008}}，再以状态码 1008、原因 slow-consumer 关闭连接；客户端可带 since 重连。服务停机时同样先下发 close 消息（reason 为 server-restarting，code 为 1001），再以状态码 1001 关闭连接，停机期间新连接返回 503，客户端应按 Retry-After 稍后带 since 重连。\n\n客户端可在同一连接上发送 {\"type\":\"command\",\"id\":\"1\",\"command\":\"move-agent\",\"params\":{...}}，服务端回复 ack（result 为对应 REST 接口的响应体）或 error（code 为对应 REST 接口的状态码），并带回相同的 id；命令造成的场景变化先于 ack 下发。命令：move-agent（server.MoveAgentCommand）、set-building-energy（server.SetBuildingEnergyCommand）、run-behavior（server.RunBehaviorCommand）、subscribe/unsubscribe（server.SubscribeCommand）。\n\n编码：握手时以 Sec-WebSocket-Protocol 协商，scene.json（缺省）为 JSON 文本帧，scene.msgpack 为 MessagePack 二进制帧。MessagePack 模式下消息语义与 JSON 相同，但结构体按字段顺序编码为数组、不携带字段名，补丁路径中的结构体字段也以数组下标表示（如 agent 位置 x 为 /5/0/3/0）；字段顺序见 /game/scene/stream/schema。客户端消息可用数组或以字段名为键的映射编码。\n\n限频：rate 参数限制每秒推送次数，两次推送之间的变化合并为一条补丁（prev 为上次推送的 seq），events 主题的事件在下次推送时一并下发；命令造成的变化也按限频推送，可能晚于 ack。连接数（WebSocket 与 SSE 合计）已满时返回 503 并带 Retry-After；rate 不高于 1 的旁观客户端另有名额上限，其余名额留给交互客户端。",
+        "produces": ["application/json", "application/x-msgpack"],
+        "parameters": [
+          {
+            "name": "topic",
+            "in": "query",
+            "type": "array",
+            "items": {"type": "string"},
+            "collectionFormat": "multi",
+            "description": "初始订阅主题，可重复；缺省为 scene"
+          },
+          {
+            "name": "clientId",
+            "in": "query",
+            "type": "string",
+            "description": "在线状态中的客户端 ID，1-64 个字母、数字或 ._:- 字符；缺省时随机生成"
+          },
+          {
+            "name": "role",
+            "in": "query",
+            "type": "string",
+            "description": "在线状态中的客户端角色，格式同 clientId；缺省为 viewer"
+          },
+          {
+            "name": "rate",
+            "in": "query",
+            "type": "number",
+            "description": "每秒最多推送次数（如 10 或 0.2），间隔按 50ms 取整，最低每分钟一次；缺省为场景每次变化立即推送"
+          },
+          {
+            "name": "Sec-WebSocket-Protocol",
+            "in": "header",
+            "type": "string",
+            "description": "scene.json 或 scene.msgpack，可列出多个，服务端选择第一个支持的"
+          },
+          {
+            "name": "since",
+            "in": "query",
+            "type": "integer",
+            "description": "重连时传入最后收到的 seq：回放缓冲（最近 256 帧）仍覆盖时依次下发其后的消息，视图在此之后才建立的主题改发 snapshot；否则先下发 {\"type\":\"resync\",\"reason\":\"since-expired\"} 再下发各主题 snapshot"
+          }
+        ],
         "responses": {
           "101": {
-            "description": "WebSocket Upgrade"
+            "description": "WebSocket Upgrade",
+            "schema": {"$ref": "#/definitions/server.SceneStreamMessage"}
+          },
+          "400": {
+            "description": "参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "503": {
+            "description": "连接数已满，Retry-After 为建议的重试秒数",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/stream/schema": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "获取场景流 MessagePack 编码的字段顺序",
+        "description": "types 为各结构体按数组下标排列的字段（消息信封为 message 指定的类型），topics 为各主题快照的数据类型。类型描述中 [] 表示数组，? 表示可为 nil，timestamp 为 MessagePack 时间戳扩展类型。",
+        "produces": ["application/json"],
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {"$ref": "#/definitions/server.StreamSchema"}
+          }
+        }
+      }
+    },
+    "/game/scene/presence": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "获取场景流在线客户端",
+        "description": "返回当前 WebSocket 与 SSE 连接的客户端 ID、角色、订阅主题、通过命令操控过的 Agent、连接时间与推送延迟（lag 为最新场景序号与已写出消息覆盖到的序号之差，queued 为发送队列中的消息数）。",
+        "produces": ["application/json"],
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {"$ref": "#/definitions/server.StreamPresenceResponse"}
+          },
+          "503": {
+            "description": "场景流不可用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/events": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "以 Server-Sent Events 订阅火星场景流",
+        "description": "WebSocket 不可用时的替代方式，下发与 /game/scene/stream 相同的消息（server.SceneStreamMessage），每条消息为一个 data 事件。每批消息之后下发只含 id 的空事件，id 为场景序号；EventSource 重连时自动带上 Last-Event-ID，服务端按 since 相同的规则回放或要求重新同步。SSE 为单向流，不支持 command 与 resync，命令请使用对应 REST 接口。",
+        "produces": ["text/event-stream"],
+        "parameters": [
+          {
+            "name": "topic",
+            "in": "query",
+            "type": "array",
+            "items": {"type": "string"},
+            "collectionFormat": "multi",
+            "description": "订阅主题，可重复；缺省为 scene"
+          },
+          {
+            "name": "clientId",
+            "in": "query",
+            "type": "string",
+            "description": "在线状态中的客户端 ID，1-64 个字母、数字或 ._:- 字符；缺省时随机生成"
+          },
+          {
+            "name": "role",
+            "in": "query",
+            "type": "string",
+            "description": "在线状态中的客户端角色，格式同 clientId；缺省为 viewer"
+          },
+          {
+            "name": "rate",
+            "in": "query",
+            "type": "number",
+            "description": "每秒最多推送次数（如 10 或 0.2），间隔按 50ms 取整，最低每分钟一次；缺省为场景每次变化立即推送"
+          },
+          {
+            "name": "since",
+            "in": "query",
+            "type": "integer",
+            "description": "续传起点，Last-Event-ID 请求头优先"
+          },
+          {
+            "name": "Last-Event-ID",
+            "in": "header",
+            "type": "string",
+            "description": "最后收到的事件 id"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "事件流",
+            "schema": {"$ref": "#/definitions/server.SceneStreamMessage"}
+          },
+          "400": {
+            "description": "参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "503": {
+            "description": "连接数已满，Retry-After 为建议的重试秒数",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
@@ -67,15 +211,40 @@ const specJSON = `{
         }
       }
     },
-    "/game/scene/agents/{agentID}/position": {
-      "put": {
+    "/game/scene/buildings/{buildingID}/upgrades": {
+      "get": {
         "tags": ["Game"],
-        "summary": "更新 Agent 运行时坐标",
+        "summary": "查询建筑可用升级路径与升级历史",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "buildingID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "升级信息",
+            "schema": {"$ref": "#/definitions/game.BuildingUpgradeOverview"}
+          },
+          "404": {
+            "description": "建筑不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/buildings/{buildingID}/upgrade": {
+      "post": {
+        "tags": ["Game"],
+        "summary": "将建筑升级为目标模板（保留建筑 ID 与历史）",
         "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "agentID",
+            "name": "buildingID",
             "in": "path",
             "required": true,
             "type": "string"
@@ -84,69 +253,88 @@ const specJSON = `{
             "name": "payload",
             "in": "body",
             "required": true,
-            "schema": {"$ref": "#/definitions/server.AgentPositionUpdateRequest"}
+            "schema": {"$ref": "#/definitions/server.BuildingUpgradeRequest"}
           }
         ],
         "responses": {
           "200": {
-            "description": "更新后的 Agent 数据",
-            "schema": {"$ref": "#/definitions/game.SceneAgent"}
+            "description": "升级结果",
+            "schema": {"$ref": "#/definitions/game.UpgradeBuildingResult"}
           },
           "400": {
-            "description": "请求参数错误",
+            "description": "升级路径不存在或新占地不合法",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "储能不足以支付升级成本，或目标模板已弃用、地形不满足要求",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
     },
-    "/game/scene/agents/{agentID}/behaviors/maintain-energy": {
+    "/game/scene/agents": {
       "post": {
         "tags": ["Game"],
-        "summary": "保持电量不减少（自动建造太阳能塔）",
+        "summary": "从 Agent 模板生成 Agent",
+        "description": "使用模板默认坐标与颜色生成 Agent，坐标被占用时放在最近的空闲格，并推送到场景流订阅者。",
+        "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "agentID",
-            "in": "path",
+            "name": "payload",
+            "in": "body",
             "required": true,
-            "type": "string"
+            "schema": {"$ref": "#/definitions/server.SpawnAgentRequest"}
           }
         ],
         "responses": {
-          "200": {
-            "description": "操作结果",
-            "schema": {"$ref": "#/definitions/server.MaintainEnergyResponse"}
+          "201": {
+            "description": "生成的 Agent 与最新场景",
+            "schema": {"$ref": "#/definitions/game.SpawnSceneAgentResult"}
           },
           "400": {
-            "description": "请求参数错误",
+            "description": "模板不存在或请求参数错误",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           },
           "409": {
-            "description": "缺少可用空间或资源冲突",
-            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
-          },
-          "424": {
-            "description": "缺少太阳能塔模板",
+            "description": "模板已弃用或没有空闲格",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
     },
-    "/system/scene": {
+    "/game/scene/jobs": {
       "get": {
-        "tags": ["System"],
-        "summary": "获取 system_* 场景快照",
+        "tags": ["Game"],
+        "summary": "列出任务队列",
         "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "status",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "enum": ["queued", "assigned", "in-progress", "done", "failed"]
+          }
+        ],
         "responses": {
           "200": {
             "description": "成功",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+            "schema": {
+              "type": "array",
+              "items": {"$ref": "#/definitions/game.Job"}
+            }
+          },
+          "400": {
+            "description": "状态参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       },
-      "put": {
-        "tags": ["System"],
-        "summary": "更新 system_* 场景配置",
+      "post": {
+        "tags": ["Game"],
+        "summary": "新建任务",
+        "description": "任务进入共享队列，每个 tick 分派给距离最近、空闲且模板技能包含该任务类型的 Agent。",
         "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
@@ -154,62 +342,88 @@ const specJSON = `{
             "name": "payload",
             "in": "body",
             "required": true,
-            "schema": {"$ref": "#/definitions/server.SystemSceneUpdateRequest"}
+            "schema": {"$ref": "#/definitions/server.JobRequest"}
           }
         ],
         "responses": {
-          "200": {
-            "description": "更新后的快照",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          "201": {
+            "description": "创建的任务",
+            "schema": {"$ref": "#/definitions/game.Job"}
           },
           "400": {
             "description": "请求参数错误",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "建筑模板已弃用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
     },
-    "/system/templates/buildings/{id}": {
-      "put": {
-        "tags": ["System"],
-        "summary": "更新系统建筑模板",
-        "consumes": ["application/json"],
+    "/game/scene/jobs/{jobID}": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "获取任务",
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "id",
+            "name": "jobID",
             "in": "path",
             "required": true,
-            "type": "string"
+            "type": "integer"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {"$ref": "#/definitions/game.Job"}
           },
+          "404": {
+            "description": "任务不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/jobs/{jobID}/cancel": {
+      "post": {
+        "tags": ["Game"],
+        "summary": "取消任务（标记为 failed 并释放 Agent，Agent 停在当前位置）",
+        "produces": ["application/json"],
+        "parameters": [
           {
-            "name": "payload",
-            "in": "body",
+            "name": "jobID",
+            "in": "path",
             "required": true,
-            "schema": {"$ref": "#/definitions/server.TemplateBuildingRequest"}
+            "type": "integer"
           }
         ],
         "responses": {
           "200": {
-            "description": "更新后的快照",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+            "description": "取消后的任务",
+            "schema": {"$ref": "#/definitions/game.Job"}
           },
-          "400": {
-            "description": "请求参数错误",
+          "404": {
+            "description": "任务不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "任务已结束",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
     },
-    "/system/templates/agents/{id}": {
+    "/game/scene/agents/{agentID}/position": {
       "put": {
-        "tags": ["System"],
-        "summary": "更新系统 Agent 模板",
+        "tags": ["Game"],
+        "summary": "更新 Agent 运行时坐标",
         "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "id",
+            "name": "agentID",
             "in": "path",
             "required": true,
             "type": "string"
@@ -218,13 +432,13 @@ const specJSON = `{
             "name": "payload",
             "in": "body",
             "required": true,
-            "schema": {"$ref": "#/definitions/server.TemplateAgentRequest"}
+            "schema": {"$ref": "#/definitions/server.AgentPositionUpdateRequest"}
           }
         ],
         "responses": {
           "200": {
-            "description": "更新后的快照",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+            "description": "更新后的 Agent 数据",
+            "schema": {"$ref": "#/definitions/game.SceneAgent"}
           },
           "400": {
             "description": "请求参数错误",
@@ -233,15 +447,16 @@ const specJSON = `{
         }
       }
     },
-    "/system/scene/buildings/{id}": {
+    "/game/scene/agents/{agentID}/path": {
       "put": {
-        "tags": ["System"],
-        "summary": "更新场景建筑实例",
+        "tags": ["Game"],
+        "summary": "为 Agent 指定移动路径",
+        "description": "提供 path 时路径需从 Agent 当前格开始逐格相邻且不穿过建筑；只提供 destination 时自动寻路。Agent 按模板速度在每个 tick 中沿路径移动，到达后在场景流中产生 arrived 事件。路径上新放置建筑时路径被取消并产生 path-cancelled 事件。",
         "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "id",
+            "name": "agentID",
             "in": "path",
             "required": true,
             "type": "string"
@@ -250,27 +465,35 @@ const specJSON = `{
             "name": "payload",
             "in": "body",
             "required": true,
-            "schema": {"$ref": "#/definitions/server.SceneBuildingRequest"}
+            "schema": {"$ref": "#/definitions/server.AgentPathRequest"}
           }
         ],
         "responses": {
           "200": {
-            "description": "更新后的快照",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+            "description": "带有新路径的 Agent 数据",
+            "schema": {"$ref": "#/definitions/game.SceneAgent"}
           },
           "400": {
-            "description": "请求参数错误",
+            "description": "路径不连续、越界或穿过建筑",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "404": {
+            "description": "Agent 不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "destination 不可达",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       },
       "delete": {
-        "tags": ["System"],
-        "summary": "删除场景建筑实例",
+        "tags": ["Game"],
+        "summary": "取消 Agent 的移动路径",
         "produces": ["application/json"],
         "parameters": [
           {
-            "name": "id",
+            "name": "agentID",
             "in": "path",
             "required": true,
             "type": "string"
@@ -278,28 +501,632 @@ const specJSON = `{
         ],
         "responses": {
           "200": {
-            "description": "更新后的快照",
-            "schema": {"$ref": "#/definitions/game.Snapshot"}
+            "description": "停在当前位置的 Agent 数据",
+            "schema": {"$ref": "#/definitions/game.SceneAgent"}
           },
-          "400": {
-            "description": "请求参数错误",
+          "404": {
+            "description": "Agent 不存在",
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
     },
-    "/system/scene/buildings/preview": {
+    "/game/scene/path": {
       "get": {
-        "tags": ["System"],
-        "summary": "预览场景建筑列表",
+        "tags": ["Game"],
+        "summary": "计算两个格子之间的最短路径",
+        "description": "基于建筑占地的 A* 寻路，结果按建筑布局缓存，布局变化后自动失效。",
         "produces": ["application/json"],
         "parameters": [
-          {
-            "name": "sceneId",
-            "in": "query",
-            "required": false,
-            "type": "string",
-            "description": "可选的场景 ID，缺省为当前运行中的场景"
+          {"name": "from", "in": "query", "required": true, "type": "string", "description": "起点，格式 x,y"},
+          {"name": "to", "in": "query", "required": true, "type": "string", "description": "终点，格式 x,y"},
+          {"name": "diagonal", "in": "query", "required": false, "type": "boolean", "default": false, "description": "是否允许不切角的斜向移动"}
+        ],
+        "responses": {
+          "200": {
+            "description": "寻路结果",
+            "schema": {"$ref": "#/definitions/game.PathResult"}
+          },
+          "400": {
+            "description": "坐标格式错误或超出场景",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "终点不可达",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/placement": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "建议建筑模板的放置位置",
+        "description": "枚举所有满足占地、地形与通道要求的位置，按到 Agent 的寻路代价、到耗能建筑的距离和地形代价评分（越低越好），返回互不重叠的前若干个。",
+        "produces": ["application/json"],
+        "parameters": [
+          {"name": "templateId", "in": "query", "required": true, "type": "string", "description": "建筑模板 ID"},
+          {"name": "agentId", "in": "query", "required": false, "type": "string", "description": "只建议该 Agent 可到达的位置"},
+          {"name": "limit", "in": "query", "required": false, "type": "integer", "default": 5, "minimum": 1, "maximum": 50},
+          {"name": "clearance", "in": "query", "required": false, "type": "integer", "default": 1, "minimum": 0, "maximum": 5, "description": "占地四周需保持无建筑的格数"}
+        ],
+        "responses": {
+          "200": {
+            "description": "放置建议",
+            "schema": {"$ref": "#/definitions/game.PlacementPlan"}
+          },
+          "400": {
+            "description": "参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "404": {
+            "description": "模板或 Agent 不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "模板已弃用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/behaviors": {
+      "get": {
+        "tags": ["Game"],
+        "summary": "列出可用的 Agent 行为及其参数结构",
+        "produces": ["application/json"],
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {
+              "type": "array",
+              "items": {"$ref": "#/definitions/game.BehaviorSpec"}
+            }
+          }
+        }
+      }
+    },
+    "/game/scene/agents/{agentID}/behaviors/{behaviorID}": {
+      "post": {
+        "tags": ["Game"],
+        "summary": "执行 Agent 行为",
+        "description": "请求体为行为参数对象，按 /game/behaviors 返回的参数结构校验；响应体由具体行为决定。",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "agentID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "behaviorID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "params",
+            "in": "body",
+            "required": false,
+            "schema": {"type": "object"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "行为执行结果",
+            "schema": {"type": "object"}
+          },
+          "400": {
+            "description": "Agent 不存在或参数不符合参数结构",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "404": {
+            "description": "行为不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/game/scene/agents/{agentID}/behaviors/maintain-energy": {
+      "post": {
+        "tags": ["Game"],
+        "summary": "保持电量不减少（建造生产建筑或关停耗能建筑）",
+        "description": "maintain-energy 行为，由通用行为路由处理。请求体可选，缺省时建造太阳能塔直至净能量流不为负。",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "agentID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "params",
+            "in": "body",
+            "required": false,
+            "schema": {"$ref": "#/definitions/game.MaintainEnergyParams"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "操作结果",
+            "schema": {"$ref": "#/definitions/game.MaintainEnergyResult"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "缺少可用空间、资源冲突或模板已弃用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "424": {
+            "description": "缺少太阳能塔模板",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/scene": {
+      "get": {
+        "tags": ["System"],
+        "summary": "获取 system_* 场景快照",
+        "produces": ["application/json"],
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          }
+        }
+      },
+      "put": {
+        "tags": ["System"],
+        "summary": "更新 system_* 场景配置",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.SystemSceneUpdateRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/scene/terrain": {
+      "get": {
+        "tags": ["System"],
+        "summary": "获取场景地形层",
+        "produces": ["application/json"],
+        "responses": {
+          "200": {
+            "description": "成功，未配置时返回全部为风化层的地形",
+            "schema": {"$ref": "#/definitions/game.TerrainLayer"}
+          }
+        }
+      },
+      "put": {
+        "tags": ["System"],
+        "summary": "以游程整体替换场景地形",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.TerrainUpdateRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "最新地形层",
+            "schema": {"$ref": "#/definitions/game.TerrainLayer"}
+          },
+          "400": {
+            "description": "地形数据不合法",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "patch": {
+        "tags": ["System"],
+        "summary": "按矩形涂刷场景地形",
+        "description": "已有建筑不受影响，地形只约束之后的放置与寻路代价",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.TerrainPaintRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "最新地形层",
+            "schema": {"$ref": "#/definitions/game.TerrainLayer"}
+          },
+          "400": {
+            "description": "地形数据不合法",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/scenes/generate": {
+      "post": {
+        "tags": ["System"],
+        "summary": "按种子程序化生成新场景",
+        "description": "生成地形层、资源点、通过放置校验的初始建筑与起始 Agent；相同参数总是得到相同结果。新场景不会替换当前运行的场景。",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.GenerateSceneRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "dryRun 预览",
+            "schema": {"$ref": "#/definitions/game.GenerateSceneResult"}
+          },
+          "201": {
+            "description": "已写入的新场景",
+            "schema": {"$ref": "#/definitions/game.GenerateSceneResult"}
+          },
+          "400": {
+            "description": "参数错误或地图放不下指定的建筑",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "场景已存在或模板已弃用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/templates/buildings/{id}": {
+      "put": {
+        "tags": ["System"],
+        "summary": "更新系统建筑模板",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.TemplateBuildingRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "delete": {
+        "tags": ["System"],
+        "summary": "删除系统建筑模板（支持 dryRun、reassign、cascade）",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "mode",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "enum": ["block", "reassign", "cascade"],
+            "description": "存在引用实例时的处理方式，默认 block"
+          },
+          {
+            "name": "reassignTo",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "reassign 模式下实例改用的模板 ID，每座实例的占地需满足该模板的地形要求且不与其他建筑重叠"
+          },
+          {
+            "name": "dryRun",
+            "in": "query",
+            "required": false,
+            "type": "boolean",
+            "description": "仅列出依赖实例，不执行删除"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "删除或演练结果",
+            "schema": {"$ref": "#/definitions/game.TemplateDeletionResult"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "模板仍被实例引用、目标模板已弃用或实例无法改用目标模板",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/templates/buildings/{id}/upgrades/{targetID}": {
+      "put": {
+        "tags": ["System"],
+        "summary": "创建或更新建筑模板升级路径",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "targetID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.BuildingUpgradePathRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "delete": {
+        "tags": ["System"],
+        "summary": "删除建筑模板升级路径",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "targetID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "404": {
+            "description": "升级路径不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/templates/agents/{id}": {
+      "put": {
+        "tags": ["System"],
+        "summary": "更新系统 Agent 模板",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.TemplateAgentRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "delete": {
+        "tags": ["System"],
+        "summary": "删除系统 Agent 模板（支持 dryRun、reassign、cascade）",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "mode",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "enum": ["block", "reassign", "cascade"],
+            "description": "存在引用实例时的处理方式，默认 block；cascade 同时清除被删除 Agent 的行为历史"
+          },
+          {
+            "name": "reassignTo",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "reassign 模式下实例改用的模板 ID"
+          },
+          {
+            "name": "dryRun",
+            "in": "query",
+            "required": false,
+            "type": "boolean",
+            "description": "仅列出依赖实例，不执行删除"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "删除或演练结果",
+            "schema": {"$ref": "#/definitions/game.TemplateDeletionResult"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "模板仍被实例引用或目标模板已弃用",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/scene/buildings/{id}": {
+      "get": {
+        "tags": ["System"],
+        "summary": "查看场景建筑实例的生效值与模板继承情况",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "生效值与覆盖详情",
+            "schema": {"$ref": "#/definitions/game.BuildingInheritance"}
+          },
+          "404": {
+            "description": "建筑不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "put": {
+        "tags": ["System"],
+        "summary": "更新场景建筑实例",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.SceneBuildingRequest"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "409": {
+            "description": "模板已弃用，或占地压在陨石坑上、缺少模板要求的地形",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      },
+      "delete": {
+        "tags": ["System"],
+        "summary": "删除场景建筑实例",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "更新后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/system/scene/buildings/preview": {
+      "get": {
+        "tags": ["System"],
+        "summary": "预览场景建筑列表",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "sceneId",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "description": "可选的场景 ID，缺省为当前运行中的场景"
           },
           {
             "name": "limit",
@@ -362,6 +1189,41 @@ const specJSON = `{
             "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
+      },
+      "delete": {
+        "tags": ["System"],
+        "summary": "删除场景 Agent 实例（动作列表与运行时坐标一并删除）",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "history",
+            "in": "query",
+            "required": false,
+            "type": "string",
+            "enum": ["keep", "purge"],
+            "description": "行为历史处理策略，默认 keep 保留；purge 与实例在同一事务中删除行为事件与动作状态"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "删除后的快照",
+            "schema": {"$ref": "#/definitions/game.Snapshot"}
+          },
+          "400": {
+            "description": "请求参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          },
+          "404": {
+            "description": "Agent 不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
       }
     },
     "/agents/{agentID}/actions": {
@@ -395,7 +1257,8 @@ const specJSON = `{
       },
       "post": {
         "tags": ["Agents"],
-        "summary": "记录 Agent 行为事件",
+        "summary": "执行并记录 Agent 行为事件",
+        "description": "Agent 位于场景中时先校验动作是否在其可用动作内并执行移动（move_left/right/up/down，payload 可带 steps），执行结果自动写入 result_status/result_message 并推送新坐标；场景外的 Agent 仅记录事件。",
         "consumes": ["application/json"],
         "produces": ["application/json"],
         "parameters": [
@@ -414,8 +1277,8 @@ const specJSON = `{
         ],
         "responses": {
           "201": {
-            "description": "记录成功",
-            "schema": {"$ref": "#/definitions/server.StatusResponse"}
+            "description": "记录成功，附带执行结果",
+            "schema": {"$ref": "#/definitions/server.AgentActionResponse"}
           },
           "400": {
             "description": "请求参数错误",
@@ -437,10 +1300,95 @@ const specJSON = `{
             "type": "string"
           }
         ],
+        "responses": {
+          "200": {
+            "description": "成功，包含动作列表与目标评估状态",
+            "schema": {"$ref": "#/definitions/server.AgentStateResponse"}
+          }
+        }
+      }
+    },
+    "/agents/{agentID}/goals": {
+      "get": {
+        "tags": ["Agents"],
+        "summary": "列出 Agent 的持久目标",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "agentID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
         "responses": {
           "200": {
             "description": "成功",
-            "schema": {"$ref": "#/definitions/action.State"}
+            "schema": {
+              "type": "array",
+              "items": {"$ref": "#/definitions/game.Goal"}
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": ["Agents"],
+        "summary": "为 Agent 分配持久目标（每个模拟 tick 重新评估）",
+        "consumes": ["application/json"],
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "agentID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "payload",
+            "in": "body",
+            "required": true,
+            "schema": {"$ref": "#/definitions/server.AgentGoalRequest"}
+          }
+        ],
+        "responses": {
+          "201": {
+            "description": "创建的目标",
+            "schema": {"$ref": "#/definitions/game.Goal"}
+          },
+          "400": {
+            "description": "Agent 不存在或目标参数错误",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
+          }
+        }
+      }
+    },
+    "/agents/{agentID}/goals/{goalID}": {
+      "delete": {
+        "tags": ["Agents"],
+        "summary": "删除 Agent 目标",
+        "produces": ["application/json"],
+        "parameters": [
+          {
+            "name": "agentID",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          },
+          {
+            "name": "goalID",
+            "in": "path",
+            "required": true,
+            "type": "integer"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "删除成功",
+            "schema": {"$ref": "#/definitions/server.StatusResponse"}
+          },
+          "404": {
+            "description": "目标不存在",
+            "schema": {"$ref": "#/definitions/server.ErrorResponse"}
           }
         }
       }
@@ -473,6 +1421,61 @@ const specJSON = `{
         "updated_at": {"type": "string", "format": "date-time"}
       }
     },
+    "server.AgentStateResponse": {
+      "type": "object",
+      "properties": {
+        "agent_id": {"type": "string"},
+        "actions": {
+          "type": "array",
+          "items": {"type": "string"}
+        },
+        "updated_at": {"type": "string", "format": "date-time"},
+        "goals": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.Goal"}
+        }
+      }
+    },
+    "server.AgentGoalRequest": {
+      "type": "object",
+      "properties": {
+        "kind": {"type": "string", "enum": ["net-flow", "storage-level", "patrol"]},
+        "params": {"$ref": "#/definitions/game.GoalParams"}
+      },
+      "required": ["kind"]
+    },
+    "game.GoalParams": {
+      "type": "object",
+      "properties": {
+        "minNetFlow": {"type": "number", "description": "net-flow：净能量流下限，默认 0"},
+        "minStoragePercent": {"type": "number", "description": "storage-level：储能百分比下限 (0, 100]"},
+        "waypoints": {
+          "type": "array",
+          "description": "patrol：依次循环经过的航点",
+          "items": {
+            "type": "array",
+            "items": {"type": "integer"},
+            "minItems": 2,
+            "maxItems": 2
+          }
+        }
+      }
+    },
+    "game.Goal": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "agentId": {"type": "string"},
+        "kind": {"type": "string", "enum": ["net-flow", "storage-level", "patrol"]},
+        "params": {"$ref": "#/definitions/game.GoalParams"},
+        "status": {"type": "string", "enum": ["pending", "satisfied", "correcting", "active", "failing"]},
+        "cursor": {"type": "integer", "description": "patrol 当前目标航点序号"},
+        "failures": {"type": "integer", "description": "累计评估失败次数"},
+        "lastMessage": {"type": "string"},
+        "lastEvaluatedAt": {"type": "string", "format": "date-time"},
+        "createdAt": {"type": "string", "format": "date-time"}
+      }
+    },
     "game.Scene": {
       "type": "object",
       "properties": {
@@ -495,6 +1498,24 @@ const specJSON = `{
         "agentTemplates": {
           "type": "array",
           "items": {"$ref": "#/definitions/game.AgentTemplate"}
+        },
+        "jobs": {
+          "type": "array",
+          "description": "未结束的任务以及最近一分钟内结束的任务",
+          "items": {"$ref": "#/definitions/game.Job"}
+        },
+        "movementEvents": {
+          "type": "array",
+          "description": "仅出现在场景流 tick 中，本次 tick 的到达与路径取消事件",
+          "items": {"$ref": "#/definitions/game.MovementEvent"}
+        },
+        "terrain": {
+          "$ref": "#/definitions/game.TerrainLayer",
+          "description": "场景流只在连接建立与地形变更时携带，其余推送省略此字段"
+        },
+        "deposits": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.ResourceDeposit"}
         }
       }
     },
@@ -519,7 +1540,18 @@ const specJSON = `{
         "agentTemplates": {
           "type": "array",
           "items": {"$ref": "#/definitions/game.AgentTemplate"}
-        }
+        },
+        "terrain": {"$ref": "#/definitions/game.TerrainLayer"}
+      }
+    },
+    "game.MovementEvent": {
+      "type": "object",
+      "properties": {
+        "type": {"type": "string", "enum": ["arrived", "path-cancelled"]},
+        "agentId": {"type": "string"},
+        "position": {"type": "array", "items": {"type": "number"}},
+        "blockedBy": {"type": "string"},
+        "message": {"type": "string"}
       }
     },
     "game.SceneGrid": {
@@ -547,7 +1579,8 @@ const specJSON = `{
           "type": "array",
           "items": {"type": "integer"}
         },
-        "energy": {"$ref": "#/definitions/game.SceneEnergy"}
+        "energy": {"$ref": "#/definitions/game.SceneEnergy"},
+        "offline": {"type": "boolean", "description": "已断电，耗能不计入能量平衡"}
       }
     },
     "game.SceneEnergy": {
@@ -567,40 +1600,193 @@ const specJSON = `{
         "label": {"type": "string"},
         "position": {
           "type": "array",
-          "items": {"type": "integer"}
+          "items": {"type": "number"},
+          "description": "沿路径移动时为插值后的坐标"
         },
         "color": {"type": "integer"},
         "actions": {
           "type": "array",
           "items": {"type": "string"}
+        },
+        "speed": {"type": "number", "description": "每秒移动的格数，来自模板"},
+        "path": {
+          "type": "array",
+          "description": "剩余待经过的格子",
+          "items": {"type": "array", "items": {"type": "integer"}}
+        }
+      }
+    },
+    "game.SceneMeta": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "name": {"type": "string"}
+      }
+    },
+    "game.BuildingTemplate": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "label": {"type": "string"},
+        "tier": {"type": "integer"},
+        "size": {
+          "type": "array",
+          "items": {"type": "integer"},
+          "minItems": 2,
+          "maxItems": 2
+        },
+        "energy": {"$ref": "#/definitions/game.SceneEnergy"},
+        "upgrades": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.BuildingUpgrade"}
+        },
+        "deprecated": {"type": "boolean"},
+        "requiredTerrain": {
+          "type": "string",
+          "enum": ["regolith", "rock", "ice", "slope"],
+          "description": "非空时建筑占地内至少需有一格为该地形"
+        }
+      }
+    },
+    "game.BuildingInheritance": {
+      "type": "object",
+      "properties": {
+        "building": {"$ref": "#/definitions/game.SceneBuilding"},
+        "template": {"$ref": "#/definitions/game.BuildingTemplate"},
+        "fields": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.InheritedField"}
+        }
+      }
+    },
+    "game.InheritedField": {
+      "type": "object",
+      "properties": {
+        "field": {"type": "string"},
+        "effective": {},
+        "override": {},
+        "template": {},
+        "source": {"type": "string", "enum": ["instance", "template", "unset"]}
+      }
+    },
+    "game.BuildingUpgrade": {
+      "type": "object",
+      "properties": {
+        "targetTemplateId": {"type": "string"},
+        "energyCost": {"type": "integer"},
+        "footprintRule": {"type": "string", "enum": ["anchor", "center"]}
+      }
+    },
+    "game.BuildingUpgradeEvent": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer"},
+        "buildingId": {"type": "string"},
+        "fromTemplateId": {"type": "string"},
+        "toTemplateId": {"type": "string"},
+        "energyCost": {"type": "integer"},
+        "rectBefore": {
+          "type": "array",
+          "items": {"type": "integer"}
+        },
+        "rectAfter": {
+          "type": "array",
+          "items": {"type": "integer"}
+        },
+        "createdAt": {"type": "string", "format": "date-time"}
+      }
+    },
+    "game.BuildingUpgradeOverview": {
+      "type": "object",
+      "properties": {
+        "buildingId": {"type": "string"},
+        "templateId": {"type": "string"},
+        "available": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.BuildingUpgrade"}
+        },
+        "history": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.BuildingUpgradeEvent"}
         }
       }
     },
-    "game.SceneMeta": {
+    "game.UpgradeBuildingResult": {
+      "type": "object",
+      "properties": {
+        "scene": {"$ref": "#/definitions/game.Scene"},
+        "building": {"$ref": "#/definitions/game.SceneBuilding"},
+        "fromTemplateId": {"type": "string"},
+        "toTemplateId": {"type": "string"},
+        "energyCost": {"type": "integer"}
+      }
+    },
+    "game.AgentTemplate": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "label": {"type": "string"},
+        "color": {"type": "integer"},
+        "position": {
+          "type": "array",
+          "items": {"type": "integer"}
+        },
+        "skills": {
+          "type": "array",
+          "items": {"type": "string", "enum": ["build", "repair", "haul", "explore"]}
+        },
+        "speed": {"type": "number", "description": "每秒移动的格数"},
+        "deprecated": {"type": "boolean"}
+      }
+    },
+    "game.Job": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "kind": {"type": "string", "enum": ["build", "repair", "haul", "explore"]},
+        "status": {"type": "string", "enum": ["queued", "assigned", "in-progress", "done", "failed"]},
+        "priority": {"type": "integer"},
+        "target": {"type": "array", "items": {"type": "integer"}},
+        "buildingId": {"type": "string"},
+        "templateId": {"type": "string"},
+        "assignedAgentId": {"type": "string"},
+        "progress": {"type": "number", "description": "到达目标后的工作进度 0~1"},
+        "source": {"type": "string", "enum": ["api", "rule", "behavior"]},
+        "message": {"type": "string"},
+        "createdAt": {"type": "string", "format": "date-time"},
+        "updatedAt": {"type": "string", "format": "date-time"}
+      }
+    },
+    "game.SpawnSceneAgentResult": {
       "type": "object",
       "properties": {
-        "id": {"type": "string"},
-        "name": {"type": "string"}
+        "agent": {"$ref": "#/definitions/game.SceneAgent"},
+        "scene": {"$ref": "#/definitions/game.Scene"}
       }
     },
-    "game.BuildingTemplate": {
+    "game.TemplateDependent": {
       "type": "object",
       "properties": {
         "id": {"type": "string"},
-        "label": {"type": "string"},
-        "energy": {"$ref": "#/definitions/game.SceneEnergy"}
+        "sceneId": {"type": "string"},
+        "label": {"type": "string"}
       }
     },
-    "game.AgentTemplate": {
+    "game.TemplateDeletionResult": {
       "type": "object",
       "properties": {
-        "id": {"type": "string"},
-        "label": {"type": "string"},
-        "color": {"type": "integer"},
-        "position": {
+        "templateId": {"type": "string"},
+        "kind": {"type": "string", "enum": ["building", "agent"]},
+        "mode": {"type": "string", "enum": ["block", "reassign", "cascade"]},
+        "dryRun": {"type": "boolean"},
+        "reassignTo": {"type": "string"},
+        "dependents": {
           "type": "array",
-          "items": {"type": "integer"}
-        }
+          "items": {"$ref": "#/definitions/game.TemplateDependent"}
+        },
+        "blocked": {"type": "boolean"},
+        "deleted": {"type": "boolean"},
+        "snapshot": {"$ref": "#/definitions/game.Snapshot"}
       }
     },
     "server.AgentActionRequest": {
@@ -635,10 +1821,45 @@ const specJSON = `{
       "type": "object",
       "properties": {
         "label": {"type": "string"},
-        "energy": {"$ref": "#/definitions/server.TemplateEnergyRequest"}
+        "tier": {"type": "integer", "description": "省略时保留原值，新建模板缺省为 1"},
+        "size": {
+          "type": "array",
+          "items": {"type": "integer"},
+          "maxItems": 2,
+          "minItems": 2,
+          "description": "[宽, 高]，省略时保留原值，元素个数不为 2 时返回 400"
+        },
+        "energy": {"$ref": "#/definitions/server.TemplateEnergyRequest"},
+        "applyToInstances": {
+          "type": "boolean",
+          "description": "为 true 时清除引用该模板的实例上的能量覆盖（energy.current 除外）"
+        },
+        "deprecated": {
+          "type": "boolean",
+          "description": "弃用后不可用于新建实例，已存在的实例不受影响；缺省保持原值"
+        },
+        "requiredTerrain": {
+          "type": "string",
+          "enum": ["", "regolith", "rock", "ice", "slope"],
+          "description": "建筑占地内至少需有一格为该地形，空字符串清除要求；缺省保持原值"
+        }
       },
       "required": ["label"]
     },
+    "server.BuildingUpgradePathRequest": {
+      "type": "object",
+      "properties": {
+        "energyCost": {"type": "integer"},
+        "footprintRule": {"type": "string", "enum": ["anchor", "center"]}
+      }
+    },
+    "server.BuildingUpgradeRequest": {
+      "type": "object",
+      "properties": {
+        "targetTemplateId": {"type": "string"}
+      },
+      "required": ["targetTemplateId"]
+    },
     "server.TemplateAgentRequest": {
       "type": "object",
       "properties": {
@@ -649,10 +1870,61 @@ const specJSON = `{
           "items": {"type": "integer"},
           "maxItems": 2,
           "minItems": 2
+        },
+        "skills": {
+          "type": "array",
+          "items": {"type": "string"},
+          "description": "可领取的任务类型；缺省保持原值"
+        },
+        "speed": {
+          "type": "number",
+          "description": "每秒移动的格数，必须为正；缺省保持原值，新模板默认 1"
+        },
+        "deprecated": {
+          "type": "boolean",
+          "description": "弃用后不可用于新建实例，已存在的实例不受影响；缺省保持原值"
         }
       },
       "required": ["label"]
     },
+    "server.JobRequest": {
+      "type": "object",
+      "properties": {
+        "kind": {"type": "string", "enum": ["build", "repair", "haul", "explore"]},
+        "target": {
+          "type": "array",
+          "items": {"type": "integer"},
+          "maxItems": 2,
+          "minItems": 2,
+          "description": "build/haul/explore 必填"
+        },
+        "buildingId": {"type": "string", "description": "repair 必填"},
+        "templateId": {"type": "string", "description": "build 必填"},
+        "priority": {"type": "integer", "description": "数值越大越先分派"}
+      },
+      "required": ["kind"]
+    },
+    "server.SpawnAgentRequest": {
+      "type": "object",
+      "properties": {
+        "templateId": {"type": "string"},
+        "id": {"type": "string", "description": "缺省时按 <templateId>-NN 自动生成"},
+        "label": {"type": "string"},
+        "position": {
+          "type": "array",
+          "items": {"type": "integer"},
+          "maxItems": 2,
+          "minItems": 2,
+          "description": "缺省时使用模板默认坐标"
+        },
+        "actions": {
+          "type": "array",
+          "items": {"type": "string"},
+          "description": "缺省时开放 move_left/move_right/move_up/move_down"
+        }
+      },
+      "required": ["templateId"]
+    },
     "server.SceneBuildingRequest": {
       "type": "object",
       "properties": {
@@ -664,7 +1936,8 @@ const specJSON = `{
           "maxItems": 4,
           "minItems": 4
         },
-        "energy": {"$ref": "#/definitions/server.TemplateEnergyRequest"}
+        "energy": {"$ref": "#/definitions/server.TemplateEnergyRequest"},
+        "powered": {"type": "boolean", "description": "供电状态，缺省保留原值；重新供电被关停的耗能建筑时传 true"}
       },
       "required": ["label", "rect"]
     },
@@ -687,18 +1960,101 @@ const specJSON = `{
       },
       "required": ["label", "position"]
     },
-    "server.MaintainEnergyResponse": {
+    "game.BehaviorSpec": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "label": {"type": "string"},
+        "description": {"type": "string"},
+        "params": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.BehaviorParam"}
+        }
+      }
+    },
+    "game.BehaviorParam": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "type": {"type": "string", "enum": ["string", "number", "integer", "boolean", "array"]},
+        "required": {"type": "boolean"},
+        "default": {},
+        "enum": {"type": "array", "items": {"type": "string"}},
+        "description": {"type": "string"}
+      }
+    },
+    "game.MaintainEnergyParams": {
+      "type": "object",
+      "properties": {
+        "templates": {
+          "type": "array",
+          "items": {"type": "string"},
+          "description": "可建造的生产模板，按优先顺序尝试；缺省为 solar_tower"
+        },
+        "maxBuildings": {"type": "integer", "minimum": 0, "maximum": 50, "default": 50, "description": "最多新建或关停的建筑数，0 表示缺省上限 50"},
+        "margin": {"type": "number", "minimum": 0, "default": 0, "description": "目标净能量流余量"},
+        "mode": {"type": "string", "enum": ["build", "shed", "plan", "queue"], "default": "build", "description": "build 建造生产建筑，shed 关停耗能建筑，plan 只返回两种方案，queue 为生产建筑排队建造任务（来源为 behavior）"},
+        "dryRun": {"type": "boolean", "default": false, "description": "只返回所选模式的方案，不写库"}
+      }
+    },
+    "game.MaintainEnergyResult": {
       "type": "object",
       "properties": {
         "scene": {"$ref": "#/definitions/game.Scene"},
+        "mode": {"type": "string", "enum": ["build", "shed", "plan", "queue"]},
+        "dryRun": {"type": "boolean"},
+        "margin": {"type": "number"},
         "created": {
           "type": "array",
           "items": {"$ref": "#/definitions/game.SceneBuilding"}
         },
+        "shed": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.SceneBuilding"}
+        },
         "netFlowBefore": {"type": "number"},
-        "netFlowAfter": {"type": "number"},
+        "netFlowAfter": {"type": "number", "description": "dryRun 时为方案执行后的预计值"},
         "towersBuilt": {"type": "integer"},
-        "relocation": {"$ref": "#/definitions/game.AgentRelocation"}
+        "relocation": {"$ref": "#/definitions/game.AgentRelocation"},
+        "plans": {
+          "type": "array",
+          "description": "dryRun 或 queue 模式下为所选模式的方案，plan 模式下依次为 build 与 shed 方案",
+          "items": {"$ref": "#/definitions/game.MaintainEnergyPlan"}
+        },
+        "jobs": {
+          "type": "array",
+          "description": "queue 模式下新排队的建造任务，未结束的建造任务已计入缺口",
+          "items": {"$ref": "#/definitions/game.Job"}
+        }
+      }
+    },
+    "game.MaintainEnergyPlan": {
+      "type": "object",
+      "properties": {
+        "mode": {"type": "string", "enum": ["build", "shed", "queue"]},
+        "build": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.PlannedBuilding"}
+        },
+        "shed": {
+          "type": "array",
+          "items": {"type": "string"},
+          "description": "待关停的耗能建筑 ID"
+        },
+        "relocation": {"$ref": "#/definitions/game.AgentRelocation"},
+        "netFlowAfter": {"type": "number"},
+        "shortfall": {"type": "number", "description": "受数量上限限制后仍未补足的缺口"},
+        "error": {"type": "string", "description": "plan 模式下该方案无法生成的原因"}
+      }
+    },
+    "game.PlannedBuilding": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "templateId": {"type": "string"},
+        "label": {"type": "string"},
+        "rect": {"type": "array", "items": {"type": "integer"}},
+        "output": {"type": "integer"}
       }
     },
     "game.AgentRelocation": {
@@ -748,6 +2104,26 @@ const specJSON = `{
         "height": {"type": "integer"}
       }
     },
+    "server.AgentActionResponse": {
+      "type": "object",
+      "properties": {
+        "status": {"type": "string"},
+        "result": {"$ref": "#/definitions/game.AgentActionResult"}
+      }
+    },
+    "game.AgentActionResult": {
+      "type": "object",
+      "properties": {
+        "agentId": {"type": "string"},
+        "action": {"type": "string"},
+        "status": {"type": "string", "enum": ["succeeded", "rejected", "blocked", "ignored"]},
+        "message": {"type": "string"},
+        "executed": {"type": "boolean"},
+        "from": {"type": "array", "items": {"type": "number"}},
+        "to": {"type": "array", "items": {"type": "number"}},
+        "agent": {"$ref": "#/definitions/game.SceneAgent"}
+      }
+    },
     "server.StatusResponse": {
       "type": "object",
       "properties": {
@@ -774,6 +2150,301 @@ const specJSON = `{
         "y": {"type": "number"}
       },
       "required": ["x", "y"]
+    },
+    "server.AgentPathRequest": {
+      "type": "object",
+      "properties": {
+        "path": {
+          "type": "array",
+          "items": {
+            "type": "array",
+            "items": {"type": "integer"},
+            "minItems": 2,
+            "maxItems": 2
+          }
+        },
+        "destination": {
+          "type": "array",
+          "items": {"type": "integer"},
+          "minItems": 2,
+          "maxItems": 2,
+          "description": "path 为空时自动寻路到该格"
+        },
+        "diagonal": {"type": "boolean", "description": "自动寻路是否允许斜向移动"}
+      }
+    },
+    "game.PathResult": {
+      "type": "object",
+      "properties": {
+        "from": {"type": "array", "items": {"type": "integer"}},
+        "to": {"type": "array", "items": {"type": "integer"}},
+        "diagonal": {"type": "boolean"},
+        "path": {
+          "type": "array",
+          "description": "不含起点、包含终点",
+          "items": {"type": "array", "items": {"type": "integer"}}
+        },
+        "cost": {"type": "number"}
+      }
+    },
+    "game.PlacementSuggestion": {
+      "type": "object",
+      "properties": {
+        "rect": {"type": "array", "items": {"type": "integer"}, "description": "[x, y, width, height]"},
+        "score": {"type": "number", "description": "加权代价，越低越好"},
+        "agentDistance": {"type": "number", "description": "Agent 走到占地旁的寻路代价，仅指定 agentId 时返回"},
+        "consumerDistance": {"type": "number", "description": "到最近耗能建筑边缘的距离"},
+        "terrainCost": {"type": "number", "description": "占地内格子的平均通行代价"}
+      }
+    },
+    "game.PlacementPlan": {
+      "type": "object",
+      "properties": {
+        "templateId": {"type": "string"},
+        "agentId": {"type": "string"},
+        "size": {"type": "array", "items": {"type": "integer"}},
+        "clearance": {"type": "integer"},
+        "suggestions": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/game.PlacementSuggestion"}
+        }
+      }
+    },
+    "game.TerrainLayer": {
+      "type": "object",
+      "description": "按行优先游程编码的地形层，runs 中每项为 [legend 下标, 连续格数]",
+      "properties": {
+        "width": {"type": "integer"},
+        "height": {"type": "integer"},
+        "legend": {
+          "type": "array",
+          "items": {"type": "string"},
+          "example": ["regolith", "rock", "crater", "ice", "slope"]
+        },
+        "runs": {
+          "type": "array",
+          "items": {"type": "array", "items": {"type": "integer"}, "minItems": 2, "maxItems": 2}
+        }
+      }
+    },
+    "server.SceneStreamMessage": {
+      "type": "object",
+      "properties": {
+        "type": {"type": "string", "enum": ["snapshot", "patch", "event", "resync", "command", "ack", "error", "close"]},
+        "topic": {"type": "string", "description": "消息所属主题；resync 中指定需要重发的主题"},
+        "seq": {"type": "integer", "description": "场景序号，同一连接上单调不减，ack/error 中为回复时的序号；客户端消息中忽略"},
+        "prev": {"type": "integer", "description": "该主题上一次变化的 seq，仅 patch 携带"},
+        "scene": {"$ref": "#/definitions/game.Scene"},
+        "data": {"description": "非 scene 主题的快照"},
+        "ops": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/server.PatchOperation"}
+        },
+        "events": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/server.StreamEvent"}
+        },
+        "reason": {"type": "string", "enum": ["since-expired", "slow-consumer"], "description": "服务端 resync 通知或 close 消息的原因"},
+        "id": {"type": "string", "description": "命令请求 ID，ack/error 原样带回"},
+        "command": {"type": "string", "enum": ["move-agent", "set-building-energy", "run-behavior", "subscribe", "unsubscribe"]},
+        "params": {"type": "object"},
+        "result": {},
+        "error": {"$ref": "#/definitions/server.StreamError"}
+      },
+      "required": ["type"]
+    },
+    "server.StreamError": {
+      "type": "object",
+      "properties": {
+        "code": {"type": "integer", "description": "与对应 REST 接口一致的 HTTP 状态码"},
+        "message": {"type": "string"}
+      }
+    },
+    "server.MoveAgentCommand": {
+      "type": "object",
+      "properties": {
+        "agentId": {"type": "string"},
+        "path": {"type": "array", "items": {"type": "array", "items": {"type": "integer"}}},
+        "destination": {"type": "array", "items": {"type": "integer"}},
+        "diagonal": {"type": "boolean"}
+      },
+      "required": ["agentId"]
+    },
+    "server.SetBuildingEnergyCommand": {
+      "type": "object",
+      "properties": {
+        "buildingId": {"type": "string"},
+        "current": {"type": "number"}
+      },
+      "required": ["buildingId", "current"]
+    },
+    "server.RunBehaviorCommand": {
+      "type": "object",
+      "properties": {
+        "agentId": {"type": "string"},
+        "behaviorId": {"type": "string"},
+        "params": {"type": "object"}
+      },
+      "required": ["agentId", "behaviorId"]
+    },
+    "server.SubscribeCommand": {
+      "type": "object",
+      "properties": {
+        "topics": {"type": "array", "items": {"type": "string"}, "description": "scene、energy、events、presence、building:<id>、agent:<id> 或 viewport:x,y,w,h"}
+      },
+      "required": ["topics"]
+    },
+    "server.StreamEvent": {
+      "type": "object",
+      "properties": {
+        "kind": {"type": "string", "enum": ["movement", "job", "presence"]},
+        "movement": {"$ref": "#/definitions/game.MovementEvent"},
+        "job": {"$ref": "#/definitions/game.Job"},
+        "previousStatus": {"type": "string", "description": "任务此前的状态，新任务为空"},
+        "action": {"type": "string", "enum": ["join", "leave", "update"], "description": "presence 事件的动作"},
+        "presence": {"$ref": "#/definitions/server.StreamPresence"}
+      }
+    },
+    "server.StreamPresence": {
+      "type": "object",
+      "properties": {
+        "clientId": {"type": "string"},
+        "role": {"type": "string"},
+        "transport": {"type": "string", "enum": ["websocket", "sse"]},
+        "encoding": {"type": "string", "enum": ["scene.json", "scene.msgpack"]},
+        "rate": {"type": "number", "description": "限频客户端的每秒推送次数"},
+        "topics": {"type": "array", "items": {"type": "string"}},
+        "controlling": {"type": "array", "items": {"type": "string"}, "description": "通过命令操控过的 Agent"},
+        "connectedAt": {"type": "string", "format": "date-time"},
+        "lag": {"type": "integer", "description": "最新场景序号与已写出消息覆盖到的序号之差；订阅主题没有变化时不计入，限频客户端在下次推送前计入"},
+        "queued": {"type": "integer"}
+      }
+    },
+    "server.StreamPresenceResponse": {
+      "type": "object",
+      "properties": {
+        "clients": {"type": "array", "items": {"$ref": "#/definitions/server.StreamPresence"}}
+      }
+    },
+    "server.StreamSchema": {
+      "type": "object",
+      "properties": {
+        "protocols": {"type": "array", "items": {"type": "string"}},
+        "message": {"type": "string", "description": "消息信封的类型名"},
+        "event": {"type": "string", "description": "events 中元素的类型名"},
+        "topics": {"type": "object", "additionalProperties": {"type": "string"}},
+        "types": {"type": "object", "additionalProperties": {"type": "array", "items": {"$ref": "#/definitions/server.StreamSchemaField"}}}
+      }
+    },
+    "server.StreamSchemaField": {
+      "type": "object",
+      "properties": {
+        "index": {"type": "integer"},
+        "name": {"type": "string", "description": "JSON 模式下的字段名"},
+        "type": {"type": "string"}
+      }
+    },
+    "server.ViewportView": {
+      "type": "object",
+      "properties": {
+        "rect": {"type": "array", "items": {"type": "integer"}, "description": "[x, y, w, h]"},
+        "buildings": {"type": "array", "items": {"$ref": "#/definitions/game.SceneBuilding"}},
+        "agents": {"type": "array", "items": {"$ref": "#/definitions/game.SceneAgent"}}
+      }
+    },
+    "game.EnergySummary": {
+      "type": "object",
+      "properties": {
+        "consumption": {"type": "number"},
+        "output": {"type": "number"},
+        "netFlow": {"type": "number"},
+        "storageCurrent": {"type": "integer"},
+        "storageCapacity": {"type": "integer"},
+        "offlineCount": {"type": "integer", "description": "已断电的建筑数"}
+      }
+    },
+    "server.PatchOperation": {
+      "type": "object",
+      "properties": {
+        "op": {"type": "string", "enum": ["add", "remove", "replace"]},
+        "path": {"type": "string", "description": "RFC 6901 JSON Pointer"},
+        "value": {}
+      },
+      "required": ["op", "path"]
+    },
+    "server.TerrainUpdateRequest": {
+      "type": "object",
+      "properties": {
+        "runs": {
+          "type": "array",
+          "description": "行优先的 [地形编码, 连续格数]，总格数必须等于场景宽×高",
+          "items": {"type": "array", "items": {"type": "integer"}, "minItems": 2, "maxItems": 2}
+        }
+      },
+      "required": ["runs"]
+    },
+    "server.TerrainPaintRequest": {
+      "type": "object",
+      "properties": {
+        "paints": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "type": {"type": "string", "enum": ["regolith", "rock", "crater", "ice", "slope"]},
+              "rect": {"type": "array", "items": {"type": "integer"}, "minItems": 4, "maxItems": 4}
+            }
+          }
+        }
+      },
+      "required": ["paints"]
+    },
+    "game.ResourceDeposit": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "kind": {"type": "string", "enum": ["ice", "metal"]},
+        "position": {"type": "array", "items": {"type": "integer"}, "minItems": 2, "maxItems": 2},
+        "amount": {"type": "integer"}
+      }
+    },
+    "game.TemplateCount": {
+      "type": "object",
+      "properties": {
+        "templateId": {"type": "string"},
+        "count": {"type": "integer", "minimum": 0}
+      }
+    },
+    "game.GenerateSceneResult": {
+      "type": "object",
+      "properties": {
+        "seed": {"type": "integer", "format": "int64"},
+        "dryRun": {"type": "boolean"},
+        "scene": {"$ref": "#/definitions/game.Scene"}
+      }
+    },
+    "server.GenerateSceneRequest": {
+      "type": "object",
+      "properties": {
+        "sceneId": {"type": "string"},
+        "name": {"type": "string", "description": "缺省与 sceneId 相同"},
+        "seed": {"type": "integer", "format": "int64"},
+        "width": {"type": "integer", "minimum": 16, "maximum": 512},
+        "height": {"type": "integer", "minimum": 16, "maximum": 512},
+        "tileSize": {"type": "integer", "description": "缺省 32"},
+        "buildings": {
+          "type": "array",
+          "description": "按顺序从地图中心向外放置；缺省为默认开局组合",
+          "items": {"$ref": "#/definitions/game.TemplateCount"}
+        },
+        "agents": {
+          "type": "array",
+          "description": "缺省每个未弃用的 Agent 模板各一个",
+          "items": {"$ref": "#/definitions/game.TemplateCount"}
+        },
+        "dryRun": {"type": "boolean", "description": "为 true 时只返回预览，不写库"}
+      },
+      "required": ["sceneId", "width", "height"]
     }
   }
 }`
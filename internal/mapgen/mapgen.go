@@ -0,0 +1,391 @@
+// Package mapgen 按种子确定性地生成前哨地图：地形、资源点、初始建筑布局与起始 Agent 位置。
+// 相同的 Config 总是得到相同的 Map，便于设计与压测复现。
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Terrain 为格子地形编码，与 game.TerrainType 保持一致。
+type Terrain uint8
+
+const (
+	Regolith Terrain = iota
+	Rock
+	Crater
+	Ice
+	Slope
+)
+
+const (
+	MinSize = 16
+	MaxSize = 512
+
+	// clearance 为建筑之间保留的通道宽度，保证 Agent 能在建筑间通行。
+	clearance = 1
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid map config")
+	ErrNoRoom        = errors.New("no room for building")
+)
+
+// BuildingSpec 描述需要放置的一类建筑。
+type BuildingSpec struct {
+	TemplateID string
+	Width      int
+	Height     int
+	Count      int
+	// RequiredTerrain 非空时占地内至少需有一格为该地形。
+	RequiredTerrain *Terrain
+}
+
+// AgentSpec 描述需要放置的一类起始 Agent。
+type AgentSpec struct {
+	TemplateID string
+	Count      int
+}
+
+// Config 为地图生成参数。
+type Config struct {
+	Seed      int64
+	Width     int
+	Height    int
+	Buildings []BuildingSpec
+	Agents    []AgentSpec
+}
+
+// Deposit 表示资源点。
+type Deposit struct {
+	Kind   string `json:"kind"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Amount int    `json:"amount"`
+}
+
+// Building 表示生成的建筑占地。
+type Building struct {
+	TemplateID string `json:"templateId"`
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+// Agent 表示生成的 Agent 起始位置。
+type Agent struct {
+	TemplateID string `json:"templateId"`
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+}
+
+// Map 为生成结果，Terrain 按行优先排列。
+type Map struct {
+	Seed      int64      `json:"seed"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Terrain   []Terrain  `json:"-"`
+	Deposits  []Deposit  `json:"deposits"`
+	Buildings []Building `json:"buildings"`
+	Agents    []Agent    `json:"agents"`
+}
+
+// At 返回格子的地形，越界时返回 Crater 以免被当作可用格子。
+func (m Map) At(x, y int) Terrain {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return Crater
+	}
+	return m.Terrain[y*m.Width+x]
+}
+
+// Generate 根据配置生成地图。建筑按 Buildings 的顺序从地图中心向外放置，彼此之间保留通道。
+func Generate(cfg Config) (Map, error) {
+	if cfg.Width < MinSize || cfg.Height < MinSize || cfg.Width > MaxSize || cfg.Height > MaxSize {
+		return Map{}, fmt.Errorf("%w: width and height must be between %d and %d", ErrInvalidConfig, MinSize, MaxSize)
+	}
+	for _, spec := range cfg.Buildings {
+		if spec.TemplateID == "" || spec.Width <= 0 || spec.Height <= 0 || spec.Count < 0 {
+			return Map{}, fmt.Errorf("%w: building %q needs a positive footprint and a non-negative count", ErrInvalidConfig, spec.TemplateID)
+		}
+		if spec.RequiredTerrain != nil && *spec.RequiredTerrain == Crater {
+			return Map{}, fmt.Errorf("%w: building %q cannot require craters", ErrInvalidConfig, spec.TemplateID)
+		}
+	}
+	for _, spec := range cfg.Agents {
+		if spec.TemplateID == "" || spec.Count < 0 {
+			return Map{}, fmt.Errorf("%w: agent %q needs a non-negative count", ErrInvalidConfig, spec.TemplateID)
+		}
+	}
+
+	g := &generator{
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		m:        Map{Seed: cfg.Seed, Width: cfg.Width, Height: cfg.Height},
+		occupied: make([]bool, cfg.Width*cfg.Height),
+	}
+	g.m.Terrain = make([]Terrain, cfg.Width*cfg.Height)
+	g.base = g.baseRect()
+
+	g.paintElevation()
+	g.paintCraters()
+	g.paintIce()
+	g.placeMetal()
+
+	center := [2]int{
+		cfg.Width/2 + g.rng.Intn(5) - 2,
+		cfg.Height/2 + g.rng.Intn(5) - 2,
+	}
+	for _, spec := range cfg.Buildings {
+		for i := 0; i < spec.Count; i++ {
+			if err := g.placeBuilding(spec, center); err != nil {
+				return Map{}, fmt.Errorf("%w: %s #%d", err, spec.TemplateID, i+1)
+			}
+		}
+	}
+	for _, spec := range cfg.Agents {
+		for i := 0; i < spec.Count; i++ {
+			if err := g.placeAgent(spec, center); err != nil {
+				return Map{}, fmt.Errorf("%w: %s #%d", err, spec.TemplateID, i+1)
+			}
+		}
+	}
+
+	return g.m, nil
+}
+
+type generator struct {
+	rng      *rand.Rand
+	m        Map
+	base     [4]int
+	occupied []bool
+}
+
+// baseRect 返回地图中央保持平坦的基地区域。
+func (g *generator) baseRect() [4]int {
+	w := max(g.m.Width/3, 8)
+	h := max(g.m.Height/3, 8)
+	return [4]int{(g.m.Width - w) / 2, (g.m.Height - h) / 2, w, h}
+}
+
+func (g *generator) inBase(x, y int) bool {
+	return x >= g.base[0] && y >= g.base[1] && x < g.base[0]+g.base[2] && y < g.base[1]+g.base[3]
+}
+
+func (g *generator) set(x, y int, t Terrain) {
+	if x >= 0 && y >= 0 && x < g.m.Width && y < g.m.Height {
+		g.m.Terrain[y*g.m.Width+x] = t
+	}
+}
+
+// paintElevation 以两层值噪声生成高程，高处为斜坡，次高处为岩石，基地区域保持风化层。
+func (g *generator) paintElevation() {
+	coarse := newValueNoise(g.rng, g.m.Width, g.m.Height, 12)
+	fine := newValueNoise(g.rng, g.m.Width, g.m.Height, 5)
+	for y := 0; y < g.m.Height; y++ {
+		for x := 0; x < g.m.Width; x++ {
+			if g.inBase(x, y) {
+				continue
+			}
+			elevation := (coarse.at(x, y)*2 + fine.at(x, y)) / 3
+			switch {
+			case elevation > 0.72:
+				g.set(x, y, Slope)
+			case elevation > 0.6:
+				g.set(x, y, Rock)
+			}
+		}
+	}
+}
+
+// paintCraters 在基地区域之外生成带岩石边缘的陨石坑。
+func (g *generator) paintCraters() {
+	count := max(1, g.m.Width*g.m.Height/900)
+	for i := 0; i < count; i++ {
+		for attempt := 0; attempt < 20; attempt++ {
+			radius := 1 + g.rng.Intn(3)
+			cx, cy := g.rng.Intn(g.m.Width), g.rng.Intn(g.m.Height)
+			if g.discTouchesBase(cx, cy, radius+1) {
+				continue
+			}
+			g.paintDisc(cx, cy, radius+1, func(x, y int) {
+				if g.m.At(x, y) != Crater {
+					g.set(x, y, Rock)
+				}
+			})
+			g.paintDisc(cx, cy, radius, func(x, y int) { g.set(x, y, Crater) })
+			break
+		}
+	}
+}
+
+// paintIce 生成冰层斑块，每块对应一个冰资源点。第一块靠近基地边缘，保证开局可达。
+func (g *generator) paintIce() {
+	count := max(2, g.m.Width*g.m.Height/1200)
+	for i := 0; i < count; i++ {
+		for attempt := 0; attempt < 20; attempt++ {
+			radius := 1 + g.rng.Intn(2)
+			var cx, cy int
+			if i == 0 {
+				cx = g.base[0] + g.base[2] + radius + 1 + g.rng.Intn(3)
+				cy = g.base[1] + g.rng.Intn(g.base[3])
+			} else {
+				cx, cy = g.rng.Intn(g.m.Width), g.rng.Intn(g.m.Height)
+			}
+			if g.m.At(cx, cy) == Crater || g.discTouchesBase(cx, cy, radius) {
+				continue
+			}
+			g.paintDisc(cx, cy, radius, func(x, y int) {
+				if g.m.At(x, y) != Crater {
+					g.set(x, y, Ice)
+				}
+			})
+			g.m.Deposits = append(g.m.Deposits, Deposit{Kind: "ice", X: cx, Y: cy, Amount: 200 + g.rng.Intn(301)})
+			break
+		}
+	}
+}
+
+// placeMetal 在岩石格子上放置金属资源点。
+func (g *generator) placeMetal() {
+	count := max(1, g.m.Width*g.m.Height/1500)
+	for i := 0; i < count; i++ {
+		for attempt := 0; attempt < 50; attempt++ {
+			x, y := g.rng.Intn(g.m.Width), g.rng.Intn(g.m.Height)
+			if g.m.At(x, y) != Rock {
+				continue
+			}
+			g.m.Deposits = append(g.m.Deposits, Deposit{Kind: "metal", X: x, Y: y, Amount: 100 + g.rng.Intn(301)})
+			break
+		}
+	}
+}
+
+func (g *generator) discTouchesBase(cx, cy, radius int) bool {
+	return cx+radius >= g.base[0] && cx-radius < g.base[0]+g.base[2] &&
+		cy+radius >= g.base[1] && cy-radius < g.base[1]+g.base[3]
+}
+
+func (g *generator) paintDisc(cx, cy, radius int, paint func(x, y int)) {
+	for y := cy - radius; y <= cy+radius; y++ {
+		for x := cx - radius; x <= cx+radius; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= radius*radius {
+				paint(x, y)
+			}
+		}
+	}
+}
+
+// placeBuilding 从 center 逐圈向外寻找满足地形要求且四周留有通道的位置。
+func (g *generator) placeBuilding(spec BuildingSpec, center [2]int) error {
+	from := [2]int{center[0] - spec.Width/2, center[1] - spec.Height/2}
+	x, y, ok := g.ringSearch(from, func(x, y int) bool { return g.siteFits(spec, x, y) })
+	if !ok {
+		return ErrNoRoom
+	}
+	for yy := y; yy < y+spec.Height; yy++ {
+		for xx := x; xx < x+spec.Width; xx++ {
+			g.occupied[yy*g.m.Width+xx] = true
+		}
+	}
+	g.m.Buildings = append(g.m.Buildings, Building{TemplateID: spec.TemplateID, X: x, Y: y, Width: spec.Width, Height: spec.Height})
+	return nil
+}
+
+func (g *generator) siteFits(spec BuildingSpec, x, y int) bool {
+	if x < 0 || y < 0 || x+spec.Width > g.m.Width || y+spec.Height > g.m.Height {
+		return false
+	}
+	for yy := y - clearance; yy < y+spec.Height+clearance; yy++ {
+		for xx := x - clearance; xx < x+spec.Width+clearance; xx++ {
+			if xx >= 0 && yy >= 0 && xx < g.m.Width && yy < g.m.Height && g.occupied[yy*g.m.Width+xx] {
+				return false
+			}
+		}
+	}
+	found := spec.RequiredTerrain == nil
+	for yy := y; yy < y+spec.Height; yy++ {
+		for xx := x; xx < x+spec.Width; xx++ {
+			tile := g.m.At(xx, yy)
+			if tile == Crater {
+				return false
+			}
+			if spec.RequiredTerrain != nil && tile == *spec.RequiredTerrain {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// placeAgent 在 center 附近选取未被占用、不是陨石坑的格子。
+func (g *generator) placeAgent(spec AgentSpec, center [2]int) error {
+	x, y, ok := g.ringSearch(center, func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < g.m.Width && y < g.m.Height &&
+			!g.occupied[y*g.m.Width+x] && g.m.At(x, y) != Crater
+	})
+	if !ok {
+		return ErrNoRoom
+	}
+	g.occupied[y*g.m.Width+x] = true
+	g.m.Agents = append(g.m.Agents, Agent{TemplateID: spec.TemplateID, X: x, Y: y})
+	return nil
+}
+
+// ringSearch 按切比雪夫距离逐圈向外枚举格子，返回第一个满足 fits 的格子。
+func (g *generator) ringSearch(from [2]int, fits func(x, y int) bool) (int, int, bool) {
+	limit := max(g.m.Width, g.m.Height) + max(abs(from[0]), abs(from[1]))
+	for ring := 0; ring <= limit; ring++ {
+		for y := from[1] - ring; y <= from[1]+ring; y++ {
+			step := 2 * ring
+			if y == from[1]-ring || y == from[1]+ring || step == 0 {
+				step = 1
+			}
+			for x := from[0] - ring; x <= from[0]+ring; x += step {
+				if fits(x, y) {
+					return x, y, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// valueNoise 为格点随机值的平滑双线性插值。
+type valueNoise struct {
+	cell    int
+	columns int
+	values  []float64
+}
+
+func newValueNoise(rng *rand.Rand, width, height, cell int) valueNoise {
+	columns := width/cell + 2
+	rows := height/cell + 2
+	values := make([]float64, columns*rows)
+	for i := range values {
+		values[i] = rng.Float64()
+	}
+	return valueNoise{cell: cell, columns: columns, values: values}
+}
+
+func (n valueNoise) at(x, y int) float64 {
+	fx, fy := float64(x)/float64(n.cell), float64(y)/float64(n.cell)
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := smoothstep(fx-float64(x0)), smoothstep(fy-float64(y0))
+	v := func(cx, cy int) float64 { return n.values[cy*n.columns+cx] }
+	top := v(x0, y0)*(1-tx) + v(x0+1, y0)*tx
+	bottom := v(x0, y0+1)*(1-tx) + v(x0+1, y0+1)*tx
+	return top*(1-ty) + bottom*ty
+}
+
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
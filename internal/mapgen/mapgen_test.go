@@ -0,0 +1,101 @@
+package mapgen
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testConfig(seed int64) Config {
+	ice := Ice
+	return Config{
+		Seed:   seed,
+		Width:  64,
+		Height: 48,
+		Buildings: []BuildingSpec{
+			{TemplateID: "central_dome", Width: 4, Height: 4, Count: 1},
+			{TemplateID: "habitat_block", Width: 3, Height: 2, Count: 3},
+			{TemplateID: "ice_extractor", Width: 2, Height: 2, Count: 1, RequiredTerrain: &ice},
+		},
+		Agents: []AgentSpec{{TemplateID: "ares", Count: 2}},
+	}
+}
+
+func TestGenerateIsReproducible(t *testing.T) {
+	first, err := Generate(testConfig(42))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	second, err := Generate(testConfig(42))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("expected identical maps for the same seed")
+	}
+
+	other, err := Generate(testConfig(7))
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if reflect.DeepEqual(first.Terrain, other.Terrain) {
+		t.Fatal("expected different terrain for a different seed")
+	}
+}
+
+func TestGenerateLayoutRespectsTerrainAndClearance(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		m, err := Generate(testConfig(seed))
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if len(m.Buildings) != 5 || len(m.Agents) != 2 {
+			t.Fatalf("seed %d: expected 5 buildings and 2 agents, got %d and %d", seed, len(m.Buildings), len(m.Agents))
+		}
+		if len(m.Deposits) == 0 || m.Deposits[0].Kind != "ice" {
+			t.Fatalf("seed %d: expected an ice deposit first, got %+v", seed, m.Deposits)
+		}
+
+		for i, b := range m.Buildings {
+			hasIce := false
+			for y := b.Y; y < b.Y+b.Height; y++ {
+				for x := b.X; x < b.X+b.Width; x++ {
+					switch m.At(x, y) {
+					case Crater:
+						t.Fatalf("seed %d: %s placed on crater at (%d,%d)", seed, b.TemplateID, x, y)
+					case Ice:
+						hasIce = true
+					}
+				}
+			}
+			if b.TemplateID == "ice_extractor" && !hasIce {
+				t.Fatalf("seed %d: ice extractor at (%d,%d) has no ice", seed, b.X, b.Y)
+			}
+			for _, other := range m.Buildings[i+1:] {
+				if b.X-clearance < other.X+other.Width && other.X < b.X+b.Width+clearance &&
+					b.Y-clearance < other.Y+other.Height && other.Y < b.Y+b.Height+clearance {
+					t.Fatalf("seed %d: %+v and %+v leave no walkway", seed, b, other)
+				}
+			}
+		}
+		for _, a := range m.Agents {
+			if m.At(a.X, a.Y) == Crater {
+				t.Fatalf("seed %d: agent placed on crater at (%d,%d)", seed, a.X, a.Y)
+			}
+		}
+	}
+}
+
+func TestGenerateRejectsInvalidConfig(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.Width = 8
+	if _, err := Generate(cfg); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+
+	cfg = testConfig(1)
+	cfg.Buildings = []BuildingSpec{{TemplateID: "huge", Width: 20, Height: 20, Count: 6}}
+	if _, err := Generate(cfg); !errors.Is(err, ErrNoRoom) {
+		t.Fatalf("expected ErrNoRoom, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package pathfinding
+
+import "sync"
+
+// Cache 缓存同一网格上的寻路结果，网格变化后需调用 Reset 使缓存失效。
+type Cache struct {
+	mu      sync.Mutex
+	grid    *Grid
+	version uint64
+	limit   int
+	paths   map[cacheKey]cachedPath
+}
+
+type cacheKey struct {
+	from, to Point
+	opts     Options
+}
+
+type cachedPath struct {
+	path  []Point
+	cost  float64
+	found bool
+}
+
+// NewCache 返回最多保存 limit 条结果的缓存，超出后整体清空。
+func NewCache(limit int) *Cache {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &Cache{limit: limit, paths: make(map[cacheKey]cachedPath)}
+}
+
+// Reset 替换网格并清空缓存，version 用于调用方判断网格是否已是最新。
+func (c *Cache) Reset(grid *Grid, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.grid = grid
+	c.version = version
+	c.paths = make(map[cacheKey]cachedPath)
+}
+
+// Current 返回当前网格及其版本，尚未设置网格时返回 nil。
+func (c *Cache) Current() (*Grid, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.grid, c.version
+}
+
+// FindPath 返回缓存的寻路结果，未命中时在当前网格上计算并缓存。
+// 返回的路径为副本，调用方可以自由修改。
+func (c *Cache) FindPath(from, to Point, opts Options) ([]Point, float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.grid == nil {
+		return nil, 0, false
+	}
+
+	key := cacheKey{from: from, to: to, opts: opts}
+	entry, ok := c.paths[key]
+	if !ok {
+		entry.path, entry.cost, entry.found = FindPath(c.grid, from, to, opts)
+		if len(c.paths) >= c.limit {
+			c.paths = make(map[cacheKey]cachedPath)
+		}
+		c.paths[key] = entry
+	}
+	return append([]Point(nil), entry.path...), entry.cost, entry.found
+}
@@ -0,0 +1,274 @@
+// Package pathfinding 提供基于占用网格的 A* 寻路，支持斜向移动、地形代价与结果缓存。
+package pathfinding
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Point 表示网格中的一个格子。
+type Point struct {
+	X int
+	Y int
+}
+
+// Options 控制寻路行为。
+type Options struct {
+	// Diagonal 允许斜向移动，斜向移动不能穿过两侧任一被占用的格子。
+	Diagonal bool
+}
+
+// Grid 为寻路使用的占用网格，每个格子记录进入该格的代价，0 表示不可通行。
+type Grid struct {
+	width   int
+	height  int
+	costs   []float64
+	minCost float64
+}
+
+// NewGrid 返回所有格子代价为 1 的网格。
+func NewGrid(width, height int) *Grid {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	costs := make([]float64, width*height)
+	for i := range costs {
+		costs[i] = 1
+	}
+	return &Grid{width: width, height: height, costs: costs, minCost: 1}
+}
+
+// Width 返回网格宽度。
+func (g *Grid) Width() int { return g.width }
+
+// Height 返回网格高度。
+func (g *Grid) Height() int { return g.height }
+
+// InBounds 判断格子是否位于网格内。
+func (g *Grid) InBounds(p Point) bool {
+	return p.X >= 0 && p.Y >= 0 && p.X < g.width && p.Y < g.height
+}
+
+// Walkable 判断格子是否可通行。
+func (g *Grid) Walkable(p Point) bool {
+	return g.InBounds(p) && g.costs[p.Y*g.width+p.X] > 0
+}
+
+// Cost 返回进入格子的代价，越界或被占用时返回 0。
+func (g *Grid) Cost(p Point) float64 {
+	if !g.InBounds(p) {
+		return 0
+	}
+	return g.costs[p.Y*g.width+p.X]
+}
+
+// SetCost 设置进入格子的代价，cost <= 0 表示不可通行。
+func (g *Grid) SetCost(p Point, cost float64) {
+	if !g.InBounds(p) {
+		return
+	}
+	if cost < 0 {
+		cost = 0
+	}
+	g.costs[p.Y*g.width+p.X] = cost
+	if cost > 0 && cost < g.minCost {
+		g.minCost = cost
+	}
+}
+
+// Block 将矩形区域标记为不可通行，超出网格的部分会被忽略。
+func (g *Grid) Block(x, y, width, height int) {
+	for yy := max(y, 0); yy < min(y+height, g.height); yy++ {
+		for xx := max(x, 0); xx < min(x+width, g.width); xx++ {
+			g.costs[yy*g.width+xx] = 0
+		}
+	}
+}
+
+// CanStep 判断能否从 from 一步移动到相邻的 to。
+func (g *Grid) CanStep(from, to Point, opts Options) bool {
+	dx, dy := to.X-from.X, to.Y-from.Y
+	if dx == 0 && dy == 0 || dx < -1 || dx > 1 || dy < -1 || dy > 1 {
+		return false
+	}
+	if !g.Walkable(to) {
+		return false
+	}
+	if dx != 0 && dy != 0 {
+		if !opts.Diagonal {
+			return false
+		}
+		return g.Walkable(Point{from.X + dx, from.Y}) && g.Walkable(Point{from.X, from.Y + dy})
+	}
+	return true
+}
+
+// FindPath 使用 A* 计算 from 到 to 的最低代价路径，返回的路径不含起点、包含终点。
+// 起点可以位于被占用的格子上，终点必须可通行。
+func FindPath(g *Grid, from, to Point, opts Options) ([]Point, float64, bool) {
+	if !g.InBounds(from) || !g.Walkable(to) {
+		return nil, 0, false
+	}
+	heuristic := func(p Point) float64 {
+		return estimate(p, to, opts) * g.minCost
+	}
+	return search(g, from, opts, func(p Point) bool { return p == to }, heuristic)
+}
+
+// FindNearest 从 from 出发按代价由低到高搜索，返回到达第一个满足 goal 的可通行格子的路径。
+// 起点本身满足条件时返回空路径。
+func FindNearest(g *Grid, from Point, opts Options, goal func(Point) bool) ([]Point, float64, bool) {
+	if !g.InBounds(from) {
+		return nil, 0, false
+	}
+	return search(g, from, opts, func(p Point) bool { return g.Walkable(p) && goal(p) }, func(Point) float64 { return 0 })
+}
+
+// CostField 返回从 from 出发到达每个格子的最低代价，按行优先排列，无法到达的格子为 +Inf。
+func CostField(g *Grid, from Point, opts Options) []float64 {
+	size := g.width * g.height
+	cost := make([]float64, size)
+	for i := range cost {
+		cost[i] = math.Inf(1)
+	}
+	if !g.InBounds(from) {
+		return cost
+	}
+
+	start := from.Y*g.width + from.X
+	cost[start] = 0
+	closed := make([]bool, size)
+	open := &openSet{{index: start}}
+	for open.Len() > 0 {
+		node := heap.Pop(open).(openNode)
+		if closed[node.index] {
+			continue
+		}
+		closed[node.index] = true
+		cur := Point{node.index % g.width, node.index / g.width}
+		for _, step := range stepsFor(opts) {
+			next := Point{cur.X + step.X, cur.Y + step.Y}
+			if !g.CanStep(cur, next, opts) {
+				continue
+			}
+			idx := next.Y*g.width + next.X
+			if candidate := cost[node.index] + stepCost(g, idx, step); candidate < cost[idx] {
+				cost[idx] = candidate
+				heap.Push(open, openNode{index: idx, priority: candidate})
+			}
+		}
+	}
+	return cost
+}
+
+var (
+	orthogonalSteps = []Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	diagonalSteps   = []Point{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+)
+
+func search(g *Grid, from Point, opts Options, done func(Point) bool, heuristic func(Point) float64) ([]Point, float64, bool) {
+	if done(from) {
+		return nil, 0, true
+	}
+
+	size := g.width * g.height
+	cost := make([]float64, size)
+	parent := make([]int32, size)
+	closed := make([]bool, size)
+	for i := range cost {
+		cost[i] = math.Inf(1)
+		parent[i] = -1
+	}
+
+	start := from.Y*g.width + from.X
+	cost[start] = 0
+	open := &openSet{{index: start, priority: heuristic(from)}}
+
+	steps := stepsFor(opts)
+	for open.Len() > 0 {
+		node := heap.Pop(open).(openNode)
+		if closed[node.index] {
+			continue
+		}
+		closed[node.index] = true
+		cur := Point{node.index % g.width, node.index / g.width}
+		if node.index != start && done(cur) {
+			return reconstruct(g, parent, start, node.index), cost[node.index], true
+		}
+
+		for _, step := range steps {
+			next := Point{cur.X + step.X, cur.Y + step.Y}
+			if !g.CanStep(cur, next, opts) {
+				continue
+			}
+			idx := next.Y*g.width + next.X
+			if closed[idx] {
+				continue
+			}
+			if candidate := cost[node.index] + stepCost(g, idx, step); candidate < cost[idx] {
+				cost[idx] = candidate
+				parent[idx] = int32(node.index)
+				heap.Push(open, openNode{index: idx, priority: candidate + heuristic(next)})
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+func stepsFor(opts Options) []Point {
+	if !opts.Diagonal {
+		return orthogonalSteps
+	}
+	return append(append([]Point{}, orthogonalSteps...), diagonalSteps...)
+}
+
+// stepCost 返回迈入 idx 格子的代价，斜向移动乘以 √2。
+func stepCost(g *Grid, idx int, step Point) float64 {
+	cost := g.costs[idx]
+	if step.X != 0 && step.Y != 0 {
+		cost *= math.Sqrt2
+	}
+	return cost
+}
+
+func reconstruct(g *Grid, parent []int32, start, end int) []Point {
+	var path []Point
+	for idx := end; idx != start; idx = int(parent[idx]) {
+		path = append(path, Point{idx % g.width, idx / g.width})
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// estimate 返回单位代价下的距离下界：四向为曼哈顿距离，斜向为八方向距离。
+func estimate(a, b Point, opts Options) float64 {
+	dx := math.Abs(float64(a.X - b.X))
+	dy := math.Abs(float64(a.Y - b.Y))
+	if !opts.Diagonal {
+		return dx + dy
+	}
+	return dx + dy + (math.Sqrt2-2)*math.Min(dx, dy)
+}
+
+type openNode struct {
+	index    int
+	priority float64
+}
+
+type openSet []openNode
+
+func (o openSet) Len() int            { return len(o) }
+func (o openSet) Less(i, j int) bool  { return o[i].priority < o[j].priority }
+func (o openSet) Swap(i, j int)       { o[i], o[j] = o[j], o[i] }
+func (o *openSet) Push(x interface{}) { *o = append(*o, x.(openNode)) }
+func (o *openSet) Pop() interface{} {
+	old := *o
+	n := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return n
+}
@@ -0,0 +1,107 @@
+package pathfinding
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFindPathRoutesAroundWall(t *testing.T) {
+	grid := NewGrid(6, 5)
+	grid.Block(2, 0, 1, 4)
+
+	path, cost, ok := FindPath(grid, Point{0, 0}, Point{4, 0}, Options{})
+	if !ok {
+		t.Fatalf("expected a path around the wall")
+	}
+	if len(path) != 12 || cost != 12 || path[len(path)-1] != (Point{4, 0}) {
+		t.Fatalf("unexpected path %v cost=%v", path, cost)
+	}
+	for _, p := range path {
+		if !grid.Walkable(p) {
+			t.Fatalf("path crosses blocked tile %v", p)
+		}
+	}
+
+	grid.Block(2, 4, 1, 1)
+	if _, _, ok := FindPath(grid, Point{0, 0}, Point{4, 0}, Options{}); ok {
+		t.Fatalf("expected no path once the wall is closed")
+	}
+}
+
+func TestFindPathDiagonalAndTerrainCost(t *testing.T) {
+	grid := NewGrid(5, 5)
+
+	path, cost, ok := FindPath(grid, Point{0, 0}, Point{3, 3}, Options{Diagonal: true})
+	if !ok || len(path) != 3 || math.Abs(cost-3*math.Sqrt2) > 1e-9 {
+		t.Fatalf("expected straight diagonal, got %v cost=%v", path, cost)
+	}
+
+	grid.Block(1, 0, 1, 1)
+	if grid.CanStep(Point{0, 0}, Point{1, 1}, Options{Diagonal: true}) {
+		t.Fatalf("diagonal step must not cut a blocked corner")
+	}
+
+	rough := NewGrid(3, 3)
+	rough.SetCost(Point{1, 0}, 5)
+	path, cost, ok = FindPath(rough, Point{0, 0}, Point{2, 0}, Options{})
+	if !ok || cost != 4 || path[0] != (Point{0, 1}) {
+		t.Fatalf("expected detour around costly tile, got %v cost=%v", path, cost)
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	grid := NewGrid(5, 5)
+	grid.Block(1, 0, 1, 2)
+
+	path, _, ok := FindNearest(grid, Point{0, 0}, Options{}, func(p Point) bool { return p.X == 2 })
+	if !ok || path[len(path)-1] != (Point{2, 2}) {
+		t.Fatalf("expected nearest reachable tile in column 2, got %v", path)
+	}
+	if path, _, ok := FindNearest(grid, Point{0, 0}, Options{}, func(p Point) bool { return p.X == 0 }); !ok || len(path) != 0 {
+		t.Fatalf("expected empty path when start satisfies goal, got %v", path)
+	}
+}
+
+func TestCacheResetInvalidates(t *testing.T) {
+	cache := NewCache(8)
+	if _, _, ok := cache.FindPath(Point{0, 0}, Point{1, 0}, Options{}); ok {
+		t.Fatalf("expected no result without a grid")
+	}
+
+	cache.Reset(NewGrid(3, 1), 1)
+	path, _, ok := cache.FindPath(Point{0, 0}, Point{2, 0}, Options{})
+	if !ok || len(path) != 2 {
+		t.Fatalf("expected path on open grid, got %v", path)
+	}
+	path[0] = Point{9, 9}
+	if again, _, _ := cache.FindPath(Point{0, 0}, Point{2, 0}, Options{}); again[0] != (Point{1, 0}) {
+		t.Fatalf("cached path must not be shared with callers, got %v", again)
+	}
+
+	blocked := NewGrid(3, 1)
+	blocked.Block(1, 0, 1, 1)
+	cache.Reset(blocked, 2)
+	if _, version := cache.Current(); version != 2 {
+		t.Fatalf("expected version 2, got %d", version)
+	}
+	if _, _, ok := cache.FindPath(Point{0, 0}, Point{2, 0}, Options{}); ok {
+		t.Fatalf("expected reset to drop the cached path")
+	}
+}
+
+func TestCostFieldMatchesFindPath(t *testing.T) {
+	grid := NewGrid(6, 5)
+	grid.Block(2, 0, 1, 4)
+	grid.SetCost(Point{4, 4}, 3)
+
+	field := CostField(grid, Point{0, 0}, Options{})
+	for _, target := range []Point{{4, 0}, {5, 4}, {1, 3}} {
+		_, cost, ok := FindPath(grid, Point{0, 0}, target, Options{})
+		if !ok || field[target.Y*6+target.X] != cost {
+			t.Fatalf("target %v: field %v, FindPath %v (ok=%v)", target, field[target.Y*6+target.X], cost, ok)
+		}
+	}
+	if !math.IsInf(field[2], 1) {
+		t.Fatalf("expected blocked tile to be unreachable, got %v", field[2])
+	}
+}
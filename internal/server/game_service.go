@@ -11,14 +11,41 @@ type GameService interface {
 	Scene() game.Scene
 	Snapshot() game.Snapshot
 	UpdateSceneConfig(context.Context, game.UpdateSceneConfigInput) (game.Snapshot, error)
+	Terrain() game.TerrainLayer
+	UpdateSceneTerrain(context.Context, [][2]int) (game.TerrainLayer, error)
+	PaintSceneTerrain(context.Context, []game.TerrainPaint) (game.TerrainLayer, error)
+	GenerateScene(context.Context, game.GenerateSceneInput) (game.GenerateSceneResult, error)
 	UpdateBuildingTemplate(context.Context, game.UpdateBuildingTemplateInput) (game.Snapshot, error)
 	UpdateAgentTemplate(context.Context, game.UpdateAgentTemplateInput) (game.Snapshot, error)
+	DeleteBuildingTemplate(context.Context, game.DeleteTemplateInput) (game.TemplateDeletionResult, error)
+	DeleteAgentTemplate(context.Context, game.DeleteTemplateInput) (game.TemplateDeletionResult, error)
 	UpdateSceneBuilding(context.Context, game.UpdateSceneBuildingInput) (game.Snapshot, error)
 	ListSceneBuildings(context.Context, string, int) ([]game.SceneBuilding, error)
 	DeleteSceneBuilding(context.Context, string) (game.Snapshot, error)
+	DescribeSceneBuilding(context.Context, string) (game.BuildingInheritance, error)
 	UpdateSceneAgent(context.Context, game.UpdateSceneAgentInput) (game.Snapshot, error)
+	DeleteSceneAgent(context.Context, game.DeleteSceneAgentInput) (game.Snapshot, error)
+	SpawnSceneAgent(context.Context, game.SpawnSceneAgentInput) (game.SpawnSceneAgentResult, error)
 	UpdateBuildingEnergyCurrent(context.Context, string, float64) (game.SceneBuilding, error)
-	AdvanceEnergyState(context.Context, float64, float64) (game.Scene, error)
+	AdvanceSimulation(context.Context, float64, float64) (game.Scene, error)
+	SubscribeSceneChanges() (<-chan game.Scene, func())
 	UpdateAgentRuntimePosition(context.Context, string, float64, float64) (game.SceneAgent, error)
-	MaintainEnergyNonNegative(context.Context, string) (game.MaintainEnergyResult, error)
+	SetAgentPath(context.Context, game.SetAgentPathInput) (game.SceneAgent, error)
+	CancelAgentPath(context.Context, string) (game.SceneAgent, error)
+	FindPath(game.FindPathInput) (game.PathResult, error)
+	SuggestPlacement(game.PlacementQuery) (game.PlacementPlan, error)
+	ExecuteAgentAction(context.Context, game.ExecuteAgentActionInput) (game.AgentActionResult, error)
+	Behaviors() []game.BehaviorSpec
+	RunBehavior(context.Context, game.RunBehaviorInput) (game.BehaviorResult, error)
+	AssignAgentGoal(context.Context, game.AssignGoalInput) (game.Goal, error)
+	ListAgentGoals(context.Context, string) ([]game.Goal, error)
+	DeleteAgentGoal(context.Context, string, int64) error
+	CreateJob(context.Context, game.CreateJobInput) (game.Job, error)
+	ListJobs(context.Context, string) ([]game.Job, error)
+	GetJob(context.Context, int64) (game.Job, error)
+	CancelJob(context.Context, int64) (game.Job, error)
+	UpgradeSceneBuilding(context.Context, game.UpgradeSceneBuildingInput) (game.UpgradeBuildingResult, error)
+	BuildingUpgrades(context.Context, string) (game.BuildingUpgradeOverview, error)
+	UpdateBuildingUpgrade(context.Context, game.UpdateBuildingUpgradeInput) (game.Snapshot, error)
+	DeleteBuildingUpgrade(context.Context, string, string) (game.Snapshot, error)
 }
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// patchOp 为 RFC 6902 JSON Patch 操作，remove 不带 value；MessagePack 模式下 value 为 MessagePack 编码。
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// diffJSON 比较两份由 encoding/json 解码出的文档，返回把 before 变为 after 的补丁。
+func diffJSON(ops []patchOp, path string, before, after interface{}) []patchOp {
+	return diffDocs(streamJSON, ops, path, before, after)
+}
+
+// diffDocs 比较两份由 enc 解码出的文档，补丁中的值以 enc 编码。
+// 对象按键递归比较；数组按下标逐项比较，多出的项追加在末尾，缺少的项从末尾起删除。
+// MessagePack 模式下结构体编码为数组，路径片段为字段下标。
+func diffDocs(enc streamEncoding, ops []patchOp, path string, before, after interface{}) []patchOp {
+	switch a := after.(type) {
+	case map[string]interface{}:
+		if b, ok := before.(map[string]interface{}); ok {
+			for _, key := range sortedKeys(b) {
+				if _, exists := a[key]; !exists {
+					ops = append(ops, patchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+				}
+			}
+			for _, key := range sortedKeys(a) {
+				child := path + "/" + escapePointer(key)
+				if old, exists := b[key]; exists {
+					ops = diffDocs(enc, ops, child, old, a[key])
+				} else {
+					ops = append(ops, newPatchOp(enc, "add", child, a[key]))
+				}
+			}
+			return ops
+		}
+	case []interface{}:
+		if b, ok := before.([]interface{}); ok {
+			shared := min(len(a), len(b))
+			for i := 0; i < shared; i++ {
+				ops = diffDocs(enc, ops, path+"/"+strconv.Itoa(i), b[i], a[i])
+			}
+			for i := len(b) - 1; i >= len(a); i-- {
+				ops = append(ops, patchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+			}
+			for i := len(b); i < len(a); i++ {
+				ops = append(ops, newPatchOp(enc, "add", path+"/"+strconv.Itoa(i), a[i]))
+			}
+			return ops
+		}
+	}
+	if !reflect.DeepEqual(before, after) {
+		ops = append(ops, newPatchOp(enc, "replace", path, after))
+	}
+	return ops
+}
+
+func newPatchOp(enc streamEncoding, op, path string, value interface{}) patchOp {
+	raw, err := enc.marshal(value)
+	if err != nil {
+		raw, _ = enc.marshal(nil)
+	}
+	return patchOp{Op: op, Path: path, Value: raw}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer 按 RFC 6901 转义 JSON Pointer 中的单个片段。
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
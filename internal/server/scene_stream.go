@@ -1,11 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"eeo/backend/internal/service/game"
@@ -22,17 +27,101 @@ type sceneStream struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	mu          sync.RWMutex
-	clients     map[*sceneStreamClient]struct{}
-	lastPayload []byte
+	// quit 通知 loop 在当前 tick 结束后退出，loopDone 在 loop 退出时关闭；lastTick 为最近一次 tick 的时间，由 stateMu 保护。
+	quit     chan struct{}
+	quitOnce sync.Once
+	loopDone chan struct{}
+	lastTick time.Time
+
+	// changes 接收服务发布的场景变化，unsubscribe 在停止时取消订阅。
+	changes     <-chan game.Scene
+	unsubscribe func()
+
+	// mu 保护客户端集合与连接名额，maxClients 与 maxSpectators 为连接总数与旁观客户端数的上限；
+	// closing 在停机时置位，之后不再接受新连接。
+	mu            sync.RWMutex
+	closing       bool
+	clients       map[*sceneStreamClient]struct{}
+	connections   int
+	spectators    int
+	maxClients    int
+	maxSpectators int
+
+	// stateMu 串行化场景发布，保证补丁按序号入队，且新订阅的快照与之后的补丁首尾相接。
+	// seq 在场景每次变化时加 1；views 只保存至少有一个客户端订阅的主题。
+	stateMu   sync.Mutex
+	seq       uint64
+	lastRaw   json.RawMessage
+	lastScene game.Scene
+	views     map[viewKey]*topicState
+	jobStatus map[int64]string
+
+	// throttle 为限频组下次允许推送的时间，pending 为到期前场景发生过变化、等待 flush 的组，
+	// pendingEvents 为各 events 订阅组尚未下发的事件。flushTimer 在最早的待推送组到期时调用 flush。
+	throttle      map[viewGroup]time.Time
+	pending       map[viewGroup]struct{}
+	pendingEvents map[viewKey][]streamEvent
+	flushTimer    *time.Timer
+
+	// history 为最近场景帧的回放缓冲，供带 since 重连的客户端补齐错过的消息。
+	history      []streamFrame
+	historyBytes int
+}
+
+const (
+	streamMessageSnapshot = "snapshot"
+	streamMessagePatch    = "patch"
+	streamMessageResync   = "resync"
+	streamMessageEvent    = "event"
+)
+
+// sceneStreamMessage 为场景流消息：订阅主题时与客户端请求重同步时下发 snapshot，之后主题视图每次变化下发 patch。
+// seq 为场景序号，同一连接上单调不减；prev 为该主题上一次变化的 seq，大于客户端该主题最后收到的 seq 时
+// 说明有遗漏，客户端发送 {"type":"resync","topic":...} 重新获取快照。scene 主题的快照放在 scene 字段，其他主题放在 data 字段。
+// 客户端以 command 消息发送命令，服务端以 ack 或 error 回复并带回相同的 id。
+// 以 MessagePack 编码时 scene、data、result 与补丁值保存的是 MessagePack 编码的内容。
+type sceneStreamMessage struct {
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic,omitempty"`
+	Seq     uint64          `json:"seq"`
+	Prev    uint64          `json:"prev,omitempty"`
+	Scene   json.RawMessage `json:"scene,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Ops     []patchOp       `json:"ops,omitempty"`
+	Events  []streamEvent   `json:"events,omitempty"`
+	Reason  string          `json:"reason,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Command string          `json:"command,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *streamError    `json:"error,omitempty"`
 }
 
 type sceneStreamClient struct {
-	stream *sceneStream
-	conn   *websocket.Conn
-	send   chan string
-	once   sync.Once
-	done   chan struct{}
+	stream    *sceneStream
+	transport streamTransport
+	group     viewGroup
+	send      chan streamPayload
+	once      sync.Once
+	done      chan struct{}
+	// finished 在 run 结束连接后关闭。
+	finished chan struct{}
+
+	// id、role 与 connectedAt 为在线状态中的客户端标识，controlling 为通过命令操控过的 Agent，
+	// sentSeq 为已写出的消息覆盖到的场景序号，checkpointed 为最近一次入队的检查点序号，由 stream.stateMu 保护。
+	id           string
+	role         string
+	connectedAt  time.Time
+	sentSeq      atomic.Uint64
+	checkpointed uint64
+
+	mu          sync.Mutex
+	topics      map[string]struct{}
+	controlling map[string]struct{}
+
+	// closeCode 与 closeReason 在 done 关闭前写入，非空时结束连接前告知客户端关闭原因。
+	closeCode   int
+	closeReason string
 }
 
 func newSceneStream(svc GameService, interval time.Duration, seconds, drainFactor float64) *sceneStream {
@@ -47,16 +136,33 @@ func newSceneStream(svc GameService, interval time.Duration, seconds, drainFacto
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	changes, unsubscribe := svc.SubscribeSceneChanges()
 
 	stream := &sceneStream{
-		service:     svc,
-		interval:    interval,
-		seconds:     seconds,
-		drainFactor: drainFactor,
-		ctx:         ctx,
-		cancel:      cancel,
-		clients:     make(map[*sceneStreamClient]struct{}),
+		service:       svc,
+		interval:      interval,
+		seconds:       seconds,
+		drainFactor:   drainFactor,
+		ctx:           ctx,
+		cancel:        cancel,
+		quit:          make(chan struct{}),
+		loopDone:      make(chan struct{}),
+		lastTick:      time.Now(),
+		changes:       changes,
+		unsubscribe:   unsubscribe,
+		clients:       make(map[*sceneStreamClient]struct{}),
+		maxClients:    maxStreamClients,
+		maxSpectators: maxStreamSpectators,
+		views:         make(map[viewKey]*topicState),
+		throttle:      make(map[viewGroup]time.Time),
+		pending:       make(map[viewGroup]struct{}),
+		pendingEvents: make(map[viewKey][]streamEvent),
+
+		// 以毫秒时间戳为序号起点，保证重启后的序号大于重启前客户端记录的序号。
+		seq: uint64(time.Now().UnixMilli()),
 	}
+	stream.flushTimer = time.AfterFunc(maxStreamInterval, stream.flush)
+	stream.flushTimer.Stop()
 
 	go stream.loop()
 
@@ -66,127 +172,283 @@ func newSceneStream(svc GameService, interval time.Duration, seconds, drainFacto
 func (s *sceneStream) loop() {
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
+	defer close(s.loopDone)
 
 	for {
 		select {
 		case <-ticker.C:
 			s.tick()
+		case scene := <-s.changes:
+			s.broadcast(scene)
+
+		case <-s.quit:
+			return
 		case <-s.ctx.Done():
 			return
 		}
 	}
 }
 
+// tick 推进一次模拟，结果经服务的场景变化订阅推送。
 func (s *sceneStream) tick() {
-	scene, err := s.service.AdvanceEnergyState(s.ctx, s.seconds, s.drainFactor)
-	if err != nil {
-		log.Printf("sceneStream: advance energy failed: %v", err)
-		return
+	s.stateMu.Lock()
+	s.lastTick = time.Now()
+	s.stateMu.Unlock()
+	if _, err := s.service.AdvanceSimulation(s.ctx, s.seconds, s.drainFactor); err != nil {
+		log.Printf("sceneStream: advance simulation failed: %v", err)
 	}
+}
 
-	payload, err := json.Marshal(scene)
-	if err != nil {
-		log.Printf("sceneStream: marshal scene failed: %v", err)
+// handle 建立场景流 WebSocket 连接，可通过重复的 topic 查询参数指定初始订阅，缺省订阅 scene；
+// 重连时以 since 传入最后收到的 seq，服务端回放其后的消息。客户端以子协议 scene.msgpack 协商 MessagePack 编码，
+// 以 rate 限制每秒推送次数。
+func (s *sceneStream) handle(c *gin.Context) {
+	query, ok := parseStreamQuery(c, "")
+	if !ok || !s.admit(c, query) {
 		return
 	}
+	defer s.release(query)
 
-	s.mu.Lock()
-	s.lastPayload = payload
-	clients := make([]*sceneStreamClient, 0, len(s.clients))
-	for client := range s.clients {
-		clients = append(clients, client)
-	}
-	s.mu.Unlock()
-
-	for _, client := range clients {
-		client.enqueue(payload)
-	}
-}
-
-func (s *sceneStream) handle(c *gin.Context) {
+	encoding := streamJSON
 	wsServer := websocket.Server{
 		Handshake: func(cfg *websocket.Config, req *http.Request) error {
+			encoding, cfg.Protocol = negotiateStreamEncoding(cfg.Protocol)
 			return nil
 		},
 		Handler: func(conn *websocket.Conn) {
-			s.addClient(conn)
+			// http.Server 的读写超时只适用于普通请求，长连接的写超时由 run 管理。
+			_ = conn.SetDeadline(time.Time{})
+			client := s.addClient(&websocketTransport{conn: conn, encoding: encoding}, query)
+			go client.readLoop(conn)
+			client.run()
 		},
 	}
 
 	wsServer.ServeHTTP(c.Writer, c.Request)
 }
 
-func (s *sceneStream) addClient(conn *websocket.Conn) {
+// parseStreamQuery 解析 clientId、role、topic、since 与 rate 查询参数，lastEventID 非空时代替 since；
+// 参数无效时返回 400 并返回 false。
+func parseStreamQuery(c *gin.Context, lastEventID string) (streamQuery, bool) {
+	query := streamQuery{topics: []string{streamTopicScene}}
+	for _, param := range []struct {
+		name   string
+		target *string
+	}{{"clientId", &query.clientID}, {"role", &query.role}} {
+		value, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+		parsed, err := parsePresenceName(param.name, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return streamQuery{}, false
+		}
+		*param.target = parsed
+	}
+
+	value := c.Query("since")
+	if lastEventID != "" {
+		value = lastEventID
+	}
+	if value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "since must be a non-negative integer"})
+			return streamQuery{}, false
+		}
+		query.since = &parsed
+	}
+
+	if values := c.QueryArray("topic"); len(values) > 0 {
+		var err error
+		if query.topics, err = parseStreamTopics(values); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return streamQuery{}, false
+		}
+	}
+
+	var err error
+	if query.interval, err = parseStreamRate(c.Query("rate")); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return streamQuery{}, false
+	}
+	return query, true
+}
+
+func newSceneStreamClient(s *sceneStream, transport streamTransport, topics []string, backlog int) *sceneStreamClient {
 	client := &sceneStreamClient{
-		stream: s,
-		conn:   conn,
-		send:   make(chan string, 8),
-		done:   make(chan struct{}),
+		stream:    s,
+		transport: transport,
+		group:     viewGroup{encoding: transport.messageEncoding()},
+		send:      make(chan streamPayload, streamSendBuffer+backlog),
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+		topics:    make(map[string]struct{}, len(topics)),
+
+		id:          newStreamClientID(),
+		role:        defaultStreamRole,
+		connectedAt: time.Now(),
+		controlling: make(map[string]struct{}),
 	}
+	for _, topic := range topics {
+		client.topics[topic] = struct{}{}
+	}
+	return client
+}
 
+// addClient 登记客户端并放入首批消息，调用方启动读循环（如有）后调用 run。
+func (s *sceneStream) addClient(transport streamTransport, query streamQuery) *sceneStreamClient {
+	// 先把最新场景作为补丁发给已有客户端，再登记新客户端并下发快照或回放，避免新客户端重复收到这次变化。
+	// 发送队列额外容纳首批消息，回放较多时不会被当作慢消费者断开。
+	group := viewGroup{encoding: transport.messageEncoding(), interval: query.interval}
+	s.stateMu.Lock()
+	s.deliver(s.publishLocked(s.service.Scene()))
+	payloads := s.initialPayloadsLocked(group, query.topics, query.since)
+	client := newSceneStreamClient(s, transport, query.topics, len(payloads)+1)
+	client.group = group
+	if query.clientID != "" {
+		client.id = query.clientID
+	}
+	if query.role != "" {
+		client.role = query.role
+	}
 	s.mu.Lock()
 	s.clients[client] = struct{}{}
+	closing := s.closing
 	s.mu.Unlock()
-
-	if payload := s.initialPayload(); len(payload) > 0 {
+	for _, payload := range payloads {
 		client.enqueue(payload)
 	}
+	client.checkpoint(s.seq)
+	s.announceLocked(presenceJoin, client)
+	s.stateMu.Unlock()
 
-	go client.writeLoop()
-	go client.readLoop()
+	// 名额在停机开始前占用的连接可能晚于 drain 登记，直接告知客户端服务正在重启。
+	if closing {
+		client.closeWith(streamCloseGoingAway, streamCloseServerRestarting)
+	}
 
-	client.wait()
+	return client
 }
 
-func (s *sceneStream) initialPayload() []byte {
-	s.mu.RLock()
-	if s.lastPayload != nil {
-		payload := make([]byte, len(s.lastPayload))
-		copy(payload, s.lastPayload)
-		s.mu.RUnlock()
-		return payload
-	}
-	s.mu.RUnlock()
+// broadcast 向订阅者推送场景相对上次推送的变化，场景未变化时不推送。
+func (s *sceneStream) broadcast(scene game.Scene) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 
-	payload, err := json.Marshal(s.service.Scene())
+	s.deliver(s.publishLocked(scene))
+}
+
+// publishLocked 将场景记为最新状态，只为有订阅者的主题计算视图，返回各主题需要立即推送的消息；
+// 限频的组未到推送时间时由 flush 稍后推送。场景未变化时返回 nil。调用方需持有 stateMu。
+func (s *sceneStream) publishLocked(scene game.Scene) []topicMessage {
+	raw, err := json.Marshal(scene)
 	if err != nil {
-		log.Printf("sceneStream: marshal initial scene failed: %v", err)
+		log.Printf("sceneStream: marshal scene failed: %v", err)
 		return nil
 	}
-	return payload
+	if s.lastRaw != nil && bytes.Equal(raw, s.lastRaw) {
+		return nil
+	}
+	s.seq++
+	s.lastRaw, s.lastScene = raw, scene
+
+	active := s.activeTopics()
+	s.pruneLocked(active)
+	if s.eventsActive(active) {
+		s.queueEventsLocked(active, s.sceneEventsLocked(scene))
+	} else {
+		s.jobStatus = nil
+	}
+
+	messages := s.collectLocked(active, false)
+	s.recordFrameLocked(messages)
+	return messages
 }
 
-func (s *sceneStream) broadcast(scene game.Scene) {
-	payload, err := json.Marshal(scene)
+// eventsActive 判断是否有客户端以任一编码订阅 events 主题。
+func (s *sceneStream) eventsActive(active map[viewKey]struct{}) bool {
+	for key := range active {
+		if key.topic == streamTopicEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsMessageLocked 以 enc 编码本次场景产生的事件消息，没有事件时返回 nil。调用方需持有 stateMu。
+func (s *sceneStream) eventsMessageLocked(enc streamEncoding, events []streamEvent) []byte {
+	if len(events) == 0 {
+		return nil
+	}
+	payload, err := enc.encodeMessage(sceneStreamMessage{Type: streamMessageEvent, Topic: streamTopicEvents, Seq: s.seq, Events: events})
 	if err != nil {
-		log.Printf("sceneStream: marshal scene failed: %v", err)
-		return
+		log.Printf("sceneStream: marshal events failed: %v", err)
+		return nil
 	}
+	return payload
+}
 
-	s.mu.Lock()
-	s.lastPayload = payload
-	clients := make([]*sceneStreamClient, 0, len(s.clients))
-	for client := range s.clients {
-		clients = append(clients, client)
+// resync 向客户端重新下发主题快照，topic 为空时重发其订阅的全部主题。
+func (s *sceneStream) resync(client *sceneStreamClient, topic string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	topics := client.subscribedTopics()
+	for _, name := range topics {
+		if topic == "" || topic == name {
+			key := viewKey{viewGroup: client.group, topic: name}
+			s.ensureTopicLocked(key)
+			client.enqueue(s.topicSnapshotLocked(key))
+		}
 	}
-	s.mu.Unlock()
+}
 
-	for _, client := range clients {
-		client.enqueue(payload)
+// activeTopics 返回各组至少有一个客户端订阅的主题。
+func (s *sceneStream) activeTopics() map[viewKey]struct{} {
+	active := make(map[viewKey]struct{})
+	for _, client := range s.snapshotClients() {
+		for _, topic := range client.subscribedTopics() {
+			active[viewKey{viewGroup: client.group, topic: topic}] = struct{}{}
+		}
 	}
+	return active
 }
 
-func (s *sceneStream) stop() {
-	s.cancel()
+// deliver 把各主题的消息发给同组中订阅了该主题的客户端，并把没有待推送变化的客户端标记为已覆盖到当前序号：
+// 订阅的主题本次没有变化的客户端同样是最新的，限频组到期推送后才标记。调用方需持有 stateMu。
+func (s *sceneStream) deliver(messages []topicMessage) {
+	for _, client := range s.snapshotClients() {
+		for _, message := range messages {
+			if message.key.viewGroup == client.group && client.wants(message.key.topic) {
+				client.enqueue(message.payload)
+			}
+		}
+		if _, waiting := s.pending[client.group]; !waiting && client.checkpointed < s.seq {
+			client.checkpoint(s.seq)
+		}
+	}
+}
 
-	s.mu.Lock()
+func (s *sceneStream) snapshotClients() []*sceneStreamClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	clients := make([]*sceneStreamClient, 0, len(s.clients))
 	for client := range s.clients {
 		clients = append(clients, client)
 	}
-	s.mu.Unlock()
+	return clients
+}
 
-	for _, client := range clients {
+func (s *sceneStream) stop() {
+	s.cancel()
+	s.unsubscribe()
+	s.stateMu.Lock()
+	s.flushTimer.Stop()
+	s.stateMu.Unlock()
+
+	for _, client := range s.snapshotClients() {
 		client.close()
 	}
 }
@@ -195,23 +457,52 @@ func (c *sceneStreamClient) enqueue(payload []byte) {
 	if len(payload) == 0 {
 		return
 	}
-	message := string(payload)
+	c.push(streamPayload{data: string(payload)})
+}
+
+// checkpoint 标记此前入队的消息已覆盖到场景序号 seq，SSE 据此更新 Last-Event-ID。调用方需持有 stream.stateMu。
+func (c *sceneStreamClient) checkpoint(seq uint64) {
+	c.checkpointed = seq
+	c.push(streamPayload{checkpoint: seq})
+}
+
+func (c *sceneStreamClient) push(item streamPayload) {
 	select {
-	case c.send <- message:
+	case c.send <- item:
+	case <-c.done:
 	default:
-		c.close()
+		c.closeWith(streamClosePolicyViolation, streamCloseSlowConsumer)
 	}
 }
 
 func (c *sceneStreamClient) close() {
+	c.closeWith(0, "")
+}
+
+// closeWith 注销客户端并通知读写循环退出，reason 非空时由 finish 告知客户端关闭原因。
+func (c *sceneStreamClient) closeWith(code int, reason string) {
 	c.once.Do(func() {
+		c.closeCode, c.closeReason = code, reason
 		c.stream.removeClient(c)
 		close(c.done)
 	})
 }
 
-func (c *sceneStreamClient) wait() {
+// run 启动写循环并阻塞到客户端关闭。写循环可能阻塞在读取缓慢的客户端上，关闭后先设置写超时令其退出，
+// 再由当前 goroutine 独占连接结束会话；SSE 的处理函数返回后不能再写入响应。
+func (c *sceneStreamClient) run() {
+	defer close(c.finished)
+	defer c.stream.announce(presenceLeave, c)
+	writer := make(chan struct{})
+	go func() {
+		defer close(writer)
+		c.writeLoop()
+	}()
+
 	<-c.done
+	c.transport.deadline(time.Now().Add(streamCloseWriteGracePeriod))
+	<-writer
+	c.finish()
 }
 
 func (s *sceneStream) removeClient(client *sceneStreamClient) {
@@ -220,28 +511,92 @@ func (s *sceneStream) removeClient(client *sceneStreamClient) {
 		delete(s.clients, client)
 	}
 	s.mu.Unlock()
-
-	close(client.send)
-	_ = client.conn.Close()
 }
 
 func (c *sceneStreamClient) writeLoop() {
 	defer c.close()
 
-	for payload := range c.send {
-		if err := websocket.Message.Send(c.conn, payload); err != nil {
+	// send 不会被关闭，客户端关闭后可能仍有并发的 enqueue，退出以 done 为准。
+	for {
+		select {
+		case item := <-c.send:
+			var err error
+			if item.data == "" {
+				if err = c.transport.checkpoint(item.checkpoint); err == nil {
+					c.sentSeq.Store(item.checkpoint)
+				}
+			} else {
+				err = c.transport.write(item.data)
+			}
+			if err != nil {
+				return
+			}
+		case <-c.done:
 			return
 		}
 	}
 }
 
-func (c *sceneStreamClient) readLoop() {
+// readLoop 读取 WebSocket 客户端发来的消息，SSE 客户端没有读循环。
+func (c *sceneStreamClient) readLoop(conn *websocket.Conn) {
 	defer c.close()
 
 	for {
-		var message string
-		if err := websocket.Message.Receive(c.conn, &message); err != nil {
+		var message []byte
+		if err := websocket.Message.Receive(conn, &message); err != nil {
 			return
 		}
+		c.stream.handleMessage(c, message)
+	}
+}
+
+func (c *sceneStreamClient) wants(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.topics[topic]
+	return ok
+}
+
+// addTopics 添加订阅主题，返回此前未订阅的主题；订阅总数超过 maxStreamTopics 时不做修改并返回错误。
+func (c *sceneStreamClient) addTopics(topics []string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var added []string
+	for _, topic := range topics {
+		if _, ok := c.topics[topic]; !ok {
+			added = append(added, topic)
+		}
+	}
+	if len(c.topics)+len(added) > maxStreamTopics {
+		return nil, fmt.Errorf("at most %d topics allowed", maxStreamTopics)
+	}
+	for _, topic := range added {
+		c.topics[topic] = struct{}{}
+	}
+	return added, nil
+}
+
+// unsubscribe 取消订阅主题，返回订阅是否发生变化。
+func (c *sceneStreamClient) unsubscribe(topics []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	for _, topic := range topics {
+		if _, ok := c.topics[topic]; ok {
+			delete(c.topics, topic)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (c *sceneStreamClient) subscribedTopics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
 	}
+	sort.Strings(topics)
+	return topics
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
@@ -20,6 +21,7 @@ import (
 type Server struct {
 	cfg         config.Config
 	engine      *gin.Engine
+	httpServer  *http.Server
 	gameSvc     GameService
 	actionSvc   *actionservice.Service
 	sceneStream *sceneStream
@@ -53,12 +55,39 @@ func New(cfg config.Config, gameSvc GameService, actionSvc *actionservice.Servic
 
 	srv.registerRoutes()
 
+	srv.httpServer = &http.Server{
+		Addr:         cfg.HTTP.Address(),
+		Handler:      srv.engine,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
+	}
+
 	return srv
 }
 
-// Run 监听并启动 HTTP 服务。
+// Run 监听并启动 HTTP 服务，阻塞到服务出错或 Shutdown 被调用；因 Shutdown 停止时返回 nil，
+// 调用方需等待 Shutdown 返回后再释放依赖。
 func (s *Server) Run() error {
-	return s.engine.Run(s.cfg.HTTP.Address())
+	log.Printf("http server listening on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 优雅关闭服务：先停止模拟并以 1001 server-restarting 关闭场景流连接，再停止监听并等待进行中的请求结束，
+// 最后推进一次模拟保存停机前的进度。ctx 结束时不再等待并返回错误。
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.sceneStream != nil {
+		s.sceneStream.drain(ctx)
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if s.sceneStream != nil {
+		s.sceneStream.finalTick(ctx)
+		s.sceneStream.stop()
+	}
+	return err
 }
 
 func (s *Server) registerRoutes() {
@@ -72,21 +101,46 @@ func (s *Server) registerRoutes() {
 		{
 			gameRoutes.GET("/scene", s.getGameScene)
 			gameRoutes.GET("/scene/stream", s.streamGameScene)
+			gameRoutes.GET("/scene/events", s.streamGameSceneEvents)
+			gameRoutes.GET("/scene/stream/schema", s.getGameSceneStreamSchema)
+			gameRoutes.GET("/scene/presence", s.getGameScenePresence)
 			gameRoutes.POST("/scene/buildings/:buildingID/energy", s.updateGameBuildingEnergy)
+			gameRoutes.GET("/scene/buildings/:buildingID/upgrades", s.listGameBuildingUpgrades)
+			gameRoutes.POST("/scene/buildings/:buildingID/upgrade", s.upgradeGameBuilding)
+			gameRoutes.POST("/scene/agents", s.spawnGameAgent)
 			gameRoutes.PUT("/scene/agents/:agentID/position", s.updateGameAgentPosition)
-			gameRoutes.Any("/scene/agents/:agentID/behaviors/maintain-energy", s.handleMaintainEnergy)
+			gameRoutes.PUT("/scene/agents/:agentID/path", s.setGameAgentPath)
+			gameRoutes.DELETE("/scene/agents/:agentID/path", s.cancelGameAgentPath)
+			gameRoutes.GET("/scene/path", s.findGamePath)
+			gameRoutes.GET("/scene/placement", s.suggestGamePlacement)
+			gameRoutes.GET("/behaviors", s.listBehaviors)
+			gameRoutes.GET("/scene/jobs", s.listGameJobs)
+			gameRoutes.POST("/scene/jobs", s.createGameJob)
+			gameRoutes.GET("/scene/jobs/:jobID", s.getGameJob)
+			gameRoutes.POST("/scene/jobs/:jobID/cancel", s.cancelGameJob)
+			gameRoutes.Any("/scene/agents/:agentID/behaviors/:behaviorID", s.handleAgentBehavior)
 		}
 
 		system := v1.Group("/system")
 		{
 			system.GET("/scene", s.getSystemScene)
 			system.PUT("/scene", s.updateSystemScene)
+			system.GET("/scene/terrain", s.getSystemSceneTerrain)
+			system.PUT("/scene/terrain", s.updateSystemSceneTerrain)
+			system.PATCH("/scene/terrain", s.paintSystemSceneTerrain)
+			system.POST("/scenes/generate", s.generateSystemScene)
 			system.PUT("/templates/buildings/:id", s.updateSystemBuildingTemplate)
+			system.PUT("/templates/buildings/:id/upgrades/:targetID", s.updateSystemBuildingUpgrade)
+			system.DELETE("/templates/buildings/:id/upgrades/:targetID", s.deleteSystemBuildingUpgrade)
+			system.DELETE("/templates/buildings/:id", s.deleteSystemBuildingTemplate)
 			system.PUT("/templates/agents/:id", s.updateSystemAgentTemplate)
+			system.DELETE("/templates/agents/:id", s.deleteSystemAgentTemplate)
+			system.GET("/scene/buildings/:id", s.getSystemSceneBuilding)
 			system.PUT("/scene/buildings/:id", s.updateSystemSceneBuilding)
 			system.DELETE("/scene/buildings/:id", s.deleteSystemSceneBuilding)
 			system.GET("/scene/buildings/preview", s.previewSceneBuildings)
 			system.PUT("/scene/agents/:id", s.updateSystemSceneAgent)
+			system.DELETE("/scene/agents/:id", s.deleteSystemSceneAgent)
 		}
 
 		agents := v1.Group("/agents")
@@ -94,6 +148,9 @@ func (s *Server) registerRoutes() {
 			agents.POST("/:agentID/actions", s.createAgentAction)
 			agents.GET("/:agentID/actions", s.listAgentActions)
 			agents.GET("/:agentID/state", s.getAgentState)
+			agents.GET("/:agentID/goals", s.listAgentGoals)
+			agents.POST("/:agentID/goals", s.createAgentGoal)
+			agents.DELETE("/:agentID/goals/:goalID", s.deleteAgentGoal)
 		}
 	}
 }
@@ -120,6 +177,29 @@ func (s *Server) streamGameScene(c *gin.Context) {
 	s.sceneStream.handle(c)
 }
 
+// streamGameSceneEvents 以 SSE 推送场景流，供不支持 WebSocket 的环境使用。
+func (s *Server) streamGameSceneEvents(c *gin.Context) {
+	if s.sceneStream == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "scene stream is not available"})
+		return
+	}
+	s.sceneStream.handleEvents(c)
+}
+
+// getGameSceneStreamSchema 返回场景流 MessagePack 编码的字段顺序。
+func (s *Server) getGameSceneStreamSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, streamSchema)
+}
+
+// getGameScenePresence 返回当前连接场景流的客户端。
+func (s *Server) getGameScenePresence(c *gin.Context) {
+	if s.sceneStream == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "scene stream is not available"})
+		return
+	}
+	c.JSON(http.StatusOK, StreamPresenceResponse{Clients: s.sceneStream.presence()})
+}
+
 // getSystemScene 返回 system_* 场景快照。
 func (s *Server) getSystemScene(c *gin.Context) {
 	c.JSON(http.StatusOK, s.gameSvc.Snapshot())
@@ -163,6 +243,107 @@ func (s *Server) updateSystemScene(c *gin.Context) {
 	c.JSON(http.StatusOK, snapshot)
 }
 
+// getSystemSceneTerrain 返回场景地形层。
+func (s *Server) getSystemSceneTerrain(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gameSvc.Terrain())
+}
+
+// updateSystemSceneTerrain 以游程整体替换场景地形。
+func (s *Server) updateSystemSceneTerrain(c *gin.Context) {
+	var req TerrainUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	runs := make([][2]int, 0, len(req.Runs))
+	for _, run := range req.Runs {
+		if len(run) != 2 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "each run must contain [code, count]"})
+			return
+		}
+		runs = append(runs, [2]int{run[0], run[1]})
+	}
+
+	terrain, err := s.gameSvc.UpdateSceneTerrain(c.Request.Context(), runs)
+	s.respondTerrain(c, terrain, err)
+}
+
+// paintSystemSceneTerrain 将若干矩形区域涂成指定地形。
+func (s *Server) paintSystemSceneTerrain(c *gin.Context) {
+	var req TerrainPaintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	paints := make([]game.TerrainPaint, 0, len(req.Paints))
+	for _, paint := range req.Paints {
+		if len(paint.Rect) != 4 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "rect must contain [x, y, width, height]"})
+			return
+		}
+		paints = append(paints, game.TerrainPaint{
+			Type: paint.Type,
+			Rect: [4]int{paint.Rect[0], paint.Rect[1], paint.Rect[2], paint.Rect[3]},
+		})
+	}
+
+	terrain, err := s.gameSvc.PaintSceneTerrain(c.Request.Context(), paints)
+	s.respondTerrain(c, terrain, err)
+}
+
+func (s *Server) respondTerrain(c *gin.Context, terrain game.TerrainLayer, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidTerrain) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, terrain)
+}
+
+// generateSystemScene 按种子程序化生成新场景，dryRun 时只返回预览。
+func (s *Server) generateSystemScene(c *gin.Context) {
+	var req GenerateSceneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	result, err := s.gameSvc.GenerateScene(c.Request.Context(), game.GenerateSceneInput{
+		SceneID:   req.SceneID,
+		Name:      req.Name,
+		Seed:      req.Seed,
+		Width:     req.Width,
+		Height:    req.Height,
+		TileSize:  req.TileSize,
+		Buildings: req.Buildings,
+		Agents:    req.Agents,
+		DryRun:    req.DryRun,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, game.ErrInvalidSceneConfig):
+			status = http.StatusBadRequest
+		case errors.Is(err, game.ErrSceneExists), errors.Is(err, game.ErrTemplateDeprecated):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	status := http.StatusCreated
+	if result.DryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, result)
+}
+
 func (s *Server) updateGameBuildingEnergy(c *gin.Context) {
 	buildingID := c.Param("buildingID")
 	if strings.TrimSpace(buildingID) == "" {
@@ -178,7 +359,7 @@ func (s *Server) updateGameBuildingEnergy(c *gin.Context) {
 
 	building, err := s.gameSvc.UpdateBuildingEnergyCurrent(c.Request.Context(), buildingID, req.Current)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		c.JSON(buildingEnergyErrorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -207,55 +388,371 @@ func (s *Server) updateGameAgentPosition(c *gin.Context) {
 	c.JSON(http.StatusOK, agent)
 }
 
-func (s *Server) handleMaintainEnergy(c *gin.Context) {
-	if c.Request.Method != http.MethodPost {
-		c.JSON(http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed, use POST"})
+// setGameAgentPath 为 Agent 指定移动路径，Agent 随后按模板速度在每个 tick 中沿路径移动。
+func (s *Server) setGameAgentPath(c *gin.Context) {
+	var req AgentPathRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	input, err := req.toInput(c.Param("agentID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	agent, err := s.gameSvc.SetAgentPath(c.Request.Context(), input)
+	if err != nil {
+		s.respondAgentPathError(c, err)
 		return
 	}
-	s.maintainEnergyBalance(c)
+
+	c.JSON(http.StatusOK, agent)
 }
 
-func (s *Server) maintainEnergyBalance(c *gin.Context) {
-	agentID := c.Param("agentID")
-	if strings.TrimSpace(agentID) == "" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "agentID is required"})
+func (s *Server) cancelGameAgentPath(c *gin.Context) {
+	agent, err := s.gameSvc.CancelAgentPath(c.Request.Context(), c.Param("agentID"))
+	if err != nil {
+		s.respondAgentPathError(c, err)
 		return
 	}
 
-	log.Printf("maintainEnergyBalance: start agent=%s", agentID)
+	c.JSON(http.StatusOK, agent)
+}
 
-	result, err := s.gameSvc.MaintainEnergyNonNegative(c.Request.Context(), agentID)
+func (s *Server) respondAgentPathError(c *gin.Context, err error) {
+	c.JSON(agentPathErrorStatus(err), ErrorResponse{Error: err.Error()})
+}
+
+func agentPathErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, game.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, game.ErrInvalidSceneEntity):
+		return http.StatusNotFound
+	case errors.Is(err, game.ErrNoPath):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func buildingEnergyErrorStatus(err error) int {
+	if errors.Is(err, game.ErrInvalidSceneEntity) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// findGamePath 返回当前建筑布局下两个格子之间的最短路径。
+func (s *Server) findGamePath(c *gin.Context) {
+	from, errFrom := parseTileQuery(c.Query("from"))
+	to, errTo := parseTileQuery(c.Query("to"))
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "from and to must be formatted as x,y"})
+		return
+	}
+	diagonal, err := strconv.ParseBool(c.DefaultQuery("diagonal", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "diagonal must be a boolean"})
+		return
+	}
+
+	result, err := s.gameSvc.FindPath(game.FindPathInput{From: from, To: to, Diagonal: diagonal})
+	if err != nil {
+		s.respondAgentPathError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// suggestGamePlacement 返回模板在当前场景中按评分排序的候选放置位置。
+func (s *Server) suggestGamePlacement(c *gin.Context) {
+	templateID := strings.TrimSpace(c.Query("templateId"))
+	if templateID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "templateId is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(game.DefaultPlacementLimit)))
+	if err != nil || limit <= 0 || limit > game.MaxPlacementLimit {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "limit must be between 1 and " + strconv.Itoa(game.MaxPlacementLimit)})
+		return
+	}
+	clearance, err := strconv.Atoi(c.DefaultQuery("clearance", strconv.Itoa(game.DefaultPlacementClearance)))
+	if err != nil || clearance < 0 || clearance > 5 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "clearance must be between 0 and 5"})
+		return
+	}
+
+	plan, err := s.gameSvc.SuggestPlacement(game.PlacementQuery{
+		TemplateID: templateID,
+		AgentID:    c.Query("agentId"),
+		Limit:      limit,
+		Clearance:  clearance,
+	})
 	if err != nil {
-		log.Printf("maintainEnergyBalance: error agent=%s err=%v", agentID, err)
 		status := http.StatusInternalServerError
 		switch {
 		case errors.Is(err, game.ErrInvalidSceneEntity):
+			status = http.StatusNotFound
+		case errors.Is(err, game.ErrTemplateDeprecated):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func parseTileQuery(value string) ([2]int, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return [2]int{}, errors.New("tile must be formatted as x,y")
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return [2]int{}, err
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return [2]int{}, err
+	}
+	return [2]int{x, y}, nil
+}
+
+func (s *Server) spawnGameAgent(c *gin.Context) {
+	var req SpawnAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	input := game.SpawnSceneAgentInput{
+		TemplateID: req.TemplateID,
+		ID:         req.ID,
+		Label:      req.Label,
+		Actions:    req.Actions,
+	}
+	if req.Position != nil {
+		if len(req.Position) != 2 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "position must contain [x, y]"})
+			return
+		}
+		input.Position = &[2]int{req.Position[0], req.Position[1]}
+	}
+
+	result, err := s.gameSvc.SpawnSceneAgent(c.Request.Context(), input)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, game.ErrInvalidTemplate), errors.Is(err, game.ErrInvalidSceneEntity):
 			status = http.StatusBadRequest
-		case errors.Is(err, game.ErrSolarTemplateMissing):
-			status = http.StatusFailedDependency
-		case errors.Is(err, game.ErrNoAvailablePlacement):
+		case errors.Is(err, game.ErrTemplateDeprecated), errors.Is(err, game.ErrNoFreeTile):
 			status = http.StatusConflict
 		}
 		c.JSON(status, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if s.sceneStream != nil {
-		s.sceneStream.broadcast(result.Scene)
+	c.JSON(http.StatusCreated, result)
+}
+
+func (s *Server) listGameJobs(c *gin.Context) {
+	jobs, err := s.gameSvc.ListJobs(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		s.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (s *Server) createGameJob(c *gin.Context) {
+	var req JobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	input := game.CreateJobInput{
+		Kind:       req.Kind,
+		BuildingID: req.BuildingID,
+		TemplateID: req.TemplateID,
+		Priority:   req.Priority,
+		Source:     game.JobSourceAPI,
+	}
+	if req.Target != nil {
+		if len(req.Target) != 2 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "target must contain [x, y]"})
+			return
+		}
+		input.Target = &[2]int{req.Target[0], req.Target[1]}
+	}
+
+	job, err := s.gameSvc.CreateJob(c.Request.Context(), input)
+	if err != nil {
+		s.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, job)
+}
+
+func (s *Server) getGameJob(c *gin.Context) {
+	jobID, err := strconv.ParseInt(c.Param("jobID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "jobID must be an integer"})
+		return
+	}
+
+	job, err := s.gameSvc.GetJob(c.Request.Context(), jobID)
+	if err != nil {
+		s.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Server) cancelGameJob(c *gin.Context) {
+	jobID, err := strconv.ParseInt(c.Param("jobID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "jobID must be an integer"})
+		return
+	}
+
+	job, err := s.gameSvc.CancelJob(c.Request.Context(), jobID)
+	if err != nil {
+		s.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Server) respondJobError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, game.ErrInvalidJob):
+		status = http.StatusBadRequest
+	case errors.Is(err, game.ErrJobNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, game.ErrJobFinished), errors.Is(err, game.ErrTemplateDeprecated):
+		status = http.StatusConflict
+	}
+	c.JSON(status, ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) listGameBuildingUpgrades(c *gin.Context) {
+	buildingID := strings.TrimSpace(c.Param("buildingID"))
+	if buildingID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "buildingID is required"})
+		return
+	}
+
+	overview, err := s.gameSvc.BuildingUpgrades(c.Request.Context(), buildingID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidSceneEntity) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, overview)
+}
+
+func (s *Server) upgradeGameBuilding(c *gin.Context) {
+	buildingID := strings.TrimSpace(c.Param("buildingID"))
+	if buildingID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "buildingID is required"})
+		return
+	}
+
+	var req BuildingUpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	result, err := s.gameSvc.UpgradeSceneBuilding(c.Request.Context(), game.UpgradeSceneBuildingInput{
+		BuildingID:       buildingID,
+		TargetTemplateID: req.TargetTemplateID,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, game.ErrInvalidSceneEntity), errors.Is(err, game.ErrUpgradeUnavailable):
+			status = http.StatusBadRequest
+		case errors.Is(err, game.ErrInsufficientEnergy), errors.Is(err, game.ErrTemplateDeprecated), errors.Is(err, game.ErrTerrainUnsuitable):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
 	}
 
-	response := MaintainEnergyResponse{
-		Scene:         result.Scene,
-		Created:       result.Created,
-		NetFlowBefore: result.NetFlowBefore,
-		NetFlowAfter:  result.NetFlowAfter,
-		TowersBuilt:   result.TowersBuilt,
-		Relocation:    result.Relocation,
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) listBehaviors(c *gin.Context) {
+	c.JSON(http.StatusOK, s.gameSvc.Behaviors())
+}
+
+func (s *Server) handleAgentBehavior(c *gin.Context) {
+	if c.Request.Method != http.MethodPost {
+		c.JSON(http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed, use POST"})
+		return
+	}
+	s.runAgentBehavior(c)
+}
+
+func (s *Server) runAgentBehavior(c *gin.Context) {
+	agentID := c.Param("agentID")
+	if strings.TrimSpace(agentID) == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "agentID is required"})
+		return
+	}
+	behaviorID := c.Param("behaviorID")
+
+	params, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("runAgentBehavior: start agent=%s behavior=%s", agentID, behaviorID)
+
+	result, err := s.gameSvc.RunBehavior(c.Request.Context(), game.RunBehaviorInput{
+		AgentID:    agentID,
+		BehaviorID: behaviorID,
+		Params:     params,
+	})
+	if err != nil {
+		log.Printf("runAgentBehavior: error agent=%s behavior=%s err=%v", agentID, behaviorID, err)
+		c.JSON(behaviorErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
 	}
 
-	log.Printf("maintainEnergyBalance: success agent=%s towers=%d relocation=%v", agentID, result.TowersBuilt, result.Relocation != nil)
+	log.Printf("runAgentBehavior: success agent=%s behavior=%s", agentID, behaviorID)
+
+	c.JSON(http.StatusOK, result.Output)
+}
 
-	c.JSON(http.StatusOK, response)
+func behaviorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, game.ErrUnknownBehavior):
+		return http.StatusNotFound
+	case errors.Is(err, game.ErrInvalidSceneEntity), errors.Is(err, game.ErrInvalidBehaviorParams):
+		return http.StatusBadRequest
+	case errors.Is(err, game.ErrSolarTemplateMissing):
+		return http.StatusFailedDependency
+	case errors.Is(err, game.ErrNoAvailablePlacement), errors.Is(err, game.ErrTemplateDeprecated):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
 }
 
 func (s *Server) updateSystemBuildingTemplate(c *gin.Context) {
@@ -271,10 +768,25 @@ func (s *Server) updateSystemBuildingTemplate(c *gin.Context) {
 		return
 	}
 
+	var size *[2]int
+	if req.Size != nil {
+		if len(req.Size) != 2 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "size must be [width, height]"})
+			return
+		}
+		dims := [2]int{req.Size[0], req.Size[1]}
+		size = &dims
+	}
+
 	input := game.UpdateBuildingTemplateInput{
-		ID:     id,
-		Label:  req.Label,
-		Energy: energyRequestToInput(req.Energy),
+		ID:               id,
+		Label:            req.Label,
+		Tier:             req.Tier,
+		Size:             size,
+		Energy:           energyRequestToInput(req.Energy),
+		ApplyToInstances: req.ApplyToInstances,
+		Deprecated:       req.Deprecated,
+		RequiredTerrain:  req.RequiredTerrain,
 	}
 
 	snapshot, err := s.gameSvc.UpdateBuildingTemplate(c.Request.Context(), input)
@@ -290,6 +802,106 @@ func (s *Server) updateSystemBuildingTemplate(c *gin.Context) {
 	c.JSON(http.StatusOK, snapshot)
 }
 
+func (s *Server) deleteSystemBuildingTemplate(c *gin.Context) {
+	s.deleteSystemTemplate(c, s.gameSvc.DeleteBuildingTemplate)
+}
+
+func (s *Server) deleteSystemAgentTemplate(c *gin.Context) {
+	s.deleteSystemTemplate(c, s.gameSvc.DeleteAgentTemplate)
+}
+
+// deleteSystemTemplate 处理模板删除，支持 dryRun 预览依赖实例以及 block/reassign/cascade 模式。
+func (s *Server) deleteSystemTemplate(c *gin.Context, remove func(context.Context, game.DeleteTemplateInput) (game.TemplateDeletionResult, error)) {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "id is required"})
+		return
+	}
+
+	dryRun := false
+	if raw := strings.TrimSpace(c.Query("dryRun")); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "dryRun must be a boolean"})
+			return
+		}
+		dryRun = parsed
+	}
+
+	result, err := remove(c.Request.Context(), game.DeleteTemplateInput{
+		ID:         id,
+		Mode:       c.Query("mode"),
+		ReassignTo: c.Query("reassignTo"),
+		DryRun:     dryRun,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, game.ErrInvalidTemplate):
+			status = http.StatusBadRequest
+		case errors.Is(err, game.ErrTemplateInUse), errors.Is(err, game.ErrTemplateDeprecated):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) updateSystemBuildingUpgrade(c *gin.Context) {
+	fromID := strings.TrimSpace(c.Param("id"))
+	toID := strings.TrimSpace(c.Param("targetID"))
+	if fromID == "" || toID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "id and targetID are required"})
+		return
+	}
+
+	var req BuildingUpgradePathRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	snapshot, err := s.gameSvc.UpdateBuildingUpgrade(c.Request.Context(), game.UpdateBuildingUpgradeInput{
+		FromTemplateID: fromID,
+		ToTemplateID:   toID,
+		EnergyCost:     req.EnergyCost,
+		FootprintRule:  req.FootprintRule,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidTemplate) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (s *Server) deleteSystemBuildingUpgrade(c *gin.Context) {
+	fromID := strings.TrimSpace(c.Param("id"))
+	toID := strings.TrimSpace(c.Param("targetID"))
+	if fromID == "" || toID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "id and targetID are required"})
+		return
+	}
+
+	snapshot, err := s.gameSvc.DeleteBuildingUpgrade(c.Request.Context(), fromID, toID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidTemplate) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
 func (s *Server) updateSystemAgentTemplate(c *gin.Context) {
 	id := c.Param("id")
 	if strings.TrimSpace(id) == "" {
@@ -310,10 +922,13 @@ func (s *Server) updateSystemAgentTemplate(c *gin.Context) {
 	}
 
 	input := game.UpdateAgentTemplateInput{
-		ID:       id,
-		Label:    req.Label,
-		Color:    req.Color,
-		Position: position,
+		ID:         id,
+		Label:      req.Label,
+		Color:      req.Color,
+		Position:   position,
+		Skills:     req.Skills,
+		Speed:      req.Speed,
+		Deprecated: req.Deprecated,
 	}
 
 	snapshot, err := s.gameSvc.UpdateAgentTemplate(c.Request.Context(), input)
@@ -329,6 +944,27 @@ func (s *Server) updateSystemAgentTemplate(c *gin.Context) {
 	c.JSON(http.StatusOK, snapshot)
 }
 
+// getSystemSceneBuilding 返回建筑实例的生效值以及哪些字段覆盖了模板。
+func (s *Server) getSystemSceneBuilding(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "id is required"})
+		return
+	}
+
+	inheritance, err := s.gameSvc.DescribeSceneBuilding(c.Request.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidSceneEntity) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, inheritance)
+}
+
 func (s *Server) updateSystemSceneBuilding(c *gin.Context) {
 	id := c.Param("id")
 	if strings.TrimSpace(id) == "" {
@@ -355,13 +991,17 @@ func (s *Server) updateSystemSceneBuilding(c *gin.Context) {
 		TemplateID: normalizeStringPointer(req.TemplateID),
 		Rect:       rect,
 		Energy:     energyRequestToInput(req.Energy),
+		Powered:    req.Powered,
 	}
 
 	snapshot, err := s.gameSvc.UpdateSceneBuilding(c.Request.Context(), input)
 	if err != nil {
 		status := http.StatusInternalServerError
-		if errors.Is(err, game.ErrInvalidSceneEntity) {
+		switch {
+		case errors.Is(err, game.ErrInvalidSceneEntity):
 			status = http.StatusBadRequest
+		case errors.Is(err, game.ErrTemplateDeprecated), errors.Is(err, game.ErrTerrainUnsuitable):
+			status = http.StatusConflict
 		}
 		c.JSON(status, ErrorResponse{Error: err.Error()})
 		return
@@ -458,6 +1098,36 @@ func (s *Server) updateSystemSceneAgent(c *gin.Context) {
 		if errors.Is(err, game.ErrInvalidTemplate) {
 			status = http.StatusBadRequest
 		}
+		if errors.Is(err, game.ErrTemplateDeprecated) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// deleteSystemSceneAgent 删除场景 Agent，history=purge 时一并清除行为历史。
+func (s *Server) deleteSystemSceneAgent(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "id is required"})
+		return
+	}
+
+	history := strings.ToLower(strings.TrimSpace(c.DefaultQuery("history", agentHistoryKeep)))
+	if history != agentHistoryKeep && history != agentHistoryPurge {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "history must be keep or purge"})
+		return
+	}
+
+	snapshot, err := s.gameSvc.DeleteSceneAgent(c.Request.Context(), game.DeleteSceneAgentInput{ID: id, PurgeHistory: history == agentHistoryPurge})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidSceneEntity) {
+			status = http.StatusNotFound
+		}
 		c.JSON(status, ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -492,12 +1162,29 @@ func (s *Server) createAgentAction(c *gin.Context) {
 		Actions:       req.Actions,
 	}
 
+	// 场景中存在的 Agent 执行动作，执行结果覆盖请求中的 result_status/result_message，
+	// 并与移动在同一事务中记录；场景外的 Agent 沿用仅记录事件的行为。
+	executed, err := s.gameSvc.ExecuteAgentAction(c.Request.Context(), game.ExecuteAgentActionInput{
+		AgentID:    agentID,
+		ActionType: req.ActionType,
+		Payload:    json.RawMessage(req.Payload),
+		Log:        &input,
+	})
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, AgentActionResponse{Status: "logged", Result: &executed})
+		return
+	case !errors.Is(err, game.ErrInvalidSceneEntity):
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
 	if err := s.actionSvc.LogAction(c.Request.Context(), input); err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, StatusResponse{Status: "logged"})
+	c.JSON(http.StatusCreated, AgentActionResponse{Status: "logged"})
 }
 
 // listAgentActions 返回 Agent 行为事件列表。
@@ -538,7 +1225,75 @@ func (s *Server) getAgentState(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, state)
+	goals, err := s.gameSvc.ListAgentGoals(c.Request.Context(), agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, AgentStateResponse{State: state, Goals: goals})
+}
+
+// listAgentGoals 返回 Agent 的持久目标及其评估状态。
+func (s *Server) listAgentGoals(c *gin.Context) {
+	goals, err := s.gameSvc.ListAgentGoals(c.Request.Context(), c.Param("agentID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, goals)
+}
+
+// createAgentGoal 为 Agent 分配一个每个 tick 重新评估的持久目标。
+func (s *Server) createAgentGoal(c *gin.Context) {
+	agentID := strings.TrimSpace(c.Param("agentID"))
+	if agentID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "agentID is required"})
+		return
+	}
+
+	var req AgentGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	goal, err := s.gameSvc.AssignAgentGoal(c.Request.Context(), game.AssignGoalInput{
+		AgentID: agentID,
+		Kind:    req.Kind,
+		Params:  req.Params,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidSceneEntity) || errors.Is(err, game.ErrInvalidGoal) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, goal)
+}
+
+// deleteAgentGoal 删除 Agent 的目标。
+func (s *Server) deleteAgentGoal(c *gin.Context) {
+	goalID, err := strconv.ParseInt(c.Param("goalID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "goalID must be an integer"})
+		return
+	}
+
+	if err := s.gameSvc.DeleteAgentGoal(c.Request.Context(), c.Param("agentID"), goalID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, game.ErrInvalidGoal) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "deleted"})
 }
 
 // swaggerSpec 返回 Swagger JSON。
@@ -564,6 +1319,35 @@ type AgentActionRequest struct {
 	Actions       []string        `json:"actions"`
 }
 
+// TerrainUpdateRequest 为整体替换场景地形的请求体，runs 为行优先的 [地形编码, 连续格数]。
+type TerrainUpdateRequest struct {
+	Runs [][]int `json:"runs" binding:"required"`
+}
+
+// TerrainPaintRequest 为按矩形涂刷场景地形的请求体。
+type TerrainPaintRequest struct {
+	Paints []TerrainPaintItem `json:"paints" binding:"required"`
+}
+
+// TerrainPaintItem 描述一次涂刷，type 为地形名称，rect 为 [x, y, width, height]。
+type TerrainPaintItem struct {
+	Type string `json:"type"`
+	Rect []int  `json:"rect"`
+}
+
+// GenerateSceneRequest 为程序化生成场景的请求体，buildings/agents 缺省时使用默认开局组合。
+type GenerateSceneRequest struct {
+	SceneID   string               `json:"sceneId" binding:"required"`
+	Name      string               `json:"name"`
+	Seed      int64                `json:"seed"`
+	Width     int                  `json:"width" binding:"required"`
+	Height    int                  `json:"height" binding:"required"`
+	TileSize  int                  `json:"tileSize"`
+	Buildings []game.TemplateCount `json:"buildings"`
+	Agents    []game.TemplateCount `json:"agents"`
+	DryRun    bool                 `json:"dryRun"`
+}
+
 // SystemSceneUpdateRequest 为更新系统场景配置的请求体。
 type SystemSceneUpdateRequest struct {
 	SceneID    string                  `json:"scene_id" binding:"required"`
@@ -594,14 +1378,33 @@ type TemplateEnergyRequest struct {
 }
 
 type TemplateBuildingRequest struct {
-	Label  string                 `json:"label"`
-	Energy *TemplateEnergyRequest `json:"energy"`
+	Label            string                 `json:"label"`
+	Tier             *int                   `json:"tier"`
+	Size             []int                  `json:"size"`
+	Energy           *TemplateEnergyRequest `json:"energy"`
+	ApplyToInstances bool                   `json:"applyToInstances"`
+	Deprecated       *bool                  `json:"deprecated"`
+	RequiredTerrain  *string                `json:"requiredTerrain"`
+}
+
+// BuildingUpgradePathRequest 为配置模板升级路径的请求体。
+type BuildingUpgradePathRequest struct {
+	EnergyCost    int    `json:"energyCost"`
+	FootprintRule string `json:"footprintRule"`
+}
+
+// BuildingUpgradeRequest 为升级场景建筑的请求体。
+type BuildingUpgradeRequest struct {
+	TargetTemplateID string `json:"targetTemplateId" binding:"required"`
 }
 
 type TemplateAgentRequest struct {
-	Label           string `json:"label"`
-	Color           *int   `json:"color"`
-	DefaultPosition []int  `json:"defaultPosition"`
+	Label           string    `json:"label"`
+	Color           *int      `json:"color"`
+	DefaultPosition []int     `json:"defaultPosition"`
+	Skills          *[]string `json:"skills"`
+	Speed           *float64  `json:"speed"`
+	Deprecated      *bool     `json:"deprecated"`
 }
 
 type SceneBuildingRequest struct {
@@ -609,6 +1412,7 @@ type SceneBuildingRequest struct {
 	TemplateID *string                `json:"templateId"`
 	Rect       []int                  `json:"rect"`
 	Energy     *TemplateEnergyRequest `json:"energy"`
+	Powered    *bool                  `json:"powered"`
 }
 
 type SceneAgentRequest struct {
@@ -619,11 +1423,52 @@ type SceneAgentRequest struct {
 	Actions    []string `json:"actions"`
 }
 
+// SpawnAgentRequest 为从模板生成 Agent 的请求体，ID、标签与坐标缺省时自动生成。
+type SpawnAgentRequest struct {
+	TemplateID string   `json:"templateId" binding:"required"`
+	ID         string   `json:"id"`
+	Label      string   `json:"label"`
+	Position   []int    `json:"position"`
+	Actions    []string `json:"actions"`
+}
+
+const (
+	agentHistoryKeep  = "keep"
+	agentHistoryPurge = "purge"
+)
+
+// JobRequest 为新建任务的请求体，repair 使用 buildingId，build 需要 templateId 与 target。
+type JobRequest struct {
+	Kind       string `json:"kind" binding:"required"`
+	Target     []int  `json:"target"`
+	BuildingID string `json:"buildingId"`
+	TemplateID string `json:"templateId"`
+	Priority   int    `json:"priority"`
+}
+
+// AgentGoalRequest 为分配 Agent 目标的请求体。
+type AgentGoalRequest struct {
+	Kind   string          `json:"kind" binding:"required"`
+	Params game.GoalParams `json:"params"`
+}
+
+// AgentStateResponse 在动作状态之外附带 Agent 的目标评估情况。
+type AgentStateResponse struct {
+	actionservice.State
+	Goals []game.Goal `json:"goals"`
+}
+
 // StatusResponse 表示通用状态响应。
 type StatusResponse struct {
 	Status string `json:"status"`
 }
 
+// AgentActionResponse 为记录 Agent 行为后的响应，Agent 位于场景中时附带执行结果。
+type AgentActionResponse struct {
+	Status string                  `json:"status"`
+	Result *game.AgentActionResult `json:"result,omitempty"`
+}
+
 // ErrorResponse 表示通用错误响应。
 type ErrorResponse struct {
 	Error string `json:"error"`
@@ -638,13 +1483,29 @@ type AgentPositionUpdateRequest struct {
 	Y float64 `json:"y"`
 }
 
-type MaintainEnergyResponse struct {
-	Scene         game.Scene            `json:"scene"`
-	Created       []game.SceneBuilding  `json:"created"`
-	NetFlowBefore float64               `json:"netFlowBefore"`
-	NetFlowAfter  float64               `json:"netFlowAfter"`
-	TowersBuilt   int                   `json:"towersBuilt"`
-	Relocation    *game.AgentRelocation `json:"relocation,omitempty"`
+type AgentPathRequest struct {
+	Path        [][]int `json:"path"`
+	Destination []int   `json:"destination"`
+	Diagonal    bool    `json:"diagonal"`
+}
+
+func (r AgentPathRequest) toInput(agentID string) (game.SetAgentPathInput, error) {
+	input := game.SetAgentPathInput{AgentID: agentID, Diagonal: r.Diagonal}
+	if r.Destination != nil {
+		if len(r.Destination) != 2 {
+			return input, errors.New("destination must contain [x, y]")
+		}
+		input.Destination = &[2]int{r.Destination[0], r.Destination[1]}
+	}
+
+	input.Path = make([][2]int, 0, len(r.Path))
+	for _, tile := range r.Path {
+		if len(tile) != 2 {
+			return input, errors.New("path tiles must contain [x, y]")
+		}
+		input.Path = append(input.Path, [2]int{tile[0], tile[1]})
+	}
+	return input, nil
 }
 
 const swaggerUIHTML = `<!DOCTYPE html>
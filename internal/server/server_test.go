@@ -1,23 +1,30 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"eeo/backend/internal/config"
 	actionservice "eeo/backend/internal/service/action"
 	"eeo/backend/internal/service/game"
 	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"golang.org/x/net/websocket"
 )
 
 type mockGameService struct {
-	mu sync.Mutex
+	mu      sync.Mutex
+	changes game.SceneBus
 
 	scene           game.Scene
 	snapshot        game.Snapshot
@@ -47,6 +54,24 @@ type mockGameService struct {
 	}
 	runtimeUpdateCalls int
 
+	upgradeCalls     int
+	lastUpgradeInput game.UpgradeSceneBuildingInput
+	upgradeErr       error
+
+	lastTemplateDelete game.DeleteTemplateInput
+
+	goals []game.Goal
+	jobs  []game.Job
+
+	executeCalls int
+	executeErr   error
+	executed     *game.AgentActionResult
+	lastExecute  game.ExecuteAgentActionInput
+
+	lastSpawnInput game.SpawnSceneAgentInput
+	spawnErr       error
+
+	lastAgentDelete game.DeleteSceneAgentInput
 }
 
 func newMockGameService() *mockGameService {
@@ -60,8 +85,9 @@ func newMockGameService() *mockGameService {
 		Grid:  scene.Grid,
 	}
 	building := game.SceneBuilding{
-		ID:    "storage-1",
-		Label: "储能节点",
+		ID:         "storage-1",
+		TemplateID: "power_station",
+		Label:      "储能节点",
 		Energy: &game.SceneEnergy{
 			Type:     "storage",
 			Current:  150,
@@ -102,6 +128,64 @@ func (m *mockGameService) UpdateSceneConfig(_ context.Context, _ game.UpdateScen
 	return m.Snapshot(), nil
 }
 
+func (m *mockGameService) Terrain() game.TerrainLayer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.terrainLocked()
+}
+
+func (m *mockGameService) terrainLocked() game.TerrainLayer {
+	if m.scene.Terrain == nil {
+		return *game.NewTerrainLayer(m.scene.Grid.Cols, m.scene.Grid.Rows)
+	}
+	return *m.scene.Terrain
+}
+
+func (m *mockGameService) UpdateSceneTerrain(_ context.Context, runs [][2]int) (game.TerrainLayer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	layer, err := game.DecodeTerrainRuns(m.scene.Grid.Cols, m.scene.Grid.Rows, runs)
+	if err != nil {
+		return game.TerrainLayer{}, err
+	}
+	m.scene.Terrain = layer
+	return *layer, nil
+}
+
+func (m *mockGameService) PaintSceneTerrain(_ context.Context, paints []game.TerrainPaint) (game.TerrainLayer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.terrainLocked()
+	layer := current.Clone()
+	for _, paint := range paints {
+		typ, ok := game.ParseTerrainType(paint.Type)
+		if !ok {
+			return game.TerrainLayer{}, fmt.Errorf("%w: unknown terrain %q", game.ErrInvalidTerrain, paint.Type)
+		}
+		layer.Paint(paint.Rect, typ)
+	}
+	m.scene.Terrain = layer
+	return *layer, nil
+}
+
+func (m *mockGameService) GenerateScene(_ context.Context, in game.GenerateSceneInput) (game.GenerateSceneResult, error) {
+	if in.SceneID == m.scene.ID {
+		return game.GenerateSceneResult{}, fmt.Errorf("%w: %s", game.ErrSceneExists, in.SceneID)
+	}
+	if in.Width < 16 || in.Height < 16 {
+		return game.GenerateSceneResult{}, fmt.Errorf("%w: map too small", game.ErrInvalidSceneConfig)
+	}
+	scene := game.Scene{
+		ID:         in.SceneID,
+		Name:       in.Name,
+		Dimensions: game.SceneDims{Width: in.Width, Height: in.Height},
+		Terrain:    game.NewTerrainLayer(in.Width, in.Height),
+	}
+	return game.GenerateSceneResult{Seed: in.Seed, DryRun: in.DryRun, Scene: scene}, nil
+}
+
 func (m *mockGameService) UpdateBuildingTemplate(_ context.Context, _ game.UpdateBuildingTemplateInput) (game.Snapshot, error) {
 	return m.Snapshot(), nil
 }
@@ -110,6 +194,33 @@ func (m *mockGameService) UpdateAgentTemplate(_ context.Context, _ game.UpdateAg
 	return m.Snapshot(), nil
 }
 
+func (m *mockGameService) DeleteBuildingTemplate(_ context.Context, in game.DeleteTemplateInput) (game.TemplateDeletionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastTemplateDelete = in
+
+	var dependents []game.TemplateDependent
+	for _, building := range m.scene.Buildings {
+		if building.TemplateID == in.ID {
+			dependents = append(dependents, game.TemplateDependent{ID: building.ID, SceneID: m.scene.ID, Label: building.Label})
+		}
+	}
+	result := game.TemplateDeletionResult{TemplateID: in.ID, Kind: "building", Mode: in.Mode, DryRun: in.DryRun, Dependents: dependents}
+	if in.DryRun {
+		return result, nil
+	}
+	if len(dependents) > 0 && (in.Mode == "" || in.Mode == game.TemplateDeleteBlock) {
+		result.Blocked = true
+		return result, fmt.Errorf("%w: building %s", game.ErrTemplateInUse, in.ID)
+	}
+	result.Deleted = true
+	return result, nil
+}
+
+func (m *mockGameService) DeleteAgentTemplate(_ context.Context, in game.DeleteTemplateInput) (game.TemplateDeletionResult, error) {
+	return game.TemplateDeletionResult{TemplateID: in.ID, Kind: "agent", Mode: in.Mode, DryRun: in.DryRun, Deleted: !in.DryRun}, nil
+}
+
 func (m *mockGameService) UpdateSceneBuilding(_ context.Context, _ game.UpdateSceneBuildingInput) (game.Snapshot, error) {
 	return m.Snapshot(), nil
 }
@@ -132,6 +243,39 @@ func (m *mockGameService) UpdateSceneAgent(_ context.Context, _ game.UpdateScene
 	return m.Snapshot(), nil
 }
 
+func (m *mockGameService) DeleteSceneAgent(_ context.Context, in game.DeleteSceneAgentInput) (game.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastAgentDelete = in
+
+	for idx, agent := range m.scene.Agents {
+		if agent.ID == in.ID {
+			m.scene.Agents = append(m.scene.Agents[:idx], m.scene.Agents[idx+1:]...)
+			m.snapshot.Agents = m.scene.Agents
+			return m.snapshot, nil
+		}
+	}
+
+	return game.Snapshot{}, fmt.Errorf("%w: agent %s not found", game.ErrInvalidSceneEntity, in.ID)
+}
+
+func (m *mockGameService) SpawnSceneAgent(_ context.Context, in game.SpawnSceneAgentInput) (game.SpawnSceneAgentResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSpawnInput = in
+	if m.spawnErr != nil {
+		return game.SpawnSceneAgentResult{}, m.spawnErr
+	}
+
+	agent := game.SceneAgent{ID: in.ID, TemplateID: in.TemplateID, Label: in.Label, Position: []float64{1, 1}}
+	if agent.ID == "" {
+		agent.ID = fmt.Sprintf("%s-%02d", in.TemplateID, len(m.scene.Agents)+1)
+	}
+	m.scene.Agents = append(m.scene.Agents, agent)
+	m.snapshot.Agents = m.scene.Agents
+	return game.SpawnSceneAgentResult{Agent: agent, Scene: m.scene}, nil
+}
+
 func (m *mockGameService) DeleteSceneBuilding(_ context.Context, id string) (game.Snapshot, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -148,6 +292,18 @@ func (m *mockGameService) DeleteSceneBuilding(_ context.Context, id string) (gam
 	return game.Snapshot{}, fmt.Errorf("building %s not found", id)
 }
 
+func (m *mockGameService) DescribeSceneBuilding(_ context.Context, id string) (game.BuildingInheritance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, building := range m.scene.Buildings {
+		if building.ID == id {
+			return game.BuildingInheritance{Building: building}, nil
+		}
+	}
+	return game.BuildingInheritance{}, fmt.Errorf("%w: building %s not found", game.ErrInvalidSceneEntity, id)
+}
+
 func (m *mockGameService) UpdateBuildingEnergyCurrent(_ context.Context, id string, current float64) (game.SceneBuilding, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -176,6 +332,105 @@ func (m *mockGameService) AdvanceEnergyState(_ context.Context, seconds float64,
 	return m.scene, nil
 }
 
+func (m *mockGameService) AdvanceSimulation(ctx context.Context, seconds float64, drainFactor float64) (game.Scene, error) {
+	return m.AdvanceEnergyState(ctx, seconds, drainFactor)
+}
+
+func (m *mockGameService) SubscribeSceneChanges() (<-chan game.Scene, func()) {
+	return m.changes.Subscribe()
+}
+
+func (m *mockGameService) AssignAgentGoal(_ context.Context, in game.AssignGoalInput) (game.Goal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if in.Kind != game.GoalKindNetFlow && in.Kind != game.GoalKindStorageLevel && in.Kind != game.GoalKindPatrol {
+		return game.Goal{}, fmt.Errorf("%w: unknown kind %s", game.ErrInvalidGoal, in.Kind)
+	}
+	goal := game.Goal{ID: int64(len(m.goals) + 1), AgentID: in.AgentID, Kind: in.Kind, Params: in.Params, Status: game.GoalStatusPending}
+	m.goals = append(m.goals, goal)
+	return goal, nil
+}
+
+func (m *mockGameService) ListAgentGoals(_ context.Context, agentID string) ([]game.Goal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	goals := []game.Goal{}
+	for _, goal := range m.goals {
+		if goal.AgentID == agentID {
+			goals = append(goals, goal)
+		}
+	}
+	return goals, nil
+}
+
+func (m *mockGameService) DeleteAgentGoal(_ context.Context, agentID string, goalID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for idx, goal := range m.goals {
+		if goal.ID == goalID && goal.AgentID == agentID {
+			m.goals = append(m.goals[:idx], m.goals[idx+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: goal %d not found", game.ErrInvalidGoal, goalID)
+}
+
+func (m *mockGameService) CreateJob(_ context.Context, in game.CreateJobInput) (game.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if in.Target == nil && in.BuildingID == "" {
+		return game.Job{}, fmt.Errorf("%w: target required", game.ErrInvalidJob)
+	}
+	job := game.Job{ID: int64(len(m.jobs) + 1), Kind: in.Kind, Status: game.JobStatusQueued, Priority: in.Priority, Source: in.Source}
+	if in.Target != nil {
+		job.Target = []int{in.Target[0], in.Target[1]}
+	}
+	m.jobs = append(m.jobs, job)
+	m.scene.Jobs = m.jobs
+	return job, nil
+}
+
+func (m *mockGameService) ListJobs(_ context.Context, status string) ([]game.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []game.Job{}
+	for _, job := range m.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *mockGameService) GetJob(_ context.Context, jobID int64) (game.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.ID == jobID {
+			return job, nil
+		}
+	}
+	return game.Job{}, fmt.Errorf("%w: job %d", game.ErrJobNotFound, jobID)
+}
+
+func (m *mockGameService) CancelJob(_ context.Context, jobID int64) (game.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for idx, job := range m.jobs {
+		if job.ID != jobID {
+			continue
+		}
+		if job.Status == game.JobStatusDone || job.Status == game.JobStatusFailed {
+			return game.Job{}, fmt.Errorf("%w: job %d", game.ErrJobFinished, jobID)
+		}
+		job.Status = game.JobStatusFailed
+		job.Message = "cancelled"
+		m.jobs[idx] = job
+		return job, nil
+	}
+	return game.Job{}, fmt.Errorf("%w: job %d", game.ErrJobNotFound, jobID)
+}
+
 func (m *mockGameService) UpdateAgentRuntimePosition(_ context.Context, agentID string, posX, posY float64) (game.SceneAgent, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -200,18 +455,133 @@ func (m *mockGameService) UpdateAgentRuntimePosition(_ context.Context, agentID
 	return game.SceneAgent{}, fmt.Errorf("agent %s not found", agentID)
 }
 
-func (m *mockGameService) MaintainEnergyNonNegative(_ context.Context, agentID string) (game.MaintainEnergyResult, error) {
+func (m *mockGameService) SetAgentPath(_ context.Context, in game.SetAgentPathInput) (game.SceneAgent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(in.Path) == 0 {
+		return game.SceneAgent{}, fmt.Errorf("%w: path requires at least one tile", game.ErrInvalidPath)
+	}
+	for idx, agent := range m.scene.Agents {
+		if agent.ID == in.AgentID {
+			m.scene.Agents[idx].Path = in.Path
+			return m.scene.Agents[idx], nil
+		}
+	}
+	return game.SceneAgent{}, fmt.Errorf("%w: agent %s not found", game.ErrInvalidSceneEntity, in.AgentID)
+}
+
+func (m *mockGameService) CancelAgentPath(_ context.Context, agentID string) (game.SceneAgent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for idx, agent := range m.scene.Agents {
+		if agent.ID == agentID {
+			m.scene.Agents[idx].Path = nil
+			return m.scene.Agents[idx], nil
+		}
+	}
+	return game.SceneAgent{}, fmt.Errorf("%w: agent %s not found", game.ErrInvalidSceneEntity, agentID)
+}
+
+func (m *mockGameService) FindPath(in game.FindPathInput) (game.PathResult, error) {
+	if in.From == in.To {
+		return game.PathResult{From: in.From, To: in.To, Path: [][2]int{}}, nil
+	}
+	if in.To[0] < 0 || in.To[1] < 0 {
+		return game.PathResult{}, fmt.Errorf("%w: tile outside scene", game.ErrInvalidPath)
+	}
+	if in.To == [2]int{9, 9} {
+		return game.PathResult{}, fmt.Errorf("%w: walled in", game.ErrNoPath)
+	}
+	return game.PathResult{From: in.From, To: in.To, Diagonal: in.Diagonal, Path: [][2]int{in.To}, Cost: 1}, nil
+}
+
+func (m *mockGameService) SuggestPlacement(q game.PlacementQuery) (game.PlacementPlan, error) {
+	switch q.TemplateID {
+	case "legacy":
+		return game.PlacementPlan{}, fmt.Errorf("%w: %s", game.ErrTemplateDeprecated, q.TemplateID)
+	case "solar_array":
+	default:
+		return game.PlacementPlan{}, fmt.Errorf("%w: template %s not found", game.ErrInvalidSceneEntity, q.TemplateID)
+	}
+	plan := game.PlacementPlan{TemplateID: q.TemplateID, AgentID: q.AgentID, Size: [2]int{2, 2}, Clearance: q.Clearance}
+	for i := 0; i < q.Limit; i++ {
+		plan.Suggestions = append(plan.Suggestions, game.PlacementSuggestion{Rect: [4]int{i * 3, 0, 2, 2}, Score: float64(i)})
+	}
+	return plan, nil
+}
+
+func (m *mockGameService) ExecuteAgentAction(_ context.Context, in game.ExecuteAgentActionInput) (game.AgentActionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.executeCalls++
+	m.lastExecute = in
+	if m.executeErr != nil {
+		return game.AgentActionResult{}, m.executeErr
+	}
+	if m.executed != nil {
+		return *m.executed, nil
+	}
+	return game.AgentActionResult{}, fmt.Errorf("%w: agent %s not found", game.ErrInvalidSceneEntity, in.AgentID)
+}
+
+func (m *mockGameService) Behaviors() []game.BehaviorSpec {
+	return []game.BehaviorSpec{{ID: "maintain-energy", Label: "维持能量平衡", Params: []game.BehaviorParam{}}}
+}
+
+func (m *mockGameService) RunBehavior(_ context.Context, in game.RunBehaviorInput) (game.BehaviorResult, error) {
+	if in.BehaviorID != "maintain-energy" {
+		return game.BehaviorResult{}, fmt.Errorf("%w: %s", game.ErrUnknownBehavior, in.BehaviorID)
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.lastMaintainID = agentID
+	m.lastMaintainID = in.AgentID
 	m.maintainCalls++
 	if m.maintainErr != nil {
-		return game.MaintainEnergyResult{}, m.maintainErr
+		return game.BehaviorResult{}, m.maintainErr
+	}
+	result := m.maintainResult
+	if result.Scene.ID == "" {
+		result = game.MaintainEnergyResult{Scene: m.scene}
 	}
-	if m.maintainResult.Scene.ID == "" {
-		return game.MaintainEnergyResult{Scene: m.scene}, nil
+	return game.BehaviorResult{AgentID: in.AgentID, BehaviorID: in.BehaviorID, Scene: result.Scene, Output: result}, nil
+}
+
+func (m *mockGameService) UpgradeSceneBuilding(_ context.Context, in game.UpgradeSceneBuildingInput) (game.UpgradeBuildingResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upgradeCalls++
+	m.lastUpgradeInput = in
+	if m.upgradeErr != nil {
+		return game.UpgradeBuildingResult{}, m.upgradeErr
 	}
-	return m.maintainResult, nil
+	for idx, building := range m.scene.Buildings {
+		if building.ID == in.BuildingID {
+			previous := building.TemplateID
+			building.TemplateID = in.TargetTemplateID
+			m.scene.Buildings[idx] = building
+			return game.UpgradeBuildingResult{
+				Scene:          m.scene,
+				Building:       building,
+				FromTemplateID: previous,
+				ToTemplateID:   in.TargetTemplateID,
+			}, nil
+		}
+	}
+	return game.UpgradeBuildingResult{}, fmt.Errorf("%w: building %s not found", game.ErrInvalidSceneEntity, in.BuildingID)
+}
+
+func (m *mockGameService) BuildingUpgrades(_ context.Context, buildingID string) (game.BuildingUpgradeOverview, error) {
+	return game.BuildingUpgradeOverview{BuildingID: buildingID}, nil
+}
+
+func (m *mockGameService) UpdateBuildingUpgrade(_ context.Context, _ game.UpdateBuildingUpgradeInput) (game.Snapshot, error) {
+	return m.Snapshot(), nil
+}
+
+func (m *mockGameService) DeleteBuildingUpgrade(_ context.Context, _, _ string) (game.Snapshot, error) {
+	return m.Snapshot(), nil
 }
 
 func newTestServer() (*Server, *mockGameService) {
@@ -291,7 +661,7 @@ func TestServerMaintainEnergyPost(t *testing.T) {
 	mockSvc.mu.Lock()
 	defer mockSvc.mu.Unlock()
 	if mockSvc.maintainCalls == 0 {
-		t.Fatalf("expected MaintainEnergyNonNegative to be called")
+		t.Fatalf("expected maintain-energy behavior to run")
 	}
 }
 
@@ -309,7 +679,7 @@ func TestServerMaintainEnergyGetMethodNotAllowed(t *testing.T) {
 	mockSvc.mu.Lock()
 	defer mockSvc.mu.Unlock()
 	if mockSvc.maintainCalls != 0 {
-		t.Fatalf("expected MaintainEnergyNonNegative not to be called")
+		t.Fatalf("expected maintain-energy behavior not to run")
 	}
 }
 
@@ -333,3 +703,1210 @@ func TestServerDeleteSceneBuilding(t *testing.T) {
 		t.Fatalf("expected building to be removed, got %d", len(mockSvc.scene.Buildings))
 	}
 }
+
+func TestServerUpgradeBuilding(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/v1/game/scene/buildings/"+mockSvc.buildingResult.ID+"/upgrade",
+		strings.NewReader(`{"targetTemplateId":"solar_tower_mk2"}`),
+	)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 OK, got %d", resp.Code)
+	}
+
+	var payload game.UpgradeBuildingResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if payload.Building.ID != mockSvc.buildingResult.ID || payload.ToTemplateID != "solar_tower_mk2" {
+		t.Fatalf("unexpected upgrade result: %+v", payload)
+	}
+
+	mockSvc.mu.Lock()
+	defer mockSvc.mu.Unlock()
+	if mockSvc.upgradeCalls != 1 {
+		t.Fatalf("expected UpgradeSceneBuilding to be called once, got %d", mockSvc.upgradeCalls)
+	}
+}
+
+func TestServerUpgradeBuildingInsufficientEnergy(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	mockSvc.upgradeErr = fmt.Errorf("%w: need 120, stored 0", game.ErrInsufficientEnergy)
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/v1/game/scene/buildings/"+mockSvc.buildingResult.ID+"/upgrade",
+		strings.NewReader(`{"targetTemplateId":"solar_tower_mk2"}`),
+	)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected HTTP 409, got %d", resp.Code)
+	}
+}
+
+func TestServerGetSystemSceneBuilding(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/system/scene/buildings/"+mockSvc.buildingResult.ID, nil)
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 OK, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/system/scene/buildings/missing", nil)
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404 for unknown building, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/system/scene/buildings/preview", nil)
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"count"`) {
+		t.Fatalf("expected preview route to remain reachable, got %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestServerDeleteBuildingTemplateModes(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/system/templates/buildings/power_station?dryRun=true", nil)
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 for dry run, got %d", resp.Code)
+	}
+	var preview game.TemplateDeletionResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(preview.Dependents) != 1 || preview.Dependents[0].ID != mockSvc.buildingResult.ID {
+		t.Fatalf("expected dry run to list dependent building, got %+v", preview.Dependents)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/v1/system/templates/buildings/power_station", nil)
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected HTTP 409 when template is in use, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/v1/system/templates/buildings/power_station?mode=reassign&reassignTo=solar_array", nil)
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 for reassign, got %d", resp.Code)
+	}
+
+	mockSvc.mu.Lock()
+	defer mockSvc.mu.Unlock()
+	if mockSvc.lastTemplateDelete.Mode != game.TemplateDeleteReassign || mockSvc.lastTemplateDelete.ReassignTo != "solar_array" {
+		t.Fatalf("expected reassign parameters to be forwarded, got %+v", mockSvc.lastTemplateDelete)
+	}
+}
+
+func TestServerUpdateBuildingTemplateRejectsBadSize(t *testing.T) {
+	srv, _ := newTestServer()
+
+	for body, want := range map[string]int{
+		`{"label":"Hut","size":[2]}`:     http.StatusBadRequest,
+		`{"label":"Hut","size":[2,2,2]}`: http.StatusBadRequest,
+		`{"label":"Hut","size":[2,2]}`:   http.StatusOK,
+		`{"label":"Hut"}`:                http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/v1/system/templates/buildings/hut", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, req)
+		if resp.Code != want {
+			t.Fatalf("%s: expected %d, got %d: %s", body, want, resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestServerSpawnGameAgent(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	body := strings.NewReader(`{"templateId":"support","position":[3,4]}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/game/scene/agents", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected HTTP 201, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	var payload game.SpawnSceneAgentResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if payload.Agent.ID != "support-02" || len(payload.Scene.Agents) != 2 {
+		t.Fatalf("unexpected spawn result: %+v", payload)
+	}
+	if pos := mockSvc.lastSpawnInput.Position; pos == nil || *pos != [2]int{3, 4} {
+		t.Fatalf("expected position to be forwarded, got %v", pos)
+	}
+
+	mockSvc.spawnErr = fmt.Errorf("%w: near [3 4]", game.ErrNoFreeTile)
+	req = httptest.NewRequest(http.MethodPost, "/v1/game/scene/agents", strings.NewReader(`{"templateId":"support"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected HTTP 409 when no tile is free, got %d", resp.Code)
+	}
+}
+
+func TestServerDeleteSystemSceneAgent(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/system/scene/agents/ares-01?history=forget", nil)
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected HTTP 400 for unknown history policy, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/v1/system/scene/agents/ares-01?history=purge", nil)
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(mockSvc.Scene().Agents) != 0 {
+		t.Fatalf("expected agent to be removed")
+	}
+	if !mockSvc.lastAgentDelete.PurgeHistory {
+		t.Fatalf("expected history purge to be forwarded to the service")
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/system/scene/agents/ares-01", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404 for missing agent, got %d", resp.Code)
+	}
+}
+
+func TestServerCreateAgentActionStopsOnExecutorFailure(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	mockSvc.executeErr = fmt.Errorf("runtime state unavailable")
+
+	body := strings.NewReader(`{"action_type":"move_left"}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/agents/ares-01/actions", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("expected HTTP 500, got %d", resp.Code)
+	}
+	if mockSvc.executeCalls != 1 {
+		t.Fatalf("expected executor to be called once, got %d", mockSvc.executeCalls)
+	}
+}
+
+func TestServerCreateAgentActionLogsThroughExecutor(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	mockSvc.executed = &game.AgentActionResult{AgentID: "ares-01", Action: "move_left", Status: game.ActionStatusSucceeded, Executed: true}
+
+	body := strings.NewReader(`{"action_type":"move_left","issued_by":"console"}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/agents/ares-01/actions", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected HTTP 201, got %d: %s", resp.Code, resp.Body.String())
+	}
+	log := mockSvc.lastExecute.Log
+	if log == nil || log.AgentID != "ares-01" || log.ActionType != "move_left" || log.IssuedBy != "console" {
+		t.Fatalf("expected action log to be handed to the executor, got %+v", log)
+	}
+}
+
+func TestServerUnknownBehaviorNotFound(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/game/scene/agents/ares-01/behaviors/dance", nil)
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404, got %d", resp.Code)
+	}
+	if mockSvc.maintainCalls != 0 {
+		t.Fatalf("expected maintain-energy not to run")
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/behaviors", nil))
+	var specs []game.BehaviorSpec
+	if err := json.Unmarshal(resp.Body.Bytes(), &specs); err != nil || len(specs) != 1 {
+		t.Fatalf("expected behavior list, got %s (%v)", resp.Body.String(), err)
+	}
+}
+
+func TestServerAgentGoalsLifecycle(t *testing.T) {
+	srv, _ := newTestServer()
+
+	body := strings.NewReader(`{"kind":"patrol","params":{"waypoints":[[1,1],[4,1]]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/agents/ares-01/goals", body)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected HTTP 201, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/agents/ares-01/goals", strings.NewReader(`{"kind":"dance"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected HTTP 400 for unknown kind, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/agents/ares-01/goals", nil))
+	var goals []game.Goal
+	if err := json.Unmarshal(resp.Body.Bytes(), &goals); err != nil || len(goals) != 1 || len(goals[0].Params.Waypoints) != 2 {
+		t.Fatalf("unexpected goals: %s (%v)", resp.Body.String(), err)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/agents/ares-01/goals/1", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 on delete, got %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/agents/ares-01/goals/1", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404 for missing goal, got %d", resp.Code)
+	}
+}
+
+func TestServerJobLifecycle(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/game/scene/jobs", strings.NewReader(`{"kind":"explore","target":[4,5],"priority":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected HTTP 201, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if len(mockSvc.Scene().Jobs) != 1 || mockSvc.jobs[0].Source != game.JobSourceAPI {
+		t.Fatalf("expected queued api job in scene, got %+v", mockSvc.jobs)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/game/scene/jobs", strings.NewReader(`{"kind":"haul"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected HTTP 400 for missing target, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/game/scene/jobs/1/cancel", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 on cancel, got %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/game/scene/jobs/1/cancel", nil))
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected HTTP 409 when cancelling a finished job, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/jobs/9", nil))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404 for missing job, got %d", resp.Code)
+	}
+}
+
+func TestServerAgentPathLifecycle(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/game/scene/agents/ares-01/path", strings.NewReader(`{"path":[[11,10],[12,10]]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if path := mockSvc.Scene().Agents[0].Path; len(path) != 2 || path[1] != [2]int{12, 10} {
+		t.Fatalf("expected path to be stored, got %v", path)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v1/game/scene/agents/ares-01/path", strings.NewReader(`{"path":[[11]]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected HTTP 400 for malformed tile, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v1/game/scene/agents/ghost/path", strings.NewReader(`{"path":[[1,1]]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected HTTP 404 for missing agent, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/v1/game/scene/agents/ares-01/path", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200 on cancel, got %d", resp.Code)
+	}
+	if path := mockSvc.Scene().Agents[0].Path; len(path) != 0 {
+		t.Fatalf("expected path to be cleared, got %v", path)
+	}
+}
+
+func TestServerFindGamePath(t *testing.T) {
+	srv, _ := newTestServer()
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/path?from=1,1&to=2,1&diagonal=true", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var result game.PathResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Diagonal || len(result.Path) != 1 || result.Path[0] != [2]int{2, 1} {
+		t.Fatalf("unexpected path result: %+v", result)
+	}
+
+	for query, want := range map[string]int{
+		"from=1&to=2,1":                  http.StatusBadRequest,
+		"from=1,1&to=-1,0":               http.StatusBadRequest,
+		"from=1,1&to=2,1&diagonal=maybe": http.StatusBadRequest,
+		"from=1,1&to=9,9":                http.StatusConflict,
+	} {
+		resp = httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/path?"+query, nil))
+		if resp.Code != want {
+			t.Fatalf("query %s: expected HTTP %d, got %d", query, want, resp.Code)
+		}
+	}
+}
+
+func TestServerSystemSceneTerrain(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/system/scene/terrain", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var terrain game.TerrainLayer
+	if err := json.Unmarshal(resp.Body.Bytes(), &terrain); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(terrain.Runs) != 1 || terrain.Runs[0] != [2]int{int(game.TerrainRegolith), 100} {
+		t.Fatalf("expected a single regolith run, got %+v", terrain.Runs)
+	}
+
+	body := `{"paints":[{"type":"ice","rect":[0,0,2,1]},{"type":"crater","rect":[9,9,1,1]}]}`
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPatch, "/v1/system/scene/terrain", strings.NewReader(body)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if layer := mockSvc.Scene().Terrain; layer.At(1, 0) != game.TerrainIce || layer.At(9, 9) != game.TerrainCrater || layer.At(2, 0) != game.TerrainRegolith {
+		t.Fatalf("unexpected painted terrain: %+v", layer.Runs)
+	}
+
+	for method, body := range map[string]string{
+		http.MethodPatch: `{"paints":[{"type":"lava","rect":[0,0,1,1]}]}`,
+		http.MethodPut:   `{"runs":[[0,99]]}`,
+	} {
+		resp = httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, httptest.NewRequest(method, "/v1/system/scene/terrain", strings.NewReader(body)))
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s %s: expected HTTP 400, got %d", method, body, resp.Code)
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, "/v1/system/scene/terrain", strings.NewReader(`{"runs":[[1,50],[4,50]]}`)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if layer := mockSvc.Scene().Terrain; layer.At(9, 4) != game.TerrainRock || layer.At(0, 5) != game.TerrainSlope {
+		t.Fatalf("unexpected replaced terrain: %+v", layer.Runs)
+	}
+}
+
+func TestDiffJSONPatchRoundTrip(t *testing.T) {
+	decode := func(raw string) interface{} {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+			t.Fatalf("decode %s: %v", raw, err)
+		}
+		return doc
+	}
+	cases := [][2]string{
+		{`{"a":1,"b":[1,2,3],"c":{"d":"x"}}`, `{"a":2,"b":[1,5],"c":{"d":"x","e/f":null},"g":[{"id":1}]}`},
+		{`{"list":[1]}`, `{"list":[1,2,3],"x~y":true}`},
+		{`{"a":{"b":1}}`, `{"a":[1]}`},
+		{`{"same":[{"k":0}]}`, `{"same":[{"k":0}]}`},
+	}
+	for _, tc := range cases {
+		before, after := decode(tc[0]), decode(tc[1])
+		ops := diffJSON(nil, "", before, after)
+		if tc[0] == tc[1] && len(ops) != 0 {
+			t.Fatalf("expected no ops for identical documents, got %+v", ops)
+		}
+		patched := applyTestPatch(t, decode(tc[0]), ops)
+		if !reflect.DeepEqual(patched, after) {
+			t.Fatalf("patch %+v turned %s into %v, want %s", ops, tc[0], patched, tc[1])
+		}
+	}
+}
+
+// applyTestPatch 为测试用的 JSON Patch 实现，只支持 diffJSON 生成的 add/replace/remove。
+func applyTestPatch(t *testing.T, doc interface{}, ops []patchOp) interface{} {
+	t.Helper()
+	var apply func(node interface{}, tokens []string, op patchOp) interface{}
+	apply = func(node interface{}, tokens []string, op patchOp) interface{} {
+		if len(tokens) == 0 {
+			var value interface{}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				t.Fatalf("decode value of %+v: %v", op, err)
+			}
+			return value
+		}
+		key := strings.ReplaceAll(strings.ReplaceAll(tokens[0], "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if len(tokens) == 1 && op.Op == "remove" {
+				delete(n, key)
+			} else {
+				n[key] = apply(n[key], tokens[1:], op)
+			}
+			return n
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil {
+				t.Fatalf("bad index in %+v", op)
+			}
+			switch {
+			case len(tokens) == 1 && op.Op == "remove":
+				return append(n[:i], n[i+1:]...)
+			case len(tokens) == 1 && op.Op == "add":
+				return append(n[:i], append([]interface{}{apply(nil, nil, op)}, n[i:]...)...)
+			default:
+				n[i] = apply(n[i], tokens[1:], op)
+				return n
+			}
+		}
+		t.Fatalf("cannot apply %+v", op)
+		return nil
+	}
+	for _, op := range ops {
+		var tokens []string
+		if op.Path != "" {
+			tokens = strings.Split(op.Path, "/")[1:]
+		}
+		doc = apply(doc, tokens, op)
+	}
+	return doc
+}
+
+// dialSceneStream 启动测试 HTTP 服务并连接场景流，返回连接与带超时的接收函数。
+func dialSceneStream(t *testing.T, srv *Server, query string) (*websocket.Conn, func() sceneStreamMessage) {
+	t.Helper()
+	httpSrv := httptest.NewServer(srv.engine)
+	t.Cleanup(httpSrv.Close)
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http")+"/v1/game/scene/stream"+query, "", httpSrv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn, func() sceneStreamMessage {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var message sceneStreamMessage
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		return message
+	}
+}
+
+func TestSceneStreamSendsSnapshotThenPatches(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	conn, receive := dialSceneStream(t, srv, "")
+
+	snapshot := receive()
+	if snapshot.Type != streamMessageSnapshot || snapshot.Seq == 0 || len(snapshot.Scene) == 0 {
+		t.Fatalf("expected initial snapshot, got %+v", snapshot)
+	}
+
+	mockSvc.mu.Lock()
+	mockSvc.scene.Agents[0].Position = []float64{11, 10}
+	mockSvc.mu.Unlock()
+	srv.sceneStream.broadcast(mockSvc.Scene())
+	srv.sceneStream.broadcast(mockSvc.Scene())
+
+	patch := receive()
+	if patch.Type != streamMessagePatch || patch.Seq != snapshot.Seq+1 || patch.Prev != snapshot.Seq || len(patch.Ops) != 1 || patch.Ops[0].Path != "/agents/0/position/0" {
+		t.Fatalf("expected a single position patch, got %+v", patch)
+	}
+
+	if err := websocket.JSON.Send(conn, sceneStreamMessage{Type: streamMessageResync}); err != nil {
+		t.Fatalf("send resync: %v", err)
+	}
+	resync := receive()
+	if resync.Type != streamMessageSnapshot || resync.Seq != patch.Seq || !strings.Contains(string(resync.Scene), `"position":[11,10]`) {
+		t.Fatalf("expected snapshot at seq %d, got %+v", patch.Seq, resync)
+	}
+}
+
+func TestSceneStreamCommands(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	conn, receive := dialSceneStream(t, srv, "")
+	snapshot := receive()
+
+	command := func(id, name, params string) {
+		t.Helper()
+		if err := websocket.JSON.Send(conn, sceneStreamMessage{Type: streamMessageCommand, ID: id, Command: name, Params: json.RawMessage(params)}); err != nil {
+			t.Fatalf("send %s: %v", name, err)
+		}
+	}
+
+	command("1", streamCommandMoveAgent, `{"agentId":"ares-01","path":[[11,10],[12,10]]}`)
+	patch := receive()
+	if patch.Type != streamMessagePatch || patch.Seq != snapshot.Seq+1 {
+		t.Fatalf("expected the move to be streamed before the ack, got %+v", patch)
+	}
+	ack := receive()
+	if ack.Type != streamMessageAck || ack.ID != "1" || ack.Seq != patch.Seq || !strings.Contains(string(ack.Result), `"path":[[11,10],[12,10]]`) {
+		t.Fatalf("unexpected move ack: %+v", ack)
+	}
+
+	for _, tc := range []struct {
+		name, params string
+		code         int
+	}{
+		{streamCommandMoveAgent, `{"agentId":"ghost","path":[[1,1]]}`, http.StatusNotFound},
+		{streamCommandMoveAgent, `{"agentId":"ares-01","destination":[1]}`, http.StatusBadRequest},
+		{streamCommandRunBehavior, `{"agentId":"ares-01","behaviorId":"dance"}`, http.StatusNotFound},
+		{streamCommandSetBuildingEnergy, `{"buildingId":"storage-1"}`, http.StatusBadRequest},
+		{"teleport", `{}`, http.StatusBadRequest},
+	} {
+		command("bad", tc.name, tc.params)
+		if reply := receive(); reply.Type != streamMessageError || reply.ID != "bad" || reply.Error == nil || reply.Error.Code != tc.code {
+			t.Fatalf("%s %s: expected error %d, got %+v", tc.name, tc.params, tc.code, reply)
+		}
+	}
+
+	command("2", streamCommandRunBehavior, `{"agentId":"ares-01","behaviorId":"maintain-energy"}`)
+	if ack := receive(); ack.Type != streamMessageAck || ack.ID != "2" || mockSvc.maintainCalls != 1 {
+		t.Fatalf("unexpected behavior ack: %+v", ack)
+	}
+
+	command("3", streamCommandUnsubscribe, `{"topics":["scene"]}`)
+	if ack := receive(); ack.Type != streamMessageAck || string(ack.Result) != `{"topics":[]}` {
+		t.Fatalf("unexpected unsubscribe ack: %+v", ack)
+	}
+	command("4", streamCommandSetBuildingEnergy, `{"buildingId":"storage-1","current":5}`)
+	if ack := receive(); ack.Type != streamMessageAck || ack.ID != "4" || mockSvc.lastBuildingUpdate.current != 5 {
+		t.Fatalf("expected ack without a patch while unsubscribed, got %+v", ack)
+	}
+
+	command("5", streamCommandSubscribe, `{"topics":["scene"]}`)
+	if resync := receive(); resync.Type != streamMessageSnapshot {
+		t.Fatalf("expected snapshot on resubscribe, got %+v", resync)
+	}
+	if ack := receive(); ack.Type != streamMessageAck || ack.ID != "5" {
+		t.Fatalf("unexpected subscribe ack: %+v", ack)
+	}
+}
+
+func TestSceneStreamTopics(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	rec := httptest.NewRecorder()
+	srv.engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream?topic=weather", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown topic, got %d", rec.Code)
+	}
+
+	conn, receive := dialSceneStream(t, srv, "?topic=energy&topic=agent:ares-01&topic=viewport:0,0,5,5&topic=events")
+	snapshots := map[string]sceneStreamMessage{}
+	for i := 0; i < 3; i++ {
+		message := receive()
+		if message.Type != streamMessageSnapshot || len(message.Scene) != 0 || len(message.Data) == 0 {
+			t.Fatalf("expected topic snapshot, got %+v", message)
+		}
+		snapshots[message.Topic] = message
+	}
+	if !strings.Contains(string(snapshots["energy"].Data), `"netFlow"`) ||
+		!strings.Contains(string(snapshots["agent:ares-01"].Data), `"id":"ares-01"`) ||
+		string(snapshots["viewport:0,0,5,5"].Data) != `{"rect":[0,0,5,5],"buildings":[],"agents":[]}` {
+		t.Fatalf("unexpected snapshots: %+v", snapshots)
+	}
+
+	mockSvc.mu.Lock()
+	mockSvc.scene.Agents[0].Position = []float64{11, 10}
+	mockSvc.scene.MovementEvents = []game.MovementEvent{{Type: "arrived", AgentID: "ares-01", Position: []float64{11, 10}}}
+	mockSvc.mu.Unlock()
+	srv.sceneStream.broadcast(mockSvc.Scene())
+
+	// 单个 Agent 的视图很小，补丁不比视图小，改发快照；energy 与 viewport 视图未变化，不下发。
+	agent := receive()
+	if agent.Type != streamMessageSnapshot || agent.Topic != "agent:ares-01" || agent.Seq != snapshots["agent:ares-01"].Seq+1 ||
+		!strings.Contains(string(agent.Data), `"position":[11,10]`) {
+		t.Fatalf("expected agent update, got %+v", agent)
+	}
+	event := receive()
+	if event.Type != streamMessageEvent || event.Topic != "events" || event.Seq != agent.Seq ||
+		len(event.Events) != 1 || event.Events[0].Movement == nil || event.Events[0].Movement.Type != "arrived" {
+		t.Fatalf("expected movement event, got %+v", event)
+	}
+
+	if err := websocket.JSON.Send(conn, sceneStreamMessage{Type: streamMessageCommand, ID: "1", Command: streamCommandSubscribe,
+		Params: json.RawMessage(`{"topics":["viewport:1,2"]}`)}); err != nil {
+		t.Fatalf("send subscribe: %v", err)
+	}
+	if reply := receive(); reply.Type != streamMessageError || reply.Error == nil || reply.Error.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid viewport error, got %+v", reply)
+	}
+	if err := websocket.JSON.Send(conn, sceneStreamMessage{Type: streamMessageResync, Topic: "energy"}); err != nil {
+		t.Fatalf("send resync: %v", err)
+	}
+	if resync := receive(); resync.Type != streamMessageSnapshot || resync.Topic != "energy" || resync.Seq != agent.Seq {
+		t.Fatalf("expected energy snapshot at the current seq, got %+v", resync)
+	}
+}
+
+func TestSceneStreamPublishesServiceChanges(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	srv.sceneStream = newSceneStream(mockSvc, time.Hour, 0, 0)
+	defer srv.sceneStream.stop()
+
+	_, receive := dialSceneStream(t, srv, "")
+	snapshot := receive()
+
+	mockSvc.mu.Lock()
+	mockSvc.scene.Agents[0].Position = []float64{11, 10}
+	mockSvc.mu.Unlock()
+	mockSvc.changes.Publish(mockSvc.Scene())
+
+	if patch := receive(); patch.Type != streamMessagePatch || patch.Seq != snapshot.Seq+1 || patch.Ops[0].Path != "/agents/0/position/0" {
+		t.Fatalf("expected the published change to be streamed, got %+v", patch)
+	}
+}
+
+func TestSceneStreamResumeSince(t *testing.T) {
+	srv, mockSvc := newTestServer()
+
+	rec := httptest.NewRecorder()
+	srv.engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream?since=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid since, got %d", rec.Code)
+	}
+
+	_, receive := dialSceneStream(t, srv, "")
+	snapshot := receive()
+	var patches []sceneStreamMessage
+	for _, x := range []float64{11, 12} {
+		mockSvc.mu.Lock()
+		mockSvc.scene.Agents[0].Position = []float64{x, 10}
+		mockSvc.mu.Unlock()
+		srv.sceneStream.broadcast(mockSvc.Scene())
+		patches = append(patches, receive())
+	}
+
+	_, replay := dialSceneStream(t, srv, "?since="+strconv.FormatUint(snapshot.Seq, 10))
+	for _, want := range patches {
+		if got := replay(); got.Type != streamMessagePatch || got.Seq != want.Seq || !reflect.DeepEqual(got.Ops, want.Ops) {
+			t.Fatalf("expected replayed patch %+v, got %+v", want, got)
+		}
+	}
+
+	_, expired := dialSceneStream(t, srv, "?since=1")
+	if notice := expired(); notice.Type != streamMessageResync || notice.Reason != streamResyncSinceExpired || notice.Seq != patches[1].Seq {
+		t.Fatalf("expected resync notice, got %+v", notice)
+	}
+	if resync := expired(); resync.Type != streamMessageSnapshot || resync.Seq != patches[1].Seq || !strings.Contains(string(resync.Scene), `"position":[12,10]`) {
+		t.Fatalf("expected snapshot after resync notice, got %+v", resync)
+	}
+}
+
+func TestSceneStreamServerSentEvents(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	httpSrv := httptest.NewServer(srv.engine)
+	// Close 会等待 SSE 请求结束，需在各响应体关闭之后执行。
+	t.Cleanup(httpSrv.Close)
+
+	// open 连接 SSE 流，返回读取下一个事件的 id 与 data 字段的函数。
+	open := func(lastEventID string) func() (string, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, httpSrv.URL+"/v1/game/scene/events", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get events: %v", err)
+		}
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		reader := bufio.NewReader(resp.Body)
+		return func() (string, string) {
+			t.Helper()
+			var id, data string
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatalf("read event: %v", err)
+				}
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "":
+					return id, data
+				case strings.HasPrefix(line, "id:"):
+					id = strings.TrimPrefix(line, "id:")
+				case strings.HasPrefix(line, "data:"):
+					data = strings.TrimPrefix(line, "data:")
+				}
+			}
+		}
+	}
+
+	next := open("")
+	_, data := next()
+	var snapshot sceneStreamMessage
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil || snapshot.Type != streamMessageSnapshot {
+		t.Fatalf("expected snapshot event, got %q (%v)", data, err)
+	}
+	if id, data := next(); id != strconv.FormatUint(snapshot.Seq, 10) || data != "" {
+		t.Fatalf("expected checkpoint at seq %d, got id=%q data=%q", snapshot.Seq, id, data)
+	}
+
+	mockSvc.mu.Lock()
+	mockSvc.scene.Agents[0].Position = []float64{11, 10}
+	mockSvc.mu.Unlock()
+	srv.sceneStream.broadcast(mockSvc.Scene())
+	_, patch := next()
+	if id, _ := next(); !strings.Contains(patch, `"type":"patch"`) || id != strconv.FormatUint(snapshot.Seq+1, 10) {
+		t.Fatalf("expected patch followed by checkpoint, got %q id=%q", patch, id)
+	}
+
+	resumed := open(strconv.FormatUint(snapshot.Seq, 10))
+	if _, replayed := resumed(); replayed != patch {
+		t.Fatalf("expected replayed patch %q, got %q", patch, replayed)
+	}
+}
+
+func TestSceneStreamClosesSlowConsumer(t *testing.T) {
+	srv, _ := newTestServer()
+	httpSrv := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		// 不启动写循环，模拟客户端读取跟不上推送。
+		client := newSceneStreamClient(srv.sceneStream, &websocketTransport{conn: conn}, []string{streamTopicScene}, 0)
+		for i := 0; i <= cap(client.send); i++ {
+			client.enqueue([]byte(`{}`))
+		}
+		<-client.done
+		client.finish()
+	}))
+	defer httpSrv.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http"), "", httpSrv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var message sceneStreamMessage
+	if err := websocket.JSON.Receive(conn, &message); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if message.Type != streamMessageClose || message.Reason != streamCloseSlowConsumer || message.Error == nil || message.Error.Code != streamClosePolicyViolation {
+		t.Fatalf("expected slow-consumer close, got %+v", message)
+	}
+	if err := websocket.JSON.Receive(conn, &message); err == nil {
+		t.Fatalf("expected connection to be closed, got %+v", message)
+	}
+}
+
+func TestSceneStreamRateCoalescesPatches(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	_, receive := dialSceneStream(t, srv, "?rate=5")
+	snapshot := receive()
+
+	move := func(x float64) {
+		mockSvc.mu.Lock()
+		mockSvc.scene.Agents[0].Position = []float64{x, 10}
+		mockSvc.mu.Unlock()
+		srv.sceneStream.broadcast(mockSvc.Scene())
+	}
+	move(11)
+	first := receive()
+	if first.Type != streamMessagePatch || first.Seq != snapshot.Seq+1 || string(first.Ops[0].Value) != "11" {
+		t.Fatalf("expected the first change to be pushed immediately, got %+v", first)
+	}
+
+	started := time.Now()
+	move(12)
+	move(13)
+	coalesced := receive()
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the next patch to wait for the 200ms interval, got it after %v", elapsed)
+	}
+	if coalesced.Type != streamMessagePatch || coalesced.Seq != snapshot.Seq+3 || coalesced.Prev != first.Seq ||
+		len(coalesced.Ops) != 1 || string(coalesced.Ops[0].Value) != "13" {
+		t.Fatalf("expected a single coalesced patch, got %+v", coalesced)
+	}
+}
+
+func TestSceneStreamConnectionLimits(t *testing.T) {
+	srv, _ := newTestServer()
+	srv.sceneStream.maxClients = 1
+	srv.sceneStream.maxSpectators = 0
+	_, receive := dialSceneStream(t, srv, "?rate=10")
+	receive()
+
+	for path, want := range map[string]int{
+		"/v1/game/scene/stream?rate=0":     http.StatusBadRequest,
+		"/v1/game/scene/stream?rate=abc":   http.StatusBadRequest,
+		"/v1/game/scene/stream?rate=0.01":  http.StatusBadRequest,
+		"/v1/game/scene/stream?rate=1e-10": http.StatusBadRequest,
+		"/v1/game/scene/stream":            http.StatusServiceUnavailable,
+		"/v1/game/scene/events":            http.StatusServiceUnavailable,
+	} {
+		resp := httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		if resp.Code != want {
+			t.Fatalf("%s: expected %d, got %d: %s", path, want, resp.Code, resp.Body.String())
+		}
+		if want == http.StatusServiceUnavailable && resp.Header().Get("Retry-After") != "5" {
+			t.Fatalf("%s: expected Retry-After, got %q", path, resp.Header().Get("Retry-After"))
+		}
+	}
+
+	srv.sceneStream.mu.Lock()
+	srv.sceneStream.maxClients = 2
+	srv.sceneStream.mu.Unlock()
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream?rate=0.2", nil))
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected spectators to be capped separately, got %d", resp.Code)
+	}
+}
+
+func TestSceneStreamPresence(t *testing.T) {
+	srv, _ := newTestServer()
+	_, watch := dialSceneStream(t, srv, "?clientId=wall-1&role=dashboard&topic=presence")
+
+	if snapshot := watch(); snapshot.Type != streamMessageSnapshot || snapshot.Topic != streamTopicPresence || string(snapshot.Data) != "[]" {
+		t.Fatalf("expected empty presence snapshot, got %+v", snapshot)
+	}
+	// presence 事件只带一个在线状态，返回事件动作与该状态。
+	next := func() (string, StreamPresence) {
+		t.Helper()
+		message := watch()
+		if message.Type != streamMessageEvent || message.Topic != streamTopicPresence || len(message.Events) != 1 || message.Events[0].Presence == nil {
+			t.Fatalf("expected presence event, got %+v", message)
+		}
+		return message.Events[0].Action, *message.Events[0].Presence
+	}
+	if action, self := next(); action != presenceJoin || self.ClientID != "wall-1" || self.Role != "dashboard" {
+		t.Fatalf("expected own join, got %s %+v", action, self)
+	}
+
+	conn, receive := dialSceneStream(t, srv, "?clientId=op-1&role=operator")
+	receive()
+	if action, operator := next(); action != presenceJoin || operator.ClientID != "op-1" || operator.Role != "operator" ||
+		!reflect.DeepEqual(operator.Topics, []string{streamTopicScene}) {
+		t.Fatalf("expected operator join, got %s %+v", action, operator)
+	}
+
+	if err := websocket.JSON.Send(conn, sceneStreamMessage{Type: streamMessageCommand, ID: "1", Command: streamCommandMoveAgent,
+		Params: json.RawMessage(`{"agentId":"ares-01","path":[[11,10]]}`)}); err != nil {
+		t.Fatalf("send command: %v", err)
+	}
+	if action, operator := next(); action != presenceUpdate || !reflect.DeepEqual(operator.Controlling, []string{"ares-01"}) {
+		t.Fatalf("expected operator to control ares-01, got %s %+v", action, operator)
+	}
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/presence", nil))
+	var presence StreamPresenceResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &presence); err != nil || resp.Code != http.StatusOK || len(presence.Clients) != 2 {
+		t.Fatalf("unexpected presence response %d: %s", resp.Code, resp.Body.String())
+	}
+	if operator := presence.Clients[1]; operator.ClientID != "op-1" || operator.Transport != "websocket" || operator.Encoding != streamProtocolJSON ||
+		!reflect.DeepEqual(operator.Controlling, []string{"ares-01"}) || operator.ConnectedAt.IsZero() {
+		t.Fatalf("unexpected operator presence: %+v", operator)
+	}
+
+	_ = conn.Close()
+	if action, operator := next(); action != presenceLeave || operator.ClientID != "op-1" {
+		t.Fatalf("expected operator leave, got %s %+v", action, operator)
+	}
+
+	resp = httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream?clientId=bad%20id", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid clientId to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestSceneStreamPresenceLagIgnoresIdleTopics(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	_, receive := dialSceneStream(t, srv, "?clientId=idle-1&topic=energy")
+	receive()
+	_, receiveThrottled := dialSceneStream(t, srv, "?clientId=idle-2&topic=energy&rate=5")
+	receiveThrottled()
+
+	// Agent 移动不改变 energy 主题，订阅者没有待推送的消息，推送进度应跟上最新序号。
+	for x := 11.0; x <= 13; x++ {
+		mockSvc.mu.Lock()
+		mockSvc.scene.Agents[0].Position = []float64{x, 10}
+		mockSvc.mu.Unlock()
+		srv.sceneStream.broadcast(mockSvc.Scene())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		presence := srv.sceneStream.presence()
+		caughtUp := len(presence) == 2
+		for _, client := range presence {
+			caughtUp = caughtUp && client.Lag == 0
+		}
+		if caughtUp {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected idle subscribers to have no lag, got %+v", presence)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerShutdownClosesSceneStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockSvc := newMockGameService()
+	srv := New(config.Config{}, mockSvc, actionservice.New(nil))
+	t.Cleanup(srv.sceneStream.stop)
+	conn, receive := dialSceneStream(t, srv, "")
+	receive()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if message := receive(); message.Type != streamMessageClose || message.Reason != streamCloseServerRestarting ||
+		message.Error == nil || message.Error.Code != streamCloseGoingAway {
+		t.Fatalf("expected server-restarting close, got %+v", message)
+	}
+	var message sceneStreamMessage
+	if err := websocket.JSON.Receive(conn, &message); err == nil {
+		t.Fatalf("expected connection to be closed, got %+v", message)
+	}
+
+	mockSvc.mu.Lock()
+	calls, seconds := mockSvc.energyCalls, mockSvc.lastEnergyInput.seconds
+	mockSvc.mu.Unlock()
+	if calls == 0 || seconds <= 0 {
+		t.Fatalf("expected a final simulation tick, got %d calls (last %v seconds)", calls, seconds)
+	}
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream", nil))
+	if resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected new streams to be refused during shutdown, got %d", resp.Code)
+	}
+}
+
+func TestSceneStreamMessagePack(t *testing.T) {
+	srv, mockSvc := newTestServer()
+	httpSrv := httptest.NewServer(srv.engine)
+	t.Cleanup(httpSrv.Close)
+
+	cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(httpSrv.URL, "http")+"/v1/game/scene/stream", httpSrv.URL)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	cfg.Protocol = []string{"scene.v2", streamProtocolMsgpack, streamProtocolJSON}
+	conn, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if got := conn.Config().Protocol; !reflect.DeepEqual(got, []string{streamProtocolMsgpack}) {
+		t.Fatalf("expected msgpack subprotocol, got %v", got)
+	}
+	_, receiveJSON := dialSceneStream(t, srv, "")
+	jsonSnapshot := receiveJSON()
+
+	receive := func() msgpackStreamMessage {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var raw []byte
+		if err := websocket.Message.Receive(conn, &raw); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		var message msgpackStreamMessage
+		if err := codec.NewDecoderBytes(raw, streamMsgpack.(msgpackEncoding).handle).Decode(&message); err != nil {
+			t.Fatalf("decode %x: %v", raw, err)
+		}
+		return message
+	}
+	decode := func(raw []byte) []interface{} {
+		t.Helper()
+		doc, err := streamMsgpack.unmarshalDoc(raw)
+		if err != nil {
+			t.Fatalf("decode %x: %v", raw, err)
+		}
+		return doc.([]interface{})
+	}
+
+	snapshot := receive()
+	scene := decode(snapshot.Scene)
+	agent := scene[5].([]interface{})[0].([]interface{})
+	if snapshot.Type != streamMessageSnapshot || snapshot.Seq != jsonSnapshot.Seq || scene[0] != "mars_outpost" || agent[0] != "ares-01" {
+		t.Fatalf("unexpected msgpack snapshot: %+v", snapshot)
+	}
+
+	mockSvc.mu.Lock()
+	mockSvc.scene.Agents[0].Position = []float64{11, 10}
+	mockSvc.mu.Unlock()
+	srv.sceneStream.broadcast(mockSvc.Scene())
+
+	patch := receive()
+	jsonPatch := receiveJSON()
+	if patch.Type != streamMessagePatch || patch.Seq != jsonPatch.Seq || patch.Prev != jsonPatch.Prev || len(patch.Ops) != 1 || patch.Ops[0].Path != "/5/0/3/0" {
+		t.Fatalf("expected positional position patch, got %+v", patch)
+	}
+	if value, err := streamMsgpack.unmarshalDoc(patch.Ops[0].Value); err != nil || value != float64(11) {
+		t.Fatalf("unexpected patch value %v (%v)", value, err)
+	}
+
+	// 客户端消息也可以用字段名为键的映射编码。
+	command, err := streamMsgpack.marshal(map[string]interface{}{
+		"type":    streamMessageCommand,
+		"id":      "1",
+		"command": streamCommandMoveAgent,
+		"params":  map[string]interface{}{"agentId": "ares-01", "path": [][]int{{12, 10}}},
+	})
+	if err != nil {
+		t.Fatalf("marshal command: %v", err)
+	}
+	if err := websocket.Message.Send(conn, command); err != nil {
+		t.Fatalf("send command: %v", err)
+	}
+	if moved := receive(); moved.Type != streamMessagePatch {
+		t.Fatalf("expected the move to be streamed before the ack, got %+v", moved)
+	}
+	ack := receive()
+	if ack.Type != streamMessageAck || ack.ID != "1" || decode(ack.Result)[0] != "ares-01" {
+		t.Fatalf("unexpected msgpack ack: %+v", ack)
+	}
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/stream/schema", nil))
+	var schema StreamSchema
+	if err := json.Unmarshal(resp.Body.Bytes(), &schema); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("unexpected schema response %d: %s", resp.Code, resp.Body.String())
+	}
+	if schema.Types["Scene"][5].Name != "agents" || schema.Types["SceneAgent"][3].Name != "position" ||
+		schema.Types[schema.Message][6].Type != "[]PatchOp" || schema.Topics[streamTopicEnergy] != "EnergySummary" {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+}
+
+func TestServerGenerateSystemScene(t *testing.T) {
+	srv, _ := newTestServer()
+
+	for body, want := range map[string]int{
+		`{"sceneId":"gen_1","seed":7,"width":32,"height":32}`:               http.StatusCreated,
+		`{"sceneId":"gen_1","seed":7,"width":32,"height":32,"dryRun":true}`: http.StatusOK,
+		`{"sceneId":"mars_outpost","seed":7,"width":32,"height":32}`:        http.StatusConflict,
+		`{"sceneId":"gen_2","seed":7,"width":8,"height":8}`:                 http.StatusBadRequest,
+		`{"seed":7,"width":32,"height":32}`:                                 http.StatusBadRequest,
+	} {
+		resp := httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1/system/scenes/generate", strings.NewReader(body)))
+		if resp.Code != want {
+			t.Fatalf("%s: expected HTTP %d, got %d: %s", body, want, resp.Code, resp.Body.String())
+		}
+		if want == http.StatusCreated {
+			var result game.GenerateSceneResult
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if result.Seed != 7 || result.Scene.ID != "gen_1" || result.Scene.Terrain == nil {
+				t.Fatalf("unexpected generate result: %+v", result)
+			}
+		}
+	}
+}
+
+func TestServerSuggestGamePlacement(t *testing.T) {
+	srv, _ := newTestServer()
+
+	resp := httptest.NewRecorder()
+	srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/placement?templateId=solar_array&agentId=ares-01&limit=3", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected HTTP 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var plan game.PlacementPlan
+	if err := json.Unmarshal(resp.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if plan.AgentID != "ares-01" || plan.Clearance != game.DefaultPlacementClearance || len(plan.Suggestions) != 3 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	for query, want := range map[string]int{
+		"":                                    http.StatusBadRequest,
+		"templateId=solar_array&limit=0":      http.StatusBadRequest,
+		"templateId=solar_array&limit=999":    http.StatusBadRequest,
+		"templateId=solar_array&clearance=-1": http.StatusBadRequest,
+		"templateId=unknown":                  http.StatusNotFound,
+		"templateId=legacy":                   http.StatusConflict,
+	} {
+		resp = httptest.NewRecorder()
+		srv.engine.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1/game/scene/placement?"+query, nil))
+		if resp.Code != want {
+			t.Fatalf("query %q: expected HTTP %d, got %d", query, want, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"eeo/backend/internal/service/game"
+)
+
+const (
+	streamMessageCommand = "command"
+	streamMessageAck     = "ack"
+	streamMessageError   = "error"
+
+	streamCommandMoveAgent         = "move-agent"
+	streamCommandSetBuildingEnergy = "set-building-energy"
+	streamCommandRunBehavior       = "run-behavior"
+	streamCommandSubscribe         = "subscribe"
+	streamCommandUnsubscribe       = "unsubscribe"
+)
+
+// streamError 为命令失败时返回的错误，code 与对应 REST 接口的 HTTP 状态码一致。
+type streamError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// streamCommandError 携带命令失败时返回给客户端的状态码。
+type streamCommandError struct {
+	code int
+	err  error
+}
+
+func (e *streamCommandError) Error() string { return e.err.Error() }
+
+func commandError(code int, err error) error {
+	return &streamCommandError{code: code, err: err}
+}
+
+// MoveAgentCommand 为 move-agent 命令参数，与 PUT /scene/agents/{agentID}/path 的请求体相同并附带 agentId。
+type MoveAgentCommand struct {
+	AgentID string `json:"agentId"`
+	AgentPathRequest
+}
+
+// SetBuildingEnergyCommand 为 set-building-energy 命令参数。
+type SetBuildingEnergyCommand struct {
+	BuildingID string   `json:"buildingId"`
+	Current    *float64 `json:"current"`
+}
+
+// RunBehaviorCommand 为 run-behavior 命令参数，params 按行为的参数结构校验。
+type RunBehaviorCommand struct {
+	AgentID    string          `json:"agentId"`
+	BehaviorID string          `json:"behaviorId"`
+	Params     json.RawMessage `json:"params"`
+}
+
+// SubscribeCommand 为 subscribe/unsubscribe 命令参数，主题格式见 parseStreamTopic。
+type SubscribeCommand struct {
+	Topics []string `json:"topics"`
+}
+
+// handleMessage 处理客户端发来的一条消息：resync 重新下发快照，command 执行命令并回复 ack 或 error。
+// 消息按连接协商的编码解码。
+func (s *sceneStream) handleMessage(client *sceneStreamClient, raw []byte) {
+	request, err := client.group.encoding.decodeMessage(raw)
+	if err != nil {
+		s.reply(client, request, nil, commandError(http.StatusBadRequest, fmt.Errorf("invalid message: %v", err)))
+		return
+	}
+
+	switch request.Type {
+	case streamMessageResync:
+		topic := ""
+		if request.Topic != "" {
+			parsed, err := parseStreamTopic(request.Topic)
+			if err != nil {
+				s.reply(client, request, nil, commandError(http.StatusBadRequest, err))
+				return
+			}
+			topic = parsed.name
+		}
+		s.resync(client, topic)
+	case streamMessageCommand:
+		result, mutated, err := s.runCommand(client, request)
+		// 服务也会经场景变化订阅发布这次修改，这里同步推送，保证补丁先于 ack 下发。
+		if err == nil && mutated {
+			s.broadcast(s.service.Scene())
+		}
+		s.reply(client, request, result, err)
+	default:
+		s.reply(client, request, nil, commandError(http.StatusBadRequest, fmt.Errorf("unknown message type %q", request.Type)))
+	}
+}
+
+// runCommand 执行命令，调用与 REST 接口相同的服务方法，返回结果以及场景是否被修改。
+func (s *sceneStream) runCommand(client *sceneStreamClient, request sceneStreamMessage) (interface{}, bool, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	switch request.Command {
+	case streamCommandMoveAgent:
+		var params MoveAgentCommand
+		if err := decodeCommandParams(request.Params, &params); err != nil {
+			return nil, false, err
+		}
+		if strings.TrimSpace(params.AgentID) == "" {
+			return nil, false, commandError(http.StatusBadRequest, errors.New("agentId is required"))
+		}
+		input, err := params.toInput(params.AgentID)
+		if err != nil {
+			return nil, false, commandError(http.StatusBadRequest, err)
+		}
+		agent, err := s.service.SetAgentPath(ctx, input)
+		if err != nil {
+			return nil, false, commandError(agentPathErrorStatus(err), err)
+		}
+		s.controlledBy(client, agent.ID)
+		return agent, true, nil
+
+	case streamCommandSetBuildingEnergy:
+		var params SetBuildingEnergyCommand
+		if err := decodeCommandParams(request.Params, &params); err != nil {
+			return nil, false, err
+		}
+		if strings.TrimSpace(params.BuildingID) == "" || params.Current == nil {
+			return nil, false, commandError(http.StatusBadRequest, errors.New("buildingId and current are required"))
+		}
+		building, err := s.service.UpdateBuildingEnergyCurrent(ctx, params.BuildingID, *params.Current)
+		if err != nil {
+			return nil, false, commandError(buildingEnergyErrorStatus(err), err)
+		}
+		return building, true, nil
+
+	case streamCommandRunBehavior:
+		var params RunBehaviorCommand
+		if err := decodeCommandParams(request.Params, &params); err != nil {
+			return nil, false, err
+		}
+		if strings.TrimSpace(params.AgentID) == "" {
+			return nil, false, commandError(http.StatusBadRequest, errors.New("agentId is required"))
+		}
+		result, err := s.service.RunBehavior(ctx, game.RunBehaviorInput{
+			AgentID:    params.AgentID,
+			BehaviorID: params.BehaviorID,
+			Params:     params.Params,
+		})
+		if err != nil {
+			log.Printf("sceneStream: run behavior error agent=%s behavior=%s err=%v", params.AgentID, params.BehaviorID, err)
+			return nil, false, commandError(behaviorErrorStatus(err), err)
+		}
+		s.controlledBy(client, strings.TrimSpace(params.AgentID))
+		return result.Output, true, nil
+
+	case streamCommandSubscribe, streamCommandUnsubscribe:
+		var params SubscribeCommand
+		if err := decodeCommandParams(request.Params, &params); err != nil {
+			return nil, false, err
+		}
+		if len(params.Topics) == 0 {
+			return nil, false, commandError(http.StatusBadRequest, errors.New("topics is required"))
+		}
+		topics, err := parseStreamTopics(params.Topics)
+		if err != nil {
+			return nil, false, commandError(http.StatusBadRequest, err)
+		}
+		if request.Command == streamCommandSubscribe {
+			if err := s.subscribe(client, topics); err != nil {
+				return nil, false, commandError(http.StatusBadRequest, err)
+			}
+		} else if client.unsubscribe(topics) {
+			s.announce(presenceUpdate, client)
+		}
+		return SubscribeCommand{Topics: client.subscribedTopics()}, false, nil
+	}
+
+	return nil, false, commandError(http.StatusBadRequest, fmt.Errorf("unknown command %q", request.Command))
+}
+
+func decodeCommandParams(raw json.RawMessage, target interface{}) error {
+	if len(raw) == 0 {
+		return commandError(http.StatusBadRequest, errors.New("params is required"))
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return commandError(http.StatusBadRequest, fmt.Errorf("invalid params: %v", err))
+	}
+	return nil
+}
+
+// reply 回复命令结果。ack 的 seq 为回复时的场景序号，命令造成的变化已包含在不大于该序号的消息中。
+func (s *sceneStream) reply(client *sceneStreamClient, request sceneStreamMessage, result interface{}, err error) {
+	message := sceneStreamMessage{Type: streamMessageAck, ID: request.ID, Command: request.Command}
+	if err != nil {
+		code := http.StatusInternalServerError
+		var cmdErr *streamCommandError
+		if errors.As(err, &cmdErr) {
+			code = cmdErr.code
+		}
+		message.Type = streamMessageError
+		message.Error = &streamError{Code: code, Message: err.Error()}
+	} else if result != nil {
+		raw, marshalErr := client.group.encoding.marshal(result)
+		if marshalErr != nil {
+			log.Printf("sceneStream: marshal command result failed: %v", marshalErr)
+		}
+		message.Result = raw
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	message.Seq = s.seq
+	payload, marshalErr := client.group.encoding.encodeMessage(message)
+	if marshalErr != nil {
+		log.Printf("sceneStream: marshal reply failed: %v", marshalErr)
+		return
+	}
+	client.enqueue(payload)
+}
+
+// subscribe 为客户端添加主题，并为新订阅的主题补发当前快照。
+func (s *sceneStream) subscribe(client *sceneStreamClient, topics []string) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	added, err := client.addTopics(topics)
+	if err != nil {
+		return err
+	}
+	for _, topic := range added {
+		key := viewKey{viewGroup: client.group, topic: topic}
+		s.ensureTopicLocked(key)
+		client.enqueue(s.topicSnapshotLocked(key))
+	}
+	if len(added) > 0 {
+		s.announceLocked(presenceUpdate, client)
+	}
+	return nil
+}
+
+// controlledBy 记录客户端通过命令操控了 Agent，首次操控时更新在线状态。
+func (s *sceneStream) controlledBy(client *sceneStreamClient, agentID string) {
+	if client.control(agentID) {
+		s.announce(presenceUpdate, client)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// streamProtocolJSON 与 streamProtocolMsgpack 为场景流支持的 WebSocket 子协议，未协商时使用 JSON。
+	streamProtocolJSON    = "scene.json"
+	streamProtocolMsgpack = "scene.msgpack"
+)
+
+// streamEncoding 为场景流消息的编码方式。视图、补丁与事件按编码分别计算，同一编码的订阅者共享结果。
+type streamEncoding interface {
+	name() string
+	// binary 为 true 时以 WebSocket 二进制帧发送。
+	binary() bool
+	// marshal 编码视图、补丁中的值与命令结果。
+	marshal(v interface{}) ([]byte, error)
+	// unmarshalDoc 把 marshal 的结果解码为通用文档，用于计算差异。
+	unmarshalDoc(raw []byte) (interface{}, error)
+	encodeMessage(message sceneStreamMessage) ([]byte, error)
+	// decodeMessage 解码客户端消息，params 统一转换为 JSON 以复用命令处理逻辑。
+	decodeMessage(raw []byte) (sceneStreamMessage, error)
+}
+
+var (
+	streamJSON    streamEncoding = jsonEncoding{}
+	streamMsgpack streamEncoding = newMsgpackEncoding()
+)
+
+// negotiateStreamEncoding 按客户端给出的顺序选择第一个支持的子协议，均不支持时使用 JSON 且不回应子协议。
+func negotiateStreamEncoding(offered []string) (streamEncoding, []string) {
+	for _, protocol := range offered {
+		switch protocol {
+		case streamProtocolJSON:
+			return streamJSON, []string{protocol}
+		case streamProtocolMsgpack:
+			return streamMsgpack, []string{protocol}
+		}
+	}
+	return streamJSON, nil
+}
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) name() string { return streamProtocolJSON }
+
+func (jsonEncoding) binary() bool { return false }
+
+func (jsonEncoding) marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonEncoding) unmarshalDoc(raw []byte) (interface{}, error) {
+	var doc interface{}
+	err := json.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+func (jsonEncoding) encodeMessage(message sceneStreamMessage) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonEncoding) decodeMessage(raw []byte) (sceneStreamMessage, error) {
+	var message sceneStreamMessage
+	err := json.Unmarshal(raw, &message)
+	return message, err
+}
+
+// msgpackEncoding 以 MessagePack 编码，结构体按字段顺序编码为数组而不携带字段名，
+// 字段顺序见 GET /v1/game/scene/stream/schema；补丁路径同样以数组下标表示字段。
+type msgpackEncoding struct {
+	handle *codec.MsgpackHandle
+}
+
+func newMsgpackEncoding() msgpackEncoding {
+	handle := &codec.MsgpackHandle{}
+	handle.StructToArray = true
+	handle.WriteExt = true
+	handle.Raw = true
+	handle.RawToString = true
+	handle.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return msgpackEncoding{handle: handle}
+}
+
+func (msgpackEncoding) name() string { return streamProtocolMsgpack }
+
+func (msgpackEncoding) binary() bool { return true }
+
+func (e msgpackEncoding) marshal(v interface{}) ([]byte, error) {
+	var raw []byte
+	err := codec.NewEncoderBytes(&raw, e.handle).Encode(v)
+	return raw, err
+}
+
+func (e msgpackEncoding) unmarshalDoc(raw []byte) (interface{}, error) {
+	var doc interface{}
+	err := codec.NewDecoderBytes(raw, e.handle).Decode(&doc)
+	return doc, err
+}
+
+// msgpackStreamMessage 为 MessagePack 模式下的消息，字段与 sceneStreamMessage 一一对应，
+// 已编码的 scene、data、result 与补丁值原样嵌入。
+type msgpackStreamMessage struct {
+	Type    string           `json:"type"`
+	Topic   string           `json:"topic"`
+	Seq     uint64           `json:"seq"`
+	Prev    uint64           `json:"prev"`
+	Scene   codec.Raw        `json:"scene"`
+	Data    codec.Raw        `json:"data"`
+	Ops     []msgpackPatchOp `json:"ops"`
+	Events  []streamEvent    `json:"events"`
+	Reason  string           `json:"reason"`
+	ID      string           `json:"id"`
+	Command string           `json:"command"`
+	Params  interface{}      `json:"params"`
+	Result  codec.Raw        `json:"result"`
+	Error   *streamError     `json:"error"`
+}
+
+type msgpackPatchOp struct {
+	Op    string    `json:"op"`
+	Path  string    `json:"path"`
+	Value codec.Raw `json:"value"`
+}
+
+func (e msgpackEncoding) encodeMessage(message sceneStreamMessage) ([]byte, error) {
+	out := msgpackStreamMessage{
+		Type:    message.Type,
+		Topic:   message.Topic,
+		Seq:     message.Seq,
+		Prev:    message.Prev,
+		Scene:   msgpackRaw(message.Scene),
+		Data:    msgpackRaw(message.Data),
+		Events:  message.Events,
+		Reason:  message.Reason,
+		ID:      message.ID,
+		Command: message.Command,
+		Result:  msgpackRaw(message.Result),
+		Error:   message.Error,
+	}
+	if len(message.Ops) > 0 {
+		out.Ops = make([]msgpackPatchOp, len(message.Ops))
+		for i, op := range message.Ops {
+			out.Ops[i] = msgpackPatchOp{Op: op.Op, Path: op.Path, Value: msgpackRaw(op.Value)}
+		}
+	}
+	return e.marshal(out)
+}
+
+// msgpackNil 为 MessagePack 的 nil。
+var msgpackNil = codec.Raw{0xc0}
+
+// msgpackRaw 返回原样嵌入的内容。空的 codec.Raw 不写入任何字节，会使数组中后续字段错位，需写入 nil。
+func msgpackRaw(raw []byte) codec.Raw {
+	if len(raw) == 0 {
+		return msgpackNil
+	}
+	return raw
+}
+
+// decodeMessage 接受数组或以字段名为键的映射形式的客户端消息。
+func (e msgpackEncoding) decodeMessage(raw []byte) (sceneStreamMessage, error) {
+	var in struct {
+		Type    string      `json:"type"`
+		Topic   string      `json:"topic"`
+		Seq     uint64      `json:"seq"`
+		Prev    uint64      `json:"prev"`
+		Scene   interface{} `json:"scene"`
+		Data    interface{} `json:"data"`
+		Ops     interface{} `json:"ops"`
+		Events  interface{} `json:"events"`
+		Reason  string      `json:"reason"`
+		ID      string      `json:"id"`
+		Command string      `json:"command"`
+		Params  interface{} `json:"params"`
+	}
+	if err := codec.NewDecoderBytes(raw, e.handle).Decode(&in); err != nil {
+		return sceneStreamMessage{}, err
+	}
+	message := sceneStreamMessage{Type: in.Type, Topic: in.Topic, ID: in.ID, Command: in.Command}
+	if in.Params != nil {
+		params, err := json.Marshal(in.Params)
+		if err != nil {
+			return sceneStreamMessage{}, fmt.Errorf("params: %v", err)
+		}
+		message.Params = params
+	}
+	return message, nil
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultStreamRole 为未指定 role 时的客户端角色。
+	defaultStreamRole = "viewer"
+	// maxPresenceNameLength 为 clientId 与 role 的最大长度。
+	maxPresenceNameLength = 64
+
+	presenceJoin   = "join"
+	presenceLeave  = "leave"
+	presenceUpdate = "update"
+)
+
+// StreamPresence 描述一个场景流连接：客户端自报的 ID 与角色、订阅的主题、通过命令操控过的 Agent，
+// 以及推送进度。lag 为最新场景序号与已写出消息覆盖到的序号之差，queued 为发送队列中尚未写出的消息数。
+type StreamPresence struct {
+	ClientID    string    `json:"clientId"`
+	Role        string    `json:"role"`
+	Transport   string    `json:"transport"`
+	Encoding    string    `json:"encoding"`
+	Rate        float64   `json:"rate,omitempty"`
+	Topics      []string  `json:"topics"`
+	Controlling []string  `json:"controlling"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Lag         uint64    `json:"lag"`
+	Queued      int       `json:"queued"`
+}
+
+// StreamPresenceResponse 为 GET /scene/presence 的响应。
+type StreamPresenceResponse struct {
+	Clients []StreamPresence `json:"clients"`
+}
+
+// parsePresenceName 校验 clientId 与 role：1 到 64 个字母、数字或 ._:- 字符。
+func parsePresenceName(field, value string) (string, error) {
+	if len(value) == 0 || len(value) > maxPresenceNameLength {
+		return "", fmt.Errorf("%s must be 1-%d characters", field, maxPresenceNameLength)
+	}
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == ':', r == '-':
+		default:
+			return "", fmt.Errorf("%s may only contain letters, digits and ._:-", field)
+		}
+	}
+	return value, nil
+}
+
+// newStreamClientID 为未提供 clientId 的连接生成随机 ID。
+func newStreamClientID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("client-%d", time.Now().UnixNano())
+	}
+	return "client-" + hex.EncodeToString(b[:])
+}
+
+// control 记录客户端通过命令操控了 Agent，返回是否为新记录。
+func (c *sceneStreamClient) control(agentID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.controlling[agentID]; ok {
+		return false
+	}
+	c.controlling[agentID] = struct{}{}
+	return true
+}
+
+// presence 返回客户端在场景序号 seq 时的在线状态。
+func (c *sceneStreamClient) presence(seq uint64) StreamPresence {
+	c.mu.Lock()
+	controlling := make([]string, 0, len(c.controlling))
+	for agentID := range c.controlling {
+		controlling = append(controlling, agentID)
+	}
+	c.mu.Unlock()
+	sort.Strings(controlling)
+
+	presence := StreamPresence{
+		ClientID:    c.id,
+		Role:        c.role,
+		Transport:   "websocket",
+		Encoding:    c.group.encoding.name(),
+		Topics:      c.subscribedTopics(),
+		Controlling: controlling,
+		ConnectedAt: c.connectedAt,
+		Queued:      len(c.send),
+	}
+	if _, ok := c.transport.(*sseTransport); ok {
+		presence.Transport = "sse"
+	}
+	if c.group.interval > 0 {
+		presence.Rate = float64(time.Second) / float64(c.group.interval)
+	}
+	if sent := c.sentSeq.Load(); sent > 0 && seq > sent {
+		presence.Lag = seq - sent
+	}
+	return presence
+}
+
+// presenceLocked 返回全部连接的在线状态，按连接时间与客户端 ID 排序。调用方需持有 stateMu。
+func (s *sceneStream) presenceLocked() []StreamPresence {
+	clients := s.snapshotClients()
+	presence := make([]StreamPresence, 0, len(clients))
+	for _, client := range clients {
+		presence = append(presence, client.presence(s.seq))
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		if !presence[i].ConnectedAt.Equal(presence[j].ConnectedAt) {
+			return presence[i].ConnectedAt.Before(presence[j].ConnectedAt)
+		}
+		return presence[i].ClientID < presence[j].ClientID
+	})
+	return presence
+}
+
+// presence 返回全部连接的在线状态。
+func (s *sceneStream) presence() []StreamPresence {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.presenceLocked()
+}
+
+// presenceSnapshotLocked 返回 presence 主题的快照，data 为全部连接的在线状态。调用方需持有 stateMu。
+func (s *sceneStream) presenceSnapshotLocked(enc streamEncoding) []byte {
+	data, err := enc.marshal(s.presenceLocked())
+	if err != nil {
+		log.Printf("sceneStream: marshal presence failed: %v", err)
+		return nil
+	}
+	payload, err := enc.encodeMessage(sceneStreamMessage{Type: streamMessageSnapshot, Topic: streamTopicPresence, Seq: s.seq, Data: data})
+	if err != nil {
+		log.Printf("sceneStream: marshal presence snapshot failed: %v", err)
+		return nil
+	}
+	return payload
+}
+
+// announceLocked 向订阅 presence 主题的客户端下发客户端加入、离开或状态变化的事件。
+// presence 事件不进入回放缓冲，重连时以快照代替。调用方需持有 stateMu。
+func (s *sceneStream) announceLocked(action string, client *sceneStreamClient) {
+	presence := client.presence(s.seq)
+	message := sceneStreamMessage{
+		Type:   streamMessageEvent,
+		Topic:  streamTopicPresence,
+		Seq:    s.seq,
+		Events: []streamEvent{{Kind: streamTopicPresence, Action: action, Presence: &presence}},
+	}
+	payloads := make(map[streamEncoding][]byte)
+	for _, subscriber := range s.snapshotClients() {
+		if !subscriber.wants(streamTopicPresence) {
+			continue
+		}
+		enc := subscriber.group.encoding
+		payload, ok := payloads[enc]
+		if !ok {
+			var err error
+			if payload, err = enc.encodeMessage(message); err != nil {
+				log.Printf("sceneStream: marshal presence event failed: %v", err)
+			}
+			payloads[enc] = payload
+		}
+		subscriber.enqueue(payload)
+	}
+}
+
+// announce 与 announceLocked 相同，供未持有 stateMu 的调用方使用。
+func (s *sceneStream) announce(action string, client *sceneStreamClient) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.announceLocked(action, client)
+}
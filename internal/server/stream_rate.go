@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamRateGranularity 为推送间隔的取整粒度，请求频率相近的客户端落在同一组、共享视图与补丁。
+	streamRateGranularity = 50 * time.Millisecond
+	// maxStreamInterval 为 rate 参数允许的最长推送间隔。
+	maxStreamInterval = time.Minute
+	// streamSpectatorInterval 为旁观客户端的界限：推送间隔不短于该值（不高于 1 Hz）的客户端占用旁观名额。
+	streamSpectatorInterval = time.Second
+
+	// maxStreamClients 为场景流（WebSocket 与 SSE）的连接总数上限，maxStreamSpectators 为其中旁观客户端的上限，
+	// 其余名额留给交互客户端。
+	maxStreamClients    = 1000
+	maxStreamSpectators = 750
+	// streamRetryAfter 为连接数已满或正在停机时建议客户端等待的时间。
+	streamRetryAfter = 5 * time.Second
+)
+
+// streamQuery 为场景流连接的查询参数。
+type streamQuery struct {
+	clientID string
+	role     string
+	topics   []string
+	since    *uint64
+	// interval 为客户端请求的最小推送间隔，0 表示不限制。
+	interval time.Duration
+}
+
+// spectator 判断客户端是否为低频旁观客户端。
+func (q streamQuery) spectator() bool {
+	return q.interval >= streamSpectatorInterval
+}
+
+// parseStreamRate 把每秒推送次数转换为推送间隔，按 streamRateGranularity 取整；未指定时返回 0。
+func parseStreamRate(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(rate > 0) || math.IsInf(rate, 1) {
+		return 0, errors.New("rate must be a positive number of updates per second")
+	}
+	// 先比较频率再换算：过小的 rate 换算成 Duration 会溢出为负值。
+	if rate < 1/maxStreamInterval.Seconds() {
+		return 0, errors.New("rate must be at least one update per minute")
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return max(interval.Round(streamRateGranularity), streamRateGranularity), nil
+}
+
+// admit 为新连接占用名额，名额已满或正在停机时返回 503 并带 Retry-After，返回 false。
+// 占用成功时调用方需在连接结束后调用 release。
+func (s *sceneStream) admit(c *gin.Context, query streamQuery) bool {
+	s.mu.Lock()
+	reason := ""
+	switch {
+	case s.closing:
+		reason = "scene stream is shutting down"
+	case s.connections >= s.maxClients || query.spectator() && s.spectators >= s.maxSpectators:
+		reason = "too many scene stream connections"
+	default:
+		s.connections++
+		if query.spectator() {
+			s.spectators++
+		}
+	}
+	s.mu.Unlock()
+
+	if reason != "" {
+		c.Header("Retry-After", strconv.Itoa(int(streamRetryAfter/time.Second)))
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: reason})
+		return false
+	}
+	return true
+}
+
+// release 归还 admit 占用的名额。
+func (s *sceneStream) release(query streamQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections--
+	if query.spectator() {
+		s.spectators--
+	}
+}
+
+// dueLocked 判断该组是否可以推送：不限频的组总是可以，限频的组距上次推送已满间隔。调用方需持有 stateMu。
+func (s *sceneStream) dueLocked(group viewGroup, now time.Time) bool {
+	return group.interval == 0 || !now.Before(s.throttle[group])
+}
+
+// collectLocked 为可推送的组计算各主题消息；未到推送时间的组记为待推送，到期时由 flush 合并推送期间的全部变化。
+// flushing 为 true 时只处理待推送的组。调用方需持有 stateMu。
+func (s *sceneStream) collectLocked(active map[viewKey]struct{}, flushing bool) []topicMessage {
+	now := time.Now()
+	keys := make([]viewKey, 0, len(active))
+	for key := range active {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].topic != keys[j].topic {
+			return keys[i].topic < keys[j].topic
+		}
+		if keys[i].encoding != keys[j].encoding {
+			return keys[i].encoding.name() < keys[j].encoding.name()
+		}
+		return keys[i].interval < keys[j].interval
+	})
+
+	var messages []topicMessage
+	processed := make(map[viewGroup]struct{})
+	sent := make(map[viewGroup]struct{})
+	for _, key := range keys {
+		// presence 主题不随场景变化，由 announceLocked 推送。
+		if key.topic == streamTopicPresence {
+			continue
+		}
+		if !s.dueLocked(key.viewGroup, now) {
+			s.pending[key.viewGroup] = struct{}{}
+			continue
+		}
+		if _, ok := s.pending[key.viewGroup]; flushing && !ok {
+			continue
+		}
+		processed[key.viewGroup] = struct{}{}
+
+		var payload []byte
+		if key.topic == streamTopicEvents {
+			payload = s.eventsMessageLocked(key.encoding, s.pendingEvents[key])
+			delete(s.pendingEvents, key)
+		} else {
+			payload = s.updateTopicLocked(key, s.lastScene, s.lastRaw)
+		}
+		if len(payload) > 0 {
+			messages = append(messages, topicMessage{key: key, payload: payload})
+			sent[key.viewGroup] = struct{}{}
+		}
+	}
+
+	for group := range processed {
+		delete(s.pending, group)
+	}
+	for group := range sent {
+		if group.interval > 0 {
+			s.throttle[group] = now.Add(group.interval)
+		}
+	}
+	s.scheduleFlushLocked(now)
+	return messages
+}
+
+// queueEventsLocked 把本次场景的事件追加到各 events 订阅组的待发列表，限频的组在下次推送时一并下发。调用方需持有 stateMu。
+func (s *sceneStream) queueEventsLocked(active map[viewKey]struct{}, events []streamEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for key := range active {
+		if key.topic == streamTopicEvents {
+			s.pendingEvents[key] = append(s.pendingEvents[key], events...)
+		}
+	}
+}
+
+// pruneLocked 丢弃已无订阅者的视图、待发事件与限频状态。调用方需持有 stateMu。
+func (s *sceneStream) pruneLocked(active map[viewKey]struct{}) {
+	groups := make(map[viewGroup]struct{})
+	for key := range active {
+		groups[key.viewGroup] = struct{}{}
+	}
+	for key := range s.views {
+		if _, ok := active[key]; !ok {
+			delete(s.views, key)
+		}
+	}
+	for key := range s.pendingEvents {
+		if _, ok := active[key]; !ok {
+			delete(s.pendingEvents, key)
+		}
+	}
+	for group := range s.throttle {
+		if _, ok := groups[group]; !ok {
+			delete(s.throttle, group)
+			delete(s.pending, group)
+		}
+	}
+}
+
+// scheduleFlushLocked 把 flush 定时器设为最早到期的待推送组，没有待推送的组时停止定时器。调用方需持有 stateMu。
+func (s *sceneStream) scheduleFlushLocked(now time.Time) {
+	var next time.Time
+	for group := range s.pending {
+		if due := s.throttle[group]; next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if next.IsZero() {
+		s.flushTimer.Stop()
+		return
+	}
+	s.flushTimer.Reset(max(next.Sub(now), 0))
+}
+
+// flush 推送到期的待推送组，补丁为该组上次推送以来全部变化的合并结果。
+func (s *sceneStream) flush() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	messages := s.collectLocked(s.activeTopics(), true)
+	if len(messages) > 0 {
+		s.recordFrameLocked(messages)
+	}
+	s.deliver(messages)
+}
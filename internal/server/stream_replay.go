@@ -0,0 +1,121 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	streamMessageClose = "close"
+
+	// maxReplayFrames 与 maxReplayBytes 限制回放缓冲保留的场景帧数与消息总字节数。
+	maxReplayFrames = 256
+	maxReplayBytes  = 4 << 20
+
+	// streamSendBuffer 为客户端发送队列长度，需容纳一次订阅全部主题的快照。
+	streamSendBuffer = 64
+
+	// streamCloseSlowConsumer 为发送队列写满时的关闭原因，关闭帧状态码为 1008（Policy Violation）。
+	streamCloseSlowConsumer     = "slow-consumer"
+	streamClosePolicyViolation  = 1008
+	streamResyncSinceExpired    = "since-expired"
+	streamCloseWriteGracePeriod = time.Second
+)
+
+// streamFrame 为一次场景变化产生的各主题消息，按场景序号保存在回放缓冲中。
+type streamFrame struct {
+	seq      uint64
+	messages []topicMessage
+	size     int
+}
+
+// recordFrameLocked 把本次场景变化的消息追加到回放缓冲，超出上限时丢弃最早的帧。调用方需持有 stateMu。
+func (s *sceneStream) recordFrameLocked(messages []topicMessage) {
+	frame := streamFrame{seq: s.seq, messages: messages}
+	for _, message := range messages {
+		frame.size += len(message.payload)
+	}
+	s.history = append(s.history, frame)
+	s.historyBytes += frame.size
+	drop := 0
+	for drop < len(s.history)-1 && (len(s.history)-drop > maxReplayFrames || s.historyBytes > maxReplayBytes) {
+		s.historyBytes -= s.history[drop].size
+		drop++
+	}
+	if drop > 0 {
+		s.history = append(s.history[:0:0], s.history[drop:]...)
+	}
+}
+
+// initialPayloadsLocked 返回新连接需要先收到的消息。since 为 nil 时下发各主题快照；
+// 否则回放 since 之后的变化，回放缓冲不再覆盖 since 时先下发 resync 通知再下发快照。调用方需持有 stateMu。
+func (s *sceneStream) initialPayloadsLocked(group viewGroup, topics []string, since *uint64) [][]byte {
+	var payloads [][]byte
+	snapshots := topics
+	if since != nil {
+		if s.canReplayLocked(*since) {
+			payloads, snapshots = s.replayLocked(group, topics, *since)
+		} else {
+			payloads = append(payloads, s.controlMessageLocked(group.encoding, streamMessageResync, streamResyncSinceExpired))
+		}
+	}
+	for _, topic := range snapshots {
+		key := viewKey{viewGroup: group, topic: topic}
+		s.ensureTopicLocked(key)
+		if payload := s.topicSnapshotLocked(key); len(payload) > 0 {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
+
+// canReplayLocked 判断回放缓冲是否包含 since 之后的全部场景帧。
+// 服务启动时场景序号以毫秒时间戳为起点，重启前的 since 总小于缓冲起点，会被要求重新同步。调用方需持有 stateMu。
+func (s *sceneStream) canReplayLocked(since uint64) bool {
+	if since > s.seq {
+		return false
+	}
+	if since == s.seq {
+		return true
+	}
+	return len(s.history) > 0 && s.history[0].seq <= since+1
+}
+
+// replayLocked 返回组内各主题在 since 之后的消息，以及需要改发快照的主题：
+// 视图在 since 之后才建立的主题没有完整的变化记录，presence 事件不进入回放缓冲，这些主题只能下发快照。调用方需持有 stateMu。
+func (s *sceneStream) replayLocked(group viewGroup, topics []string, since uint64) ([][]byte, []string) {
+	replay := make(map[string]struct{}, len(topics))
+	var snapshots []string
+	for _, topic := range topics {
+		key := viewKey{viewGroup: group, topic: topic}
+		s.ensureTopicLocked(key)
+		if state, ok := s.views[key]; ok && state.since > since || topic == streamTopicPresence {
+			snapshots = append(snapshots, topic)
+			continue
+		}
+		replay[topic] = struct{}{}
+	}
+
+	var payloads [][]byte
+	for _, frame := range s.history {
+		if frame.seq <= since {
+			continue
+		}
+		for _, message := range frame.messages {
+			if _, ok := replay[message.key.topic]; ok && message.key.viewGroup == group {
+				payloads = append(payloads, message.payload)
+			}
+		}
+	}
+	return payloads, snapshots
+}
+
+// controlMessageLocked 返回以 enc 编码、带当前场景序号的 resync 或 close 通知。调用方需持有 stateMu。
+func (s *sceneStream) controlMessageLocked(enc streamEncoding, messageType, reason string) []byte {
+	payload, err := enc.encodeMessage(sceneStreamMessage{Type: messageType, Seq: s.seq, Reason: reason})
+	if err != nil {
+		log.Printf("sceneStream: marshal %s failed: %v", messageType, err)
+		return nil
+	}
+	return payload
+}
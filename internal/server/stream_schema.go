@@ -0,0 +1,134 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"eeo/backend/internal/service/game"
+	"github.com/ugorji/go/codec"
+)
+
+// StreamSchemaField 为 MessagePack 模式下结构体数组中的一个元素，index 为其在数组中的下标。
+type StreamSchemaField struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+}
+
+// StreamSchema 描述场景流的二进制编码：types 为各结构体按数组下标排列的字段，
+// message 为消息信封类型，topics 为各主题快照 data 字段的类型（scene 主题放在 scene 字段）。
+type StreamSchema struct {
+	Protocols []string                       `json:"protocols"`
+	Message   string                         `json:"message"`
+	Event     string                         `json:"event"`
+	Topics    map[string]string              `json:"topics"`
+	Types     map[string][]StreamSchemaField `json:"types"`
+}
+
+// streamSchemaMessage 为 schema 中的消息信封类型名。
+const streamSchemaMessage = "Message"
+
+var rawMessageType = reflect.TypeOf(codec.Raw(nil))
+
+// schemaTypeNames 为未导出类型在 schema 中的名称。
+var schemaTypeNames = map[string]string{
+	"msgpackPatchOp": "PatchOp",
+	"streamEvent":    "Event",
+	"streamError":    "Error",
+}
+
+// buildStreamSchema 由消息与视图类型的反射信息生成 schema，字段顺序与 MessagePack 编码一致。
+func buildStreamSchema() StreamSchema {
+	schema := StreamSchema{
+		Protocols: []string{streamProtocolJSON, streamProtocolMsgpack},
+		Message:   streamSchemaMessage,
+		Topics: map[string]string{
+			streamTopicScene:    "Scene",
+			streamTopicEnergy:   "EnergySummary",
+			streamTopicBuilding: "SceneBuilding",
+			streamTopicAgent:    "SceneAgent",
+			streamTopicViewport: "ViewportView",
+			streamTopicPresence: "[]StreamPresence",
+		},
+		Types: make(map[string][]StreamSchemaField),
+	}
+	schema.Types[streamSchemaMessage] = schemaFields(schema.Types, reflect.TypeOf(msgpackStreamMessage{}))
+	// scene 与 data 为已编码的视图，补丁值与 result 可为任意类型。
+	for i, field := range schema.Types[streamSchemaMessage] {
+		switch field.Name {
+		case "scene":
+			schema.Types[streamSchemaMessage][i].Type = "Scene"
+		case "data":
+			schema.Types[streamSchemaMessage][i].Type = "any (see topics)"
+		}
+	}
+	schema.Event = schemaType(schema.Types, reflect.TypeOf(streamEvent{}))
+	for _, view := range []interface{}{game.Scene{}, game.EnergySummary{}, ViewportView{}} {
+		schemaType(schema.Types, reflect.TypeOf(view))
+	}
+	return schema
+}
+
+// schemaType 返回类型的描述，结构体登记到 types 并以类型名表示。
+func schemaType(types map[string][]StreamSchemaField, t reflect.Type) string {
+	switch {
+	case t == rawMessageType:
+		return "any"
+	case t == reflect.TypeOf(time.Time{}):
+		return "timestamp"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaType(types, t.Elem()) + "?"
+	case reflect.Slice, reflect.Array:
+		return "[]" + schemaType(types, t.Elem())
+	case reflect.Map:
+		return "map[" + schemaType(types, t.Key()) + "]" + schemaType(types, t.Elem())
+	case reflect.Interface:
+		return "any"
+	case reflect.Struct:
+		name := t.Name()
+		if alias, ok := schemaTypeNames[name]; ok {
+			name = alias
+		}
+		if _, ok := types[name]; !ok {
+			// 先占位，避免自引用的类型无限递归。
+			types[name] = nil
+			types[name] = schemaFields(types, t)
+		}
+		return name
+	}
+	return t.Kind().String()
+}
+
+// schemaFields 按声明顺序列出导出字段，忽略 json:"-" 的字段，展开无标签的匿名结构体。
+func schemaFields(types map[string][]StreamSchemaField, t reflect.Type) []StreamSchemaField {
+	var fields []StreamSchemaField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for _, inner := range schemaFields(types, field.Type) {
+				inner.Index = len(fields)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, StreamSchemaField{Index: len(fields), Name: name, Type: schemaType(types, field.Type)})
+	}
+	return fields
+}
+
+// streamSchema 在包初始化时生成，之后不再变化。
+var streamSchema = buildStreamSchema()
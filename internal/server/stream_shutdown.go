@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// streamCloseServerRestarting 为停机时的关闭原因，关闭帧状态码为 1001（Going Away）。
+	streamCloseServerRestarting = "server-restarting"
+	streamCloseGoingAway        = 1001
+)
+
+// drain 停止接受新连接，等待进行中的 tick 结束后停止模拟，再以 1001 关闭全部客户端并等待关闭消息发出。
+// ctx 结束时不再等待。
+func (s *sceneStream) drain(ctx context.Context) {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	s.quitOnce.Do(func() { close(s.quit) })
+	select {
+	case <-s.loopDone:
+	case <-ctx.Done():
+		return
+	}
+
+	clients := s.snapshotClients()
+	for _, client := range clients {
+		client.closeWith(streamCloseGoingAway, streamCloseServerRestarting)
+	}
+	for _, client := range clients {
+		select {
+		case <-client.finished:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finalTick 把模拟推进到当前时间，保存上次 tick 之后的进度。需在 drain 停止模拟且进行中的请求结束后调用。
+func (s *sceneStream) finalTick(ctx context.Context) {
+	select {
+	case <-s.loopDone:
+	default:
+		log.Printf("sceneStream: simulation still running, skip final tick")
+		return
+	}
+
+	s.stateMu.Lock()
+	elapsed := time.Since(s.lastTick)
+	s.stateMu.Unlock()
+	seconds := s.seconds * elapsed.Seconds() / s.interval.Seconds()
+	if seconds <= 0 {
+		return
+	}
+	if _, err := s.service.AdvanceSimulation(ctx, seconds, s.drainFactor); err != nil {
+		log.Printf("sceneStream: final simulation tick failed: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"eeo/backend/internal/service/game"
+)
+
+const (
+	// streamTopicScene 为完整场景的快照与补丁，连接时默认订阅。
+	streamTopicScene    = "scene"
+	streamTopicEnergy   = "energy"
+	streamTopicEvents   = "events"
+	streamTopicBuilding = "building"
+	streamTopicAgent    = "agent"
+	streamTopicViewport = "viewport"
+	// streamTopicPresence 为场景流连接的在线状态，订阅时下发快照，之后下发 presence 事件。
+	streamTopicPresence = "presence"
+
+	// maxStreamTopics 为单个客户端最多订阅的主题数。
+	maxStreamTopics = 32
+)
+
+// streamTopic 为解析后的订阅主题。name 为规范化后的主题名，同一主题的所有订阅者共享一份视图状态。
+type streamTopic struct {
+	name string
+	kind string
+	id   string
+	rect [4]int
+}
+
+// parseStreamTopic 解析主题：scene、energy、events、presence、building:<id>、agent:<id> 或 viewport:x,y,w,h。
+func parseStreamTopic(value string) (streamTopic, error) {
+	value = strings.TrimSpace(value)
+	kind, arg, hasArg := strings.Cut(value, ":")
+	switch kind {
+	case streamTopicScene, streamTopicEnergy, streamTopicEvents, streamTopicPresence:
+		if hasArg {
+			return streamTopic{}, fmt.Errorf("topic %q takes no argument", kind)
+		}
+		return streamTopic{name: kind, kind: kind}, nil
+	case streamTopicBuilding, streamTopicAgent:
+		id := strings.TrimSpace(arg)
+		if id == "" {
+			return streamTopic{}, fmt.Errorf("topic %s requires an id", kind)
+		}
+		return streamTopic{name: kind + ":" + id, kind: kind, id: id}, nil
+	case streamTopicViewport:
+		parts := strings.Split(arg, ",")
+		if len(parts) != 4 {
+			return streamTopic{}, fmt.Errorf("topic viewport requires x,y,w,h")
+		}
+		var rect [4]int
+		for i, part := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return streamTopic{}, fmt.Errorf("topic viewport has invalid value %q", part)
+			}
+			rect[i] = n
+		}
+		if rect[2] <= 0 || rect[3] <= 0 {
+			return streamTopic{}, fmt.Errorf("topic viewport width/height must be positive")
+		}
+		return streamTopic{name: fmt.Sprintf("viewport:%d,%d,%d,%d", rect[0], rect[1], rect[2], rect[3]), kind: kind, rect: rect}, nil
+	}
+	return streamTopic{}, fmt.Errorf("unknown topic %q", value)
+}
+
+// parseStreamTopics 解析并去重主题列表，返回规范化后的主题名。
+func parseStreamTopics(values []string) ([]string, error) {
+	names := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		topic, err := parseStreamTopic(value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[topic.name]; ok {
+			continue
+		}
+		seen[topic.name] = struct{}{}
+		names = append(names, topic.name)
+	}
+	if len(names) > maxStreamTopics {
+		return nil, fmt.Errorf("at most %d topics allowed", maxStreamTopics)
+	}
+	return names, nil
+}
+
+// ViewportView 为 viewport 主题的数据：与矩形相交的建筑以及位于矩形内的 Agent。
+type ViewportView struct {
+	Rect      [4]int               `json:"rect"`
+	Buildings []game.SceneBuilding `json:"buildings"`
+	Agents    []game.SceneAgent    `json:"agents"`
+}
+
+// topicView 计算主题在场景上的视图，scene 主题由调用方处理。
+func topicView(topic streamTopic, scene game.Scene) interface{} {
+	switch topic.kind {
+	case streamTopicEnergy:
+		return game.SummarizeEnergy(scene)
+	case streamTopicBuilding:
+		for i := range scene.Buildings {
+			if scene.Buildings[i].ID == topic.id {
+				return scene.Buildings[i]
+			}
+		}
+	case streamTopicAgent:
+		for i := range scene.Agents {
+			if scene.Agents[i].ID == topic.id {
+				return scene.Agents[i]
+			}
+		}
+	case streamTopicViewport:
+		view := ViewportView{Rect: topic.rect, Buildings: []game.SceneBuilding{}, Agents: []game.SceneAgent{}}
+		x, y, w, h := topic.rect[0], topic.rect[1], topic.rect[2], topic.rect[3]
+		for _, building := range scene.Buildings {
+			if len(building.Rect) == 4 && building.Rect[0] < x+w && x < building.Rect[0]+building.Rect[2] &&
+				building.Rect[1] < y+h && y < building.Rect[1]+building.Rect[3] {
+				view.Buildings = append(view.Buildings, building)
+			}
+		}
+		for _, agent := range scene.Agents {
+			if len(agent.Position) == 2 && agent.Position[0] >= float64(x) && agent.Position[0] < float64(x+w) &&
+				agent.Position[1] >= float64(y) && agent.Position[1] < float64(y+h) {
+				view.Agents = append(view.Agents, agent)
+			}
+		}
+		return view
+	}
+	return nil
+}
+
+// viewGroup 为共享视图的一组订阅者：编码与推送间隔相同，组内每个主题只计算一次补丁。
+type viewGroup struct {
+	encoding streamEncoding
+	// interval 为最小推送间隔，0 表示场景每次变化立即推送。
+	interval time.Duration
+}
+
+// viewKey 标识某组订阅者的主题视图。
+type viewKey struct {
+	viewGroup
+	topic string
+}
+
+// topicState 为某个主题最近一次下发的视图，seq 为该视图最近一次变化时的场景序号，
+// since 为建立视图时的场景序号，此后主题的每次变化都记录在回放缓冲中。raw 为按视图编码序列化的结果。
+type topicState struct {
+	seq   uint64
+	since uint64
+	doc   interface{}
+	raw   json.RawMessage
+}
+
+// topicMessage 为需要发给某组订阅者的一条主题消息。
+type topicMessage struct {
+	key     viewKey
+	payload []byte
+}
+
+// streamEvent 为 events 主题下发的事件（Agent 移动事件或任务状态变化），或 presence 主题下发的连接加入、离开与状态变化。
+type streamEvent struct {
+	Kind           string              `json:"kind"`
+	Movement       *game.MovementEvent `json:"movement,omitempty"`
+	Job            *game.Job           `json:"job,omitempty"`
+	PreviousStatus string              `json:"previousStatus,omitempty"`
+	Action         string              `json:"action,omitempty"`
+	Presence       *StreamPresence     `json:"presence,omitempty"`
+}
+
+// updateTopicLocked 以场景更新主题视图并返回补丁消息；视图未变化时返回 nil，补丁不比视图小时改发快照。
+// raw 为 JSON 序列化的场景，JSON 编码的 scene 主题直接复用。调用方需持有 stateMu。
+func (s *sceneStream) updateTopicLocked(key viewKey, scene game.Scene, raw json.RawMessage) []byte {
+	var value interface{} = scene
+	if key.topic != streamTopicScene {
+		topic, err := parseStreamTopic(key.topic)
+		if err != nil {
+			return nil
+		}
+		value = topicView(topic, scene)
+	}
+	if key.topic != streamTopicScene || key.encoding != streamJSON {
+		var err error
+		if raw, err = key.encoding.marshal(value); err != nil {
+			log.Printf("sceneStream: marshal topic %s failed: %v", key.topic, err)
+			return nil
+		}
+	}
+
+	state, ok := s.views[key]
+	if ok && string(state.raw) == string(raw) {
+		return nil
+	}
+	doc, err := key.encoding.unmarshalDoc(raw)
+	if err != nil {
+		log.Printf("sceneStream: decode topic %s failed: %v", key.topic, err)
+		return nil
+	}
+	if !ok {
+		s.views[key] = &topicState{seq: s.seq, since: s.seq, doc: doc, raw: raw}
+		return s.topicSnapshotLocked(key)
+	}
+
+	ops := diffDocs(key.encoding, nil, "", state.doc, doc)
+	state.doc, state.raw = doc, raw
+	if len(ops) == 0 {
+		return nil
+	}
+	prev := state.seq
+	state.seq = s.seq
+	payload, err := key.encoding.encodeMessage(sceneStreamMessage{Type: streamMessagePatch, Topic: key.topic, Seq: s.seq, Prev: prev, Ops: ops})
+	if err != nil || len(payload) >= len(raw) {
+		return s.topicSnapshotLocked(key)
+	}
+	return payload
+}
+
+// ensureTopicLocked 为新订阅的主题建立视图状态；events 主题记录当前任务状态，只推送之后的变化。调用方需持有 stateMu。
+func (s *sceneStream) ensureTopicLocked(key viewKey) {
+	switch key.topic {
+	case streamTopicEvents:
+		if s.jobStatus == nil {
+			s.sceneEventsLocked(s.lastScene)
+		}
+		return
+	case streamTopicPresence:
+		return
+	}
+	if _, ok := s.views[key]; ok {
+		return
+	}
+	s.updateTopicLocked(key, s.lastScene, s.lastRaw)
+}
+
+// topicSnapshotLocked 返回主题当前视图的快照消息，seq 为当前场景序号；events 主题没有快照。调用方需持有 stateMu。
+func (s *sceneStream) topicSnapshotLocked(key viewKey) []byte {
+	if key.topic == streamTopicPresence {
+		return s.presenceSnapshotLocked(key.encoding)
+	}
+	state, ok := s.views[key]
+	if !ok {
+		return nil
+	}
+	message := sceneStreamMessage{Type: streamMessageSnapshot, Topic: key.topic, Seq: s.seq}
+	if key.topic == streamTopicScene {
+		message.Scene = state.raw
+	} else {
+		message.Data = state.raw
+	}
+	payload, err := key.encoding.encodeMessage(message)
+	if err != nil {
+		log.Printf("sceneStream: marshal snapshot failed: %v", err)
+		return nil
+	}
+	return payload
+}
+
+// sceneEventsLocked 提取本次场景中的事件：tick 产生的移动事件，以及相对上次发布状态发生变化的任务。
+// 尚未记录任务状态时只记录、不产生任务事件。调用方需持有 stateMu。
+func (s *sceneStream) sceneEventsLocked(scene game.Scene) []streamEvent {
+	var events []streamEvent
+	for i := range scene.MovementEvents {
+		events = append(events, streamEvent{Kind: "movement", Movement: &scene.MovementEvents[i]})
+	}
+
+	statuses := make(map[int64]string, len(scene.Jobs))
+	for i := range scene.Jobs {
+		job := scene.Jobs[i]
+		statuses[job.ID] = job.Status
+		if s.jobStatus == nil {
+			continue
+		}
+		if previous, ok := s.jobStatus[job.ID]; !ok || previous != job.Status {
+			events = append(events, streamEvent{Kind: "job", Job: &job, PreviousStatus: previous})
+		}
+	}
+	s.jobStatus = statuses
+	return events
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// streamPayload 为发送队列中的一项：一条消息，或 data 为空时标记此前入队的消息已覆盖到场景序号 checkpoint。
+type streamPayload struct {
+	data       string
+	checkpoint uint64
+}
+
+// streamTransport 为场景流客户端的底层连接，WebSocket 与 SSE 共用同一套订阅、回放与发送队列。
+type streamTransport interface {
+	// write 发送一条消息。
+	write(payload string) error
+	// checkpoint 标记此前的消息已覆盖到场景序号 seq，断线重连时可从该序号续传。
+	checkpoint(seq uint64) error
+	// deadline 设置写超时。
+	deadline(t time.Time)
+	// close 结束连接，message 不为 nil 时先告知客户端关闭原因。
+	close(message *sceneStreamMessage)
+	// messageEncoding 返回连接使用的消息编码。
+	messageEncoding() streamEncoding
+}
+
+// finish 在客户端关闭后结束连接，带原因关闭时附带 close 消息。
+func (c *sceneStreamClient) finish() {
+	if c.closeReason == "" {
+		c.transport.close(nil)
+		return
+	}
+
+	c.stream.stateMu.Lock()
+	seq := c.stream.seq
+	c.stream.stateMu.Unlock()
+	c.transport.deadline(time.Now().Add(streamCloseWriteGracePeriod))
+	c.transport.close(&sceneStreamMessage{
+		Type:   streamMessageClose,
+		Seq:    seq,
+		Reason: c.closeReason,
+		Error:  &streamError{Code: c.closeCode, Message: c.closeReason},
+	})
+}
+
+// websocketTransport 按握手时协商的编码发送消息，二进制编码使用二进制帧。
+type websocketTransport struct {
+	conn     *websocket.Conn
+	encoding streamEncoding
+}
+
+func (t *websocketTransport) write(payload string) error {
+	if t.messageEncoding().binary() {
+		return websocket.Message.Send(t.conn, []byte(payload))
+	}
+	return websocket.Message.Send(t.conn, payload)
+}
+
+// checkpoint 对 WebSocket 无需处理，客户端自行记录收到的 seq。
+func (t *websocketTransport) checkpoint(uint64) error {
+	return nil
+}
+
+func (t *websocketTransport) deadline(deadline time.Time) {
+	_ = t.conn.SetWriteDeadline(deadline)
+}
+
+// close 带原因关闭时先发送 close 消息，再发送携带相同状态码与原因的关闭帧，否则正常关闭。
+func (t *websocketTransport) close(message *sceneStreamMessage) {
+	if message == nil {
+		_ = t.conn.Close()
+		return
+	}
+	payload, err := t.messageEncoding().encodeMessage(*message)
+	if err != nil || t.write(string(payload)) != nil {
+		return
+	}
+	// 关闭帧已发出，底层连接由 websocket.Server 在处理函数返回后关闭。
+	_ = closeFrameCodec.Send(t.conn, *message)
+}
+
+// messageEncoding 未指定编码时使用 JSON。
+func (t *websocketTransport) messageEncoding() streamEncoding {
+	if t.encoding == nil {
+		return streamJSON
+	}
+	return t.encoding
+}
+
+// closeFrameCodec 发送带状态码与原因的 WebSocket 关闭帧。
+var closeFrameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		message := v.(sceneStreamMessage)
+		payload := make([]byte, 2, 2+len(message.Reason))
+		binary.BigEndian.PutUint16(payload, uint16(message.Error.Code))
+		return append(payload, message.Reason...), websocket.CloseFrame, nil
+	},
+}
+
+// sseTransport 以 text/event-stream 推送消息，每条消息为一个 data 事件。
+type sseTransport struct {
+	w gin.ResponseWriter
+}
+
+func (t *sseTransport) write(payload string) error {
+	if err := sse.Encode(t.w, sse.Event{Data: []byte(payload)}); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return nil
+}
+
+// checkpoint 发送只有 id 的空事件：浏览器据此更新 Last-Event-ID 而不触发 message 事件，
+// 重连时从最后一批完整收到的消息之后续传。
+func (t *sseTransport) checkpoint(seq uint64) error {
+	if _, err := fmt.Fprintf(t.w, "id:%d\n\n", seq); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return nil
+}
+
+func (t *sseTransport) deadline(deadline time.Time) {
+	_ = http.NewResponseController(t.w).SetWriteDeadline(deadline)
+}
+
+// close 带原因关闭时先发送 close 消息；处理函数返回后连接结束，EventSource 会带 Last-Event-ID 自动重连。
+func (t *sseTransport) close(message *sceneStreamMessage) {
+	if message == nil {
+		return
+	}
+	if payload, err := json.Marshal(message); err == nil {
+		_ = t.write(string(payload))
+	}
+}
+
+// messageEncoding 对 SSE 固定为 JSON，事件数据为文本。
+func (t *sseTransport) messageEncoding() streamEncoding {
+	return streamJSON
+}
+
+// handleEvents 以 Server-Sent Events 推送与 WebSocket 流相同的消息，供无法升级 WebSocket 的环境使用。
+// 查询参数与 WebSocket 流相同；重连时浏览器带上的 Last-Event-ID 代替 since。SSE 为单向流，命令需走 REST 接口。
+func (s *sceneStream) handleEvents(c *gin.Context) {
+	query, ok := parseStreamQuery(c, c.GetHeader("Last-Event-ID"))
+	if !ok || !s.admit(c, query) {
+		return
+	}
+	defer s.release(query)
+
+	// http.Server 的读写超时只适用于普通请求；读超时到期会取消请求上下文并结束事件流。
+	controller := http.NewResponseController(c.Writer)
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", sse.ContentType)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	client := s.addClient(&sseTransport{w: c.Writer}, query)
+	go func() {
+		select {
+		case <-c.Request.Context().Done():
+			client.close()
+		case <-client.done:
+		}
+	}()
+	client.run()
+}
@@ -51,16 +51,8 @@ func New(db *sql.DB) *Service {
 }
 
 func (s *Service) LogAction(ctx context.Context, in LogActionInput) error {
-	if in.AgentID == "" {
-		return errors.New("agent_id required")
-	}
-	if in.ActionType == "" {
-		return errors.New("action_type required")
-	}
-
-	label := in.Label
-	if label == "" {
-		label = in.AgentID
+	if err := validateLogAction(in); err != nil {
+		return err
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -73,7 +65,25 @@ func (s *Service) LogAction(ctx context.Context, in LogActionInput) error {
 		}
 	}()
 
-	if _, err = tx.ExecContext(
+	if err = RecordAction(ctx, tx, in); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordAction 在调用方的事务中写入行为事件与动作状态，供执行动作时与移动一并提交。
+func RecordAction(ctx context.Context, tx *sql.Tx, in LogActionInput) error {
+	if err := validateLogAction(in); err != nil {
+		return err
+	}
+
+	label := in.Label
+	if label == "" {
+		label = in.AgentID
+	}
+
+	if _, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO agents (id, label) VALUES ($1, $2)
          ON CONFLICT (id) DO UPDATE SET label = EXCLUDED.label`,
@@ -82,7 +92,7 @@ func (s *Service) LogAction(ctx context.Context, in LogActionInput) error {
 		return err
 	}
 
-	if _, err = tx.ExecContext(
+	if _, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO agent_action_events
             (agent_id, action_type, payload, issued_by, source, correlation_id, result_status, result_message)
@@ -100,7 +110,7 @@ func (s *Service) LogAction(ctx context.Context, in LogActionInput) error {
 	}
 
 	if in.Actions != nil {
-		if _, err = tx.ExecContext(
+		if _, err := tx.ExecContext(
 			ctx,
 			`INSERT INTO agent_action_state (agent_id, actions, updated_at)
 			 VALUES ($1, $2, now())
@@ -113,7 +123,17 @@ func (s *Service) LogAction(ctx context.Context, in LogActionInput) error {
 		}
 	}
 
-	return tx.Commit()
+	return nil
+}
+
+func validateLogAction(in LogActionInput) error {
+	if in.AgentID == "" {
+		return errors.New("agent_id required")
+	}
+	if in.ActionType == "" {
+		return errors.New("action_type required")
+	}
+	return nil
 }
 
 func (s *Service) ListEvents(ctx context.Context, agentID string, limit int) ([]Event, error) {
@@ -170,6 +190,20 @@ func (s *Service) GetState(ctx context.Context, agentID string) (State, error) {
 	return st, err
 }
 
+// PurgeHistory 在调用方的事务中删除 Agent 的行为历史，供删除场景 Agent 时与实例一并提交。
+func PurgeHistory(ctx context.Context, tx *sql.Tx, agentID string) error {
+	for _, stmt := range []string{
+		`DELETE FROM agent_action_events WHERE agent_id = $1`,
+		`DELETE FROM agent_action_state WHERE agent_id = $1`,
+		`DELETE FROM agents WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, agentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func nullableString(s string) interface{} {
 	if s == "" {
 		return nil
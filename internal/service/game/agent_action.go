@@ -0,0 +1,213 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	actionservice "eeo/backend/internal/service/action"
+)
+
+const (
+	ActionStatusSucceeded = "succeeded"
+	ActionStatusRejected  = "rejected"
+	ActionStatusBlocked   = "blocked"
+	ActionStatusIgnored   = "ignored"
+)
+
+// movementDeltas 描述移动动作对应的单步位移，y 轴向下为正。
+var movementDeltas = map[string][2]int{
+	"move_left":  {-1, 0},
+	"move_right": {1, 0},
+	"move_up":    {0, -1},
+	"move_down":  {0, 1},
+}
+
+// ExecuteAgentActionInput 表示执行 Agent 动作所需的参数。
+// Log 非空时，执行结果填入其 result_status/result_message，与移动在同一事务中记录。
+type ExecuteAgentActionInput struct {
+	AgentID    string
+	ActionType string
+	Payload    json.RawMessage
+	Log        *actionservice.LogActionInput
+}
+
+// AgentActionResult 描述动作执行结果，Status/Message 会写入行为事件的 result_status/result_message。
+type AgentActionResult struct {
+	AgentID  string      `json:"agentId"`
+	Action   string      `json:"action"`
+	Status   string      `json:"status"`
+	Message  string      `json:"message"`
+	Executed bool        `json:"executed"`
+	From     []float64   `json:"from,omitempty"`
+	To       []float64   `json:"to,omitempty"`
+	Agent    *SceneAgent `json:"agent,omitempty"`
+}
+
+// movementPayload 为移动动作的可选参数。
+type movementPayload struct {
+	Steps int `json:"steps"`
+}
+
+// ExecuteAgentAction 校验动作是否在 Agent 的可用动作内，并执行移动类动作。
+// 未授权或被阻挡的动作不会报错，而是以结果状态返回，便于调用方照常记录事件。
+func (s *Service) ExecuteAgentAction(ctx context.Context, in ExecuteAgentActionInput) (AgentActionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agentID := strings.TrimSpace(in.AgentID)
+	action := strings.TrimSpace(in.ActionType)
+	if agentID == "" {
+		return AgentActionResult{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
+	}
+	agent, ok := findAgent(s.scene, agentID)
+	if !ok {
+		return AgentActionResult{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+
+	result, to := resolveAgentAction(s.scene, s.spatialIndex(), agent, action, in.Payload)
+	if to == nil && in.Log == nil {
+		return result, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AgentActionResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if to != nil {
+		if err = writeAgentPosition(ctx, tx, agentID, float64(to[0]), float64(to[1])); err != nil {
+			return AgentActionResult{}, err
+		}
+	}
+	if in.Log != nil {
+		entry := *in.Log
+		entry.ResultStatus = result.Status
+		entry.ResultMessage = result.Message
+		if err = actionservice.RecordAction(ctx, tx, entry); err != nil {
+			return AgentActionResult{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return AgentActionResult{}, err
+	}
+	if to == nil {
+		return result, nil
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return AgentActionResult{}, err
+	}
+	updated, ok := findAgent(s.scene, agentID)
+	if !ok {
+		return AgentActionResult{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+	result.To = updated.Position
+	result.Agent = &updated
+	return result, nil
+}
+
+// resolveAgentAction 计算动作的执行结果，动作会移动 Agent 时返回目标格；index 为场景建筑的空间索引。
+func resolveAgentAction(scene Scene, index *SpatialIndex, agent SceneAgent, action string, raw json.RawMessage) (AgentActionResult, *[2]int) {
+	result := AgentActionResult{AgentID: agent.ID, Action: action}
+	if !agentAllowsAction(agent, action) {
+		result.Status = ActionStatusRejected
+		result.Message = fmt.Sprintf("action %s is not allowed for agent %s", action, agent.ID)
+		return result, nil
+	}
+
+	delta, isMove := movementDeltas[action]
+	if !isMove {
+		result.Status = ActionStatusIgnored
+		result.Message = fmt.Sprintf("action %s has no executor", action)
+		return result, nil
+	}
+
+	steps := 1
+	if len(raw) > 0 && string(raw) != "null" {
+		var payload movementPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			result.Status = ActionStatusRejected
+			result.Message = fmt.Sprintf("invalid movement payload: %v", err)
+			return result, nil
+		}
+		if payload.Steps > 0 {
+			steps = payload.Steps
+		}
+	}
+
+	from := agentTile(agent.Position)
+	to, moved, blockedBy := planMovement(from, delta, steps, scene.Dimensions, index, scene.Terrain)
+	result.From = append([]float64(nil), agent.Position...)
+	if moved == 0 {
+		result.Status = ActionStatusBlocked
+		result.Message = describeBlock(blockedBy)
+		result.To = result.From
+		return result, nil
+	}
+
+	result.Status = ActionStatusSucceeded
+	result.Executed = true
+	result.Message = fmt.Sprintf("moved %d tile(s) to (%d, %d)", moved, to[0], to[1])
+	if moved < steps {
+		result.Message += "; " + describeBlock(blockedBy)
+	}
+	return result, &to
+}
+
+func agentAllowsAction(agent SceneAgent, action string) bool {
+	for _, allowed := range agent.Actions {
+		if allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+// agentTile 将运行时浮点坐标四舍五入到所在格子。
+func agentTile(position []float64) [2]int {
+	var tile [2]int
+	for i := 0; i < len(position) && i < 2; i++ {
+		tile[i] = int(math.Round(position[i]))
+	}
+	return tile
+}
+
+// planMovement 沿方向逐格前进，遇到场景边界、建筑或陨石坑时停止，返回终点、实际步数以及阻挡原因。
+// 与寻路和路径校验一致，陨石坑不可通行。
+func planMovement(from, delta [2]int, steps int, dims SceneDims, index *SpatialIndex, terrain *TerrainLayer) ([2]int, int, string) {
+	cur := from
+	for moved := 0; moved < steps; moved++ {
+		next := [2]int{cur[0] + delta[0], cur[1] + delta[1]}
+		if next[0] < 0 || next[1] < 0 || (dims.Width > 0 && next[0] >= dims.Width) || (dims.Height > 0 && next[1] >= dims.Height) {
+			return cur, moved, "scene boundary"
+		}
+		if building, blocked := index.BuildingAt(next[0], next[1]); blocked {
+			return cur, moved, "building " + building.ID
+		}
+		if terrain.At(next[0], next[1]) == TerrainCrater {
+			return cur, moved, fmt.Sprintf("crater at (%d, %d)", next[0], next[1])
+		}
+		cur = next
+	}
+	return cur, steps, ""
+}
+
+func buildingAt(x, y int, buildings []SceneBuilding) (string, bool) {
+	for _, building := range buildings {
+		if tileIsBlocked(x, y, []SceneBuilding{building}) {
+			return building.ID, true
+		}
+	}
+	return "", false
+}
+
+func describeBlock(blockedBy string) string {
+	return "blocked by " + blockedBy
+}
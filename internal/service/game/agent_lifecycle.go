@@ -0,0 +1,225 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	actionservice "eeo/backend/internal/service/action"
+)
+
+// defaultAgentActions 为从模板生成的 Agent 默认开放的动作。
+var defaultAgentActions = []string{"move_left", "move_right", "move_up", "move_down"}
+
+// DeleteSceneAgentInput 表示删除 Agent 所需的参数，PurgeHistory 为 true 时一并删除其行为历史。
+type DeleteSceneAgentInput struct {
+	ID           string
+	PurgeHistory bool
+}
+
+// SpawnSceneAgentInput 表示从模板生成 Agent 所需的参数，除模板外均可缺省。
+type SpawnSceneAgentInput struct {
+	TemplateID string
+	ID         string
+	Label      string
+	Position   *[2]int
+	Actions    []string
+}
+
+// SpawnSceneAgentResult 描述生成的 Agent 以及生成后的场景。
+type SpawnSceneAgentResult struct {
+	Agent SceneAgent `json:"agent"`
+	Scene Scene      `json:"scene"`
+}
+
+// DeleteSceneAgent 删除场景中的 Agent 实例，动作列表与运行时坐标随外键级联删除；
+// 需要清除行为历史时与实例在同一事务中删除。
+func (s *Service) DeleteSceneAgent(ctx context.Context, in DeleteSceneAgentInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agentID := strings.TrimSpace(in.ID)
+	if agentID == "" {
+		return Snapshot{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM system_scene_agents WHERE id = $1 AND scene_id = $2`, agentID, s.scene.ID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if rows, errRows := res.RowsAffected(); errRows == nil && rows == 0 {
+		err = fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+		return Snapshot{}, err
+	}
+	if in.PurgeHistory {
+		if err = actionservice.PurgeHistory(ctx, tx, agentID); err != nil {
+			return Snapshot{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return Snapshot{}, err
+	}
+
+	return s.snapshot(), nil
+}
+
+// SpawnSceneAgent 以模板的默认坐标与颜色生成 Agent，默认坐标被占用时落在最近的空闲格。
+func (s *Service) SpawnSceneAgent(ctx context.Context, in SpawnSceneAgentInput) (SpawnSceneAgentResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	templateID := strings.TrimSpace(in.TemplateID)
+	if templateID == "" {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: templateId required", ErrInvalidTemplate)
+	}
+	tpl, ok := findAgentTemplate(s.scene, templateID)
+	if !ok {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: agent template %s not found", ErrInvalidTemplate, templateID)
+	}
+	if tpl.Deprecated {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, templateID)
+	}
+
+	// Agent ID 在所有场景间唯一，写入时的 upsert 不区分场景，因此需先确认 ID 未被任何场景占用。
+	agentID := strings.TrimSpace(in.ID)
+	label := strings.TrimSpace(in.Label)
+	if agentID == "" {
+		index := nextAgentIndex(s.scene.Agents, tpl.ID)
+		for {
+			agentID = fmt.Sprintf("%s-%02d", tpl.ID, index)
+			taken, err := s.agentIDTaken(ctx, agentID)
+			if err != nil {
+				return SpawnSceneAgentResult{}, err
+			}
+			if !taken {
+				break
+			}
+			index++
+		}
+		if label == "" {
+			label = fmt.Sprintf("%s-%02d", tpl.Label, index)
+		}
+	} else if taken, err := s.agentIDTaken(ctx, agentID); err != nil {
+		return SpawnSceneAgentResult{}, err
+	} else if taken {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: agent %s already exists", ErrInvalidSceneEntity, agentID)
+	}
+	if label == "" {
+		label = tpl.Label
+	}
+
+	start := spawnOrigin(s.scene, tpl, in.Position)
+	tile, ok := findFreeAgentTile(s.scene, start)
+	if !ok {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: near %v", ErrNoFreeTile, start)
+	}
+
+	actions := in.Actions
+	if len(actions) == 0 {
+		actions = defaultAgentActions
+	}
+
+	input := UpdateSceneAgentInput{
+		ID:         agentID,
+		Label:      label,
+		TemplateID: &tpl.ID,
+		Position:   tile,
+		Actions:    actions,
+	}
+	if tpl.Color != 0 {
+		color := tpl.Color
+		input.Color = &color
+	}
+
+	if _, err := s.updateSceneAgent(ctx, input); err != nil {
+		return SpawnSceneAgentResult{}, err
+	}
+
+	agent, ok := findAgent(s.scene, agentID)
+	if !ok {
+		return SpawnSceneAgentResult{}, fmt.Errorf("%w: agent %s not found after spawn", ErrInvalidSceneEntity, agentID)
+	}
+	return SpawnSceneAgentResult{Agent: agent, Scene: s.scene}, nil
+}
+
+// agentIDTaken 判断 Agent ID 是否已被任意场景使用。
+func (s *Service) agentIDTaken(ctx context.Context, agentID string) (bool, error) {
+	if _, exists := findAgent(s.scene, agentID); exists {
+		return true, nil
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM system_scene_agents WHERE id = $1)`, agentID).Scan(&exists)
+	return exists, err
+}
+
+// spawnOrigin 依次取请求坐标、模板默认坐标与场景中心作为生成起点。
+func spawnOrigin(scene Scene, tpl *AgentTemplate, requested *[2]int) [2]int {
+	switch {
+	case requested != nil:
+		return *requested
+	case tpl != nil && len(tpl.Position) == 2:
+		return [2]int{tpl.Position[0], tpl.Position[1]}
+	default:
+		return [2]int{scene.Dimensions.Width / 2, scene.Dimensions.Height / 2}
+	}
+}
+
+// findFreeAgentTile 从起点开始广度优先搜索，返回最近的既不在建筑内也没有其他 Agent 的格子。
+func findFreeAgentTile(scene Scene, start [2]int) ([2]int, bool) {
+	dims := scene.Dimensions
+	if dims.Width <= 0 || dims.Height <= 0 {
+		return [2]int{}, false
+	}
+	start = clampTile([]float64{float64(start[0]), float64(start[1])}, dims)
+
+	occupied := make(map[point]bool, len(scene.Agents))
+	for _, agent := range scene.Agents {
+		tile := clampTile(agent.Position, dims)
+		occupied[point{tile[0], tile[1]}] = true
+	}
+
+	visited := map[point]bool{{start[0], start[1]}: true}
+	queue := []point{{start[0], start[1]}}
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+		if !occupied[cur] && !tileIsBlocked(cur.x, cur.y, scene.Buildings) {
+			return [2]int{cur.x, cur.y}, true
+		}
+		for _, nb := range neighbors4(cur) {
+			if nb.x < 0 || nb.x >= dims.Width || nb.y < 0 || nb.y >= dims.Height || visited[nb] {
+				continue
+			}
+			visited[nb] = true
+			queue = append(queue, nb)
+		}
+	}
+	return [2]int{}, false
+}
+
+// nextAgentIndex 返回模板下一个可用的序号，序号取自 <template>-NN 形式的 ID。
+func nextAgentIndex(agents []SceneAgent, templateID string) int {
+	maxIndex := 0
+	prefix := templateID + "-"
+	for _, agent := range agents {
+		if !strings.HasPrefix(agent.ID, prefix) {
+			continue
+		}
+		if value, err := strconv.Atoi(strings.TrimPrefix(agent.ID, prefix)); err == nil && value > maxIndex {
+			maxIndex = value
+		}
+	}
+	return maxIndex + 1
+}
@@ -0,0 +1,293 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	BehaviorParamString  = "string"
+	BehaviorParamNumber  = "number"
+	BehaviorParamInteger = "integer"
+	BehaviorParamBoolean = "boolean"
+	// BehaviorParamStringArray 为字符串数组，Enum 非空时约束每一项。
+	BehaviorParamStringArray = "array"
+)
+
+// Behavior 描述可由 Agent 触发的行为，新行为只需实现该接口并调用 RegisterBehavior。
+// Run 调用时服务未加锁，只能通过导出方法访问服务。
+type Behavior interface {
+	Spec() BehaviorSpec
+	Run(ctx context.Context, s *Service, agent SceneAgent, params BehaviorParams) (BehaviorResult, error)
+}
+
+// BehaviorSpec 描述行为的标识与参数结构。
+type BehaviorSpec struct {
+	ID          string          `json:"id"`
+	Label       string          `json:"label"`
+	Description string          `json:"description,omitempty"`
+	Params      []BehaviorParam `json:"params"`
+}
+
+// BehaviorParam 描述单个行为参数。
+type BehaviorParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Enum        []string    `json:"enum,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// BehaviorParams 为通过参数结构校验后的行为参数，缺省值已填充。
+type BehaviorParams map[string]interface{}
+
+// RunBehaviorInput 表示触发 Agent 行为所需的参数。
+type RunBehaviorInput struct {
+	AgentID    string
+	BehaviorID string
+	Params     json.RawMessage
+}
+
+// BehaviorResult 描述行为执行结果，Output 为返回给调用方的行为专属数据。
+type BehaviorResult struct {
+	AgentID    string
+	BehaviorID string
+	Scene      Scene
+	Output     interface{}
+}
+
+// BehaviorRegistry 按 ID 保存已注册的行为。
+type BehaviorRegistry struct {
+	mu        sync.RWMutex
+	behaviors map[string]Behavior
+}
+
+// NewBehaviorRegistry 返回包含指定行为的注册表。
+func NewBehaviorRegistry(behaviors ...Behavior) *BehaviorRegistry {
+	r := &BehaviorRegistry{behaviors: make(map[string]Behavior)}
+	for _, b := range behaviors {
+		r.Register(b)
+	}
+	return r
+}
+
+// Register 注册行为，相同 ID 的行为会被替换。
+func (r *BehaviorRegistry) Register(b Behavior) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.behaviors[b.Spec().ID] = b
+}
+
+// Lookup 按 ID 查找行为。
+func (r *BehaviorRegistry) Lookup(id string) (Behavior, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.behaviors[id]
+	return b, ok
+}
+
+// Specs 返回按 ID 排序的行为描述。
+func (r *BehaviorRegistry) Specs() []BehaviorSpec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	specs := make([]BehaviorSpec, 0, len(r.behaviors))
+	for _, b := range r.behaviors {
+		specs = append(specs, b.Spec())
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].ID < specs[j].ID })
+	return specs
+}
+
+// defaultBehaviors 为服务默认使用的行为注册表。
+var defaultBehaviors = NewBehaviorRegistry(maintainEnergyBehavior{})
+
+// RegisterBehavior 向默认注册表注册行为。
+func RegisterBehavior(b Behavior) {
+	defaultBehaviors.Register(b)
+}
+
+// Behaviors 返回当前可用的行为描述。
+func (s *Service) Behaviors() []BehaviorSpec {
+	return s.behaviorRegistry().Specs()
+}
+
+// RunBehavior 校验参数后为指定 Agent 执行行为。
+func (s *Service) RunBehavior(ctx context.Context, in RunBehaviorInput) (BehaviorResult, error) {
+	behaviorID := strings.TrimSpace(in.BehaviorID)
+	behavior, ok := s.behaviorRegistry().Lookup(behaviorID)
+	if !ok {
+		return BehaviorResult{}, fmt.Errorf("%w: %s", ErrUnknownBehavior, behaviorID)
+	}
+
+	params, err := validateBehaviorParams(behavior.Spec(), in.Params)
+	if err != nil {
+		return BehaviorResult{}, err
+	}
+
+	agentID := strings.TrimSpace(in.AgentID)
+	if agentID == "" {
+		return BehaviorResult{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
+	}
+	s.mu.RLock()
+	agent, ok := findAgent(s.scene, agentID)
+	s.mu.RUnlock()
+	if !ok {
+		return BehaviorResult{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+
+	result, err := behavior.Run(ctx, s, agent, params)
+	if err != nil {
+		return BehaviorResult{}, err
+	}
+	result.AgentID = agentID
+	result.BehaviorID = behaviorID
+	return result, nil
+}
+
+func (s *Service) behaviorRegistry() *BehaviorRegistry {
+	if s.behaviors != nil {
+		return s.behaviors
+	}
+	return defaultBehaviors
+}
+
+// validateBehaviorParams 按参数结构解析请求体，拒绝未知参数与类型不符的值，并填充缺省值。
+func validateBehaviorParams(spec BehaviorSpec, raw json.RawMessage) (BehaviorParams, error) {
+	values := map[string]interface{}{}
+	if trimmed := strings.TrimSpace(string(raw)); trimmed != "" && trimmed != "null" {
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("%w: params must be a JSON object: %v", ErrInvalidBehaviorParams, err)
+		}
+	}
+
+	known := make(map[string]BehaviorParam, len(spec.Params))
+	for _, p := range spec.Params {
+		known[p.Name] = p
+	}
+	for name := range values {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown param %s", ErrInvalidBehaviorParams, name)
+		}
+	}
+
+	params := make(BehaviorParams, len(spec.Params))
+	for _, p := range spec.Params {
+		value, ok := values[p.Name]
+		if !ok || value == nil {
+			if p.Required {
+				return nil, fmt.Errorf("%w: %s required", ErrInvalidBehaviorParams, p.Name)
+			}
+			if p.Default != nil {
+				params[p.Name] = p.Default
+			}
+			continue
+		}
+		converted, err := convertBehaviorParam(p, value)
+		if err != nil {
+			return nil, err
+		}
+		params[p.Name] = converted
+	}
+	return params, nil
+}
+
+func convertBehaviorParam(p BehaviorParam, value interface{}) (interface{}, error) {
+	switch p.Type {
+	case BehaviorParamString:
+		str, ok := value.(string)
+		if !ok {
+			break
+		}
+		if len(p.Enum) > 0 && !containsString(p.Enum, str) {
+			return nil, fmt.Errorf("%w: %s must be one of %s", ErrInvalidBehaviorParams, p.Name, strings.Join(p.Enum, ", "))
+		}
+		return str, nil
+	case BehaviorParamNumber:
+		if num, ok := value.(float64); ok {
+			return num, nil
+		}
+	case BehaviorParamInteger:
+		if num, ok := value.(float64); ok && num == float64(int(num)) {
+			return int(num), nil
+		}
+	case BehaviorParamBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case BehaviorParamStringArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			break
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			str, ok := item.(string)
+			if !ok || strings.TrimSpace(str) == "" {
+				return nil, fmt.Errorf("%w: %s must contain non-empty strings", ErrInvalidBehaviorParams, p.Name)
+			}
+			if len(p.Enum) > 0 && !containsString(p.Enum, str) {
+				return nil, fmt.Errorf("%w: %s items must be one of %s", ErrInvalidBehaviorParams, p.Name, strings.Join(p.Enum, ", "))
+			}
+			values = append(values, strings.TrimSpace(str))
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%w: %s must be %s", ErrInvalidBehaviorParams, p.Name, p.Type)
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// maintainEnergyBehavior 将 MaintainEnergyNonNegative 封装为行为。
+type maintainEnergyBehavior struct{}
+
+func (maintainEnergyBehavior) Spec() BehaviorSpec {
+	return BehaviorSpec{
+		ID:          "maintain-energy",
+		Label:       "维持能量平衡",
+		Description: "建造生产建筑或关停耗能建筑，直至场景净能量流不低于目标余量",
+		Params: []BehaviorParam{
+			{Name: "templates", Type: BehaviorParamStringArray, Description: "可建造的生产模板，按优先顺序尝试；缺省为太阳能塔"},
+			{Name: "maxBuildings", Type: BehaviorParamInteger, Default: MaxMaintainBuildings, Description: "最多新建或关停的建筑数，0 表示缺省上限"},
+			{Name: "margin", Type: BehaviorParamNumber, Default: 0.0, Description: "目标净能量流余量"},
+			{Name: "mode", Type: BehaviorParamString, Default: MaintainModeBuild, Enum: []string{MaintainModeBuild, MaintainModeShed, MaintainModePlan, MaintainModeQueue}, Description: "build 建造生产建筑，shed 关停耗能建筑，plan 只返回两种方案，queue 为生产建筑排队建造任务"},
+			{Name: "dryRun", Type: BehaviorParamBoolean, Default: false, Description: "只返回所选模式的方案，不写库"},
+		},
+	}
+}
+
+func (maintainEnergyBehavior) Run(ctx context.Context, s *Service, agent SceneAgent, params BehaviorParams) (BehaviorResult, error) {
+	opts := MaintainEnergyOptions{JobSource: JobSourceBehavior}
+	if templates, ok := params["templates"].([]string); ok {
+		opts.Templates = templates
+	}
+	if maxBuildings, ok := params["maxBuildings"].(int); ok {
+		opts.MaxBuildings = maxBuildings
+	}
+	if margin, ok := params["margin"].(float64); ok {
+		opts.Margin = margin
+	}
+	if mode, ok := params["mode"].(string); ok {
+		opts.Mode = mode
+	}
+	if dryRun, ok := params["dryRun"].(bool); ok {
+		opts.DryRun = dryRun
+	}
+
+	result, err := s.MaintainEnergy(ctx, agent.ID, opts)
+	if err != nil {
+		return BehaviorResult{}, err
+	}
+	return BehaviorResult{Scene: result.Scene, Output: result}, nil
+}
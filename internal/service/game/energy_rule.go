@@ -6,8 +6,21 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
+
+	"eeo/backend/internal/pathfinding"
+)
+
+const (
+	MaintainModeBuild = "build"
+	MaintainModeShed  = "shed"
+	MaintainModePlan  = "plan"
+	MaintainModeQueue = "queue"
+
+	// MaxMaintainBuildings 为单次 maintain-energy 最多新建或关停的建筑数。
+	MaxMaintainBuildings = 50
 )
 
 type energyBalance struct {
@@ -16,14 +29,59 @@ type energyBalance struct {
 	storage     []SceneBuilding
 }
 
+// MaintainEnergyOptions 为 maintain-energy 的策略参数，零值即默认策略：建造太阳能塔直至净能量流不为负。
+type MaintainEnergyOptions struct {
+	// Templates 为可建造的生产模板，按优先顺序尝试；为空时使用太阳能塔。
+	Templates []string
+	// MaxBuildings 为本次最多新建或关停的建筑数，0 表示 MaxMaintainBuildings。
+	MaxBuildings int
+	// Margin 为目标净能量流，调整后净能量流不低于该值即视为满足。
+	Margin float64
+	// Mode 为 build（建造生产建筑）、shed（关停耗能建筑）、plan（只返回两种方案）
+	// 或 queue（为生产建筑排队建造任务），缺省为 build。
+	Mode string
+	// DryRun 为 true 时只返回所选模式的方案，不写库。
+	DryRun bool
+	// JobSource 为 queue 模式下任务的来源，缺省为 rule。
+	JobSource string
+}
+
 // MaintainEnergyResult 描述“保持电量不减少”指令的执行结果。
 type MaintainEnergyResult struct {
-	Scene         Scene            `json:"scene"`
-	Created       []SceneBuilding  `json:"created"`
-	NetFlowBefore float64          `json:"netFlowBefore"`
-	NetFlowAfter  float64          `json:"netFlowAfter"`
-	TowersBuilt   int              `json:"towersBuilt"`
-	Relocation    *AgentRelocation `json:"relocation,omitempty"`
+	Scene         Scene                `json:"scene"`
+	Mode          string               `json:"mode"`
+	DryRun        bool                 `json:"dryRun,omitempty"`
+	Margin        float64              `json:"margin"`
+	Created       []SceneBuilding      `json:"created"`
+	Shed          []SceneBuilding      `json:"shed,omitempty"`
+	NetFlowBefore float64              `json:"netFlowBefore"`
+	NetFlowAfter  float64              `json:"netFlowAfter"`
+	TowersBuilt   int                  `json:"towersBuilt"`
+	Relocation    *AgentRelocation     `json:"relocation,omitempty"`
+	Plans         []MaintainEnergyPlan `json:"plans,omitempty"`
+	Jobs          []Job                `json:"jobs,omitempty"`
+}
+
+// MaintainEnergyPlan 描述某一模式下尚未执行的调整方案。
+type MaintainEnergyPlan struct {
+	Mode         string            `json:"mode"`
+	Build        []PlannedBuilding `json:"build,omitempty"`
+	Shed         []string          `json:"shed,omitempty"`
+	Relocation   *AgentRelocation  `json:"relocation,omitempty"`
+	NetFlowAfter float64           `json:"netFlowAfter"`
+	// Shortfall 为受数量上限限制后仍未补足的缺口。
+	Shortfall float64 `json:"shortfall"`
+	// Error 仅在 plan 模式下出现，说明该方案无法生成的原因。
+	Error string `json:"error,omitempty"`
+}
+
+// PlannedBuilding 描述方案中待新建的生产建筑。
+type PlannedBuilding struct {
+	ID         string `json:"id"`
+	TemplateID string `json:"templateId"`
+	Label      string `json:"label"`
+	Rect       [4]int `json:"rect"`
+	Output     int    `json:"output"`
 }
 
 // EnergyMaintainer 负责实现能量守恒相关规则。
@@ -45,93 +103,81 @@ type AgentRelocation struct {
 	Path     [][2]float64 `json:"path,omitempty"`
 }
 
-func (m *EnergyMaintainer) Maintain(ctx context.Context, scene Scene, agent SceneAgent) (MaintainEnergyResult, Scene, *AgentRelocation, error) {
+// Maintain 按策略补足能量缺口：build 模式建造生产建筑，shed 模式关停耗能建筑，plan 模式只返回两种方案。
+func (m *EnergyMaintainer) Maintain(ctx context.Context, scene Scene, agent SceneAgent, opts MaintainEnergyOptions) (MaintainEnergyResult, Scene, *AgentRelocation, error) {
+	opts, producers, err := normalizeMaintainOptions(scene, opts)
+	if err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+
 	balance := computeEnergyBalance(scene)
 	netFlow := balance.output - balance.consumption
+	deficit := opts.Margin - netFlow
 
 	result := MaintainEnergyResult{
 		Scene:         scene,
+		Mode:          opts.Mode,
+		DryRun:        opts.DryRun,
+		Margin:        opts.Margin,
+		Created:       []SceneBuilding{},
 		NetFlowBefore: netFlow,
 		NetFlowAfter:  netFlow,
 	}
 
-	if netFlow >= 0 {
+	if opts.Mode == MaintainModePlan {
+		buildPlan, _, err := planProducers(scene, agent, producers, deficit, opts.MaxBuildings)
+		if err != nil {
+			buildPlan.Error = err.Error()
+		}
+		result.Plans = []MaintainEnergyPlan{buildPlan, planLoadShedding(scene, deficit, opts.MaxBuildings)}
 		return result, scene, nil, nil
 	}
 
-	template, ok := findSolarTemplate(scene)
-	if !ok || template.Energy == nil || template.Energy.Output <= 0 {
-		return MaintainEnergyResult{}, Scene{}, nil, ErrSolarTemplateMissing
-	}
-
-	towerOutput := float64(template.Energy.Output)
-	deficit := balance.consumption - balance.output
 	if deficit <= 0 {
 		return result, scene, nil, nil
 	}
 
-	towersNeeded := int(math.Ceil(deficit / towerOutput))
-	if towersNeeded <= 0 {
-		return result, scene, nil, nil
-	}
-
-	log.Printf("EnergyMaintainer: start agent=%s netFlow=%.2f deficit=%.2f towers=%d", agent.ID, netFlow, deficit, towersNeeded)
-
-	width, height := determineSolarTowerFootprint(scene.Buildings)
-	baseOccupied := append([]SceneBuilding(nil), scene.Buildings...)
-	occupied := append([]SceneBuilding(nil), baseOccupied...)
-	baseIndex := nextSolarTowerIndex(baseOccupied)
-	nextIndex := baseIndex
-	planned := make([]plannedTower, 0, towersNeeded)
+	log.Printf("EnergyMaintainer: start agent=%s mode=%s netFlow=%.2f deficit=%.2f dryRun=%v", agent.ID, opts.Mode, netFlow, deficit, opts.DryRun)
 
-	agentTile := clampTile(agent.Position, scene.Dimensions)
-	currentTile := agentTile
-	var relocation *AgentRelocation
-	var relocationPathTiles [][2]int
-	visitedTiles := map[[2]int]struct{}{
-		agentTile: struct{}{},
+	if opts.Mode == MaintainModeShed {
+		plan := planLoadShedding(scene, deficit, opts.MaxBuildings)
+		if opts.DryRun {
+			result.Plans = []MaintainEnergyPlan{plan}
+			result.NetFlowAfter = plan.NetFlowAfter
+			return result, scene, nil, nil
+		}
+		updatedScene, err := m.shedLoad(ctx, scene, plan.Shed)
+		if err != nil {
+			return MaintainEnergyResult{}, Scene{}, nil, err
+		}
+		result.Scene = updatedScene
+		result.Shed = collectBuildings(updatedScene.Buildings, plan.Shed)
+		balanceAfter := computeEnergyBalance(updatedScene)
+		result.NetFlowAfter = balanceAfter.output - balanceAfter.consumption
+		log.Printf("EnergyMaintainer: success agent=%s shed=%d", agent.ID, len(result.Shed))
+		return result, updatedScene, nil, nil
 	}
 
-	for len(planned) < towersNeeded {
-		x, y, ok := findAdjacentPlacementForAgent(currentTile, width, height, occupied, scene.Dimensions)
-		if !ok {
-			tile, placement, pathTiles, found := findRelocationAndPlacement(currentTile, width, height, occupied, scene.Dimensions)
-			if !found {
-				log.Printf("EnergyMaintainer: no placement available agent=%s built=%d/%d", agent.ID, len(planned), towersNeeded)
-				return MaintainEnergyResult{}, Scene{}, relocation, ErrNoAvailablePlacement
-			}
-			if _, seen := visitedTiles[tile]; seen {
-				log.Printf("EnergyMaintainer: relocation revisited agent=%s tile=(%d,%d)", agent.ID, tile[0], tile[1])
-				return MaintainEnergyResult{}, Scene{}, relocation, ErrNoAvailablePlacement
-			}
-			visitedTiles[tile] = struct{}{}
-			currentTile = tile
-			relocationPathTiles = pathTiles
-			if relocation == nil || relocation.Position[0] != float64(tile[0]) || relocation.Position[1] != float64(tile[1]) {
-				relocation = &AgentRelocation{ID: agent.ID, Position: [2]float64{float64(tile[0]), float64(tile[1])}}
-				log.Printf("EnergyMaintainer: relocation agent=%s to (%d,%d)", agent.ID, tile[0], tile[1])
-			}
-			x, y = placement[0], placement[1]
-		}
+	if opts.Mode == MaintainModeQueue {
+		return m.queueProducers(ctx, scene, agent, producers, deficit, opts, result)
+	}
 
-		if !areaIsFree(occupied, x, y, width, height) {
-			log.Printf("EnergyMaintainer: placement blocked agent=%s at (%d,%d)", agent.ID, x, y)
-			return MaintainEnergyResult{}, Scene{}, relocation, ErrNoAvailablePlacement
+	plan, relocationPathTiles, err := planProducers(scene, agent, producers, deficit, opts.MaxBuildings)
+	if err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+	relocation := plan.Relocation
+	if relocation != nil && len(relocationPathTiles) > 0 {
+		path := make([][2]float64, len(relocationPathTiles))
+		for i, tile := range relocationPathTiles {
+			path[i] = [2]float64{float64(tile[0]) + 0.5, float64(tile[1]) + 0.5}
 		}
-
-		nextIndex++
-		id := fmt.Sprintf("solar_tower_auto_%02d", nextIndex)
-		label := fmt.Sprintf("太阳能塔 自动 %02d", nextIndex)
-		planned = append(planned, plannedTower{
-			id:     id,
-			label:  label,
-			x:      x,
-			y:      y,
-			width:  width,
-			height: height,
-		})
-		occupied = append(occupied, SceneBuilding{ID: id, TemplateID: solarTowerTemplateID, Rect: []int{x, y, width, height}})
-		log.Printf("EnergyMaintainer: planned tower %d/%d at (%d,%d)", len(planned), towersNeeded, x, y)
+		relocation.Path = path
+	}
+	if opts.DryRun {
+		result.Plans = []MaintainEnergyPlan{plan}
+		result.NetFlowAfter = plan.NetFlowAfter
+		return result, scene, nil, nil
 	}
 
 	tx, err := m.db.BeginTx(ctx, nil)
@@ -144,7 +190,7 @@ func (m *EnergyMaintainer) Maintain(ctx context.Context, scene Scene, agent Scen
 		}
 	}()
 
-	for _, tower := range planned {
+	for _, building := range plan.Build {
 		if _, err = tx.ExecContext(ctx, `
 			INSERT INTO system_scene_buildings (id, scene_id, template_id, label, position_x, position_y, size_width, size_height)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
@@ -156,7 +202,7 @@ func (m *EnergyMaintainer) Maintain(ctx context.Context, scene Scene, agent Scen
 			              position_y = EXCLUDED.position_y,
 			              size_width = EXCLUDED.size_width,
 			              size_height = EXCLUDED.size_height
-		`, tower.id, scene.ID, solarTowerTemplateID, tower.label, tower.x, tower.y, tower.width, tower.height); err != nil {
+		`, building.ID, scene.ID, building.TemplateID, building.Label, building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]); err != nil {
 			return MaintainEnergyResult{}, Scene{}, relocation, err
 		}
 	}
@@ -170,66 +216,326 @@ func (m *EnergyMaintainer) Maintain(ctx context.Context, scene Scene, agent Scen
 		return MaintainEnergyResult{}, Scene{}, relocation, err
 	}
 
-	created := collectCreatedBuildings(updatedScene.Buildings, planned)
+	ids := make([]string, len(plan.Build))
+	for i, building := range plan.Build {
+		ids[i] = building.ID
+	}
+	created := collectBuildings(updatedScene.Buildings, ids)
 	balanceAfter := computeEnergyBalance(updatedScene)
 	result.Scene = updatedScene
 	result.Created = created
 	result.NetFlowAfter = balanceAfter.output - balanceAfter.consumption
 	result.TowersBuilt = len(created)
-	if relocation != nil {
-		if len(relocationPathTiles) > 0 {
-			path := make([][2]float64, len(relocationPathTiles))
-			for i, tile := range relocationPathTiles {
-				path[i] = [2]float64{float64(tile[0]) + 0.5, float64(tile[1]) + 0.5}
+	result.Relocation = relocation
+
+	log.Printf("EnergyMaintainer: success agent=%s built=%d relocation=%v", agent.ID, len(created), relocation != nil)
+
+	return result, updatedScene, relocation, nil
+}
+
+// queueProducers 为补足缺口所需的生产建筑排队建造任务，由任务 tick 分派给具备 build 技能的 Agent 建造。
+// 未结束的建造任务视为已占用其目标区域，其产出计入缺口，重复执行不会为同一缺口重复排队；
+// 调用的 Agent 不会被搬迁。
+func (m *EnergyMaintainer) queueProducers(ctx context.Context, scene Scene, agent SceneAgent, producers []*BuildingTemplate, deficit float64, opts MaintainEnergyOptions, result MaintainEnergyResult) (MaintainEnergyResult, Scene, *AgentRelocation, error) {
+	planning := scene
+	planning.Buildings = append([]SceneBuilding(nil), scene.Buildings...)
+	for _, job := range scene.Jobs {
+		if job.Kind != JobKindBuild || job.Status == JobStatusDone || job.Status == JobStatusFailed {
+			continue
+		}
+		tpl, ok := findBuildingTemplate(scene, job.TemplateID)
+		if !ok {
+			continue
+		}
+		rect := buildJobRect(tpl, [2]int{job.Target[0], job.Target[1]})
+		planning.Buildings = append(planning.Buildings, SceneBuilding{ID: fmt.Sprintf("job_%d", job.ID), TemplateID: tpl.ID, Rect: rect[:]})
+		if tpl.Energy != nil {
+			deficit -= float64(tpl.Energy.Output)
+		}
+	}
+
+	plan, _, err := planProducers(planning, agent, producers, deficit, opts.MaxBuildings)
+	if err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+	plan.Mode = MaintainModeQueue
+	plan.Relocation = nil
+	result.Plans = []MaintainEnergyPlan{plan}
+	if opts.DryRun || len(plan.Build) == 0 {
+		return result, scene, nil, nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	jobs := make([]Job, 0, len(plan.Build))
+	for _, building := range plan.Build {
+		var job Job
+		job, err = insertJob(ctx, tx, scene.ID, CreateJobInput{Kind: JobKindBuild, TemplateID: building.TemplateID, Source: opts.JobSource}, [2]int{building.Rect[0], building.Rect[1]})
+		if err != nil {
+			return MaintainEnergyResult{}, Scene{}, nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err = tx.Commit(); err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+
+	updatedScene, err := m.loadScene(m.db, scene.ID)
+	if err != nil {
+		return MaintainEnergyResult{}, Scene{}, nil, err
+	}
+	result.Scene = updatedScene
+	result.Jobs = jobs
+	log.Printf("EnergyMaintainer: success agent=%s queued=%d", agent.ID, len(jobs))
+	return result, updatedScene, nil, nil
+}
+
+// normalizeMaintainOptions 校验策略参数并填充缺省值，返回按优先顺序排列的生产模板。
+func normalizeMaintainOptions(scene Scene, opts MaintainEnergyOptions) (MaintainEnergyOptions, []*BuildingTemplate, error) {
+	opts.Mode = strings.ToLower(strings.TrimSpace(opts.Mode))
+	switch opts.Mode {
+	case "":
+		opts.Mode = MaintainModeBuild
+	case MaintainModeBuild, MaintainModeShed, MaintainModePlan, MaintainModeQueue:
+	default:
+		return opts, nil, fmt.Errorf("%w: mode must be build, shed, plan or queue", ErrInvalidBehaviorParams)
+	}
+	opts.JobSource = strings.TrimSpace(opts.JobSource)
+	if opts.JobSource == "" {
+		opts.JobSource = JobSourceRule
+	}
+	if opts.MaxBuildings < 0 || opts.MaxBuildings > MaxMaintainBuildings {
+		return opts, nil, fmt.Errorf("%w: maxBuildings must be between 0 and %d, 0 for the default", ErrInvalidBehaviorParams, MaxMaintainBuildings)
+	}
+	if opts.MaxBuildings == 0 {
+		opts.MaxBuildings = MaxMaintainBuildings
+	}
+	if opts.Margin < 0 || math.IsNaN(opts.Margin) || math.IsInf(opts.Margin, 0) {
+		return opts, nil, fmt.Errorf("%w: margin must be a non-negative number", ErrInvalidBehaviorParams)
+	}
+
+	if len(opts.Templates) == 0 {
+		tpl, ok := findBuildingTemplate(scene, solarTowerTemplateID)
+		if !ok || tpl.Deprecated || tpl.Energy == nil || tpl.Energy.Output <= 0 {
+			if opts.Mode == MaintainModeShed {
+				return opts, nil, nil
 			}
-			relocation.Path = path
+			return opts, nil, ErrSolarTemplateMissing
+		}
+		return opts, []*BuildingTemplate{tpl}, nil
+	}
+
+	producers := make([]*BuildingTemplate, 0, len(opts.Templates))
+	seen := make(map[string]struct{}, len(opts.Templates))
+	for _, id := range opts.Templates {
+		id = strings.TrimSpace(id)
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		tpl, ok := findBuildingTemplate(scene, id)
+		if !ok {
+			return opts, nil, fmt.Errorf("%w: template %s not found", ErrInvalidBehaviorParams, id)
+		}
+		if tpl.Deprecated {
+			return opts, nil, fmt.Errorf("%w: %s", ErrTemplateDeprecated, id)
+		}
+		if tpl.Energy == nil || tpl.Energy.Output <= 0 {
+			return opts, nil, fmt.Errorf("%w: template %s produces no energy", ErrInvalidBehaviorParams, id)
 		}
-		result.Relocation = relocation
+		producers = append(producers, tpl)
 	}
+	return opts, producers, nil
+}
 
-	log.Printf("EnergyMaintainer: success agent=%s towersBuilt=%d relocation=%v", agent.ID, len(planned), relocation != nil)
+// planProducers 在不写库的前提下为 Agent 规划补足 deficit 所需的生产建筑，最多 maxBuildings 座。
+// 每座建筑依次尝试各模板，优先放在 Agent 相邻处，放不下时沿最短路径搬迁到最近的可放置位置。
+// 返回的第二个值为搬迁路径上的格子。
+func planProducers(scene Scene, agent SceneAgent, producers []*BuildingTemplate, deficit float64, maxBuildings int) (MaintainEnergyPlan, [][2]int, error) {
+	plan := MaintainEnergyPlan{Mode: MaintainModeBuild, Build: []PlannedBuilding{}}
+	balance := computeEnergyBalance(scene)
+	plan.NetFlowAfter = balance.output - balance.consumption
+	if deficit <= 0 {
+		return plan, nil, nil
+	}
+	if len(producers) == 0 {
+		plan.Shortfall = deficit
+		return plan, nil, ErrSolarTemplateMissing
+	}
 
-	return result, updatedScene, relocation, nil
+	occupied := NewSpatialIndex(scene.Dimensions, scene.Buildings)
+	nextIndex := make(map[string]int, len(producers))
+	for _, tpl := range producers {
+		nextIndex[tpl.ID] = nextAutoBuildingIndex(scene.Buildings, tpl.ID)
+	}
+
+	agentTile := clampTile(agent.Position, scene.Dimensions)
+	currentTile := agentTile
+	visitedTiles := map[[2]int]struct{}{
+		agentTile: struct{}{},
+	}
+	var relocationPath [][2]int
+
+	remaining := deficit
+	place := func(tpl *BuildingTemplate, x, y, width, height int) {
+		nextIndex[tpl.ID]++
+		building := PlannedBuilding{
+			ID:         fmt.Sprintf("%s_auto_%02d", tpl.ID, nextIndex[tpl.ID]),
+			TemplateID: tpl.ID,
+			Label:      fmt.Sprintf("%s 自动 %02d", tpl.Label, nextIndex[tpl.ID]),
+			Rect:       [4]int{x, y, width, height},
+			Output:     tpl.Energy.Output,
+		}
+		plan.Build = append(plan.Build, building)
+		occupied.Add(SceneBuilding{ID: building.ID, TemplateID: tpl.ID, Rect: []int{x, y, width, height}})
+		remaining -= float64(tpl.Energy.Output)
+		plan.NetFlowAfter += float64(tpl.Energy.Output)
+		log.Printf("EnergyMaintainer: planned %s at (%d,%d) remaining=%.2f", building.ID, x, y, remaining)
+	}
+
+	for remaining > 0 && len(plan.Build) < maxBuildings {
+		placed := false
+		for _, tpl := range producers {
+			width, height := producerFootprint(tpl, scene.Buildings)
+			area := placementArea{occupied: occupied, terrain: scene.Terrain, requiredTerrain: tpl.RequiredTerrain}
+			if x, y, ok := findAdjacentPlacementForAgent(currentTile, width, height, area, scene.Dimensions); ok {
+				place(tpl, x, y, width, height)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		for _, tpl := range producers {
+			width, height := producerFootprint(tpl, scene.Buildings)
+			area := placementArea{occupied: occupied, terrain: scene.Terrain, requiredTerrain: tpl.RequiredTerrain}
+			tile, placement, pathTiles, found := findRelocationAndPlacement(currentTile, width, height, area, scene.Dimensions)
+			if !found {
+				continue
+			}
+			if _, seen := visitedTiles[tile]; seen {
+				log.Printf("EnergyMaintainer: relocation revisited agent=%s tile=(%d,%d)", agent.ID, tile[0], tile[1])
+				return plan, relocationPath, ErrNoAvailablePlacement
+			}
+			visitedTiles[tile] = struct{}{}
+			currentTile = tile
+			// 每次搬迁从上一段的终点出发，路径逐段拼接，Agent 沿完整路线走到最后一个位置。
+			relocationPath = append(relocationPath, pathTiles...)
+			if plan.Relocation == nil || plan.Relocation.Position[0] != float64(tile[0]) || plan.Relocation.Position[1] != float64(tile[1]) {
+				plan.Relocation = &AgentRelocation{ID: agent.ID, Position: [2]float64{float64(tile[0]), float64(tile[1])}}
+				log.Printf("EnergyMaintainer: relocation agent=%s to (%d,%d)", agent.ID, tile[0], tile[1])
+			}
+			place(tpl, placement[0], placement[1], width, height)
+			placed = true
+			break
+		}
+		if !placed {
+			log.Printf("EnergyMaintainer: no placement available agent=%s built=%d", agent.ID, len(plan.Build))
+			return plan, relocationPath, ErrNoAvailablePlacement
+		}
+	}
+
+	plan.Shortfall = math.Max(remaining, 0)
+	return plan, relocationPath, nil
 }
 
-func findSolarTemplate(scene Scene) (*BuildingTemplate, bool) {
-	for i := range scene.BuildingTemplates {
-		tpl := &scene.BuildingTemplates[i]
-		if tpl.ID == solarTowerTemplateID {
-			return tpl, true
+// planLoadShedding 选出需要关停的耗能建筑：每次优先关停能单独补足剩余缺口的最小耗能建筑，
+// 没有时关停耗能最大的建筑，直至补足缺口或达到数量上限。
+func planLoadShedding(scene Scene, deficit float64, maxBuildings int) MaintainEnergyPlan {
+	balance := computeEnergyBalance(scene)
+	plan := MaintainEnergyPlan{Mode: MaintainModeShed, Shed: []string{}, NetFlowAfter: balance.output - balance.consumption}
+	if deficit <= 0 {
+		return plan
+	}
+
+	var consumers []SceneBuilding
+	for _, building := range scene.Buildings {
+		if building.Energy != nil && strings.EqualFold(building.Energy.Type, "consumer") && !building.Offline && building.Energy.Rate > 0 {
+			consumers = append(consumers, building)
 		}
 	}
-	return nil, false
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Energy.Rate != consumers[j].Energy.Rate {
+			return consumers[i].Energy.Rate < consumers[j].Energy.Rate
+		}
+		return consumers[i].ID < consumers[j].ID
+	})
+
+	remaining := deficit
+	for remaining > 0 && len(consumers) > 0 && len(plan.Shed) < maxBuildings {
+		pick := len(consumers) - 1
+		for i, consumer := range consumers {
+			if float64(consumer.Energy.Rate) >= remaining {
+				pick = i
+				break
+			}
+		}
+		rate := float64(consumers[pick].Energy.Rate)
+		plan.Shed = append(plan.Shed, consumers[pick].ID)
+		plan.NetFlowAfter += rate
+		remaining -= rate
+		consumers = append(consumers[:pick], consumers[pick+1:]...)
+	}
+	plan.Shortfall = math.Max(remaining, 0)
+	return plan
 }
 
-type plannedTower struct {
-	id     string
-	label  string
-	x      int
-	y      int
-	width  int
-	height int
+// shedLoad 将指定耗能建筑标记为断电并重新加载场景。
+func (m *EnergyMaintainer) shedLoad(ctx context.Context, scene Scene, ids []string) (Scene, error) {
+	if len(ids) == 0 {
+		return scene, nil
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Scene{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, id := range ids {
+		if _, err = tx.ExecContext(ctx, `UPDATE system_scene_buildings SET powered = FALSE WHERE id = $1 AND scene_id = $2`, id, scene.ID); err != nil {
+			return Scene{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return Scene{}, err
+	}
+	return m.loadScene(m.db, scene.ID)
 }
 
-func collectCreatedBuildings(buildings []SceneBuilding, planned []plannedTower) []SceneBuilding {
-	created := make([]SceneBuilding, 0, len(planned))
-	if len(planned) == 0 {
-		return created
+func collectBuildings(buildings []SceneBuilding, ids []string) []SceneBuilding {
+	collected := make([]SceneBuilding, 0, len(ids))
+	if len(ids) == 0 {
+		return collected
 	}
 
-	plannedIDs := make(map[string]struct{}, len(planned))
-	for _, p := range planned {
-		plannedIDs[p.id] = struct{}{}
+	wanted := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
 	}
 
 	for _, building := range buildings {
-		if _, ok := plannedIDs[building.ID]; ok {
-			created = append(created, building)
+		if _, ok := wanted[building.ID]; ok {
+			collected = append(collected, building)
 		}
 	}
-	return created
+	return collected
 }
 
+// computeEnergyBalance 汇总场景的耗能与产能，断电的耗能建筑不计入。
 func computeEnergyBalance(scene Scene) energyBalance {
 	var balance energyBalance
 	for _, building := range scene.Buildings {
@@ -238,7 +544,9 @@ func computeEnergyBalance(scene Scene) energyBalance {
 		}
 		switch strings.ToLower(building.Energy.Type) {
 		case "consumer":
-			balance.consumption += float64(building.Energy.Rate)
+			if !building.Offline {
+				balance.consumption += float64(building.Energy.Rate)
+			}
 		case "storage":
 			balance.output += float64(building.Energy.Output)
 			balance.storage = append(balance.storage, building)
@@ -247,21 +555,54 @@ func computeEnergyBalance(scene Scene) energyBalance {
 	return balance
 }
 
-func determineSolarTowerFootprint(buildings []SceneBuilding) (int, int) {
+// EnergySummary 为场景的能量汇总。
+type EnergySummary struct {
+	Consumption     float64 `json:"consumption"`
+	Output          float64 `json:"output"`
+	NetFlow         float64 `json:"netFlow"`
+	StorageCurrent  int     `json:"storageCurrent"`
+	StorageCapacity int     `json:"storageCapacity"`
+	OfflineCount    int     `json:"offlineCount"`
+}
+
+// SummarizeEnergy 汇总场景的耗能、产能与储能，口径与 maintain-energy 相同。
+func SummarizeEnergy(scene Scene) EnergySummary {
+	balance := computeEnergyBalance(scene)
+	summary := EnergySummary{
+		Consumption: balance.consumption,
+		Output:      balance.output,
+		NetFlow:     balance.output - balance.consumption,
+	}
+	for _, building := range balance.storage {
+		summary.StorageCurrent += building.Energy.Current
+		summary.StorageCapacity += building.Energy.Capacity
+	}
+	for _, building := range scene.Buildings {
+		if building.Offline {
+			summary.OfflineCount++
+		}
+	}
+	return summary
+}
+
+// producerFootprint 返回生产模板的占地：优先取模板尺寸，其次取场景中同模板建筑的尺寸，否则为 1×1。
+func producerFootprint(tpl *BuildingTemplate, buildings []SceneBuilding) (int, int) {
+	if len(tpl.Size) == 2 && tpl.Size[0] > 0 && tpl.Size[1] > 0 {
+		return tpl.Size[0], tpl.Size[1]
+	}
 	for _, building := range buildings {
-		if building.TemplateID == solarTowerTemplateID || strings.HasPrefix(building.ID, "solar_tower") {
-			if len(building.Rect) == 4 {
-				return building.Rect[2], building.Rect[3]
-			}
+		if building.TemplateID == tpl.ID && len(building.Rect) == 4 {
+			return building.Rect[2], building.Rect[3]
 		}
 	}
-	return 4, 4
+	return 1, 1
 }
 
-func nextSolarTowerIndex(buildings []SceneBuilding) int {
+// nextAutoBuildingIndex 返回以 prefix 开头的建筑 ID 中末段数字的最大值，用于生成不冲突的自动编号。
+func nextAutoBuildingIndex(buildings []SceneBuilding, prefix string) int {
 	maxIndex := 0
 	for _, building := range buildings {
-		if !strings.HasPrefix(building.ID, "solar_tower") {
+		if !strings.HasPrefix(building.ID, prefix) {
 			continue
 		}
 		parts := strings.Split(building.ID, "_")
@@ -305,7 +646,19 @@ func clampTile(position []float64, dims SceneDims) [2]int {
 	return [2]int{x, y}
 }
 
-func findAdjacentPlacementForAgent(agentTile [2]int, width, height int, occupied []SceneBuilding, dims SceneDims) (int, int, bool) {
+// placementArea 汇总放置建筑时的约束：不能与已有建筑重叠，且需满足地形要求。
+type placementArea struct {
+	occupied        *SpatialIndex
+	terrain         *TerrainLayer
+	requiredTerrain string
+}
+
+func (a placementArea) fits(x, y, width, height int) bool {
+	return a.occupied.AreaIsFree(x, y, width, height) &&
+		checkTerrainPlacement(a.terrain, [4]int{x, y, width, height}, a.requiredTerrain) == nil
+}
+
+func findAdjacentPlacementForAgent(agentTile [2]int, width, height int, area placementArea, dims SceneDims) (int, int, bool) {
 	candidates := [][2]int{
 		{agentTile[0] - width, agentTile[1]},  // left
 		{agentTile[0] + 1, agentTile[1]},      // right
@@ -324,7 +677,7 @@ func findAdjacentPlacementForAgent(agentTile [2]int, width, height int, occupied
 		if dims.Height > 0 && y+height > dims.Height {
 			continue
 		}
-		if areaIsFree(occupied, x, y, width, height) {
+		if area.fits(x, y, width, height) {
 			return x, y, true
 		}
 	}
@@ -337,78 +690,28 @@ type point struct {
 	y int
 }
 
-func findRelocationAndPlacement(start [2]int, width, height int, occupied []SceneBuilding, dims SceneDims) ([2]int, [2]int, [][2]int, bool) {
+// findRelocationAndPlacement 沿最短路径寻找最近的可通行格子，使 Agent 在该格旁边能够放下指定尺寸的建筑。
+func findRelocationAndPlacement(start [2]int, width, height int, area placementArea, dims SceneDims) ([2]int, [2]int, [][2]int, bool) {
 	if dims.Width <= 0 || dims.Height <= 0 {
 		return [2]int{}, [2]int{}, nil, false
 	}
 
-	visited := make([][]bool, dims.Width)
-	for i := range visited {
-		visited[i] = make([]bool, dims.Height)
-	}
-
-	queue := make([]point, 0, dims.Width*dims.Height)
-	head := 0
-	queue = append(queue, point{start[0], start[1]})
-	if start[0] >= 0 && start[0] < dims.Width && start[1] >= 0 && start[1] < dims.Height {
-		visited[start[0]][start[1]] = true
-	}
-	parents := make(map[point]point)
-	startPoint := point{start[0], start[1]}
-
-	for head < len(queue) {
-		cur := queue[head]
-		head++
-
-		if cur.x < 0 || cur.x >= dims.Width || cur.y < 0 || cur.y >= dims.Height {
-			continue
-		}
-
-		if !tileIsBlocked(cur.x, cur.y, occupied) {
-			if px, py, ok := findAdjacentPlacementForAgent([2]int{cur.x, cur.y}, width, height, occupied, dims); ok {
-				pathTiles := reconstructPathTiles(parents, startPoint, cur)
-				return [2]int{cur.x, cur.y}, [2]int{px, py}, pathTiles, true
-			}
-		}
-
-		for _, nb := range neighbors4(cur) {
-			if nb.x < 0 || nb.x >= dims.Width || nb.y < 0 || nb.y >= dims.Height {
-				continue
-			}
-			if visited[nb.x][nb.y] {
-				continue
-			}
-			visited[nb.x][nb.y] = true
-			parents[nb] = cur
-			queue = append(queue, nb)
-		}
-	}
-
-	return [2]int{}, [2]int{}, nil, false
-}
-
-func reconstructPathTiles(parents map[point]point, start, end point) [][2]int {
-	if end == start {
-		return nil
-	}
-
-	path := make([][2]int, 0)
-	cur := end
-
-	for cur != start {
-		path = append(path, [2]int{cur.x, cur.y})
-		next, ok := parents[cur]
-		if !ok {
-			break
-		}
-		cur = next
+	var placement [2]int
+	grid := newOccupancyGrid(dims, area.occupied.Buildings(), area.terrain)
+	path, _, found := pathfinding.FindNearest(grid, toPoint(start), pathfinding.Options{}, func(p pathfinding.Point) bool {
+		px, py, ok := findAdjacentPlacementForAgent([2]int{p.X, p.Y}, width, height, area, dims)
+		placement = [2]int{px, py}
+		return ok
+	})
+	if !found {
+		return [2]int{}, [2]int{}, nil, false
 	}
 
-	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
-		path[i], path[j] = path[j], path[i]
+	tile := start
+	if len(path) > 0 {
+		tile = [2]int{path[len(path)-1].X, path[len(path)-1].Y}
 	}
-
-	return path
+	return tile, placement, fromPoints(path), true
 }
 
 func tileIsBlocked(x, y int, buildings []SceneBuilding) bool {
@@ -433,19 +736,6 @@ func neighbors4(p point) []point {
 	}
 }
 
-func areaIsFree(buildings []SceneBuilding, x, y, width, height int) bool {
-	for _, building := range buildings {
-		if len(building.Rect) != 4 {
-			continue
-		}
-		bx, by, bw, bh := building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]
-		if rectanglesOverlap(x, y, width, height, bx, by, bw, bh) {
-			return false
-		}
-	}
-	return true
-}
-
 func rectanglesOverlap(ax, ay, aw, ah, bx, by, bw, bh int) bool {
 	return ax < bx+bw && ax+aw > bx && ay < by+bh && ay+ah > by
 }
@@ -0,0 +1,391 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	GoalKindNetFlow      = "net-flow"
+	GoalKindStorageLevel = "storage-level"
+	GoalKindPatrol       = "patrol"
+
+	GoalStatusPending    = "pending"
+	GoalStatusSatisfied  = "satisfied"
+	GoalStatusCorrecting = "correcting"
+	GoalStatusActive     = "active"
+	GoalStatusFailing    = "failing"
+
+	// goalStorageRechargeSeconds 为储能目标计划补足缺口的时长，据此换算需要的净能量流余量。
+	goalStorageRechargeSeconds = 60
+)
+
+// Goal 描述分配给 Agent 的持久目标，每个模拟 tick 重新评估一次。
+type Goal struct {
+	ID              int64      `json:"id"`
+	AgentID         string     `json:"agentId"`
+	Kind            string     `json:"kind"`
+	Params          GoalParams `json:"params"`
+	Status          string     `json:"status"`
+	Cursor          int        `json:"cursor"`
+	Failures        int        `json:"failures"`
+	LastMessage     string     `json:"lastMessage,omitempty"`
+	LastEvaluatedAt *time.Time `json:"lastEvaluatedAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+// GoalParams 为各类目标的参数，按 Kind 取用对应字段。
+type GoalParams struct {
+	MinNetFlow        *float64 `json:"minNetFlow,omitempty"`
+	MinStoragePercent *float64 `json:"minStoragePercent,omitempty"`
+	Waypoints         [][2]int `json:"waypoints,omitempty"`
+}
+
+// AssignGoalInput 表示为 Agent 分配目标所需的参数。
+type AssignGoalInput struct {
+	AgentID string
+	Kind    string
+	Params  GoalParams
+}
+
+// goalOutcome 为单次评估的结果。
+type goalOutcome struct {
+	status  string
+	message string
+	failed  bool
+}
+
+// AssignAgentGoal 校验参数后为 Agent 新增一个持久目标。
+func (s *Service) AssignAgentGoal(ctx context.Context, in AssignGoalInput) (Goal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	agentID := strings.TrimSpace(in.AgentID)
+	if _, ok := findAgent(s.scene, agentID); !ok {
+		return Goal{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+	kind := strings.TrimSpace(in.Kind)
+	params, err := normalizeGoalParams(s.scene, kind, in.Params)
+	if err != nil {
+		return Goal{}, err
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Goal{}, err
+	}
+
+	goal := Goal{AgentID: agentID, Kind: kind, Params: params, Status: GoalStatusPending}
+	if err := s.db.QueryRowContext(ctx, `
+		INSERT INTO agent_goals (agent_id, kind, params, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, agentID, kind, raw, GoalStatusPending).Scan(&goal.ID, &goal.CreatedAt); err != nil {
+		return Goal{}, err
+	}
+	return goal, nil
+}
+
+// ListAgentGoals 返回 Agent 的全部目标及其最近一次评估结果。
+func (s *Service) ListAgentGoals(ctx context.Context, agentID string) ([]Goal, error) {
+	return s.queryGoals(ctx, `
+		SELECT id, agent_id, kind, params, status, cursor, failures, last_message, last_evaluated_at, created_at
+		  FROM agent_goals
+		 WHERE agent_id = $1
+		 ORDER BY id
+	`, strings.TrimSpace(agentID))
+}
+
+// DeleteAgentGoal 删除 Agent 的指定目标。
+func (s *Service) DeleteAgentGoal(ctx context.Context, agentID string, goalID int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM agent_goals WHERE id = $1 AND agent_id = $2`, goalID, strings.TrimSpace(agentID))
+	if err != nil {
+		return err
+	}
+	if rows, errRows := res.RowsAffected(); errRows == nil && rows == 0 {
+		return fmt.Errorf("%w: goal %d not found for agent %s", ErrInvalidGoal, goalID, agentID)
+	}
+	return nil
+}
+
+// AdvanceSimulation 推进一次模拟 tick：先结算能量，再沿路径移动 Agent、评估所有 Agent 目标并推进任务队列。
+// 移动、目标与任务失败只记录日志，不影响能量结算结果；返回并发布的场景附带本次 tick 的移动事件。
+func (s *Service) AdvanceSimulation(ctx context.Context, seconds float64, drainFactor float64) (Scene, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.simulating.Store(true)
+	defer s.simulating.Store(false)
+
+	if _, err := s.advanceEnergyState(ctx, seconds, drainFactor); err != nil {
+		s.changes.Publish(s.scene)
+		return Scene{}, err
+	}
+	events, err := s.advanceMovement(ctx, seconds)
+	if err != nil {
+		log.Printf("AdvanceSimulation: advance movement failed: %v", err)
+	}
+	if _, err := s.evaluateGoals(ctx); err != nil {
+		log.Printf("AdvanceSimulation: evaluate goals failed: %v", err)
+	}
+	if err := s.advanceJobs(ctx, seconds); err != nil {
+		log.Printf("AdvanceSimulation: advance jobs failed: %v", err)
+	}
+	scene := s.scene
+	scene.MovementEvents = events
+	s.changes.Publish(scene)
+	return scene, nil
+}
+
+// EvaluateGoals 评估当前场景中所有 Agent 的目标并持久化评估结果。
+func (s *Service) EvaluateGoals(ctx context.Context) ([]Goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evaluateGoals(ctx)
+}
+
+func (s *Service) evaluateGoals(ctx context.Context) ([]Goal, error) {
+	goals, err := s.queryGoals(ctx, `
+		SELECT g.id, g.agent_id, g.kind, g.params, g.status, g.cursor, g.failures, g.last_message, g.last_evaluated_at, g.created_at
+		  FROM agent_goals g
+		  JOIN system_scene_agents a ON a.id = g.agent_id
+		 WHERE a.scene_id = $1
+		 ORDER BY g.id
+	`, s.scene.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range goals {
+		goal := &goals[i]
+		outcome := s.evaluateGoal(ctx, goal)
+		now := time.Now()
+		goal.Status = outcome.status
+		goal.LastMessage = outcome.message
+		goal.LastEvaluatedAt = &now
+		if outcome.failed {
+			goal.Failures++
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			UPDATE agent_goals
+			   SET status = $1,
+			       last_message = $2,
+			       last_evaluated_at = $3,
+			       failures = $4,
+			       cursor = $5
+			 WHERE id = $6
+		`, goal.Status, goal.LastMessage, now, goal.Failures, goal.Cursor, goal.ID); err != nil {
+			return nil, err
+		}
+	}
+	return goals, nil
+}
+
+// patrollingAgentIDs 返回场景中带巡逻目标的 Agent。
+func (s *Service) patrollingAgentIDs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT g.agent_id
+		  FROM agent_goals g
+		  JOIN system_scene_agents a ON a.id = g.agent_id
+		 WHERE a.scene_id = $1 AND g.kind = $2
+	`, s.scene.ID, GoalKindPatrol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agentIDs []string
+	for rows.Next() {
+		var agentID string
+		if err := rows.Scan(&agentID); err != nil {
+			return nil, err
+		}
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs, rows.Err()
+}
+
+func (s *Service) evaluateGoal(ctx context.Context, goal *Goal) goalOutcome {
+	agent, ok := findAgent(s.scene, goal.AgentID)
+	if !ok {
+		return goalOutcome{status: GoalStatusFailing, message: "agent not in scene", failed: true}
+	}
+
+	switch goal.Kind {
+	case GoalKindNetFlow:
+		minFlow := floatOrZero(goal.Params.MinNetFlow)
+		balance := computeEnergyBalance(s.scene)
+		net := balance.output - balance.consumption
+		if net >= minFlow {
+			return goalOutcome{status: GoalStatusSatisfied, message: fmt.Sprintf("net flow %.0f >= %.0f", net, minFlow)}
+		}
+		return s.correctEnergy(ctx, agent, minFlow, fmt.Sprintf("net flow %.0f < %.0f", net, minFlow))
+	case GoalKindStorageLevel:
+		minPercent := floatOrZero(goal.Params.MinStoragePercent)
+		percent, ok := storageLevelPercent(s.scene)
+		if !ok {
+			return goalOutcome{status: GoalStatusFailing, message: "no storage capacity in scene", failed: true}
+		}
+		if percent >= minPercent {
+			return goalOutcome{status: GoalStatusSatisfied, message: fmt.Sprintf("storage %.1f%% >= %.1f%%", percent, minPercent)}
+		}
+		balance := computeEnergyBalance(s.scene)
+		if net := balance.output - balance.consumption; net > 0 {
+			return goalOutcome{status: GoalStatusCorrecting, message: fmt.Sprintf("storage %.1f%% < %.1f%%, recharging at %.0f", percent, minPercent, net)}
+		}
+		// 净流量为 0 时储能不会回升，余量按缺口在充能时长内补足换算，至少为 1。
+		current, capacity := storageLevel(s.scene)
+		margin := math.Max((minPercent/100*capacity-current)/goalStorageRechargeSeconds, 1)
+		return s.correctEnergy(ctx, agent, margin, fmt.Sprintf("storage %.1f%% < %.1f%%", percent, minPercent))
+	case GoalKindPatrol:
+		route, cursor, status, message := s.nextPatrolRoute(agentTile(agent.Position), goal.Params.Waypoints, goal.Cursor)
+		goal.Cursor = cursor
+		if status == GoalStatusFailing {
+			return goalOutcome{status: status, message: message, failed: true}
+		}
+		// 已在途中的 Agent 由移动 tick 沿原路径推进，到达航点后再规划下一段。
+		if len(route) > 0 && len(agent.Path) == 0 {
+			if err := s.saveAgentPath(ctx, s.db, agent, route); err != nil {
+				return goalOutcome{status: GoalStatusFailing, message: err.Error(), failed: true}
+			}
+			if err := s.reloadScene(); err != nil {
+				return goalOutcome{status: GoalStatusFailing, message: err.Error(), failed: true}
+			}
+		}
+		return goalOutcome{status: status, message: message}
+	default:
+		return goalOutcome{status: GoalStatusFailing, message: "unknown goal kind " + goal.Kind, failed: true}
+	}
+}
+
+// correctEnergy 调用 maintain-energy 规则补充太阳能塔，直至净能量流不低于 margin。
+func (s *Service) correctEnergy(ctx context.Context, agent SceneAgent, margin float64, reason string) goalOutcome {
+	result, err := s.maintainEnergy(ctx, agent.ID, MaintainEnergyOptions{Margin: margin})
+	if err != nil {
+		return goalOutcome{status: GoalStatusFailing, message: reason + ": " + err.Error(), failed: true}
+	}
+	return goalOutcome{status: GoalStatusCorrecting, message: fmt.Sprintf("%s, built %d tower(s)", reason, result.TowersBuilt)}
+}
+
+// storageLevelPercent 返回所有储能建筑的总体充能百分比。
+func storageLevelPercent(scene Scene) (float64, bool) {
+	current, capacity := storageLevel(scene)
+	if capacity <= 0 {
+		return 0, false
+	}
+	return current / capacity * 100, true
+}
+
+// storageLevel 返回所有储能建筑的当前储能与总容量。
+func storageLevel(scene Scene) (float64, float64) {
+	var current, capacity float64
+	for _, building := range computeEnergyBalance(scene).storage {
+		current += float64(building.Energy.Current)
+		capacity += float64(building.Energy.Capacity)
+	}
+	return current, capacity
+}
+
+// nextPatrolRoute 计算巡逻目标的当前路段：到达当前航点后切换到下一个航点，返回前往该航点的最短路径。
+func (s *Service) nextPatrolRoute(from [2]int, waypoints [][2]int, cursor int) ([][2]int, int, string, string) {
+	if len(waypoints) == 0 {
+		return nil, 0, GoalStatusFailing, "patrol has no waypoints"
+	}
+	cursor = ((cursor % len(waypoints)) + len(waypoints)) % len(waypoints)
+	if from == waypoints[cursor] {
+		if len(waypoints) == 1 {
+			return nil, cursor, GoalStatusSatisfied, fmt.Sprintf("holding at waypoint %v", waypoints[cursor])
+		}
+		cursor = (cursor + 1) % len(waypoints)
+	}
+	target := waypoints[cursor]
+
+	route, ok := s.routeToward(from, target)
+	if !ok {
+		return nil, cursor, GoalStatusFailing, fmt.Sprintf("waypoint %d %v unreachable", cursor, target)
+	}
+	return route, cursor, GoalStatusActive, fmt.Sprintf("heading to waypoint %d %v", cursor, target)
+}
+
+// normalizeGoalParams 校验目标参数并只保留该类目标使用的字段。
+func normalizeGoalParams(scene Scene, kind string, params GoalParams) (GoalParams, error) {
+	switch kind {
+	case GoalKindNetFlow:
+		minFlow := floatOrZero(params.MinNetFlow)
+		return GoalParams{MinNetFlow: &minFlow}, nil
+	case GoalKindStorageLevel:
+		if params.MinStoragePercent == nil {
+			return GoalParams{}, fmt.Errorf("%w: minStoragePercent required", ErrInvalidGoal)
+		}
+		if p := *params.MinStoragePercent; p <= 0 || p > 100 || math.IsNaN(p) {
+			return GoalParams{}, fmt.Errorf("%w: minStoragePercent must be in (0, 100]", ErrInvalidGoal)
+		}
+		return GoalParams{MinStoragePercent: params.MinStoragePercent}, nil
+	case GoalKindPatrol:
+		if len(params.Waypoints) == 0 {
+			return GoalParams{}, fmt.Errorf("%w: waypoints required", ErrInvalidGoal)
+		}
+		for _, wp := range params.Waypoints {
+			if wp[0] < 0 || wp[1] < 0 || wp[0] >= scene.Dimensions.Width || wp[1] >= scene.Dimensions.Height {
+				return GoalParams{}, fmt.Errorf("%w: waypoint %v outside scene", ErrInvalidGoal, wp)
+			}
+			if tileIsBlocked(wp[0], wp[1], scene.Buildings) {
+				return GoalParams{}, fmt.Errorf("%w: waypoint %v inside a building", ErrInvalidGoal, wp)
+			}
+		}
+		return GoalParams{Waypoints: params.Waypoints}, nil
+	default:
+		return GoalParams{}, fmt.Errorf("%w: kind must be %s, %s or %s", ErrInvalidGoal, GoalKindNetFlow, GoalKindStorageLevel, GoalKindPatrol)
+	}
+}
+
+func (s *Service) queryGoals(ctx context.Context, query string, arg interface{}) ([]Goal, error) {
+	rows, err := s.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		var (
+			goal        Goal
+			raw         []byte
+			message     sql.NullString
+			evaluatedAt sql.NullTime
+		)
+		if err := rows.Scan(&goal.ID, &goal.AgentID, &goal.Kind, &raw, &goal.Status, &goal.Cursor, &goal.Failures, &message, &evaluatedAt, &goal.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &goal.Params); err != nil {
+				return nil, err
+			}
+		}
+		goal.LastMessage = message.String
+		if evaluatedAt.Valid {
+			t := evaluatedAt.Time
+			goal.LastEvaluatedAt = &t
+		}
+		goals = append(goals, goal)
+	}
+	return goals, rows.Err()
+}
+
+func floatOrZero(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,131 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	fieldSourceInstance = "instance"
+	fieldSourceTemplate = "template"
+	fieldSourceUnset    = "unset"
+)
+
+// BuildingInheritance 描述建筑实例的生效值以及各字段来自实例覆盖还是模板。
+type BuildingInheritance struct {
+	Building SceneBuilding     `json:"building"`
+	Template *BuildingTemplate `json:"template,omitempty"`
+	Fields   []InheritedField  `json:"fields"`
+}
+
+// InheritedField 描述单个可继承字段的生效值、实例覆盖值与模板值。
+type InheritedField struct {
+	Field     string      `json:"field"`
+	Effective interface{} `json:"effective"`
+	Override  interface{} `json:"override"`
+	Template  interface{} `json:"template"`
+	Source    string      `json:"source"`
+}
+
+// buildingOverrides 保存建筑实例行中的原始能量列，NULL 表示沿用模板。
+type buildingOverrides struct {
+	energyType sql.NullString
+	capacity   sql.NullInt64
+	current    sql.NullInt64
+	output     sql.NullInt64
+	rate       sql.NullInt64
+}
+
+// DescribeSceneBuilding 返回建筑实例的生效值与覆盖情况。
+func (s *Service) DescribeSceneBuilding(ctx context.Context, buildingID string) (BuildingInheritance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buildingID = strings.TrimSpace(buildingID)
+	if buildingID == "" {
+		return BuildingInheritance{}, fmt.Errorf("%w: building id required", ErrInvalidSceneEntity)
+	}
+
+	building, ok := findBuilding(s.scene, buildingID)
+	if !ok {
+		return BuildingInheritance{}, fmt.Errorf("%w: building %s not found", ErrInvalidSceneEntity, buildingID)
+	}
+
+	var raw buildingOverrides
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT energy_type, energy_capacity, energy_current, energy_output, energy_rate
+		  FROM system_scene_buildings
+		 WHERE id = $1 AND scene_id = $2
+	`, buildingID, s.scene.ID).Scan(&raw.energyType, &raw.capacity, &raw.current, &raw.output, &raw.rate); err != nil {
+		if err == sql.ErrNoRows {
+			return BuildingInheritance{}, fmt.Errorf("%w: building %s not found", ErrInvalidSceneEntity, buildingID)
+		}
+		return BuildingInheritance{}, err
+	}
+
+	result := BuildingInheritance{Building: building}
+	var templateEnergy *SceneEnergy
+	if tpl, ok := findBuildingTemplate(s.scene, building.TemplateID); ok {
+		copied := *tpl
+		result.Template = &copied
+		templateEnergy = tpl.Energy
+	}
+	result.Fields = resolveInheritedFields(raw, templateEnergy)
+	return result, nil
+}
+
+// resolveInheritedFields 逐字段比较实例覆盖值与模板值，得出生效值及其来源。
+func resolveInheritedFields(raw buildingOverrides, tpl *SceneEnergy) []InheritedField {
+	var tplType interface{}
+	var tplCapacity, tplCurrent, tplOutput, tplRate interface{}
+	if tpl != nil {
+		tplType = tpl.Type
+		tplCapacity = nonZeroInt(tpl.Capacity)
+		tplCurrent = nonZeroInt(tpl.Current)
+		tplOutput = nonZeroInt(tpl.Output)
+		tplRate = nonZeroInt(tpl.Rate)
+	}
+
+	var overrideType interface{}
+	if raw.energyType.Valid {
+		overrideType = raw.energyType.String
+	}
+
+	return []InheritedField{
+		inheritedField("energy.type", overrideType, tplType),
+		inheritedField("energy.capacity", nullableIntValue(raw.capacity), tplCapacity),
+		inheritedField("energy.current", nullableIntValue(raw.current), tplCurrent),
+		inheritedField("energy.output", nullableIntValue(raw.output), tplOutput),
+		inheritedField("energy.rate", nullableIntValue(raw.rate), tplRate),
+	}
+}
+
+func inheritedField(name string, override, template interface{}) InheritedField {
+	field := InheritedField{Field: name, Override: override, Template: template}
+	switch {
+	case override != nil:
+		field.Effective = override
+		field.Source = fieldSourceInstance
+	case template != nil:
+		field.Effective = template
+		field.Source = fieldSourceTemplate
+	default:
+		field.Source = fieldSourceUnset
+	}
+	return field
+}
+
+func nullableIntValue(v sql.NullInt64) interface{} {
+	if !v.Valid {
+		return nil
+	}
+	return int(v.Int64)
+}
+
+func nonZeroInt(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,478 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	JobKindBuild   = "build"
+	JobKindRepair  = "repair"
+	JobKindHaul    = "haul"
+	JobKindExplore = "explore"
+
+	JobStatusQueued     = "queued"
+	JobStatusAssigned   = "assigned"
+	JobStatusInProgress = "in-progress"
+	JobStatusDone       = "done"
+	JobStatusFailed     = "failed"
+
+	JobSourceAPI      = "api"
+	JobSourceRule     = "rule"
+	JobSourceBehavior = "behavior"
+
+	// finishedJobVisibility 为已结束任务在场景推送中保留的时长。
+	finishedJobVisibility = time.Minute
+)
+
+// jobDurations 为各类任务在到达目标后需要的工作时长（秒）。
+var jobDurations = map[string]float64{
+	JobKindBuild:   5,
+	JobKindRepair:  3,
+	JobKindHaul:    2,
+	JobKindExplore: 1,
+}
+
+// Job 描述殖民地共享队列中的任务。
+type Job struct {
+	ID              int64     `json:"id"`
+	Kind            string    `json:"kind"`
+	Status          string    `json:"status"`
+	Priority        int       `json:"priority"`
+	Target          []int     `json:"target"`
+	BuildingID      string    `json:"buildingId,omitempty"`
+	TemplateID      string    `json:"templateId,omitempty"`
+	AssignedAgentID string    `json:"assignedAgentId,omitempty"`
+	Progress        float64   `json:"progress"`
+	Source          string    `json:"source"`
+	Message         string    `json:"message,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// CreateJobInput 表示新建任务所需的参数。
+type CreateJobInput struct {
+	Kind       string
+	Target     *[2]int
+	BuildingID string
+	TemplateID string
+	Priority   int
+	Source     string
+}
+
+const jobColumns = `id, kind, status, priority, target_x, target_y, building_id, template_id, assigned_agent_id, progress, source, message, created_at, updated_at`
+
+// CreateJob 校验并将任务加入队列，由 tick 分派给空闲且具备技能的 Agent。
+func (s *Service) CreateJob(ctx context.Context, in CreateJobInput) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kind := strings.ToLower(strings.TrimSpace(in.Kind))
+	source := strings.TrimSpace(in.Source)
+	if source == "" {
+		source = JobSourceAPI
+	}
+
+	var target [2]int
+	buildingID := strings.TrimSpace(in.BuildingID)
+	templateID := strings.TrimSpace(in.TemplateID)
+	switch kind {
+	case JobKindRepair:
+		building, ok := findBuilding(s.scene, buildingID)
+		if !ok || len(building.Rect) != 4 {
+			return Job{}, fmt.Errorf("%w: repair requires an existing buildingId", ErrInvalidJob)
+		}
+		target = [2]int{building.Rect[0], building.Rect[1]}
+	case JobKindBuild, JobKindHaul, JobKindExplore:
+		if in.Target == nil {
+			return Job{}, fmt.Errorf("%w: target required", ErrInvalidJob)
+		}
+		target = *in.Target
+		if !rectWithinScene([4]int{target[0], target[1], 1, 1}, s.scene.Dimensions) {
+			return Job{}, fmt.Errorf("%w: target %v outside scene", ErrInvalidJob, target)
+		}
+		if kind == JobKindBuild {
+			tpl, ok := findBuildingTemplate(s.scene, templateID)
+			if !ok {
+				return Job{}, fmt.Errorf("%w: build requires an existing templateId", ErrInvalidJob)
+			}
+			if tpl.Deprecated {
+				return Job{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+			}
+			rect := buildJobRect(tpl, target)
+			if !rectWithinScene(rect, s.scene.Dimensions) || !s.spatialIndex().AreaIsFree(rect[0], rect[1], rect[2], rect[3]) {
+				return Job{}, fmt.Errorf("%w: build area %v is not free", ErrInvalidJob, rect)
+			}
+			if err := s.ensureTerrainPlacement(rect, tpl.ID); err != nil {
+				return Job{}, fmt.Errorf("%w: %w", ErrInvalidJob, err)
+			}
+		}
+	default:
+		return Job{}, fmt.Errorf("%w: kind must be build, repair, haul or explore", ErrInvalidJob)
+	}
+
+	job, err := insertJob(ctx, s.db, s.scene.ID, CreateJobInput{Kind: kind, BuildingID: buildingID, TemplateID: templateID, Priority: in.Priority, Source: source}, target)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ListJobs 返回场景任务，status 为空时返回全部。
+func (s *Service) ListJobs(ctx context.Context, status string) ([]Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status = strings.TrimSpace(status)
+	if status != "" && !isJobStatus(status) {
+		return nil, fmt.Errorf("%w: unknown status %s", ErrInvalidJob, status)
+	}
+	return queryJobs(ctx, s.db, `
+		SELECT `+jobColumns+`
+		  FROM scene_jobs
+		 WHERE scene_id = $1 AND ($2 = '' OR status = $2)
+		 ORDER BY id DESC
+	`, s.scene.ID, status)
+}
+
+// GetJob 返回指定任务。
+func (s *Service) GetJob(ctx context.Context, jobID int64) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getJob(ctx, jobID)
+}
+
+func (s *Service) getJob(ctx context.Context, jobID int64) (Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM scene_jobs WHERE id = $1 AND scene_id = $2`, jobID, s.scene.ID))
+	if err == sql.ErrNoRows {
+		return Job{}, fmt.Errorf("%w: job %d", ErrJobNotFound, jobID)
+	}
+	return job, err
+}
+
+// CancelJob 将未结束的任务标记为失败并释放执行的 Agent，正前往目标的 Agent 停在当前位置。
+func (s *Service) CancelJob(ctx context.Context, jobID int64) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.getJob(ctx, jobID)
+	if err != nil {
+		return Job{}, err
+	}
+	if job.Status == JobStatusDone || job.Status == JobStatusFailed {
+		return Job{}, fmt.Errorf("%w: job %d is %s", ErrJobFinished, jobID, job.Status)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Job{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE scene_jobs
+		   SET status = $1, message = $2, updated_at = NOW()
+		 WHERE id = $3 AND status NOT IN ('done', 'failed')
+	`, JobStatusFailed, "cancelled", jobID); err != nil {
+		return Job{}, err
+	}
+	if agent, ok := findAgent(s.scene, job.AssignedAgentID); ok && len(agent.Path) > 0 {
+		if err = s.saveAgentPath(ctx, tx, agent, nil); err != nil {
+			return Job{}, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return Job{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return Job{}, err
+	}
+	return s.getJob(ctx, jobID)
+}
+
+// AdvanceJobs 推进一次任务 tick：把排队任务分派给最近的空闲 Agent，
+// 已分派的 Agent 获得前往目标的路径并由移动 tick 推进，到达后累积工作进度直至完成。
+func (s *Service) AdvanceJobs(ctx context.Context, seconds float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.advanceJobs(ctx, seconds)
+}
+
+func (s *Service) advanceJobs(ctx context.Context, seconds float64) error {
+	jobs, err := queryJobs(ctx, s.db, `
+		SELECT `+jobColumns+`
+		  FROM scene_jobs
+		 WHERE scene_id = $1 AND status IN ('queued', 'assigned', 'in-progress')
+		 ORDER BY priority DESC, id
+	`, s.scene.ID)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	busy := make(map[string]bool)
+	for _, job := range jobs {
+		if job.AssignedAgentID != "" {
+			busy[job.AssignedAgentID] = true
+		}
+	}
+	// 巡逻的 Agent 由巡逻目标规划路径，不再分派任务，以免双方互相覆盖路径。
+	patrolling, err := s.patrollingAgentIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, agentID := range patrolling {
+		busy[agentID] = true
+	}
+
+	for _, job := range jobs {
+		var err error
+		switch job.Status {
+		case JobStatusQueued:
+			err = s.dispatchJob(ctx, job, busy)
+		default:
+			err = s.workJob(ctx, job, seconds)
+		}
+		if err != nil {
+			log.Printf("AdvanceJobs: job %d failed to advance: %v", job.ID, err)
+		}
+	}
+
+	return s.reloadScene()
+}
+
+// dispatchJob 通过条件更新预留任务：只有仍处于 queued 的任务会被分派，避免两个 Agent 领取同一任务。
+func (s *Service) dispatchJob(ctx context.Context, job Job, busy map[string]bool) error {
+	agent, ok := pickAgentForJob(s.scene, job, busy)
+	if !ok {
+		return nil
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE scene_jobs
+		   SET status = $1, assigned_agent_id = $2, message = NULL, updated_at = NOW()
+		 WHERE id = $3 AND status = $4
+	`, JobStatusAssigned, agent.ID, job.ID, JobStatusQueued)
+	if err != nil {
+		return err
+	}
+	if rows, errRows := res.RowsAffected(); errRows == nil && rows == 1 {
+		busy[agent.ID] = true
+	}
+	return nil
+}
+
+func (s *Service) workJob(ctx context.Context, job Job, seconds float64) error {
+	agent, ok := findAgent(s.scene, job.AssignedAgentID)
+	if !ok {
+		return s.updateJob(ctx, job.ID, JobStatusQueued, 0, "assigned agent left the scene", true)
+	}
+
+	tile := agentTile(agent.Position)
+	rect := s.jobTargetRect(job)
+	if job.Status == JobStatusAssigned {
+		if !tileAdjacentToRect(tile, rect) {
+			// 仍在途中时沿已有路径移动；路径被取消或尚未规划时重新寻路。
+			if len(agent.Path) > 0 {
+				return nil
+			}
+			route, ok := s.routeTowardRect(tile, rect)
+			if !ok || len(route) == 0 {
+				return s.updateJob(ctx, job.ID, JobStatusFailed, 0, "target unreachable", false)
+			}
+			return s.saveAgentPath(ctx, s.db, agent, route)
+		}
+		return s.updateJob(ctx, job.ID, JobStatusInProgress, 0, "", false)
+	}
+
+	progress := job.Progress + seconds/jobDurations[job.Kind]
+	if progress < 1 {
+		return s.updateJob(ctx, job.ID, JobStatusInProgress, progress, "", false)
+	}
+
+	if job.Kind == JobKindBuild {
+		if err := s.completeBuildJob(ctx, job, rect); err != nil {
+			return s.updateJob(ctx, job.ID, JobStatusFailed, progress, err.Error(), false)
+		}
+	}
+	return s.updateJob(ctx, job.ID, JobStatusDone, 1, "", false)
+}
+
+func (s *Service) completeBuildJob(ctx context.Context, job Job, rect [4]int) error {
+	tpl, ok := findBuildingTemplate(s.scene, job.TemplateID)
+	if !ok {
+		return fmt.Errorf("%w: template %s not found", ErrInvalidJob, job.TemplateID)
+	}
+	templateID := tpl.ID
+	_, err := s.updateSceneBuilding(ctx, UpdateSceneBuildingInput{
+		ID:         fmt.Sprintf("%s_job_%d", tpl.ID, job.ID),
+		Label:      tpl.Label,
+		TemplateID: &templateID,
+		Rect:       rect,
+	})
+	return err
+}
+
+// updateJob 更新任务状态，release 为 true 时解除 Agent 分派以便重新排队。
+func (s *Service) updateJob(ctx context.Context, jobID int64, status string, progress float64, message string, release bool) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE scene_jobs
+		   SET status = $1,
+		       progress = $2,
+		       message = $3,
+		       assigned_agent_id = CASE WHEN $4 THEN NULL ELSE assigned_agent_id END,
+		       updated_at = NOW()
+		 WHERE id = $5
+	`, status, progress, nullString(message), release, jobID)
+	return err
+}
+
+func (s *Service) jobTargetRect(job Job) [4]int {
+	target := [2]int{job.Target[0], job.Target[1]}
+	switch job.Kind {
+	case JobKindRepair:
+		if building, ok := findBuilding(s.scene, job.BuildingID); ok && len(building.Rect) == 4 {
+			return [4]int{building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]}
+		}
+	case JobKindBuild:
+		if tpl, ok := findBuildingTemplate(s.scene, job.TemplateID); ok {
+			return buildJobRect(tpl, target)
+		}
+	}
+	return [4]int{target[0], target[1], 1, 1}
+}
+
+// pickAgentForJob 在空闲且模板技能包含任务类型的 Agent 中选出距离目标最近的一个；
+// 正沿路径移动（如巡逻或搬迁途中）的 Agent 不算空闲。
+func pickAgentForJob(scene Scene, job Job, busy map[string]bool) (SceneAgent, bool) {
+	candidates := make([]SceneAgent, 0, len(scene.Agents))
+	for _, agent := range scene.Agents {
+		if busy[agent.ID] || len(agent.Path) > 0 {
+			continue
+		}
+		tpl, ok := findAgentTemplate(scene, agent.TemplateID)
+		if !ok || !containsString(tpl.Skills, job.Kind) {
+			continue
+		}
+		candidates = append(candidates, agent)
+	}
+	if len(candidates) == 0 {
+		return SceneAgent{}, false
+	}
+
+	distance := func(agent SceneAgent) int {
+		tile := agentTile(agent.Position)
+		return abs(tile[0]-job.Target[0]) + abs(tile[1]-job.Target[1])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		di, dj := distance(candidates[i]), distance(candidates[j])
+		if di != dj {
+			return di < dj
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+	return candidates[0], true
+}
+
+func buildJobRect(tpl *BuildingTemplate, target [2]int) [4]int {
+	width, height := 1, 1
+	if len(tpl.Size) == 2 && tpl.Size[0] > 0 && tpl.Size[1] > 0 {
+		width, height = tpl.Size[0], tpl.Size[1]
+	}
+	return [4]int{target[0], target[1], width, height}
+}
+
+// tileAdjacentToRect 判断格子是否位于矩形内或紧邻矩形（含对角）。
+func tileAdjacentToRect(tile [2]int, rect [4]int) bool {
+	return tile[0] >= rect[0]-1 && tile[0] <= rect[0]+rect[2] &&
+		tile[1] >= rect[1]-1 && tile[1] <= rect[1]+rect[3]
+}
+
+func isJobStatus(status string) bool {
+	switch status {
+	case JobStatusQueued, JobStatusAssigned, JobStatusInProgress, JobStatusDone, JobStatusFailed:
+		return true
+	}
+	return false
+}
+
+// loadVisibleJobs 返回需要随场景推送的任务：未结束的任务以及最近结束的任务。
+func loadVisibleJobs(ctx context.Context, db *sql.DB, sceneID string) ([]Job, error) {
+	return queryJobs(ctx, db, `
+		SELECT `+jobColumns+`
+		  FROM scene_jobs
+		 WHERE scene_id = $1
+		   AND (status IN ('queued', 'assigned', 'in-progress') OR updated_at > NOW() - $2 * INTERVAL '1 second')
+		 ORDER BY priority DESC, id
+	`, sceneID, finishedJobVisibility.Seconds())
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertJob 写入已校验的任务，target 为任务目标格。
+func insertJob(ctx context.Context, db rowQueryer, sceneID string, in CreateJobInput, target [2]int) (Job, error) {
+	return scanJob(db.QueryRowContext(ctx, `
+		INSERT INTO scene_jobs (scene_id, kind, priority, target_x, target_y, building_id, template_id, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+jobColumns,
+		sceneID, in.Kind, in.Priority, target[0], target[1], nullString(in.BuildingID), nullString(in.TemplateID), in.Source))
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var (
+		job                                  Job
+		x, y                                 int
+		buildingID, templateID, agentID, msg sql.NullString
+	)
+	if err := row.Scan(&job.ID, &job.Kind, &job.Status, &job.Priority, &x, &y, &buildingID, &templateID, &agentID, &job.Progress, &job.Source, &msg, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return Job{}, err
+	}
+	job.Target = []int{x, y}
+	job.BuildingID = buildingID.String
+	job.TemplateID = templateID.String
+	job.AssignedAgentID = agentID.String
+	job.Message = msg.String
+	return job, nil
+}
+
+func queryJobs(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Job, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func nullString(v string) sql.NullString {
+	if v == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: v, Valid: true}
+}
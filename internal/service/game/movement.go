@@ -0,0 +1,274 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"eeo/backend/internal/pathfinding"
+)
+
+const (
+	MovementEventArrived       = "arrived"
+	MovementEventPathCancelled = "path-cancelled"
+)
+
+// MovementEvent 描述一次 tick 中 Agent 沿路径移动产生的事件。
+type MovementEvent struct {
+	Type      string    `json:"type"`
+	AgentID   string    `json:"agentId"`
+	Position  []float64 `json:"position"`
+	BlockedBy string    `json:"blockedBy,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// SetAgentPathInput 表示为 Agent 指定移动路径所需的参数，Path 为按顺序经过的格子。
+// Path 为空时根据 Destination 自动寻路，Diagonal 控制自动寻路是否允许斜向移动。
+type SetAgentPathInput struct {
+	AgentID     string
+	Path        [][2]int
+	Destination *[2]int
+	Diagonal    bool
+}
+
+// SetAgentPath 校验并保存 Agent 的移动路径，Agent 将在后续 tick 中按模板速度沿路径移动。
+func (s *Service) SetAgentPath(ctx context.Context, in SetAgentPathInput) (SceneAgent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agentID := strings.TrimSpace(in.AgentID)
+	if agentID == "" {
+		return SceneAgent{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
+	}
+	agent, ok := findAgent(s.scene, agentID)
+	if !ok {
+		return SceneAgent{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+	from := agentTile(agent.Position)
+	path := in.Path
+	if len(path) == 0 && in.Destination != nil {
+		result, err := s.findPath(FindPathInput{From: from, To: *in.Destination, Diagonal: in.Diagonal})
+		if err != nil {
+			return SceneAgent{}, err
+		}
+		if len(result.Path) == 0 {
+			return agent, nil
+		}
+		path = result.Path
+	}
+	if err := s.validateAgentPath(from, path); err != nil {
+		return SceneAgent{}, err
+	}
+
+	if err := s.saveAgentPath(ctx, s.db, agent, path); err != nil {
+		return SceneAgent{}, err
+	}
+	if err := s.reloadScene(); err != nil {
+		return SceneAgent{}, err
+	}
+	agent, _ = findAgent(s.scene, agentID)
+	return agent, nil
+}
+
+// CancelAgentPath 清除 Agent 当前的移动路径，Agent 停在当前插值位置。
+func (s *Service) CancelAgentPath(ctx context.Context, agentID string) (SceneAgent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agentID = strings.TrimSpace(agentID)
+	agent, ok := findAgent(s.scene, agentID)
+	if !ok {
+		return SceneAgent{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	}
+	if len(agent.Path) == 0 {
+		return agent, nil
+	}
+
+	if err := s.saveAgentPath(ctx, s.db, agent, nil); err != nil {
+		return SceneAgent{}, err
+	}
+	if err := s.reloadScene(); err != nil {
+		return SceneAgent{}, err
+	}
+	agent, _ = findAgent(s.scene, agentID)
+	return agent, nil
+}
+
+// AdvanceMovement 按速度推进所有带路径的 Agent，返回到达与路径取消事件。
+// 剩余路径上出现建筑时路径会被取消，Agent 停在当前位置。
+func (s *Service) AdvanceMovement(ctx context.Context, seconds float64) ([]MovementEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.advanceMovement(ctx, seconds)
+}
+
+func (s *Service) advanceMovement(ctx context.Context, seconds float64) ([]MovementEvent, error) {
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	var moving []SceneAgent
+	for _, agent := range s.scene.Agents {
+		if len(agent.Path) > 0 {
+			moving = append(moving, agent)
+		}
+	}
+	if len(moving) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	events := make([]MovementEvent, 0, len(moving))
+	for _, agent := range moving {
+		if blockedBy, blocked := pathBlockedBy(agent.Path, s.scene.Buildings); blocked {
+			if err = s.saveAgentPath(ctx, tx, agent, nil); err != nil {
+				return nil, err
+			}
+			events = append(events, MovementEvent{
+				Type:      MovementEventPathCancelled,
+				AgentID:   agent.ID,
+				Position:  agent.Position,
+				BlockedBy: blockedBy,
+				Message:   describeBlock(blockedBy),
+			})
+			continue
+		}
+
+		speed := agent.Speed
+		if speed <= 0 {
+			speed = 1
+		}
+		position, remaining := advanceAlongPath([2]float64{agent.Position[0], agent.Position[1]}, agent.Path, speed*seconds)
+		agent.Position = []float64{position[0], position[1]}
+		if err = s.saveAgentPath(ctx, tx, agent, remaining); err != nil {
+			return nil, err
+		}
+		if len(remaining) == 0 {
+			events = append(events, MovementEvent{
+				Type:     MovementEventArrived,
+				AgentID:  agent.ID,
+				Position: agent.Position,
+			})
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := s.reloadScene(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// saveAgentPath 保存 Agent 的插值坐标与剩余路径，path 为空时清除路径。
+func (s *Service) saveAgentPath(ctx context.Context, db execer, agent SceneAgent, path [][2]int) error {
+	encoded := sql.NullString{}
+	if len(path) > 0 {
+		raw, err := json.Marshal(path)
+		if err != nil {
+			return err
+		}
+		encoded = sql.NullString{String: string(raw), Valid: true}
+	}
+
+	x, y := agent.Position[0], agent.Position[1]
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO agent_runtime_state (agent_id, pos_x, pos_y, path)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (agent_id)
+		DO UPDATE SET pos_x = EXCLUDED.pos_x,
+		              pos_y = EXCLUDED.pos_y,
+		              path = EXCLUDED.path,
+		              updated_at = NOW()
+	`, agent.ID, x, y, encoded); err != nil {
+		return err
+	}
+
+	_, err := db.ExecContext(ctx, `
+		UPDATE system_scene_agents
+		   SET position_x = $1,
+		       position_y = $2
+		 WHERE id = $3
+	`, int(math.Round(x)), int(math.Round(y)), agent.ID)
+	return err
+}
+
+// validateAgentPath 要求路径逐格相邻、位于场景范围内且不穿过建筑，斜向移动不能切过建筑的角。
+func (s *Service) validateAgentPath(from [2]int, path [][2]int) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%w: path requires at least one tile or a destination", ErrInvalidPath)
+	}
+	scene := s.scene
+	grid := s.occupancyGrid()
+	prev := from
+	for i, tile := range path {
+		if tile[0] < 0 || tile[1] < 0 || tile[0] >= scene.Dimensions.Width || tile[1] >= scene.Dimensions.Height {
+			return fmt.Errorf("%w: path tile %d (%d,%d) outside scene", ErrInvalidPath, i, tile[0], tile[1])
+		}
+		if blockedBy, blocked := buildingAt(tile[0], tile[1], scene.Buildings); blocked {
+			return fmt.Errorf("%w: path tile %d (%d,%d) blocked by %s", ErrInvalidPath, i, tile[0], tile[1], blockedBy)
+		}
+		if scene.Terrain.At(tile[0], tile[1]) == TerrainCrater {
+			return fmt.Errorf("%w: path tile %d (%d,%d) is a crater", ErrInvalidPath, i, tile[0], tile[1])
+		}
+		if !grid.CanStep(toPoint(prev), toPoint(tile), pathfinding.Options{Diagonal: true}) {
+			return fmt.Errorf("%w: path tile %d (%d,%d) is not reachable in one step from (%d,%d)", ErrInvalidPath, i, tile[0], tile[1], prev[0], prev[1])
+		}
+		prev = tile
+	}
+	return nil
+}
+
+// advanceAlongPath 从 position 出发沿路径移动 distance 格，返回插值后的坐标与剩余路径。
+func advanceAlongPath(position [2]float64, path [][2]int, distance float64) ([2]float64, [][2]int) {
+	for len(path) > 0 && distance > 0 {
+		target := [2]float64{float64(path[0][0]), float64(path[0][1])}
+		dx, dy := target[0]-position[0], target[1]-position[1]
+		length := math.Hypot(dx, dy)
+		if length <= distance {
+			position = target
+			distance -= length
+			path = path[1:]
+			continue
+		}
+		ratio := distance / length
+		position = [2]float64{position[0] + dx*ratio, position[1] + dy*ratio}
+		distance = 0
+	}
+	if len(path) == 0 {
+		return position, nil
+	}
+	return position, path
+}
+
+func pathBlockedBy(path [][2]int, buildings []SceneBuilding) (string, bool) {
+	for _, tile := range path {
+		if id, ok := buildingAt(tile[0], tile[1], buildings); ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func tilesToPath(tiles [][2]float64) [][2]int {
+	path := make([][2]int, 0, len(tiles))
+	for _, tile := range tiles {
+		path = append(path, [2]int{int(math.Floor(tile[0])), int(math.Floor(tile[1]))})
+	}
+	return path
+}
@@ -0,0 +1,165 @@
+package game
+
+import (
+	"fmt"
+
+	"eeo/backend/internal/pathfinding"
+)
+
+const pathCacheLimit = 1024
+
+// FindPathInput 表示寻路查询参数。
+type FindPathInput struct {
+	From     [2]int
+	To       [2]int
+	Diagonal bool
+}
+
+// PathResult 描述寻路结果，Path 不含起点、包含终点。
+type PathResult struct {
+	From     [2]int   `json:"from"`
+	To       [2]int   `json:"to"`
+	Diagonal bool     `json:"diagonal"`
+	Path     [][2]int `json:"path"`
+	Cost     float64  `json:"cost"`
+}
+
+// FindPath 在当前场景的占用网格上计算 From 到 To 的最低代价路径，结果按建筑布局缓存。
+func (s *Service) FindPath(in FindPathInput) (PathResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findPath(in)
+}
+
+func (s *Service) findPath(in FindPathInput) (PathResult, error) {
+	dims := s.scene.Dimensions
+	for _, tile := range [][2]int{in.From, in.To} {
+		if tile[0] < 0 || tile[1] < 0 || tile[0] >= dims.Width || tile[1] >= dims.Height {
+			return PathResult{}, fmt.Errorf("%w: tile (%d,%d) outside scene", ErrInvalidPath, tile[0], tile[1])
+		}
+	}
+
+	path, cost, ok := s.pathCache().FindPath(toPoint(in.From), toPoint(in.To), pathfinding.Options{Diagonal: in.Diagonal})
+	if !ok {
+		return PathResult{}, fmt.Errorf("%w: from (%d,%d) to (%d,%d)", ErrNoPath, in.From[0], in.From[1], in.To[0], in.To[1])
+	}
+	return PathResult{From: in.From, To: in.To, Diagonal: in.Diagonal, Path: fromPoints(path), Cost: cost}, nil
+}
+
+// pathCache 返回与当前建筑布局一致的寻路缓存，setScene 递增布局版本后重建占用网格并清空缓存。
+func (s *Service) pathCache() *pathfinding.Cache {
+	if s.paths == nil {
+		s.paths = pathfinding.NewCache(pathCacheLimit)
+	}
+	if grid, current := s.paths.Current(); grid == nil || current != s.layoutVersion {
+		s.paths.Reset(newOccupancyGrid(s.scene.Dimensions, s.scene.Buildings, s.scene.Terrain), s.layoutVersion)
+	}
+	return s.paths
+}
+
+// occupancyGrid 返回寻路缓存中与当前布局一致的占用网格。
+func (s *Service) occupancyGrid() *pathfinding.Grid {
+	grid, _ := s.pathCache().Current()
+	return grid
+}
+
+// newOccupancyGrid 根据场景尺寸、地形代价与建筑占地生成寻路网格。
+func newOccupancyGrid(dims SceneDims, buildings []SceneBuilding, terrain *TerrainLayer) *pathfinding.Grid {
+	grid := pathfinding.NewGrid(dims.Width, dims.Height)
+	if terrain != nil {
+		for y := 0; y < dims.Height; y++ {
+			for x := 0; x < dims.Width; x++ {
+				if tile := terrain.At(x, y); tile != TerrainRegolith {
+					grid.SetCost(pathfinding.Point{X: x, Y: y}, tile.MoveCost())
+				}
+			}
+		}
+	}
+	for _, building := range buildings {
+		if len(building.Rect) < 4 {
+			continue
+		}
+		grid.Block(building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3])
+	}
+	return grid
+}
+
+// sameLayout 判断两个场景的尺寸、地形与建筑占地是否一致，setScene 据此决定是否递增布局版本。
+func sameLayout(a, b Scene) bool {
+	if a.Dimensions != b.Dimensions || len(a.Buildings) != len(b.Buildings) || !sameTerrain(a.Terrain, b.Terrain) {
+		return false
+	}
+	for i := range a.Buildings {
+		if !sameFootprint(a.Buildings[i], b.Buildings[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameFootprint 判断两座建筑的 ID 与占地是否一致。
+func sameFootprint(a, b SceneBuilding) bool {
+	return a.ID == b.ID && sameRect(a.Rect, b.Rect)
+}
+
+func sameTerrain(a, b *TerrainLayer) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Width != b.Width || a.Height != b.Height || len(a.Runs) != len(b.Runs) {
+		return false
+	}
+	for i := range a.Runs {
+		if a.Runs[i] != b.Runs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRect(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// routeToward 返回沿最短路径前往目标的格子（不含起点），已在目标上时返回空路径。
+func (s *Service) routeToward(from, target [2]int) ([][2]int, bool) {
+	if from == target {
+		return nil, true
+	}
+	path, _, ok := s.pathCache().FindPath(toPoint(from), toPoint(target), pathfinding.Options{})
+	if !ok || len(path) == 0 {
+		return nil, false
+	}
+	return fromPoints(path), true
+}
+
+// routeTowardRect 返回沿最短路径前往矩形相邻格的格子（不含起点），已相邻时返回空路径。
+func (s *Service) routeTowardRect(from [2]int, rect [4]int) ([][2]int, bool) {
+	path, _, ok := pathfinding.FindNearest(s.occupancyGrid(), toPoint(from), pathfinding.Options{}, func(p pathfinding.Point) bool {
+		return tileAdjacentToRect([2]int{p.X, p.Y}, rect)
+	})
+	if !ok || len(path) == 0 {
+		return nil, ok
+	}
+	return fromPoints(path), true
+}
+
+func toPoint(tile [2]int) pathfinding.Point {
+	return pathfinding.Point{X: tile[0], Y: tile[1]}
+}
+
+func fromPoints(points []pathfinding.Point) [][2]int {
+	tiles := make([][2]int, 0, len(points))
+	for _, p := range points {
+		tiles = append(tiles, [2]int{p.X, p.Y})
+	}
+	return tiles
+}
@@ -0,0 +1,199 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"eeo/backend/internal/pathfinding"
+)
+
+const (
+	DefaultPlacementLimit     = 5
+	MaxPlacementLimit         = 50
+	DefaultPlacementClearance = 1
+
+	// 评分为加权代价之和，越低越好：到 Agent 的寻路代价、到最近耗能建筑的距离、占地的平均地形代价。
+	placementAgentWeight    = 1.0
+	placementConsumerWeight = 0.5
+	placementTerrainWeight  = 4.0
+)
+
+// PlacementQuery 为放置建议的查询参数。
+type PlacementQuery struct {
+	TemplateID string
+	// AgentID 非空时只建议该 Agent 能走到旁边的位置，并按寻路代价评分。
+	AgentID string
+	Limit   int
+	// Clearance 为占地四周需保持无建筑的格数，保证通行。
+	Clearance int
+}
+
+// PlacementSuggestion 描述一个候选位置及其评分明细。
+type PlacementSuggestion struct {
+	Rect             [4]int   `json:"rect"`
+	Score            float64  `json:"score"`
+	AgentDistance    *float64 `json:"agentDistance,omitempty"`
+	ConsumerDistance float64  `json:"consumerDistance"`
+	TerrainCost      float64  `json:"terrainCost"`
+}
+
+// PlacementPlan 为按评分排序的放置建议，候选位置互不重叠。
+type PlacementPlan struct {
+	TemplateID  string                `json:"templateId"`
+	AgentID     string                `json:"agentId,omitempty"`
+	Size        [2]int                `json:"size"`
+	Clearance   int                   `json:"clearance"`
+	Suggestions []PlacementSuggestion `json:"suggestions"`
+}
+
+// SuggestPlacement 枚举场景内所有能放下模板的位置，按距离与地形评分后返回排名靠前且互不重叠的建议。
+// 候选位置满足与手工放置相同的规则：不与建筑重叠、不压陨石坑、满足模板的地形要求；此外四周保留通道且不压住 Agent。
+func (s *Service) SuggestPlacement(q PlacementQuery) (PlacementPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tpl, ok := findBuildingTemplate(s.scene, strings.TrimSpace(q.TemplateID))
+	if !ok {
+		return PlacementPlan{}, fmt.Errorf("%w: template %s not found", ErrInvalidSceneEntity, q.TemplateID)
+	}
+	if tpl.Deprecated {
+		return PlacementPlan{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPlacementLimit
+	}
+	limit = min(limit, MaxPlacementLimit)
+	clearance := max(q.Clearance, 0)
+
+	var agentField []float64
+	agentID := strings.TrimSpace(q.AgentID)
+	if agentID != "" {
+		agent, ok := findAgent(s.scene, agentID)
+		if !ok {
+			return PlacementPlan{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+		}
+		agentField = pathfinding.CostField(s.occupancyGrid(), toPoint(agentTile(agent.Position)), pathfinding.Options{})
+	}
+
+	footprint := buildJobRect(tpl, [2]int{})
+	width, height := footprint[2], footprint[3]
+	plan := PlacementPlan{TemplateID: tpl.ID, AgentID: agentID, Size: [2]int{width, height}, Clearance: clearance, Suggestions: []PlacementSuggestion{}}
+
+	dims := s.scene.Dimensions
+	index := s.spatialIndex()
+	consumers := consumerRects(s.scene.Buildings)
+	agentTiles := make([][2]int, 0, len(s.scene.Agents))
+	for _, agent := range s.scene.Agents {
+		agentTiles = append(agentTiles, agentTile(agent.Position))
+	}
+
+	var candidates []PlacementSuggestion
+	for y := 0; y+height <= dims.Height; y++ {
+		for x := 0; x+width <= dims.Width; x++ {
+			rect := [4]int{x, y, width, height}
+			if !index.AreaIsFree(x-clearance, y-clearance, width+2*clearance, height+2*clearance) {
+				continue
+			}
+			if checkTerrainPlacement(s.scene.Terrain, rect, tpl.RequiredTerrain) != nil || rectCoversTile(rect, agentTiles) {
+				continue
+			}
+			suggestion := PlacementSuggestion{
+				Rect:             rect,
+				ConsumerDistance: nearestRectDistance(rect, consumers),
+				TerrainCost:      meanTerrainCost(s.scene.Terrain, rect),
+			}
+			suggestion.Score = placementConsumerWeight*suggestion.ConsumerDistance + placementTerrainWeight*(suggestion.TerrainCost-1)
+			if agentField != nil {
+				distance, reachable := adjacentFieldCost(agentField, dims, rect)
+				if !reachable {
+					continue
+				}
+				suggestion.AgentDistance = &distance
+				suggestion.Score += placementAgentWeight * distance
+			}
+			suggestion.Score = math.Round(suggestion.Score*1000) / 1000
+			candidates = append(candidates, suggestion)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score < candidates[j].Score
+	})
+	for _, candidate := range candidates {
+		if len(plan.Suggestions) >= limit {
+			break
+		}
+		overlaps := false
+		for _, chosen := range plan.Suggestions {
+			if rectanglesOverlap(candidate.Rect[0], candidate.Rect[1], candidate.Rect[2], candidate.Rect[3], chosen.Rect[0], chosen.Rect[1], chosen.Rect[2], chosen.Rect[3]) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			plan.Suggestions = append(plan.Suggestions, candidate)
+		}
+	}
+	return plan, nil
+}
+
+func consumerRects(buildings []SceneBuilding) [][4]int {
+	var rects [][4]int
+	for _, building := range buildings {
+		if building.Energy != nil && building.Energy.Type == "consumer" && len(building.Rect) == 4 {
+			rects = append(rects, [4]int{building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]})
+		}
+	}
+	return rects
+}
+
+// nearestRectDistance 返回 rect 与最近目标矩形边缘之间的欧氏距离，没有目标时返回 0。
+func nearestRectDistance(rect [4]int, targets [][4]int) float64 {
+	if len(targets) == 0 {
+		return 0
+	}
+	best := math.Inf(1)
+	for _, target := range targets {
+		dx := max(0, target[0]-(rect[0]+rect[2]), rect[0]-(target[0]+target[2]))
+		dy := max(0, target[1]-(rect[1]+rect[3]), rect[1]-(target[1]+target[3]))
+		best = math.Min(best, math.Hypot(float64(dx), float64(dy)))
+	}
+	return best
+}
+
+// meanTerrainCost 返回占地内格子的平均通行代价，全为风化层时为 1。
+func meanTerrainCost(terrain *TerrainLayer, rect [4]int) float64 {
+	total := 0.0
+	for y := rect[1]; y < rect[1]+rect[3]; y++ {
+		for x := rect[0]; x < rect[0]+rect[2]; x++ {
+			total += terrain.At(x, y).MoveCost()
+		}
+	}
+	return total / float64(rect[2]*rect[3])
+}
+
+// adjacentFieldCost 返回代价场中紧邻 rect 的格子的最低代价。
+func adjacentFieldCost(field []float64, dims SceneDims, rect [4]int) (float64, bool) {
+	best := math.Inf(1)
+	for y := rect[1] - 1; y <= rect[1]+rect[3]; y++ {
+		for x := rect[0] - 1; x <= rect[0]+rect[2]; x++ {
+			inside := x >= rect[0] && x < rect[0]+rect[2] && y >= rect[1] && y < rect[1]+rect[3]
+			if inside || x < 0 || y < 0 || x >= dims.Width || y >= dims.Height {
+				continue
+			}
+			best = math.Min(best, field[y*dims.Width+x])
+		}
+	}
+	return best, !math.IsInf(best, 1)
+}
+
+func rectCoversTile(rect [4]int, tiles [][2]int) bool {
+	for _, tile := range tiles {
+		if tile[0] >= rect[0] && tile[0] < rect[0]+rect[2] && tile[1] >= rect[1] && tile[1] < rect[1]+rect[3] {
+			return true
+		}
+	}
+	return false
+}
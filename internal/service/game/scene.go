@@ -3,8 +3,11 @@ package game
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Scene 表示火星场景的静态配置。
@@ -17,6 +20,10 @@ type Scene struct {
 	Agents            []SceneAgent       `json:"agents"`
 	BuildingTemplates []BuildingTemplate `json:"buildingTemplates"`
 	AgentTemplates    []AgentTemplate    `json:"agentTemplates"`
+	Jobs              []Job              `json:"jobs,omitempty"`
+	MovementEvents    []MovementEvent    `json:"movementEvents,omitempty"`
+	Terrain           *TerrainLayer      `json:"terrain,omitempty"`
+	Deposits          []ResourceDeposit  `json:"deposits,omitempty"`
 }
 
 // SceneGrid 描述场景网格大小与基本单元尺寸。
@@ -48,6 +55,8 @@ type SceneBuilding struct {
 	Label      string       `json:"label"`
 	Rect       []int        `json:"rect"`
 	Energy     *SceneEnergy `json:"energy,omitempty"`
+	// Offline 为 true 时建筑已断电，其耗能不计入能量平衡。
+	Offline bool `json:"offline,omitempty"`
 }
 
 // SceneAgent 描述场景中的角色。
@@ -58,9 +67,19 @@ type SceneAgent struct {
 	Position   []float64  `json:"position"`
 	Color      int        `json:"color,omitempty"`
 	Actions    []string   `json:"actions,omitempty"`
+	Speed      float64    `json:"speed"`
+	Path       [][2]int   `json:"path,omitempty"`
 	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
 }
 
+// ResourceDeposit 描述场景中的资源点。
+type ResourceDeposit struct {
+	ID       int64  `json:"id"`
+	Kind     string `json:"kind"`
+	Position [2]int `json:"position"`
+	Amount   int    `json:"amount"`
+}
+
 // Snapshot 表示 system_* 表的整合视图。
 type Snapshot struct {
 	Scene             SceneMeta          `json:"scene"`
@@ -70,6 +89,7 @@ type Snapshot struct {
 	Agents            []SceneAgent       `json:"agents"`
 	BuildingTemplates []BuildingTemplate `json:"buildingTemplates"`
 	AgentTemplates    []AgentTemplate    `json:"agentTemplates"`
+	Terrain           *TerrainLayer      `json:"terrain,omitempty"`
 }
 
 // SceneMeta 描述场景的基本信息。
@@ -80,16 +100,32 @@ type SceneMeta struct {
 
 // BuildingTemplate 描述系统建筑模板。
 type BuildingTemplate struct {
-	ID     string       `json:"id"`
-	Label  string       `json:"label"`
-	Energy *SceneEnergy `json:"energy,omitempty"`
+	ID         string            `json:"id"`
+	Label      string            `json:"label"`
+	Tier       int               `json:"tier,omitempty"`
+	Size       []int             `json:"size,omitempty"`
+	Energy     *SceneEnergy      `json:"energy,omitempty"`
+	Upgrades   []BuildingUpgrade `json:"upgrades,omitempty"`
+	Deprecated bool              `json:"deprecated,omitempty"`
+	// RequiredTerrain 非空时建筑占地内至少需有一格为该地形。
+	RequiredTerrain string `json:"requiredTerrain,omitempty"`
+}
+
+// BuildingUpgrade 描述从当前模板升级到目标模板的路径。
+type BuildingUpgrade struct {
+	TargetTemplateID string `json:"targetTemplateId"`
+	EnergyCost       int    `json:"energyCost"`
+	FootprintRule    string `json:"footprintRule"`
 }
 
 type AgentTemplate struct {
-	ID       string `json:"id"`
-	Label    string `json:"label"`
-	Color    int    `json:"color,omitempty"`
-	Position []int  `json:"position,omitempty"`
+	ID         string   `json:"id"`
+	Label      string   `json:"label"`
+	Color      int      `json:"color,omitempty"`
+	Position   []int    `json:"position,omitempty"`
+	Skills     []string `json:"skills,omitempty"`
+	Speed      float64  `json:"speed"`
+	Deprecated bool     `json:"deprecated,omitempty"`
 }
 
 // UpdateSceneConfigInput 表示更新 system_* 场景配置所需的数据。
@@ -109,16 +145,24 @@ type UpdateTemplateEnergyInput struct {
 }
 
 type UpdateBuildingTemplateInput struct {
-	ID     string
-	Label  string
-	Energy *UpdateTemplateEnergyInput
+	ID               string
+	Label            string
+	Tier             *int
+	Size             *[2]int
+	Energy           *UpdateTemplateEnergyInput
+	ApplyToInstances bool
+	Deprecated       *bool
+	RequiredTerrain  *string
 }
 
 type UpdateAgentTemplateInput struct {
-	ID       string
-	Label    string
-	Color    *int
-	Position *[2]int
+	ID         string
+	Label      string
+	Color      *int
+	Position   *[2]int
+	Skills     *[]string
+	Speed      *float64
+	Deprecated *bool
 }
 
 type UpdateSceneBuildingInput struct {
@@ -127,6 +171,8 @@ type UpdateSceneBuildingInput struct {
 	TemplateID *string
 	Rect       [4]int
 	Energy     *UpdateTemplateEnergyInput
+	// Powered 为 nil 时保留原供电状态。
+	Powered *bool
 }
 
 type UpdateSceneAgentInput struct {
@@ -168,6 +214,35 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 		return Scene{}, err
 	}
 
+	terrain, err := loadSceneTerrain(ctx, db, sceneID, scene.Dimensions)
+	if err != nil {
+		return Scene{}, err
+	}
+	scene.Terrain = terrain
+
+	depositRows, err := db.QueryContext(ctx, `
+        SELECT id, kind, position_x, position_y, amount
+          FROM system_scene_deposits
+         WHERE scene_id = $1
+         ORDER BY id
+    `, sceneID)
+	if err != nil {
+		return Scene{}, err
+	}
+	defer depositRows.Close()
+
+	for depositRows.Next() {
+		var deposit ResourceDeposit
+		if err := depositRows.Scan(&deposit.ID, &deposit.Kind, &deposit.Position[0], &deposit.Position[1], &deposit.Amount); err != nil {
+			return Scene{}, err
+		}
+		scene.Deposits = append(scene.Deposits, deposit)
+	}
+	if err := depositRows.Err(); err != nil {
+		return Scene{}, err
+	}
+
+	// 建筑实例的 energy_* 列是对模板的覆盖：非 NULL 时以实例为准，NULL 时沿用模板值。
 	buildingRows, err := db.QueryContext(ctx, `
         SELECT b.id,
                b.template_id,
@@ -180,7 +255,8 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
                COALESCE(b.energy_capacity, t.energy_capacity) AS energy_capacity,
                COALESCE(b.energy_current, t.energy_current) AS energy_current,
                COALESCE(b.energy_output, t.energy_output) AS energy_output,
-               COALESCE(b.energy_rate, t.energy_rate) AS energy_rate
+               COALESCE(b.energy_rate, t.energy_rate) AS energy_rate,
+               NOT b.powered AS offline
           FROM system_scene_buildings b
           LEFT JOIN system_template_buildings t ON t.id = b.template_id
          WHERE b.scene_id = $1
@@ -198,6 +274,7 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 			posX, posY, width, height       int
 			energyType                      sql.NullString
 			capacity, current, output, rate sql.NullInt64
+			offline                         bool
 		)
 
 		if err := buildingRows.Scan(
@@ -206,6 +283,7 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 			&label,
 			&posX, &posY, &width, &height,
 			&energyType, &capacity, &current, &output, &rate,
+			&offline,
 		); err != nil {
 			return Scene{}, err
 		}
@@ -228,10 +306,11 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 		}
 
 		building := SceneBuilding{
-			ID:     id,
-			Label:  label,
-			Rect:   []int{posX, posY, width, height},
-			Energy: energy,
+			ID:      id,
+			Label:   label,
+			Rect:    []int{posX, posY, width, height},
+			Energy:  energy,
+			Offline: offline,
 		}
 		if templateID.Valid {
 			building.TemplateID = templateID.String
@@ -249,6 +328,8 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
                COALESCE(r.pos_x, s.position_x::double precision) AS pos_x,
                COALESCE(r.pos_y, s.position_y::double precision) AS pos_y,
                COALESCE(s.color, t.color) AS color,
+               COALESCE(t.speed, 1) AS speed,
+               r.path,
                r.updated_at
           FROM system_scene_agents s
           LEFT JOIN system_template_agents t ON t.id = s.template_id
@@ -269,10 +350,12 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 			templateID sql.NullString
 			x, y       float64
 			color      sql.NullInt64
+			speed      float64
+			path       []byte
 			updatedAt  sql.NullTime
 		)
 
-		if err := agentRows.Scan(&id, &templateID, &label, &x, &y, &color, &updatedAt); err != nil {
+		if err := agentRows.Scan(&id, &templateID, &label, &x, &y, &color, &speed, &path, &updatedAt); err != nil {
 			return Scene{}, err
 		}
 
@@ -280,6 +363,12 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 			ID:       id,
 			Label:    label,
 			Position: []float64{x, y},
+			Speed:    speed,
+		}
+		if len(path) > 0 {
+			if err := json.Unmarshal(path, &agent.Path); err != nil {
+				return Scene{}, fmt.Errorf("decode path for agent %s: %w", id, err)
+			}
 		}
 		if templateID.Valid {
 			agent.TemplateID = templateID.String
@@ -325,7 +414,7 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 	}
 
 	templateRows, err := db.QueryContext(ctx, `
-        SELECT id, label, energy_type, energy_capacity, energy_current, energy_output, energy_rate
+        SELECT id, label, tier, size_width, size_height, energy_type, energy_capacity, energy_current, energy_output, energy_rate, deprecated, required_terrain
           FROM system_template_buildings
          ORDER BY id
     `)
@@ -337,11 +426,15 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 	for templateRows.Next() {
 		var (
 			id, label                       string
+			tier                            int
+			sizeW, sizeH                    sql.NullInt64
 			energyType                      sql.NullString
 			capacity, current, output, rate sql.NullInt64
+			deprecated                      bool
+			requiredTerrain                 sql.NullString
 		)
 
-		if err := templateRows.Scan(&id, &label, &energyType, &capacity, &current, &output, &rate); err != nil {
+		if err := templateRows.Scan(&id, &label, &tier, &sizeW, &sizeH, &energyType, &capacity, &current, &output, &rate, &deprecated, &requiredTerrain); err != nil {
 			return Scene{}, err
 		}
 
@@ -362,14 +455,60 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 			}
 		}
 
-		scene.BuildingTemplates = append(scene.BuildingTemplates, BuildingTemplate{
-			ID:     id,
-			Label:  label,
-			Energy: energy,
-		})
+		tpl := BuildingTemplate{
+			ID:         id,
+			Label:      label,
+			Tier:       tier,
+			Energy:     energy,
+			Deprecated: deprecated,
+		}
+		if requiredTerrain.Valid {
+			tpl.RequiredTerrain = requiredTerrain.String
+		}
+		if sizeW.Valid && sizeH.Valid {
+			tpl.Size = []int{int(sizeW.Int64), int(sizeH.Int64)}
+		}
+		scene.BuildingTemplates = append(scene.BuildingTemplates, tpl)
 	}
+	if err := templateRows.Err(); err != nil {
+		return Scene{}, err
+	}
+
+	if len(scene.BuildingTemplates) > 0 {
+		templateIndex := make(map[string]int, len(scene.BuildingTemplates))
+		for i, tpl := range scene.BuildingTemplates {
+			templateIndex[tpl.ID] = i
+		}
+
+		upgradeRows, err := db.QueryContext(ctx, `
+            SELECT from_template_id, to_template_id, energy_cost, footprint_rule
+              FROM system_template_building_upgrades
+             ORDER BY from_template_id, to_template_id
+        `)
+		if err != nil {
+			return Scene{}, err
+		}
+		defer upgradeRows.Close()
+
+		for upgradeRows.Next() {
+			var (
+				fromID string
+				path   BuildingUpgrade
+			)
+			if err := upgradeRows.Scan(&fromID, &path.TargetTemplateID, &path.EnergyCost, &path.FootprintRule); err != nil {
+				return Scene{}, err
+			}
+			if idx, ok := templateIndex[fromID]; ok {
+				scene.BuildingTemplates[idx].Upgrades = append(scene.BuildingTemplates[idx].Upgrades, path)
+			}
+		}
+		if err := upgradeRows.Err(); err != nil {
+			return Scene{}, err
+		}
+	}
+
 	agentTemplateRows, err := db.QueryContext(ctx, `
-        SELECT id, label, color, default_position_x, default_position_y
+        SELECT id, label, color, default_position_x, default_position_y, skills, speed, deprecated
           FROM system_template_agents
          ORDER BY id
     `)
@@ -380,19 +519,25 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 
 	for agentTemplateRows.Next() {
 		var (
-			id, label string
-			color     sql.NullInt64
-			posX      sql.NullInt64
-			posY      sql.NullInt64
+			id, label  string
+			color      sql.NullInt64
+			posX       sql.NullInt64
+			posY       sql.NullInt64
+			skills     []string
+			speed      float64
+			deprecated bool
 		)
 
-		if err := agentTemplateRows.Scan(&id, &label, &color, &posX, &posY); err != nil {
+		if err := agentTemplateRows.Scan(&id, &label, &color, &posX, &posY, pq.Array(&skills), &speed, &deprecated); err != nil {
 			return Scene{}, err
 		}
 
 		tpl := AgentTemplate{
-			ID:    id,
-			Label: label,
+			ID:         id,
+			Label:      label,
+			Skills:     skills,
+			Speed:      speed,
+			Deprecated: deprecated,
 		}
 		if color.Valid {
 			tpl.Color = int(color.Int64)
@@ -407,6 +552,12 @@ func loadSceneFromStore(db *sql.DB, sceneID string) (Scene, error) {
 		return Scene{}, err
 	}
 
+	jobs, err := loadVisibleJobs(ctx, db, sceneID)
+	if err != nil {
+		return Scene{}, err
+	}
+	scene.Jobs = jobs
+
 	return scene, nil
 }
 
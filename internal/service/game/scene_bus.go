@@ -0,0 +1,54 @@
+package game
+
+import "sync"
+
+// SceneBus 向订阅者广播场景变化。每个订阅者只保留最新一次未读取的场景，
+// 发布从不阻塞：订阅者来不及读取时旧场景被新场景替换，订阅者需按全量场景计算差异。
+// 零值可直接使用。
+type SceneBus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan Scene
+}
+
+// Subscribe 注册订阅者，返回接收场景的通道与取消订阅的函数。
+func (b *SceneBus) Subscribe() (<-chan Scene, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		b.subs = make(map[int]chan Scene)
+	}
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Scene, 1)
+	b.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish 把场景发给所有订阅者，替换其尚未读取的旧场景。
+func (b *SceneBus) Publish(scene Scene) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- scene
+	}
+}
+
+// SubscribeSceneChanges 订阅场景变化：每次写入后重新加载的场景都会发布，模拟 tick 只在结束时发布一次并附带移动事件。
+func (s *Service) SubscribeSceneChanges() (<-chan Scene, func()) {
+	return s.changes.Subscribe()
+}
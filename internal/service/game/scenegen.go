@@ -0,0 +1,308 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"eeo/backend/internal/mapgen"
+)
+
+const (
+	defaultGeneratedTileSize = 32
+	// defaultGeneratedFootprint 为未配置尺寸的模板在生成地图时使用的边长。
+	defaultGeneratedFootprint = 3
+)
+
+// defaultGeneratedBuildings 为未指定建筑组合时的默认开局布局，模板缺失或已弃用时跳过。
+var defaultGeneratedBuildings = []TemplateCount{
+	{TemplateID: "central_dome", Count: 1},
+	{TemplateID: "habitat_block", Count: 2},
+	{TemplateID: "solar_array", Count: 2},
+	{TemplateID: "ice_extractor", Count: 1},
+}
+
+// TemplateCount 表示某个模板需要生成的数量。
+type TemplateCount struct {
+	TemplateID string `json:"templateId"`
+	Count      int    `json:"count"`
+}
+
+// GenerateSceneInput 为程序化生成场景的参数，相同参数总是得到相同的场景。
+type GenerateSceneInput struct {
+	SceneID   string
+	Name      string
+	Seed      int64
+	Width     int
+	Height    int
+	TileSize  int
+	Buildings []TemplateCount
+	// Agents 为空时每个未弃用的 Agent 模板各生成一个。
+	Agents []TemplateCount
+	DryRun bool
+}
+
+// GenerateSceneResult 为生成结果，DryRun 时场景只在内存中组装，不写库。
+type GenerateSceneResult struct {
+	Seed   int64 `json:"seed"`
+	DryRun bool  `json:"dryRun"`
+	Scene  Scene `json:"scene"`
+}
+
+// GenerateScene 按种子生成新场景：地形层、资源点、通过放置校验的初始建筑以及起始 Agent。
+// 新场景写入 system_* 表，但不会替换当前服务运行的场景。
+func (s *Service) GenerateScene(ctx context.Context, in GenerateSceneInput) (GenerateSceneResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sceneID := strings.TrimSpace(in.SceneID)
+	if sceneID == "" {
+		return GenerateSceneResult{}, fmt.Errorf("%w: sceneId required", ErrInvalidSceneConfig)
+	}
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		name = sceneID
+	}
+	tileSize := in.TileSize
+	if tileSize == 0 {
+		tileSize = defaultGeneratedTileSize
+	}
+	if tileSize < 0 {
+		return GenerateSceneResult{}, fmt.Errorf("%w: tileSize must be positive", ErrInvalidSceneConfig)
+	}
+
+	cfg, err := s.generatorConfig(in)
+	if err != nil {
+		return GenerateSceneResult{}, err
+	}
+	generated, err := mapgen.Generate(cfg)
+	if err != nil {
+		if errors.Is(err, mapgen.ErrInvalidConfig) || errors.Is(err, mapgen.ErrNoRoom) {
+			return GenerateSceneResult{}, fmt.Errorf("%w: %v", ErrInvalidSceneConfig, err)
+		}
+		return GenerateSceneResult{}, err
+	}
+
+	scene := s.sceneFromMap(sceneID, name, tileSize, generated)
+	if err := validateGeneratedLayout(scene); err != nil {
+		return GenerateSceneResult{}, err
+	}
+	if in.DryRun {
+		return GenerateSceneResult{Seed: in.Seed, DryRun: true, Scene: scene}, nil
+	}
+
+	if err := s.insertGeneratedScene(ctx, scene); err != nil {
+		return GenerateSceneResult{}, err
+	}
+	stored, err := sceneLoader(s.db, sceneID)
+	if err != nil {
+		return GenerateSceneResult{}, err
+	}
+	return GenerateSceneResult{Seed: in.Seed, Scene: stored}, nil
+}
+
+// generatorConfig 将模板组合转换为生成器配置，模板必须存在且未弃用。
+func (s *Service) generatorConfig(in GenerateSceneInput) (mapgen.Config, error) {
+	cfg := mapgen.Config{Seed: in.Seed, Width: in.Width, Height: in.Height}
+
+	buildings := in.Buildings
+	if len(buildings) == 0 {
+		for _, mix := range defaultGeneratedBuildings {
+			if tpl, ok := findBuildingTemplate(s.scene, mix.TemplateID); ok && !tpl.Deprecated {
+				buildings = append(buildings, mix)
+			}
+		}
+	}
+	for _, mix := range buildings {
+		tpl, ok := findBuildingTemplate(s.scene, strings.TrimSpace(mix.TemplateID))
+		if !ok {
+			return mapgen.Config{}, fmt.Errorf("%w: building template %s not found", ErrInvalidSceneConfig, mix.TemplateID)
+		}
+		if tpl.Deprecated {
+			return mapgen.Config{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+		}
+		spec := mapgen.BuildingSpec{TemplateID: tpl.ID, Width: defaultGeneratedFootprint, Height: defaultGeneratedFootprint, Count: mix.Count}
+		if len(tpl.Size) == 2 && tpl.Size[0] > 0 && tpl.Size[1] > 0 {
+			spec.Width, spec.Height = tpl.Size[0], tpl.Size[1]
+		}
+		if typ, ok := ParseTerrainType(tpl.RequiredTerrain); ok {
+			required := mapgen.Terrain(typ)
+			spec.RequiredTerrain = &required
+		}
+		cfg.Buildings = append(cfg.Buildings, spec)
+	}
+
+	agents := in.Agents
+	if len(agents) == 0 {
+		for _, tpl := range s.scene.AgentTemplates {
+			if !tpl.Deprecated {
+				agents = append(agents, TemplateCount{TemplateID: tpl.ID, Count: 1})
+			}
+		}
+	}
+	for _, mix := range agents {
+		tpl, ok := findAgentTemplate(s.scene, strings.TrimSpace(mix.TemplateID))
+		if !ok {
+			return mapgen.Config{}, fmt.Errorf("%w: agent template %s not found", ErrInvalidSceneConfig, mix.TemplateID)
+		}
+		if tpl.Deprecated {
+			return mapgen.Config{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+		}
+		cfg.Agents = append(cfg.Agents, mapgen.AgentSpec{TemplateID: tpl.ID, Count: mix.Count})
+	}
+	return cfg, nil
+}
+
+// sceneFromMap 将生成结果组装为场景，建筑与 Agent 的 ID 以场景 ID 为前缀，避免与其他场景冲突。
+func (s *Service) sceneFromMap(sceneID, name string, tileSize int, m mapgen.Map) Scene {
+	tiles := make([]TerrainType, len(m.Terrain))
+	for i, tile := range m.Terrain {
+		tiles[i] = TerrainType(tile)
+	}
+	scene := Scene{
+		ID:                sceneID,
+		Name:              name,
+		Grid:              SceneGrid{Cols: m.Width, Rows: m.Height, TileSize: tileSize},
+		Dimensions:        SceneDims{Width: m.Width, Height: m.Height},
+		Terrain:           newTerrainFromTiles(m.Width, m.Height, tiles),
+		BuildingTemplates: s.scene.BuildingTemplates,
+		AgentTemplates:    s.scene.AgentTemplates,
+	}
+
+	counters := make(map[string]int)
+	for _, b := range m.Buildings {
+		counters[b.TemplateID]++
+		building := SceneBuilding{
+			ID:         fmt.Sprintf("%s_%s_%02d", sceneID, b.TemplateID, counters[b.TemplateID]),
+			TemplateID: b.TemplateID,
+			Label:      b.TemplateID,
+			Rect:       []int{b.X, b.Y, b.Width, b.Height},
+		}
+		if tpl, ok := findBuildingTemplate(scene, b.TemplateID); ok {
+			building.Label = fmt.Sprintf("%s %02d", tpl.Label, counters[b.TemplateID])
+			building.Energy = tpl.Energy
+		}
+		scene.Buildings = append(scene.Buildings, building)
+	}
+
+	counters = make(map[string]int)
+	for _, a := range m.Agents {
+		counters[a.TemplateID]++
+		agent := SceneAgent{
+			ID:         fmt.Sprintf("%s_%s_%02d", sceneID, a.TemplateID, counters[a.TemplateID]),
+			TemplateID: a.TemplateID,
+			Label:      a.TemplateID,
+			Position:   []float64{float64(a.X), float64(a.Y)},
+			Speed:      1,
+		}
+		if tpl, ok := findAgentTemplate(scene, a.TemplateID); ok {
+			agent.Label = fmt.Sprintf("%s %02d", tpl.Label, counters[a.TemplateID])
+			agent.Color = tpl.Color
+			agent.Speed = tpl.Speed
+		}
+		scene.Agents = append(scene.Agents, agent)
+	}
+
+	for _, d := range m.Deposits {
+		scene.Deposits = append(scene.Deposits, ResourceDeposit{Kind: d.Kind, Position: [2]int{d.X, d.Y}, Amount: d.Amount})
+	}
+	return scene
+}
+
+// validateGeneratedLayout 按与手工放置相同的规则逐个校验生成的建筑。
+func validateGeneratedLayout(scene Scene) error {
+	index := NewSpatialIndex(scene.Dimensions, nil)
+	for _, building := range scene.Buildings {
+		rect := [4]int{building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]}
+		if !rectWithinScene(rect, scene.Dimensions) {
+			return fmt.Errorf("%w: generated building %s exceeds scene bounds", ErrInvalidSceneConfig, building.ID)
+		}
+		if err := checkBuildingPlacement(index, building.ID, rect); err != nil {
+			return err
+		}
+		var required string
+		if tpl, ok := findBuildingTemplate(scene, building.TemplateID); ok {
+			required = tpl.RequiredTerrain
+		}
+		if err := checkTerrainPlacement(scene.Terrain, rect, required); err != nil {
+			return err
+		}
+		index.Add(building)
+	}
+	return nil
+}
+
+func (s *Service) insertGeneratedScene(ctx context.Context, scene Scene) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM system_scenes WHERE id = $1)`, scene.ID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		err = fmt.Errorf("%w: %s", ErrSceneExists, scene.ID)
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `INSERT INTO system_scenes (id, name) VALUES ($1, $2)`, scene.ID, scene.Name); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO system_scene_grid (scene_id, cols, rows, tile_size) VALUES ($1, $2, $3, $4)
+	`, scene.ID, scene.Grid.Cols, scene.Grid.Rows, scene.Grid.TileSize); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO system_scene_dimensions (scene_id, width, height) VALUES ($1, $2, $3)
+	`, scene.ID, scene.Dimensions.Width, scene.Dimensions.Height); err != nil {
+		return err
+	}
+
+	runs, err := json.Marshal(scene.Terrain.Runs)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO system_scene_terrain (scene_id, width, height, runs) VALUES ($1, $2, $3, $4)
+	`, scene.ID, scene.Terrain.Width, scene.Terrain.Height, string(runs)); err != nil {
+		return err
+	}
+
+	for _, deposit := range scene.Deposits {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO system_scene_deposits (scene_id, kind, position_x, position_y, amount) VALUES ($1, $2, $3, $4, $5)
+		`, scene.ID, deposit.Kind, deposit.Position[0], deposit.Position[1], deposit.Amount); err != nil {
+			return err
+		}
+	}
+
+	// 能量属性全部沿用模板，实例上不写覆盖列。
+	for _, building := range scene.Buildings {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO system_scene_buildings (id, scene_id, template_id, label, position_x, position_y, size_width, size_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, building.ID, scene.ID, building.TemplateID, building.Label, building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]); err != nil {
+			return err
+		}
+	}
+
+	for _, agent := range scene.Agents {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO system_scene_agents (id, scene_id, template_id, label, position_x, position_y)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, agent.ID, scene.ID, agent.TemplateID, agent.Label, int(agent.Position[0]), int(agent.Position[1])); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
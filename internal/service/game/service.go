@@ -8,13 +8,31 @@ import (
     "log"
     "math"
     "strings"
+    "sync"
+    "sync/atomic"
+
+    "eeo/backend/internal/pathfinding"
+    "github.com/lib/pq"
 )
 
 // Service 负责提供游戏场景配置等业务能力。
+// HTTP 请求、场景流命令与模拟 tick 并发调用服务，导出方法持有 mu 访问场景与缓存，
+// 未导出方法假定调用方已持有 mu，导出方法之间的相互调用经由对应的未导出方法。
 type Service struct {
+	mu         sync.RWMutex
 	db         *sql.DB
 	scene      Scene
 	maintainer *EnergyMaintainer
+	behaviors  *BehaviorRegistry
+	paths      *pathfinding.Cache
+	index      *SpatialIndex
+
+	// layoutVersion 在尺寸、地形或建筑占地变化时递增，用于判断寻路缓存是否失效。
+	layoutVersion uint64
+
+	// changes 广播场景变化；simulating 为 true 时 tick 中间的重新加载不单独发布。
+	changes    SceneBus
+	simulating atomic.Bool
 }
 
 const (
@@ -33,11 +51,19 @@ func New(db *sql.DB, sceneID string) (*Service, error) {
 
 // Scene 返回静态场景配置。
 func (s *Service) Scene() Scene {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.scene
 }
 
 // Snapshot 返回整合后的系统场景原始数据，供管理端查看。
 func (s *Service) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot()
+}
+
+func (s *Service) snapshot() Snapshot {
 	return Snapshot{
 		Scene:             SceneMeta{ID: s.scene.ID, Name: s.scene.Name},
 		Grid:              s.scene.Grid,
@@ -46,19 +72,38 @@ func (s *Service) Snapshot() Snapshot {
 		Agents:            s.scene.Agents,
 		BuildingTemplates: s.scene.BuildingTemplates,
 		AgentTemplates:    s.scene.AgentTemplates,
+		Terrain:           s.scene.Terrain,
 	}
 }
 
 var (
-	ErrInvalidSceneConfig   = errors.New("invalid scene config")
-	ErrInvalidTemplate      = errors.New("invalid template")
-	ErrInvalidSceneEntity   = errors.New("invalid scene entity")
-	ErrSolarTemplateMissing = errors.New("solar tower template unavailable")
-	ErrNoAvailablePlacement = errors.New("no available placement for solar tower")
+	ErrInvalidSceneConfig    = errors.New("invalid scene config")
+	ErrInvalidTemplate       = errors.New("invalid template")
+	ErrInvalidSceneEntity    = errors.New("invalid scene entity")
+	ErrSolarTemplateMissing  = errors.New("solar tower template unavailable")
+	ErrNoAvailablePlacement  = errors.New("no available placement for solar tower")
+	ErrUpgradeUnavailable    = errors.New("building upgrade unavailable")
+	ErrInsufficientEnergy    = errors.New("insufficient stored energy")
+	ErrTemplateInUse         = errors.New("template in use")
+	ErrTemplateDeprecated    = errors.New("template deprecated")
+	ErrNoFreeTile            = errors.New("no free tile for agent")
+	ErrUnknownBehavior       = errors.New("unknown behavior")
+	ErrInvalidBehaviorParams = errors.New("invalid behavior params")
+	ErrInvalidGoal           = errors.New("invalid goal")
+	ErrInvalidJob            = errors.New("invalid job")
+	ErrJobNotFound           = errors.New("job not found")
+	ErrJobFinished           = errors.New("job already finished")
+	ErrInvalidPath           = errors.New("invalid agent path")
+	ErrNoPath                = errors.New("no path to target")
+	ErrInvalidTerrain        = errors.New("invalid terrain")
+	ErrTerrainUnsuitable     = errors.New("terrain unsuitable for placement")
+	ErrSceneExists           = errors.New("scene already exists")
 )
 
 // UpdateSceneConfig 更新 system_* 表中的场景基础配置，并返回最新快照。
 func (s *Service) UpdateSceneConfig(ctx context.Context, in UpdateSceneConfigInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if err := validateSceneConfig(in); err != nil {
 		return Snapshot{}, err
 	}
@@ -108,8 +153,8 @@ func (s *Service) UpdateSceneConfig(ctx context.Context, in UpdateSceneConfigInp
 	if err != nil {
 		return Snapshot{}, err
 	}
-	s.scene = updated
-	return s.Snapshot(), nil
+	s.setScene(updated)
+	return s.snapshot(), nil
 }
 
 func validateSceneConfig(in UpdateSceneConfigInput) error {
@@ -139,6 +184,8 @@ func validateSceneConfig(in UpdateSceneConfigInput) error {
 
 // UpdateBuildingTemplate 更新或创建系统建筑模板。
 func (s *Service) UpdateBuildingTemplate(ctx context.Context, in UpdateBuildingTemplateInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if strings.TrimSpace(in.ID) == "" {
 		return Snapshot{}, fmt.Errorf("%w: id required", ErrInvalidTemplate)
 	}
@@ -146,6 +193,26 @@ func (s *Service) UpdateBuildingTemplate(ctx context.Context, in UpdateBuildingT
 		return Snapshot{}, fmt.Errorf("%w: label required", ErrInvalidTemplate)
 	}
 
+	// Tier 与 Size 为 nil 时保留原值，新建模板的等级缺省为 1、无占地尺寸。
+	tier := sql.NullInt64{}
+	if in.Tier != nil {
+		if *in.Tier <= 0 {
+			return Snapshot{}, fmt.Errorf("%w: tier must be positive", ErrInvalidTemplate)
+		}
+		tier = sql.NullInt64{Int64: int64(*in.Tier), Valid: true}
+	}
+
+	sizeW := sql.NullInt64{}
+	sizeH := sql.NullInt64{}
+	if in.Size != nil {
+		size := *in.Size
+		if size[0] <= 0 || size[1] <= 0 {
+			return Snapshot{}, fmt.Errorf("%w: size must be positive", ErrInvalidTemplate)
+		}
+		sizeW = sql.NullInt64{Int64: int64(size[0]), Valid: true}
+		sizeH = sql.NullInt64{Int64: int64(size[1]), Valid: true}
+	}
+
 	energyType, capacity, current, output, rate := extractTemplateEnergy(in.Energy)
 	if energyType.Valid {
 		normalized := strings.ToLower(energyType.String)
@@ -155,17 +222,61 @@ func (s *Service) UpdateBuildingTemplate(ctx context.Context, in UpdateBuildingT
 		energyType.String = normalized
 	}
 
-	if _, err := s.db.ExecContext(ctx, `
-		INSERT INTO system_template_buildings (id, label, energy_type, energy_capacity, energy_current, energy_output, energy_rate)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	// RequiredTerrain 为 nil 时保留原值，空字符串表示清除地形要求。
+	requiredTerrain := sql.NullString{}
+	if in.RequiredTerrain != nil {
+		if name := strings.ToLower(strings.TrimSpace(*in.RequiredTerrain)); name != "" {
+			if typ, ok := ParseTerrainType(name); !ok || typ == TerrainCrater {
+				return Snapshot{}, fmt.Errorf("%w: requiredTerrain must be regolith, rock, ice or slope", ErrInvalidTemplate)
+			}
+			requiredTerrain = sql.NullString{String: name, Valid: true}
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO system_template_buildings (id, label, tier, size_width, size_height, energy_type, energy_capacity, energy_current, energy_output, energy_rate, deprecated, required_terrain)
+		VALUES ($1, $2, COALESCE($3, 1), $4, $5, $6, $7, $8, $9, $10, COALESCE($11, FALSE), $12)
 		ON CONFLICT (id)
 		DO UPDATE SET label = EXCLUDED.label,
+		              tier = COALESCE($3, system_template_buildings.tier),
+		              size_width = COALESCE($4, system_template_buildings.size_width),
+		              size_height = COALESCE($5, system_template_buildings.size_height),
 		              energy_type = EXCLUDED.energy_type,
 		              energy_capacity = EXCLUDED.energy_capacity,
 		              energy_current = EXCLUDED.energy_current,
 		              energy_output = EXCLUDED.energy_output,
-		              energy_rate = EXCLUDED.energy_rate
-	`, strings.TrimSpace(in.ID), strings.TrimSpace(in.Label), energyType, capacity, current, output, rate); err != nil {
+		              energy_rate = EXCLUDED.energy_rate,
+		              deprecated = COALESCE($11, system_template_buildings.deprecated),
+		              required_terrain = CASE WHEN $13 THEN EXCLUDED.required_terrain ELSE system_template_buildings.required_terrain END
+	`, strings.TrimSpace(in.ID), strings.TrimSpace(in.Label), tier, sizeW, sizeH, energyType, capacity, current, output, rate, nullBool(in.Deprecated), requiredTerrain, in.RequiredTerrain != nil); err != nil {
+		return Snapshot{}, err
+	}
+
+	// 应用到全部实例时清除实例上的能量覆盖列，使其重新继承模板；energy_current 属于运行时状态，保持不变。
+	if in.ApplyToInstances {
+		if _, err = tx.ExecContext(ctx, `
+			UPDATE system_scene_buildings
+			   SET energy_type = NULL,
+			       energy_capacity = NULL,
+			       energy_output = NULL,
+			       energy_rate = NULL
+			 WHERE template_id = $1
+		`, strings.TrimSpace(in.ID)); err != nil {
+			return Snapshot{}, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
 		return Snapshot{}, err
 	}
 
@@ -173,11 +284,13 @@ func (s *Service) UpdateBuildingTemplate(ctx context.Context, in UpdateBuildingT
 		return Snapshot{}, err
 	}
 
-	return s.Snapshot(), nil
+	return s.snapshot(), nil
 }
 
 // UpdateAgentTemplate 更新或创建系统 Agent 模板。
 func (s *Service) UpdateAgentTemplate(ctx context.Context, in UpdateAgentTemplateInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if strings.TrimSpace(in.ID) == "" {
 		return Snapshot{}, fmt.Errorf("%w: id required", ErrInvalidTemplate)
 	}
@@ -195,15 +308,36 @@ func (s *Service) UpdateAgentTemplate(ctx context.Context, in UpdateAgentTemplat
 
 	color := nullInt64(in.Color)
 
+	var skills []string
+	if in.Skills != nil {
+		skills = []string{}
+		for _, skill := range *in.Skills {
+			if trimmed := strings.ToLower(strings.TrimSpace(skill)); trimmed != "" {
+				skills = append(skills, trimmed)
+			}
+		}
+	}
+
+	speed := sql.NullFloat64{}
+	if in.Speed != nil {
+		if *in.Speed <= 0 {
+			return Snapshot{}, fmt.Errorf("%w: speed must be positive", ErrInvalidTemplate)
+		}
+		speed = sql.NullFloat64{Float64: *in.Speed, Valid: true}
+	}
+
 	if _, err := s.db.ExecContext(ctx, `
-		INSERT INTO system_template_agents (id, label, color, default_position_x, default_position_y)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO system_template_agents (id, label, color, default_position_x, default_position_y, skills, speed, deprecated)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, ARRAY[]::TEXT[]), COALESCE($8, 1), COALESCE($7, FALSE))
 		ON CONFLICT (id)
 		DO UPDATE SET label = EXCLUDED.label,
 		              color = EXCLUDED.color,
 		              default_position_x = EXCLUDED.default_position_x,
-		              default_position_y = EXCLUDED.default_position_y
-	`, strings.TrimSpace(in.ID), strings.TrimSpace(in.Label), color, posX, posY); err != nil {
+		              default_position_y = EXCLUDED.default_position_y,
+		              skills = COALESCE($6, system_template_agents.skills),
+		              speed = COALESCE($8, system_template_agents.speed),
+		              deprecated = COALESCE($7, system_template_agents.deprecated)
+	`, strings.TrimSpace(in.ID), strings.TrimSpace(in.Label), color, posX, posY, pq.Array(skills), nullBool(in.Deprecated), speed); err != nil {
 		return Snapshot{}, err
 	}
 
@@ -211,11 +345,17 @@ func (s *Service) UpdateAgentTemplate(ctx context.Context, in UpdateAgentTemplat
 		return Snapshot{}, err
 	}
 
-	return s.Snapshot(), nil
+	return s.snapshot(), nil
 }
 
 // UpdateSceneBuilding 更新或创建场景中的建筑实例。
 func (s *Service) UpdateSceneBuilding(ctx context.Context, in UpdateSceneBuildingInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateSceneBuilding(ctx, in)
+}
+
+func (s *Service) updateSceneBuilding(ctx context.Context, in UpdateSceneBuildingInput) (Snapshot, error) {
 	if strings.TrimSpace(in.ID) == "" {
 		return Snapshot{}, fmt.Errorf("%w: id required", ErrInvalidSceneEntity)
 	}
@@ -232,6 +372,13 @@ func (s *Service) UpdateSceneBuilding(ctx context.Context, in UpdateSceneBuildin
 	}
 
 	templateID := nullTrimmedString(in.TemplateID)
+	if err := s.ensureBuildingTemplatePlaceable(id, templateID); err != nil {
+		return Snapshot{}, err
+	}
+	if err := s.ensureTerrainPlacement(in.Rect, templateID.String); err != nil {
+		return Snapshot{}, err
+	}
+
 	energyType, capacity, current, output, rate := extractTemplateEnergy(in.Energy)
 	if energyType.Valid {
 		normalized := strings.ToLower(energyType.String)
@@ -242,8 +389,8 @@ func (s *Service) UpdateSceneBuilding(ctx context.Context, in UpdateSceneBuildin
 	}
 
 	if _, err := s.db.ExecContext(ctx, `
-		INSERT INTO system_scene_buildings (id, scene_id, template_id, label, position_x, position_y, size_width, size_height, energy_type, energy_capacity, energy_current, energy_output, energy_rate)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		INSERT INTO system_scene_buildings (id, scene_id, template_id, label, position_x, position_y, size_width, size_height, energy_type, energy_capacity, energy_current, energy_output, energy_rate, powered)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, COALESCE($14, TRUE))
 		ON CONFLICT (id)
 		DO UPDATE SET template_id = EXCLUDED.template_id,
 		              label = EXCLUDED.label,
@@ -255,8 +402,9 @@ func (s *Service) UpdateSceneBuilding(ctx context.Context, in UpdateSceneBuildin
 		              energy_capacity = EXCLUDED.energy_capacity,
 		              energy_current = EXCLUDED.energy_current,
 		              energy_output = EXCLUDED.energy_output,
-		              energy_rate = EXCLUDED.energy_rate
-	`, strings.TrimSpace(in.ID), s.scene.ID, templateID, strings.TrimSpace(in.Label), in.Rect[0], in.Rect[1], in.Rect[2], in.Rect[3], energyType, capacity, current, output, rate); err != nil {
+		              energy_rate = EXCLUDED.energy_rate,
+		              powered = COALESCE($14, system_scene_buildings.powered)
+	`, strings.TrimSpace(in.ID), s.scene.ID, templateID, strings.TrimSpace(in.Label), in.Rect[0], in.Rect[1], in.Rect[2], in.Rect[3], energyType, capacity, current, output, rate, nullBool(in.Powered)); err != nil {
 		return Snapshot{}, err
 	}
 
@@ -264,28 +412,28 @@ func (s *Service) UpdateSceneBuilding(ctx context.Context, in UpdateSceneBuildin
 		return Snapshot{}, err
 	}
 
-	return s.Snapshot(), nil
+	return s.snapshot(), nil
 }
 
 func (s *Service) ensureBuildingPlacement(buildingID string, rect [4]int) error {
-	x, y, w, h := rect[0], rect[1], rect[2], rect[3]
-	for _, existing := range s.scene.Buildings {
+	return checkBuildingPlacement(s.spatialIndex(), buildingID, rect)
+}
+
+// checkBuildingPlacement 校验矩形区域内除 buildingID 自身外没有其他建筑。
+func checkBuildingPlacement(index *SpatialIndex, buildingID string, rect [4]int) error {
+	for _, existing := range index.BuildingsInRect(rect[0], rect[1], rect[2], rect[3]) {
 		if existing.ID == buildingID {
 			continue
 		}
-		if len(existing.Rect) != 4 {
-			continue
-		}
-		eRect := existing.Rect
-		if rectanglesOverlap(x, y, w, h, eRect[0], eRect[1], eRect[2], eRect[3]) {
-			return fmt.Errorf("%w: building %s overlaps target area", ErrInvalidSceneEntity, existing.ID)
-		}
+		return fmt.Errorf("%w: building %s overlaps target area", ErrInvalidSceneEntity, existing.ID)
 	}
 	return nil
 }
 
 // DeleteSceneBuilding 删除场景中的建筑实例。
 func (s *Service) DeleteSceneBuilding(ctx context.Context, buildingID string) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	buildingID = strings.TrimSpace(buildingID)
 	if buildingID == "" {
 		return Snapshot{}, fmt.Errorf("%w: building id required", ErrInvalidSceneEntity)
@@ -303,11 +451,13 @@ func (s *Service) DeleteSceneBuilding(ctx context.Context, buildingID string) (S
 		return Snapshot{}, err
 	}
 
-	return s.Snapshot(), nil
+	return s.snapshot(), nil
 }
 
 // ListSceneBuildings 返回指定场景的建筑列表，支持限制数量。
 func (s *Service) ListSceneBuildings(ctx context.Context, sceneID string, limit int) ([]SceneBuilding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	sceneID = strings.TrimSpace(sceneID)
 	if sceneID == "" {
 		return nil, fmt.Errorf("%w: scene id required", ErrInvalidSceneConfig)
@@ -318,7 +468,7 @@ func (s *Service) ListSceneBuildings(ctx context.Context, sceneID string, limit
 		if err != nil {
 			return nil, err
 		}
-		s.scene = newScene
+		s.setScene(newScene)
 	}
 
 	buildings := s.scene.Buildings
@@ -333,6 +483,12 @@ func (s *Service) ListSceneBuildings(ctx context.Context, sceneID string, limit
 
 // UpdateSceneAgent 更新或创建场景中的 Agent 实例以及动作列表。
 func (s *Service) UpdateSceneAgent(ctx context.Context, in UpdateSceneAgentInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateSceneAgent(ctx, in)
+}
+
+func (s *Service) updateSceneAgent(ctx context.Context, in UpdateSceneAgentInput) (Snapshot, error) {
 	if strings.TrimSpace(in.ID) == "" {
 		return Snapshot{}, fmt.Errorf("%w: id required", ErrInvalidSceneEntity)
 	}
@@ -341,6 +497,9 @@ func (s *Service) UpdateSceneAgent(ctx context.Context, in UpdateSceneAgentInput
 	}
 
 	templateID := nullTrimmedString(in.TemplateID)
+	if err := s.ensureAgentTemplatePlaceable(strings.TrimSpace(in.ID), templateID); err != nil {
+		return Snapshot{}, err
+	}
 	color := nullInt64(in.Color)
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -390,49 +549,62 @@ func (s *Service) UpdateSceneAgent(ctx context.Context, in UpdateSceneAgentInput
 		return Snapshot{}, err
 	}
 
-	return s.Snapshot(), nil
+	return s.snapshot(), nil
 }
 
-// UpdateAgentRuntimePosition 更新运行时 Agent 坐标。
+// UpdateAgentRuntimePosition 更新运行时 Agent 坐标，直接设置坐标会取消进行中的移动路径。
 func (s *Service) UpdateAgentRuntimePosition(ctx context.Context, agentID string, posX, posY float64) (SceneAgent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateAgentRuntimePosition(ctx, agentID, posX, posY)
+}
+
+func (s *Service) updateAgentRuntimePosition(ctx context.Context, agentID string, posX, posY float64) (SceneAgent, error) {
 	agentID = strings.TrimSpace(agentID)
 	if agentID == "" {
 		return SceneAgent{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
 	}
 
-	if _, err := s.db.ExecContext(ctx, `
+	if err := writeAgentPosition(ctx, s.db, agentID, posX, posY); err != nil {
+		return SceneAgent{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return SceneAgent{}, err
+	}
+
+	for _, agent := range s.scene.Agents {
+		if agent.ID == agentID {
+			return agent, nil
+		}
+	}
+
+	return SceneAgent{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+}
+
+// writeAgentPosition 写入运行时坐标并清除移动路径，同时把场景实例坐标更新为所在格子。
+func writeAgentPosition(ctx context.Context, db execer, agentID string, posX, posY float64) error {
+	if _, err := db.ExecContext(ctx, `
         INSERT INTO agent_runtime_state (agent_id, pos_x, pos_y)
         VALUES ($1, $2, $3)
         ON CONFLICT (agent_id)
         DO UPDATE SET pos_x = EXCLUDED.pos_x,
                       pos_y = EXCLUDED.pos_y,
+                      path = NULL,
                       updated_at = NOW()
     `, agentID, posX, posY); err != nil {
-		return SceneAgent{}, err
+		return err
 	}
 
 	roundedX := int(math.Round(posX))
 	roundedY := int(math.Round(posY))
-	if _, err := s.db.ExecContext(ctx, `
+	_, err := db.ExecContext(ctx, `
 		UPDATE system_scene_agents
 		   SET position_x = $1,
 		       position_y = $2
 		 WHERE id = $3
-	`, roundedX, roundedY, agentID); err != nil {
-		return SceneAgent{}, err
-	}
-
-	if err := s.reloadScene(); err != nil {
-		return SceneAgent{}, err
-	}
-
-	for _, agent := range s.scene.Agents {
-		if agent.ID == agentID {
-			return agent, nil
-		}
-	}
-
-	return SceneAgent{}, fmt.Errorf("%w: agent %s not found", ErrInvalidSceneEntity, agentID)
+	`, roundedX, roundedY, agentID)
+	return err
 }
 
 func extractTemplateEnergy(in *UpdateTemplateEnergyInput) (sql.NullString, sql.NullInt64, sql.NullInt64, sql.NullInt64, sql.NullInt64) {
@@ -453,6 +625,13 @@ func nullTrimmedString(s *string) sql.NullString {
 	return sql.NullString{String: trimmed, Valid: true}
 }
 
+func nullBool(b *bool) sql.NullBool {
+	if b == nil {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: *b, Valid: true}
+}
+
 func nullInt64(i *int) sql.NullInt64 {
 	if i == nil {
 		return sql.NullInt64{}
@@ -465,12 +644,14 @@ func (s *Service) reloadScene() error {
 	if err != nil {
 		return err
 	}
-	s.scene = updated
+	s.setScene(updated)
 	return nil
 }
 
 // UpdateBuildingEnergyCurrent 更新指定建筑的当前能量值，并返回更新后的建筑信息。
 func (s *Service) UpdateBuildingEnergyCurrent(ctx context.Context, buildingID string, currentValue float64) (SceneBuilding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	buildingID = strings.TrimSpace(buildingID)
 	if buildingID == "" {
 		return SceneBuilding{}, fmt.Errorf("%w: building id required", ErrInvalidSceneEntity)
@@ -500,7 +681,10 @@ func (s *Service) UpdateBuildingEnergyCurrent(ctx context.Context, buildingID st
 	for _, building := range s.scene.Buildings {
 		if building.ID == buildingID {
 			if building.Energy != nil && building.Energy.Type == "storage" {
-				building.Energy.Current = currentInt
+				// 复制一份再修改，避免改动已通过 Scene() 交给其他调用方的场景。
+				energy := *building.Energy
+				energy.Current = currentInt
+				building.Energy = &energy
 			}
 			return building, nil
 		}
@@ -511,6 +695,12 @@ func (s *Service) UpdateBuildingEnergyCurrent(ctx context.Context, buildingID st
 
 // AdvanceEnergyState 根据耗能计算更新储能节点的剩余能量。
 func (s *Service) AdvanceEnergyState(ctx context.Context, seconds float64, drainFactor float64) (Scene, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.advanceEnergyState(ctx, seconds, drainFactor)
+}
+
+func (s *Service) advanceEnergyState(ctx context.Context, seconds float64, drainFactor float64) (Scene, error) {
 	if seconds <= 0 {
 		seconds = 1
 	}
@@ -589,6 +779,17 @@ func (s *Service) AdvanceEnergyState(ctx context.Context, seconds float64, drain
 
 // MaintainEnergyNonNegative 根据当前能耗情况自动补充太阳能塔直至净变化不再为负。
 func (s *Service) MaintainEnergyNonNegative(ctx context.Context, agentID string) (MaintainEnergyResult, error) {
+	return s.MaintainEnergy(ctx, agentID, MaintainEnergyOptions{})
+}
+
+// MaintainEnergy 按策略参数补足能量缺口，DryRun 或 plan 模式下只返回方案。
+func (s *Service) MaintainEnergy(ctx context.Context, agentID string, opts MaintainEnergyOptions) (MaintainEnergyResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintainEnergy(ctx, agentID, opts)
+}
+
+func (s *Service) maintainEnergy(ctx context.Context, agentID string, opts MaintainEnergyOptions) (MaintainEnergyResult, error) {
     agentID = strings.TrimSpace(agentID)
     if agentID == "" {
         return MaintainEnergyResult{}, fmt.Errorf("%w: agent id required", ErrInvalidSceneEntity)
@@ -613,20 +814,41 @@ func (s *Service) MaintainEnergyNonNegative(ctx context.Context, agentID string)
 
     log.Printf("MaintainEnergyNonNegative: start agent=%s", agentID)
 
-    result, updatedScene, relocation, err := s.maintainer.Maintain(ctx, s.scene, targetAgent)
+    result, updatedScene, relocation, err := s.maintainer.Maintain(ctx, s.scene, targetAgent, opts)
     if err != nil {
         log.Printf("MaintainEnergyNonNegative: error agent=%s err=%v", agentID, err)
         return MaintainEnergyResult{}, err
     }
 
     if relocation != nil {
-        if _, err := s.UpdateAgentRuntimePosition(ctx, relocation.ID, relocation.Position[0], relocation.Position[1]); err != nil {
+		// Agent 沿搬迁路径移动，由后续 tick 推进，而非直接瞬移到目标格。
+		// 路径按建成后的场景校验，途经之后才规划的建筑时重新寻路。
+		if err := s.reloadScene(); err != nil {
 			return MaintainEnergyResult{}, err
 		}
+		from := agentTile(targetAgent.Position)
+		path := tilesToPath(relocation.Path)
+		if len(path) == 0 || s.validateAgentPath(from, path) != nil {
+			path, _ = s.routeToward(from, [2]int{int(relocation.Position[0]), int(relocation.Position[1])})
+			relocation.Path = make([][2]float64, len(path))
+			for i, tile := range path {
+				relocation.Path[i] = [2]float64{float64(tile[0]) + 0.5, float64(tile[1]) + 0.5}
+			}
+		}
+		if len(path) > 0 {
+			if err := s.saveAgentPath(ctx, s.db, targetAgent, path); err != nil {
+				return MaintainEnergyResult{}, err
+			}
+			if err := s.reloadScene(); err != nil {
+				return MaintainEnergyResult{}, err
+			}
+		} else {
+			log.Printf("MaintainEnergyNonNegative: relocation unreachable agent=%s", agentID)
+		}
         result.Scene = s.scene
         result.Relocation = relocation
     } else {
-        s.scene = updatedScene
+        s.setScene(updatedScene)
     }
 
     log.Printf("MaintainEnergyNonNegative: success agent=%s towers=%d relocation=%v", agentID, result.TowersBuilt, relocation != nil)
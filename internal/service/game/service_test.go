@@ -1,8 +1,20 @@
 package game
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"eeo/backend/internal/mapgen"
+	"eeo/backend/internal/pathfinding"
+	actionservice "eeo/backend/internal/service/action"
 )
 
 func TestNewUsesSceneLoader(t *testing.T) {
@@ -45,3 +57,1485 @@ func TestEnsureBuildingPlacementDetectsOverlap(t *testing.T) {
 		t.Fatalf("expected non-overlapping placement to pass, got %v", err)
 	}
 }
+
+func TestResolveUpgradeFootprint(t *testing.T) {
+	rect := [4]int{10, 10, 4, 4}
+
+	if got := resolveUpgradeFootprint(rect, []int{6, 6}, footprintRuleAnchor); got != [4]int{10, 10, 6, 6} {
+		t.Fatalf("anchor rule should keep top-left, got %v", got)
+	}
+	if got := resolveUpgradeFootprint(rect, []int{6, 6}, footprintRuleCenter); got != [4]int{9, 9, 6, 6} {
+		t.Fatalf("center rule should keep center, got %v", got)
+	}
+	if got := resolveUpgradeFootprint(rect, nil, footprintRuleAnchor); got != rect {
+		t.Fatalf("missing template size should keep footprint, got %v", got)
+	}
+}
+
+func TestPlanEnergyDeduction(t *testing.T) {
+	storage := []SceneBuilding{
+		{ID: "s1", Energy: &SceneEnergy{Type: "storage", Current: 50}},
+		{ID: "s2", Energy: &SceneEnergy{Type: "storage", Current: 100}},
+	}
+
+	updates, err := planEnergyDeduction(storage, 120)
+	if err != nil {
+		t.Fatalf("expected deduction to succeed, got %v", err)
+	}
+	if updates["s1"] != 0 || updates["s2"] != 30 {
+		t.Fatalf("unexpected deduction plan: %v", updates)
+	}
+
+	if _, err := planEnergyDeduction(storage, 200); !errors.Is(err, ErrInsufficientEnergy) {
+		t.Fatalf("expected ErrInsufficientEnergy, got %v", err)
+	}
+}
+
+func TestResolveInheritedFields(t *testing.T) {
+	raw := buildingOverrides{
+		output:  sql.NullInt64{Int64: 300, Valid: true},
+		current: sql.NullInt64{Int64: 40, Valid: true},
+	}
+	tpl := &SceneEnergy{Type: "storage", Output: 220}
+
+	fields := resolveInheritedFields(raw, tpl)
+	byName := make(map[string]InheritedField, len(fields))
+	for _, f := range fields {
+		byName[f.Field] = f
+	}
+
+	if f := byName["energy.output"]; f.Source != fieldSourceInstance || f.Effective != 300 || f.Template != 220 {
+		t.Fatalf("expected instance override for output, got %+v", f)
+	}
+	if f := byName["energy.type"]; f.Source != fieldSourceTemplate || f.Effective != "storage" {
+		t.Fatalf("expected type inherited from template, got %+v", f)
+	}
+	if f := byName["energy.rate"]; f.Source != fieldSourceUnset || f.Effective != nil {
+		t.Fatalf("expected rate to be unset, got %+v", f)
+	}
+}
+
+func TestDeprecatedTemplateBlocksNewPlacement(t *testing.T) {
+	svc := &Service{
+		scene: Scene{
+			Buildings: []SceneBuilding{
+				{ID: "old_tower", TemplateID: "solar_tower", Rect: []int{0, 0, 4, 4}},
+			},
+			BuildingTemplates: []BuildingTemplate{
+				{ID: "solar_tower", Deprecated: true},
+			},
+		},
+	}
+	tpl := sql.NullString{String: "solar_tower", Valid: true}
+
+	if err := svc.ensureBuildingTemplatePlaceable("old_tower", tpl); err != nil {
+		t.Fatalf("existing instance should keep deprecated template, got %v", err)
+	}
+	if err := svc.ensureBuildingTemplatePlaceable("new_tower", tpl); !errors.Is(err, ErrTemplateDeprecated) {
+		t.Fatalf("expected ErrTemplateDeprecated for new instance, got %v", err)
+	}
+}
+
+func TestNormalizeTemplateDeleteMode(t *testing.T) {
+	if mode, err := normalizeTemplateDeleteMode("", ""); err != nil || mode != TemplateDeleteBlock {
+		t.Fatalf("expected default block mode, got %q %v", mode, err)
+	}
+	if _, err := normalizeTemplateDeleteMode("reassign", ""); !errors.Is(err, ErrInvalidTemplate) {
+		t.Fatalf("expected reassign without target to fail, got %v", err)
+	}
+	if _, err := normalizeTemplateDeleteMode("purge", ""); !errors.Is(err, ErrInvalidTemplate) {
+		t.Fatalf("expected unknown mode to fail, got %v", err)
+	}
+}
+
+func TestFindFreeAgentTile(t *testing.T) {
+	scene := Scene{
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Buildings: []SceneBuilding{
+			{ID: "hub", Rect: []int{2, 2, 2, 2}},
+		},
+		Agents: []SceneAgent{
+			{ID: "ares-01", Position: []float64{4, 2}},
+		},
+	}
+
+	if tile, ok := findFreeAgentTile(scene, [2]int{5, 5}); !ok || tile != [2]int{5, 5} {
+		t.Fatalf("expected free origin to be used, got %v %v", tile, ok)
+	}
+	tile, ok := findFreeAgentTile(scene, [2]int{2, 2})
+	if !ok {
+		t.Fatalf("expected a free tile near the building")
+	}
+	if tileIsBlocked(tile[0], tile[1], scene.Buildings) || tile == [2]int{4, 2} {
+		t.Fatalf("tile %v is occupied", tile)
+	}
+}
+
+func TestNextAgentIndex(t *testing.T) {
+	agents := []SceneAgent{{ID: "ares-01"}, {ID: "ares-07"}, {ID: "support-02"}, {ID: "ares-x"}}
+	if got := nextAgentIndex(agents, "ares"); got != 8 {
+		t.Fatalf("expected next ares index 8, got %d", got)
+	}
+	if got := nextAgentIndex(agents, "scout"); got != 1 {
+		t.Fatalf("expected first scout index 1, got %d", got)
+	}
+}
+
+func TestSpawnSceneAgentChecksIDsAcrossScenes(t *testing.T) {
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	scene := Scene{
+		ID:             "s",
+		Dimensions:     SceneDims{Width: 10, Height: 10},
+		AgentTemplates: []AgentTemplate{{ID: "ares", Label: "Ares"}},
+	}
+	// ares-01 属于另一个场景。
+	db := &fakeDB{rows: func(query string, args []driver.Value) [][]driver.Value {
+		if strings.Contains(query, "SELECT EXISTS") {
+			return [][]driver.Value{{args[0] == "ares-01"}}
+		}
+		return nil
+	}}
+	sceneLoader = func(*sql.DB, string) (Scene, error) {
+		loaded := scene
+		if args, ok := db.argsOf("INSERT INTO system_scene_agents"); ok {
+			loaded.Agents = []SceneAgent{{ID: args[0].(string), Label: args[3].(string), Position: []float64{0, 0}}}
+		}
+		return loaded, nil
+	}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := svc.SpawnSceneAgent(ctx, SpawnSceneAgentInput{TemplateID: "ares", ID: "ares-01"}); !errors.Is(err, ErrInvalidSceneEntity) {
+		t.Fatalf("expected ErrInvalidSceneEntity for an id used by another scene, got %v", err)
+	}
+	if _, ok := db.argsOf("INSERT INTO system_scene_agents"); ok {
+		t.Fatalf("expected no agent write after the id conflict")
+	}
+
+	// 生成的 ID 同样跳过其他场景已占用的序号。
+	result, err := svc.SpawnSceneAgent(ctx, SpawnSceneAgentInput{TemplateID: "ares"})
+	if err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	if result.Agent.ID != "ares-02" || result.Agent.Label != "Ares-02" {
+		t.Fatalf("expected generated agent ares-02, got %+v", result.Agent)
+	}
+}
+
+func TestDeleteSceneAgentPurgesHistoryInOneTransaction(t *testing.T) {
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) {
+		return Scene{ID: "s", Agents: []SceneAgent{{ID: "ares-01", Position: []float64{0, 0}}}}, nil
+	}
+
+	db := &fakeDB{failOn: "agent_action_events"}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if _, err := svc.DeleteSceneAgent(context.Background(), DeleteSceneAgentInput{ID: "ares-01", PurgeHistory: true}); err == nil {
+		t.Fatalf("expected history purge failure to be returned")
+	}
+	statements := db.statements()
+	if last := statements[len(statements)-1]; last != "ROLLBACK" {
+		t.Fatalf("expected the agent delete to be rolled back with the purge, got %v", statements)
+	}
+	if _, ok := findAgent(svc.Scene(), "ares-01"); !ok {
+		t.Fatalf("expected agent to remain in the scene")
+	}
+
+	db.failOn = ""
+	if _, err := svc.DeleteSceneAgent(context.Background(), DeleteSceneAgentInput{ID: "ares-01", PurgeHistory: true}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := db.argsOf("DELETE FROM agents"); !ok {
+		t.Fatalf("expected history to be purged, got %v", db.statements())
+	}
+}
+
+func TestDeleteTemplateChecksReassignAndPurgesCascadedHistory(t *testing.T) {
+	terrain := NewTerrainLayer(10, 10)
+	terrain.Paint([4]int{0, 8, 1, 1}, TerrainIce)
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) {
+		return Scene{
+			ID:         "s",
+			Dimensions: SceneDims{Width: 10, Height: 10},
+			Terrain:    terrain,
+			BuildingTemplates: []BuildingTemplate{
+				{ID: "hut"},
+				{ID: "drill", RequiredTerrain: "ice"},
+				{ID: "shed"},
+			},
+			Buildings: []SceneBuilding{{ID: "hut-1", TemplateID: "hut", Rect: []int{0, 0, 2, 2}}},
+			Agents:    []SceneAgent{{ID: "ares-01", TemplateID: "ares", Position: []float64{5, 5}}},
+		}, nil
+	}
+
+	db := &fakeDB{rows: func(query string, args []driver.Value) [][]driver.Value {
+		switch {
+		case strings.Contains(query, "SELECT EXISTS"):
+			return [][]driver.Value{{true}}
+		case strings.Contains(query, "FROM system_scene_buildings"):
+			return [][]driver.Value{{"hut-1", "s", "Hut"}}
+		case strings.Contains(query, "FROM system_scene_agents"):
+			return [][]driver.Value{{"ares-01", "s", "Ares"}}
+		}
+		return nil
+	}}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	ctx := context.Background()
+
+	// hut-1 不在冰面上，改用需要冰面的模板会被拒绝，演练同样报告。
+	for _, dryRun := range []bool{true, false} {
+		_, err := svc.DeleteBuildingTemplate(ctx, DeleteTemplateInput{ID: "hut", Mode: TemplateDeleteReassign, ReassignTo: "drill", DryRun: dryRun})
+		if !errors.Is(err, ErrTemplateInUse) || !errors.Is(err, ErrTerrainUnsuitable) {
+			t.Fatalf("expected reassignment onto unsuitable terrain to be rejected, got %v", err)
+		}
+	}
+	if len(db.statements()) != 0 {
+		t.Fatalf("expected no writes for a rejected reassignment, got %v", db.statements())
+	}
+	if _, err := svc.DeleteBuildingTemplate(ctx, DeleteTemplateInput{ID: "hut", Mode: TemplateDeleteReassign, ReassignTo: "shed"}); err != nil {
+		t.Fatalf("expected reassignment to shed to pass, got %v", err)
+	}
+
+	db.execs = nil
+	if _, err := svc.DeleteAgentTemplate(ctx, DeleteTemplateInput{ID: "ares", Mode: TemplateDeleteCascade}); err != nil {
+		t.Fatalf("cascade: %v", err)
+	}
+	args, ok := db.argsOf("DELETE FROM agents")
+	statements := db.statements()
+	if !ok || args[0] != "ares-01" || statements[len(statements)-1] != "COMMIT" {
+		t.Fatalf("expected cascaded agent history to be purged in the delete transaction, got %v", statements)
+	}
+}
+
+func TestPlanMovementStopsAtBuildingsAndBounds(t *testing.T) {
+	dims := SceneDims{Width: 10, Height: 10}
+	index := NewSpatialIndex(dims, []SceneBuilding{{ID: "hub", Rect: []int{5, 0, 2, 2}}})
+	terrain := NewTerrainLayer(10, 10)
+	terrain.Paint([4]int{2, 5, 1, 1}, TerrainCrater)
+
+	to, moved, blockedBy := planMovement([2]int{2, 1}, movementDeltas["move_right"], 5, dims, index, terrain)
+	if to != [2]int{4, 1} || moved != 2 || blockedBy != "building hub" {
+		t.Fatalf("expected to stop before hub, got %v %d %q", to, moved, blockedBy)
+	}
+
+	to, moved, blockedBy = planMovement([2]int{0, 3}, movementDeltas["move_left"], 1, dims, index, terrain)
+	if to != [2]int{0, 3} || moved != 0 || blockedBy != "scene boundary" {
+		t.Fatalf("expected boundary block, got %v %d %q", to, moved, blockedBy)
+	}
+
+	to, moved, blockedBy = planMovement([2]int{2, 2}, movementDeltas["move_down"], 5, dims, index, terrain)
+	if to != [2]int{2, 4} || moved != 2 || blockedBy != "crater at (2, 5)" {
+		t.Fatalf("expected to stop before the crater, got %v %d %q", to, moved, blockedBy)
+	}
+}
+
+func TestExecuteAgentActionRejectsWithoutMoving(t *testing.T) {
+	svc := &Service{
+		scene: Scene{
+			Dimensions: SceneDims{Width: 10, Height: 10},
+			Buildings:  []SceneBuilding{{ID: "hub", Rect: []int{3, 2, 2, 2}}},
+			Agents: []SceneAgent{
+				{ID: "ares-01", Position: []float64{2, 2}, Actions: []string{"move_right", "move_up"}},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	result, err := svc.ExecuteAgentAction(ctx, ExecuteAgentActionInput{AgentID: "ares-01", ActionType: "move_left"})
+	if err != nil || result.Status != ActionStatusRejected || result.Executed {
+		t.Fatalf("expected disallowed action to be rejected, got %+v %v", result, err)
+	}
+
+	result, err = svc.ExecuteAgentAction(ctx, ExecuteAgentActionInput{AgentID: "ares-01", ActionType: "move_right"})
+	if err != nil || result.Status != ActionStatusBlocked || result.Message != "blocked by building hub" {
+		t.Fatalf("expected move into building to be blocked, got %+v %v", result, err)
+	}
+
+	if _, err := svc.ExecuteAgentAction(ctx, ExecuteAgentActionInput{AgentID: "ghost", ActionType: "move_up"}); !errors.Is(err, ErrInvalidSceneEntity) {
+		t.Fatalf("expected unknown agent to fail, got %v", err)
+	}
+}
+
+func TestExecuteAgentActionLogsWithMoveInOneTransaction(t *testing.T) {
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) {
+		return Scene{
+			ID:         "s",
+			Dimensions: SceneDims{Width: 10, Height: 10},
+			Agents:     []SceneAgent{{ID: "ares-01", Position: []float64{2, 2}, Actions: []string{"move_right"}}},
+		}, nil
+	}
+
+	db := &fakeDB{failOn: "agent_action_events"}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	in := ExecuteAgentActionInput{
+		AgentID:    "ares-01",
+		ActionType: "move_right",
+		Log:        &actionservice.LogActionInput{AgentID: "ares-01", ActionType: "move_right"},
+	}
+	if _, err := svc.ExecuteAgentAction(context.Background(), in); err == nil {
+		t.Fatalf("expected action log failure to be returned")
+	}
+	statements := db.statements()
+	if last := statements[len(statements)-1]; last != "ROLLBACK" {
+		t.Fatalf("expected the move to be rolled back with the log, got %v", statements)
+	}
+
+	db.failOn = ""
+	result, err := svc.ExecuteAgentAction(context.Background(), in)
+	if err != nil || result.Status != ActionStatusSucceeded {
+		t.Fatalf("expected move to succeed, got %+v %v", result, err)
+	}
+	args, ok := db.argsOf("agent_action_events")
+	if !ok || args[6] != ActionStatusSucceeded {
+		t.Fatalf("expected the executor result to be logged, got %v", args)
+	}
+	if last := db.statements()[len(db.statements())-1]; last != "COMMIT" {
+		t.Fatalf("expected move and log to commit together, got %v", db.statements())
+	}
+}
+
+func TestValidateBehaviorParams(t *testing.T) {
+	spec := BehaviorSpec{
+		ID: "patrol",
+		Params: []BehaviorParam{
+			{Name: "laps", Type: BehaviorParamInteger, Default: 1},
+			{Name: "mode", Type: BehaviorParamString, Required: true, Enum: []string{"loop", "once"}},
+		},
+	}
+
+	params, err := validateBehaviorParams(spec, []byte(`{"mode":"loop"}`))
+	if err != nil || params["laps"] != 1 || params["mode"] != "loop" {
+		t.Fatalf("expected defaults to be filled, got %v %v", params, err)
+	}
+
+	for _, raw := range []string{`{}`, `{"mode":"spin"}`, `{"mode":"loop","laps":1.5}`, `{"mode":"loop","speed":2}`, `[1]`} {
+		if _, err := validateBehaviorParams(spec, []byte(raw)); !errors.Is(err, ErrInvalidBehaviorParams) {
+			t.Fatalf("expected %s to be rejected, got %v", raw, err)
+		}
+	}
+}
+
+func TestRunBehaviorUnknown(t *testing.T) {
+	svc := &Service{behaviors: NewBehaviorRegistry(maintainEnergyBehavior{})}
+	if _, err := svc.RunBehavior(context.Background(), RunBehaviorInput{AgentID: "ares-01", BehaviorID: "dance"}); !errors.Is(err, ErrUnknownBehavior) {
+		t.Fatalf("expected ErrUnknownBehavior, got %v", err)
+	}
+	if specs := svc.Behaviors(); len(specs) != 1 || specs[0].ID != "maintain-energy" {
+		t.Fatalf("unexpected behavior specs: %+v", specs)
+	}
+}
+
+func TestNextPatrolRoute(t *testing.T) {
+	scene := Scene{
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Buildings:  []SceneBuilding{{ID: "hub", Rect: []int{3, 0, 1, 3}}},
+	}
+	waypoints := [][2]int{{5, 4}, {1, 1}}
+
+	svc := &Service{scene: scene}
+	route, cursor, status, _ := svc.nextPatrolRoute([2]int{1, 1}, waypoints, 0)
+	if status != GoalStatusActive || cursor != 0 || len(route) == 0 || route[len(route)-1] != [2]int{5, 4} {
+		t.Fatalf("expected route to the first waypoint, got %v %d %s", route, cursor, status)
+	}
+	if err := svc.validateAgentPath([2]int{1, 1}, route); err != nil {
+		t.Fatalf("expected route to detour around the hub: %v", err)
+	}
+
+	_, cursor, _, _ = svc.nextPatrolRoute([2]int{5, 4}, waypoints, 0)
+	if cursor != 1 {
+		t.Fatalf("expected cursor to advance after reaching waypoint, got %d", cursor)
+	}
+}
+
+func TestJobsAndPatrolSaveRoutesForMovement(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Agents:     []SceneAgent{{ID: "ares-01", Position: []float64{0, 0}}},
+	}
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return scene, nil }
+
+	db := &fakeDB{}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	ctx := context.Background()
+	job := Job{ID: 1, Kind: JobKindExplore, Status: JobStatusAssigned, Target: []int{6, 0}, AssignedAgentID: "ares-01"}
+	if err := svc.workJob(ctx, job, 1); err != nil {
+		t.Fatalf("work job: %v", err)
+	}
+	args, ok := db.argsOf("INSERT INTO agent_runtime_state")
+	if !ok || args[1] != 0.0 || args[2] != 0.0 || args[3] != "[[1,0],[2,0],[3,0],[4,0],[5,0]]" {
+		t.Fatalf("expected the job route to be saved without moving the agent, got %v", args)
+	}
+
+	db.execs = nil
+	goal := Goal{AgentID: "ares-01", Kind: GoalKindPatrol, Params: GoalParams{Waypoints: [][2]int{{0, 3}}}}
+	if outcome := svc.evaluateGoal(ctx, &goal); outcome.status != GoalStatusActive {
+		t.Fatalf("expected patrol to be active, got %+v", outcome)
+	}
+	args, ok = db.argsOf("INSERT INTO agent_runtime_state")
+	if !ok || args[1] != 0.0 || args[3] != "[[0,1],[0,2],[0,3]]" {
+		t.Fatalf("expected the patrol route to be saved without moving the agent, got %v", args)
+	}
+
+	// 已有路径时交给移动 tick 推进，不重新规划。
+	scene.Agents[0].Path = [][2]int{{0, 1}}
+	if err := svc.reloadScene(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	db.execs = nil
+	if err := svc.workJob(ctx, job, 1); err != nil || len(db.statements()) != 0 {
+		t.Fatalf("expected a moving agent to keep its path, got %v %v", db.statements(), err)
+	}
+}
+
+func TestNormalizeGoalParams(t *testing.T) {
+	scene := Scene{Dimensions: SceneDims{Width: 10, Height: 10}}
+	if params, err := normalizeGoalParams(scene, GoalKindNetFlow, GoalParams{}); err != nil || params.MinNetFlow == nil || *params.MinNetFlow != 0 {
+		t.Fatalf("expected default min net flow, got %+v %v", params, err)
+	}
+	over := 120.0
+	if _, err := normalizeGoalParams(scene, GoalKindStorageLevel, GoalParams{MinStoragePercent: &over}); !errors.Is(err, ErrInvalidGoal) {
+		t.Fatalf("expected percent above 100 to fail, got %v", err)
+	}
+	if _, err := normalizeGoalParams(scene, GoalKindPatrol, GoalParams{Waypoints: [][2]int{{12, 0}}}); !errors.Is(err, ErrInvalidGoal) {
+		t.Fatalf("expected waypoint outside scene to fail, got %v", err)
+	}
+}
+
+func TestNetFlowGoalCorrectsUpToMinNetFlow(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 30, Height: 30},
+		BuildingTemplates: []BuildingTemplate{
+			{ID: solarTowerTemplateID, Label: "太阳能塔", Size: []int{4, 4}, Energy: &SceneEnergy{Type: "storage", Output: 100}},
+		},
+		Buildings: []SceneBuilding{{ID: "lab", Rect: []int{20, 20, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 50}}},
+		Agents:    []SceneAgent{{ID: "ares-01", Position: []float64{5, 5}}},
+	}
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return scene, nil }
+
+	db := &fakeDB{}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	minFlow := 150.0
+	goal := Goal{AgentID: "ares-01", Kind: GoalKindNetFlow, Params: GoalParams{MinNetFlow: &minFlow}}
+	if outcome := svc.evaluateGoal(context.Background(), &goal); outcome.status != GoalStatusCorrecting {
+		t.Fatalf("expected goal to be correcting, got %+v", outcome)
+	}
+	// 净流量 -50 需补足到 150，即两座 100 的塔；不带余量时只会建一座。
+	var towers int
+	for _, statement := range db.statements() {
+		if strings.Contains(statement, "INSERT INTO system_scene_buildings") {
+			towers++
+		}
+	}
+	if towers != 2 {
+		t.Fatalf("expected two towers to reach minNetFlow, got %d: %v", towers, db.statements())
+	}
+}
+
+func TestStorageLevelGoalRechargesWhenNetFlowIsZero(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 30, Height: 30},
+		BuildingTemplates: []BuildingTemplate{
+			{ID: solarTowerTemplateID, Label: "太阳能塔", Size: []int{4, 4}, Energy: &SceneEnergy{Type: "storage", Output: 100}},
+		},
+		Buildings: []SceneBuilding{
+			{ID: "battery", Rect: []int{0, 0, 2, 2}, Energy: &SceneEnergy{Type: "storage", Current: 100, Capacity: 1000, Output: 50}},
+			{ID: "lab", Rect: []int{20, 20, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 50}},
+		},
+		Agents: []SceneAgent{{ID: "ares-01", Position: []float64{10, 10}}},
+	}
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return scene, nil }
+
+	db := &fakeDB{}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	minPercent := 50.0
+	goal := Goal{AgentID: "ares-01", Kind: GoalKindStorageLevel, Params: GoalParams{MinStoragePercent: &minPercent}}
+	if outcome := svc.evaluateGoal(context.Background(), &goal); outcome.status != GoalStatusCorrecting || outcome.failed {
+		t.Fatalf("expected goal to be correcting, got %+v", outcome)
+	}
+	// 净流量为 0，需要正的余量才会建塔让储能回升。
+	var towers int
+	for _, statement := range db.statements() {
+		if strings.Contains(statement, "INSERT INTO system_scene_buildings") {
+			towers++
+		}
+	}
+	if towers == 0 {
+		t.Fatalf("expected towers to be built to recharge storage, got %v", db.statements())
+	}
+}
+
+func TestStorageLevelPercent(t *testing.T) {
+	scene := Scene{Buildings: []SceneBuilding{
+		{ID: "a", Energy: &SceneEnergy{Type: "storage", Current: 30, Capacity: 100}},
+		{ID: "b", Energy: &SceneEnergy{Type: "storage", Current: 50, Capacity: 100}},
+		{ID: "c", Energy: &SceneEnergy{Type: "consumer", Rate: 5}},
+	}}
+	if percent, ok := storageLevelPercent(scene); !ok || percent != 40 {
+		t.Fatalf("expected 40%%, got %v %v", percent, ok)
+	}
+}
+
+func TestUpdateBuildingTemplateKeepsOmittedTierAndSize(t *testing.T) {
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return Scene{ID: "s"}, nil }
+
+	db := &fakeDB{}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if _, err := svc.UpdateBuildingTemplate(context.Background(), UpdateBuildingTemplateInput{ID: "hut", Label: "Hut"}); err != nil {
+		t.Fatalf("update template: %v", err)
+	}
+	args, ok := db.argsOf("INSERT INTO system_template_buildings")
+	if !ok || args[2] != nil || args[3] != nil || args[4] != nil {
+		t.Fatalf("expected omitted tier and size to be passed as NULL, got %v", args)
+	}
+	statements := db.statements()
+	if upsert := strings.Join(statements, "\n"); !strings.Contains(upsert, "tier = COALESCE($3, system_template_buildings.tier)") ||
+		!strings.Contains(upsert, "size_width = COALESCE($4, system_template_buildings.size_width)") {
+		t.Fatalf("expected the upsert to keep existing tier and size, got %v", statements)
+	}
+}
+
+func TestPickAgentForJob(t *testing.T) {
+	scene := Scene{
+		Agents: []SceneAgent{
+			{ID: "ares-01", TemplateID: "ares", Position: []float64{0, 0}},
+			{ID: "ares-02", TemplateID: "ares", Position: []float64{8, 8}},
+			{ID: "support-01", TemplateID: "support", Position: []float64{9, 9}},
+		},
+		AgentTemplates: []AgentTemplate{
+			{ID: "ares", Skills: []string{"build", "explore"}},
+			{ID: "support", Skills: []string{"haul"}},
+		},
+	}
+	job := Job{Kind: JobKindExplore, Target: []int{9, 9}}
+
+	agent, ok := pickAgentForJob(scene, job, map[string]bool{})
+	if !ok || agent.ID != "ares-02" {
+		t.Fatalf("expected nearest skilled agent ares-02, got %v %v", agent.ID, ok)
+	}
+
+	agent, ok = pickAgentForJob(scene, job, map[string]bool{"ares-02": true})
+	if !ok || agent.ID != "ares-01" {
+		t.Fatalf("expected busy agent to be skipped, got %v %v", agent.ID, ok)
+	}
+
+	if _, ok := pickAgentForJob(scene, Job{Kind: JobKindRepair, Target: []int{0, 0}}, map[string]bool{}); ok {
+		t.Fatalf("expected no agent with repair skill")
+	}
+
+	scene.Agents[1].Path = [][2]int{{8, 9}}
+	agent, ok = pickAgentForJob(scene, job, map[string]bool{})
+	if !ok || agent.ID != "ares-01" {
+		t.Fatalf("expected moving agent to be skipped, got %v %v", agent.ID, ok)
+	}
+}
+
+func TestAdvanceJobsSkipsPatrollingAgents(t *testing.T) {
+	scene := Scene{
+		ID:             "s",
+		Dimensions:     SceneDims{Width: 10, Height: 10},
+		Agents:         []SceneAgent{{ID: "ares-01", TemplateID: "ares", Position: []float64{0, 0}}},
+		AgentTemplates: []AgentTemplate{{ID: "ares", Skills: []string{JobKindExplore}}},
+	}
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return scene, nil }
+
+	now := time.Now()
+	db := &fakeDB{rows: func(query string, args []driver.Value) [][]driver.Value {
+		switch {
+		case strings.Contains(query, "FROM agent_goals"):
+			return [][]driver.Value{{"ares-01"}}
+		case strings.Contains(query, "FROM scene_jobs"):
+			return [][]driver.Value{{int64(1), JobKindExplore, JobStatusQueued, 0, 5, 5, nil, nil, nil, 0.0, JobSourceAPI, nil, now, now}}
+		}
+		return nil
+	}}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if err := svc.advanceJobs(context.Background(), 1); err != nil {
+		t.Fatalf("advance jobs: %v", err)
+	}
+	if _, ok := db.argsOf("UPDATE scene_jobs"); ok {
+		t.Fatalf("expected the patrolling agent not to be assigned, got %v", db.statements())
+	}
+}
+
+func TestCancelJobStopsAssignedAgent(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Agents:     []SceneAgent{{ID: "ares-01", Position: []float64{1, 0}, Path: [][2]int{{2, 0}, {3, 0}}}},
+	}
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return scene, nil }
+
+	now := time.Now()
+	db := &fakeDB{rows: func(query string, args []driver.Value) [][]driver.Value {
+		if strings.Contains(query, "FROM scene_jobs") {
+			return [][]driver.Value{{int64(1), JobKindExplore, JobStatusAssigned, 0, 3, 0, nil, nil, "ares-01", 0.0, JobSourceAPI, nil, now, now}}
+		}
+		return nil
+	}}
+	svc, err := New(db.open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	if _, err := svc.CancelJob(context.Background(), 1); err != nil {
+		t.Fatalf("cancel job: %v", err)
+	}
+	args, ok := db.argsOf("INSERT INTO agent_runtime_state")
+	if !ok || args[0] != "ares-01" || args[3] != nil {
+		t.Fatalf("expected the agent's path to be cleared, got %v", db.statements())
+	}
+	if statements := db.statements(); statements[len(statements)-1] != "COMMIT" {
+		t.Fatalf("expected the cancel and path reset to commit together, got %v", statements)
+	}
+}
+
+func TestTileAdjacentToRect(t *testing.T) {
+	rect := [4]int{4, 4, 2, 2}
+	for _, tile := range [][2]int{{3, 3}, {6, 5}, {4, 6}} {
+		if !tileAdjacentToRect(tile, rect) {
+			t.Fatalf("expected %v to be adjacent to %v", tile, rect)
+		}
+	}
+	if tileAdjacentToRect([2]int{7, 4}, rect) {
+		t.Fatalf("expected [7 4] not to be adjacent")
+	}
+}
+
+func TestAdvanceAlongPathInterpolates(t *testing.T) {
+	path := [][2]int{{1, 0}, {2, 0}, {2, 1}}
+
+	pos, remaining := advanceAlongPath([2]float64{0, 0}, path, 1.5)
+	if pos != [2]float64{1.5, 0} || len(remaining) != 2 {
+		t.Fatalf("expected halfway to second tile, got %v remaining=%v", pos, remaining)
+	}
+
+	pos, remaining = advanceAlongPath(pos, remaining, 5)
+	if pos != [2]float64{2, 1} || remaining != nil {
+		t.Fatalf("expected arrival at path end, got %v remaining=%v", pos, remaining)
+	}
+}
+
+func TestValidateAgentPath(t *testing.T) {
+	scene := Scene{
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Buildings:  []SceneBuilding{{ID: "hq", Rect: []int{3, 0, 2, 2}}},
+	}
+	svc := &Service{scene: scene}
+
+	if err := svc.validateAgentPath([2]int{0, 0}, [][2]int{{1, 0}, {2, 0}, {2, 1}}); err != nil {
+		t.Fatalf("expected contiguous path to pass, got %v", err)
+	}
+	if err := svc.validateAgentPath([2]int{0, 0}, [][2]int{{2, 0}}); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected gap to be rejected, got %v", err)
+	}
+	if err := svc.validateAgentPath([2]int{2, 0}, [][2]int{{3, 0}}); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected building tile to be rejected, got %v", err)
+	}
+	if blockedBy, blocked := pathBlockedBy([][2]int{{2, 1}, {3, 1}}, scene.Buildings); !blocked || blockedBy != "hq" {
+		t.Fatalf("expected path to be blocked by hq, got %q %v", blockedBy, blocked)
+	}
+}
+
+func TestPathCacheFollowsLayoutVersion(t *testing.T) {
+	scene := Scene{
+		Dimensions: SceneDims{Width: 10, Height: 10},
+		Buildings:  []SceneBuilding{{ID: "hq", Rect: []int{3, 0, 2, 2}}},
+		Agents:     []SceneAgent{{ID: "ares-01", Position: []float64{0, 0}}},
+	}
+	svc := &Service{}
+	svc.setScene(scene)
+	grid := svc.occupancyGrid()
+
+	// 只有 Agent 移动时沿用同一网格。
+	moved := scene
+	moved.Agents = []SceneAgent{{ID: "ares-01", Position: []float64{1, 0}}}
+	svc.setScene(moved)
+	if svc.occupancyGrid() != grid {
+		t.Fatalf("expected the grid to be reused when only agents move")
+	}
+
+	built := moved
+	built.Buildings = append([]SceneBuilding{{ID: "depot", Rect: []int{0, 5, 1, 1}}}, scene.Buildings...)
+	svc.setScene(built)
+	if rebuilt := svc.occupancyGrid(); rebuilt == grid || rebuilt.Walkable(pathfinding.Point{X: 0, Y: 5}) {
+		t.Fatalf("expected the grid to be rebuilt with the new building")
+	}
+}
+
+func TestRelocationPathAvoidsBuildings(t *testing.T) {
+	dims := SceneDims{Width: 8, Height: 6}
+	occupied := []SceneBuilding{
+		{ID: "wall", Rect: []int{2, 0, 1, 5}},
+		{ID: "west", Rect: []int{0, 0, 2, 6}},
+	}
+
+	tile, _, path, ok := findRelocationAndPlacement([2]int{1, 5}, 2, 2, placementArea{occupied: NewSpatialIndex(dims, occupied)}, dims)
+	if !ok || len(path) == 0 || path[len(path)-1] != tile {
+		t.Fatalf("expected relocation path ending at %v, got %v ok=%v", tile, path, ok)
+	}
+	for _, step := range path {
+		if tileIsBlocked(step[0], step[1], occupied) {
+			t.Fatalf("relocation path crosses building at %v", step)
+		}
+	}
+
+	svc := &Service{scene: Scene{Dimensions: dims, Buildings: occupied}}
+	route, ok := svc.routeTowardRect([2]int{7, 5}, [4]int{5, 0, 1, 1})
+	if !ok || len(route) == 0 || abs(route[0][0]-7)+abs(route[0][1]-5) != 1 || !tileAdjacentToRect(route[len(route)-1], [4]int{5, 0, 1, 1}) {
+		t.Fatalf("expected a route ending next to the target, got %v", route)
+	}
+}
+
+func TestSpatialIndexQueries(t *testing.T) {
+	ix := NewSpatialIndex(SceneDims{Width: 10, Height: 10}, []SceneBuilding{
+		{ID: "a", Rect: []int{0, 0, 2, 2}},
+		{ID: "b", Rect: []int{5, 5, 2, 2}},
+		{ID: "edge", Rect: []int{9, 9, 3, 3}},
+		{ID: "overlap", Rect: []int{1, 1, 2, 2}},
+	})
+
+	if got := ix.BuildingsInRect(0, 0, 3, 3); len(got) != 2 || got[0].ID != "a" || got[1].ID != "overlap" {
+		t.Fatalf("unexpected buildings in rect: %+v", got)
+	}
+	if b, ok := ix.BuildingAt(9, 9); !ok || b.ID != "edge" {
+		t.Fatalf("expected out-of-bounds building to be found, got %+v %v", b, ok)
+	}
+	if ix.AreaIsFree(2, 2, 1, 1) || !ix.AreaIsFree(3, 0, 2, 2) {
+		t.Fatalf("unexpected area occupancy")
+	}
+	if got := ix.BuildingsWithinRadius(4.5, 4.5, 1); len(got) != 1 || got[0].ID != "b" {
+		t.Fatalf("unexpected buildings within radius: %+v", got)
+	}
+
+	ix.Remove("overlap")
+	if !ix.AreaIsFree(2, 2, 1, 1) {
+		t.Fatalf("expected removed building to free its tiles")
+	}
+	ix.Add(SceneBuilding{ID: "a", Rect: []int{3, 0, 2, 2}})
+	if !ix.AreaIsFree(0, 0, 2, 2) || ix.AreaIsFree(3, 0, 1, 1) {
+		t.Fatalf("expected re-added building to move")
+	}
+
+	if tile, ok := ix.NearestFreeArea([2]int{5, 5}, 2, 2); !ok || !ix.AreaIsFree(tile[0], tile[1], 2, 2) || abs(tile[0]-5) > 2 || abs(tile[1]-5) > 2 {
+		t.Fatalf("expected a free area next to b, got %v %v", tile, ok)
+	}
+	if _, ok := ix.NearestFreeArea([2]int{0, 0}, 11, 1); ok {
+		t.Fatalf("expected no area wider than the scene")
+	}
+}
+
+func TestSetSceneSyncsSpatialIndex(t *testing.T) {
+	dims := SceneDims{Width: 10, Height: 10}
+	scene := Scene{Dimensions: dims, Buildings: []SceneBuilding{
+		{ID: "a", Rect: []int{0, 0, 2, 2}},
+		{ID: "b", Rect: []int{3, 0, 2, 2}},
+		{ID: "c", Rect: []int{6, 0, 2, 2}},
+	}}
+	svc := &Service{}
+	svc.setScene(scene)
+	index := svc.spatialIndex()
+
+	// 中间插入、移动与删除建筑后，索引应与重新构建的结果一致且不被丢弃。
+	changed := scene
+	changed.Buildings = []SceneBuilding{
+		{ID: "a", Rect: []int{0, 0, 2, 2}},
+		{ID: "ab", Rect: []int{0, 5, 1, 1}},
+		{ID: "b", Rect: []int{3, 3, 2, 2}},
+	}
+	svc.setScene(changed)
+	if svc.spatialIndex() != index {
+		t.Fatalf("expected the index to be updated in place")
+	}
+	fresh := NewSpatialIndex(dims, changed.Buildings)
+	for y := 0; y < dims.Height; y++ {
+		for x := 0; x < dims.Width; x++ {
+			got, gotOK := index.BuildingAt(x, y)
+			want, wantOK := fresh.BuildingAt(x, y)
+			if gotOK != wantOK || got.ID != want.ID {
+				t.Fatalf("tile (%d,%d): expected %q %v, got %q %v", x, y, want.ID, wantOK, got.ID, gotOK)
+			}
+		}
+	}
+
+	changed.Dimensions = SceneDims{Width: 12, Height: 12}
+	svc.setScene(changed)
+	if svc.spatialIndex() == index {
+		t.Fatalf("expected the index to be rebuilt when the scene is resized")
+	}
+}
+
+// crowdedScene 生成 200×200 的场景，左上角按 3 格间距密集排布 count 座 2×2 建筑，4×4 的塔只能放在建筑群之外。
+func crowdedScene(count int) (Scene, SceneAgent) {
+	scene := Scene{
+		Dimensions:        SceneDims{Width: 200, Height: 200},
+		BuildingTemplates: []BuildingTemplate{{ID: solarTowerTemplateID, Label: "太阳能塔", Size: []int{4, 4}, Energy: &SceneEnergy{Type: "storage", Output: 220}}},
+	}
+	for i := 0; i < count; i++ {
+		x, y := (i%66)*3, (i/66)*3
+		scene.Buildings = append(scene.Buildings, SceneBuilding{ID: fmt.Sprintf("b%05d", i), Rect: []int{x, y, 2, 2}})
+	}
+	return scene, SceneAgent{ID: "ares-01", Position: []float64{2, 2}}
+}
+
+func TestPlanProducersRelocatesOutOfCrowdedArea(t *testing.T) {
+	scene, agent := crowdedScene(1000)
+	plan, path, err := planProducers(scene, agent, []*BuildingTemplate{&scene.BuildingTemplates[0]}, 3*220, MaxMaintainBuildings)
+	if err != nil {
+		t.Fatalf("expected plan, got %v", err)
+	}
+	if len(plan.Build) != 3 || plan.Relocation == nil || len(path) == 0 {
+		t.Fatalf("expected relocation with three towers, got %+v", plan)
+	}
+	ix := NewSpatialIndex(scene.Dimensions, scene.Buildings)
+	for _, tower := range plan.Build {
+		if !ix.AreaIsFree(tower.Rect[0], tower.Rect[1], tower.Rect[2], tower.Rect[3]) {
+			t.Fatalf("tower %s overlaps an existing building", tower.ID)
+		}
+		ix.Add(SceneBuilding{ID: tower.ID, Rect: tower.Rect[:]})
+	}
+}
+
+func TestPlanProducersChainsRelocationPaths(t *testing.T) {
+	scene, agent := crowdedScene(1000)
+	plan, path, err := planProducers(scene, agent, []*BuildingTemplate{&scene.BuildingTemplates[0]}, 8*220, MaxMaintainBuildings)
+	if err != nil || plan.Relocation == nil || len(path) == 0 {
+		t.Fatalf("expected a relocating plan, got %+v %v", plan, err)
+	}
+	end := [2]int{int(plan.Relocation.Position[0]), int(plan.Relocation.Position[1])}
+	// 有塔不与终点相邻，说明途中搬迁过不止一次。
+	earlier := 0
+	for _, tower := range plan.Build {
+		if !tileAdjacentToRect(end, tower.Rect) {
+			earlier++
+		}
+	}
+	if earlier == 0 {
+		t.Fatalf("expected towers placed before the last relocation, got %+v", plan.Build)
+	}
+
+	prev := agentTile(agent.Position)
+	for i, tile := range path {
+		if dx, dy := tile[0]-prev[0], tile[1]-prev[1]; dx*dx+dy*dy != 1 {
+			t.Fatalf("path tile %d %v does not follow %v", i, tile, prev)
+		}
+		prev = tile
+	}
+	if prev != end {
+		t.Fatalf("expected the path to end at %v, got %v", end, prev)
+	}
+}
+
+func TestMaintainEnergyStrategies(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 30, Height: 30},
+		BuildingTemplates: []BuildingTemplate{
+			{ID: solarTowerTemplateID, Label: "太阳能塔", Size: []int{4, 4}, Energy: &SceneEnergy{Type: "storage", Output: 100}},
+			{ID: "reactor", Label: "反应堆", Size: []int{2, 2}, Energy: &SceneEnergy{Type: "storage", Output: 300}},
+			{ID: "dome", Label: "穹顶", Energy: &SceneEnergy{Type: "consumer", Rate: 50}},
+		},
+		Buildings: []SceneBuilding{
+			{ID: "lab", Rect: []int{20, 20, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 120}},
+			{ID: "pump", Rect: []int{24, 20, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 60}},
+			{ID: "mine", Rect: []int{20, 24, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 200}},
+			{ID: "idle", Rect: []int{24, 24, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 500}, Offline: true},
+		},
+	}
+	agent := SceneAgent{ID: "ares-01", Position: []float64{10, 10}}
+	m := &EnergyMaintainer{}
+	ctx := context.Background()
+
+	result, _, relocation, err := m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Templates: []string{"reactor", solarTowerTemplateID}, Margin: 20, DryRun: true})
+	if err != nil || relocation != nil || len(result.Plans) != 1 {
+		t.Fatalf("expected a dry-run plan, got %+v %v %v", result, relocation, err)
+	}
+	if result.NetFlowBefore != -380 || result.NetFlowAfter != 220 || len(result.Plans[0].Build) != 2 || result.Plans[0].Build[0].ID != "reactor_auto_01" {
+		t.Fatalf("unexpected build plan: %+v", result)
+	}
+
+	result, _, _, err = m.Maintain(ctx, scene, agent, MaintainEnergyOptions{MaxBuildings: 2, DryRun: true})
+	if err != nil || len(result.Plans[0].Build) != 2 || result.Plans[0].Shortfall != 180 {
+		t.Fatalf("expected capped solar plan with shortfall, got %+v %v", result, err)
+	}
+	if rect := result.Plans[0].Build[0].Rect; rect[2] != 4 || rect[3] != 4 {
+		t.Fatalf("expected template footprint, got %v", rect)
+	}
+
+	result, _, _, err = m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Mode: MaintainModeShed, DryRun: true})
+	if err != nil || len(result.Plans) != 1 || strings.Join(result.Plans[0].Shed, ",") != "mine,lab,pump" {
+		t.Fatalf("unexpected shed plan: %+v %v", result.Plans, err)
+	}
+	result, _, _, err = m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Mode: MaintainModeShed, MaxBuildings: 1, DryRun: true})
+	if err != nil || strings.Join(result.Plans[0].Shed, ",") != "mine" || result.Plans[0].Shortfall != 180 {
+		t.Fatalf("unexpected capped shed plan: %+v %v", result.Plans, err)
+	}
+
+	result, _, _, err = m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Mode: MaintainModePlan})
+	if err != nil || len(result.Plans) != 2 || result.Plans[0].Mode != MaintainModeBuild || result.Plans[1].Mode != MaintainModeShed {
+		t.Fatalf("expected build and shed plans, got %+v %v", result.Plans, err)
+	}
+
+	for _, opts := range []MaintainEnergyOptions{
+		{Mode: "panic"},
+		{Margin: -1},
+		{MaxBuildings: MaxMaintainBuildings + 1},
+		{MaxBuildings: -1},
+		{Templates: []string{"dome"}},
+		{Templates: []string{"missing"}},
+	} {
+		if _, _, _, err := m.Maintain(ctx, scene, agent, opts); !errors.Is(err, ErrInvalidBehaviorParams) {
+			t.Fatalf("expected %+v to be rejected, got %v", opts, err)
+		}
+	}
+}
+
+func TestMaintainEnergyQueuesBuildJobs(t *testing.T) {
+	scene := Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 30, Height: 30},
+		BuildingTemplates: []BuildingTemplate{
+			{ID: solarTowerTemplateID, Label: "太阳能塔", Size: []int{2, 2}, Energy: &SceneEnergy{Type: "storage", Output: 100}},
+		},
+		Buildings: []SceneBuilding{{ID: "lab", Rect: []int{20, 20, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 250}}},
+		// 已排队的建造任务计入缺口，剩余 150 只需再排两座塔。
+		Jobs: []Job{{ID: 7, Kind: JobKindBuild, Status: JobStatusQueued, Target: []int{11, 10}, TemplateID: solarTowerTemplateID}},
+	}
+	agent := SceneAgent{ID: "ares-01", Position: []float64{10, 10}}
+	db := &fakeDB{rows: func(query string, args []driver.Value) [][]driver.Value {
+		if !strings.Contains(query, "INSERT INTO scene_jobs") {
+			return nil
+		}
+		now := time.Now()
+		return [][]driver.Value{{int64(8), args[1], JobStatusQueued, args[2], args[3], args[4], nil, args[6], nil, 0.0, args[7], nil, now, now}}
+	}}
+	m := newEnergyMaintainer(db.open(), func(*sql.DB, string) (Scene, error) { return scene, nil })
+	ctx := context.Background()
+
+	result, _, relocation, err := m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Mode: MaintainModeQueue, DryRun: true})
+	if err != nil || relocation != nil || len(result.Plans) != 1 || len(result.Plans[0].Build) != 2 || len(db.statements()) != 0 {
+		t.Fatalf("expected a two-tower queue plan without writes, got %+v %v", result, err)
+	}
+
+	result, _, relocation, err = m.Maintain(ctx, scene, agent, MaintainEnergyOptions{Mode: MaintainModeQueue})
+	if err != nil || relocation != nil || len(result.Jobs) != 2 || result.TowersBuilt != 0 {
+		t.Fatalf("expected two queued build jobs, got %+v %v", result, err)
+	}
+	pending := [4]int{11, 10, 2, 2}
+	for _, job := range result.Jobs {
+		rect := [4]int{job.Target[0], job.Target[1], 2, 2}
+		if job.Kind != JobKindBuild || job.Source != JobSourceRule || rectanglesOverlap(rect[0], rect[1], 2, 2, pending[0], pending[1], 2, 2) {
+			t.Fatalf("unexpected queued job %+v", job)
+		}
+	}
+	if last := db.statements()[len(db.statements())-1]; last != "COMMIT" {
+		t.Fatalf("expected jobs to be queued in one transaction, got %v", db.statements())
+	}
+}
+
+func BenchmarkPlanProducers(b *testing.B) {
+	for _, count := range []int{100, 1000, 4000} {
+		scene, agent := crowdedScene(count)
+		b.Run(fmt.Sprintf("buildings=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := planProducers(scene, agent, []*BuildingTemplate{&scene.BuildingTemplates[0]}, 5*220, MaxMaintainBuildings); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEnsureBuildingPlacement(b *testing.B) {
+	for _, count := range []int{100, 1000, 4000} {
+		scene, _ := crowdedScene(count)
+		svc := &Service{scene: scene}
+		b.Run(fmt.Sprintf("buildings=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := svc.ensureBuildingPlacement("new", [4]int{199, 199, 1, 1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPlacementAfterBuildingChange 每次迭代增删一座建筑后重新加载场景再做放置检查，衡量建筑变化后的索引维护开销。
+func BenchmarkPlacementAfterBuildingChange(b *testing.B) {
+	for _, count := range []int{1000, 4000} {
+		scene, _ := crowdedScene(count)
+		built := scene
+		built.Buildings = append(append([]SceneBuilding(nil), scene.Buildings...), SceneBuilding{ID: "depot", Rect: []int{198, 0, 2, 2}})
+		svc := &Service{}
+		svc.setScene(scene)
+		b.Run(fmt.Sprintf("buildings=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if i%2 == 0 {
+					svc.setScene(built)
+				} else {
+					svc.setScene(scene)
+				}
+				if err := svc.ensureBuildingPlacement("new", [4]int{199, 199, 1, 1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTerrainRunLengthRoundTrip(t *testing.T) {
+	layer := NewTerrainLayer(4, 3)
+	layer.Paint([4]int{1, 0, 2, 2}, TerrainIce)
+	layer.Paint([4]int{3, 2, 5, 5}, TerrainCrater)
+
+	decoded, err := DecodeTerrainRuns(4, 3, layer.Runs)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			if decoded.At(x, y) != layer.At(x, y) {
+				t.Fatalf("tile (%d,%d): expected %s, got %s", x, y, layer.At(x, y), decoded.At(x, y))
+			}
+		}
+	}
+	if len(layer.Runs) != 6 {
+		t.Fatalf("expected 6 runs, got %v", layer.Runs)
+	}
+
+	resized := layer.Resize(5, 2)
+	if resized.At(2, 1) != TerrainIce || resized.At(4, 0) != TerrainRegolith || resized.At(3, 2) != TerrainRegolith {
+		t.Fatalf("unexpected resized terrain: %v", resized.Runs)
+	}
+
+	for _, runs := range [][][2]int{{{0, 11}}, {{0, 13}}, {{9, 12}}, {{0, 0}, {0, 12}}} {
+		if _, err := DecodeTerrainRuns(4, 3, runs); !errors.Is(err, ErrInvalidTerrain) {
+			t.Fatalf("runs %v: expected ErrInvalidTerrain, got %v", runs, err)
+		}
+	}
+}
+
+func TestTerrainConstrainsPlacementAndPathCost(t *testing.T) {
+	terrain := NewTerrainLayer(10, 10)
+	terrain.Paint([4]int{4, 4, 2, 2}, TerrainCrater)
+	terrain.Paint([4]int{0, 8, 1, 1}, TerrainIce)
+
+	if err := checkTerrainPlacement(terrain, [4]int{3, 3, 2, 2}, ""); !errors.Is(err, ErrTerrainUnsuitable) {
+		t.Fatalf("expected crater to reject placement, got %v", err)
+	}
+	if err := checkTerrainPlacement(terrain, [4]int{0, 0, 2, 2}, "ice"); !errors.Is(err, ErrTerrainUnsuitable) {
+		t.Fatalf("expected missing ice to reject placement, got %v", err)
+	}
+	if err := checkTerrainPlacement(terrain, [4]int{0, 7, 2, 2}, "ice"); err != nil {
+		t.Fatalf("expected ice footprint to be accepted, got %v", err)
+	}
+	if err := checkTerrainPlacement(nil, [4]int{0, 0, 2, 2}, ""); err != nil {
+		t.Fatalf("expected default terrain to accept placement, got %v", err)
+	}
+
+	slope := NewTerrainLayer(5, 3)
+	slope.Paint([4]int{1, 1, 3, 1}, TerrainSlope)
+	grid := newOccupancyGrid(SceneDims{Width: 5, Height: 3}, nil, slope)
+	path, cost, ok := pathfinding.FindPath(grid, pathfinding.Point{X: 0, Y: 1}, pathfinding.Point{X: 4, Y: 1}, pathfinding.Options{})
+	if !ok {
+		t.Fatal("expected a path around the slope")
+	}
+	if cost != 6 || len(path) != 6 {
+		t.Fatalf("expected detour of cost 6 avoiding the slope, got cost %v path %v", cost, path)
+	}
+
+	grid = newOccupancyGrid(SceneDims{Width: 10, Height: 10}, nil, terrain)
+	if grid.Walkable(pathfinding.Point{X: 4, Y: 4}) {
+		t.Fatal("expected crater tiles to be impassable")
+	}
+}
+
+func TestGenerateSceneDryRunIsReproducible(t *testing.T) {
+	svc := &Service{scene: Scene{
+		BuildingTemplates: []BuildingTemplate{
+			{ID: "central_dome", Label: "联合指挥穹顶", Size: []int{4, 4}},
+			{ID: "habitat_block", Label: "居住平台"},
+			{ID: "solar_array", Label: "太阳能阵列", Size: []int{2, 2}, Energy: &SceneEnergy{Type: "storage", Output: 120}},
+			{ID: "ice_extractor", Label: "冰层提取站", Size: []int{2, 2}, RequiredTerrain: "ice"},
+			{ID: "legacy_block", Label: "旧式平台", Deprecated: true},
+		},
+		AgentTemplates: []AgentTemplate{
+			{ID: "ares", Label: "ARES", Speed: 2},
+			{ID: "retired", Label: "退役", Deprecated: true},
+		},
+	}}
+	in := GenerateSceneInput{SceneID: "gen_a", Seed: 99, Width: 48, Height: 40, DryRun: true}
+
+	first, err := svc.GenerateScene(context.Background(), in)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	second, err := svc.GenerateScene(context.Background(), in)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if fmt.Sprint(first.Scene.Buildings, first.Scene.Terrain.Runs) != fmt.Sprint(second.Scene.Buildings, second.Scene.Terrain.Runs) {
+		t.Fatal("expected identical scenes for the same seed")
+	}
+
+	scene := first.Scene
+	if !first.DryRun || scene.Grid.TileSize != defaultGeneratedTileSize || scene.Dimensions != (SceneDims{Width: 48, Height: 40}) {
+		t.Fatalf("unexpected scene header: %+v", first)
+	}
+	if len(scene.Buildings) != 6 || len(scene.Agents) != 1 || len(scene.Deposits) == 0 {
+		t.Fatalf("expected 6 buildings, 1 agent and deposits, got %d, %d, %d", len(scene.Buildings), len(scene.Agents), len(scene.Deposits))
+	}
+	if scene.Buildings[0].ID != "gen_a_central_dome_01" || scene.Buildings[1].Rect[2] != defaultGeneratedFootprint {
+		t.Fatalf("unexpected building naming or footprint: %+v", scene.Buildings[:2])
+	}
+	if agent := scene.Agents[0]; agent.TemplateID != "ares" || agent.Speed != 2 {
+		t.Fatalf("unexpected agent: %+v", agent)
+	}
+	if err := validateGeneratedLayout(scene); err != nil {
+		t.Fatalf("generated layout should pass placement checks: %v", err)
+	}
+
+	if _, err := svc.GenerateScene(context.Background(), GenerateSceneInput{SceneID: "gen_b", Width: 48, Height: 40, DryRun: true,
+		Buildings: []TemplateCount{{TemplateID: "legacy_block", Count: 1}}}); !errors.Is(err, ErrTemplateDeprecated) {
+		t.Fatalf("expected ErrTemplateDeprecated, got %v", err)
+	}
+	if _, err := svc.GenerateScene(context.Background(), GenerateSceneInput{SceneID: "gen_c", Width: 4, Height: 4, DryRun: true}); !errors.Is(err, ErrInvalidSceneConfig) {
+		t.Fatalf("expected ErrInvalidSceneConfig for a tiny map, got %v", err)
+	}
+}
+
+func TestMapgenTerrainCodesMatchTerrainTypes(t *testing.T) {
+	pairs := map[mapgen.Terrain]TerrainType{
+		mapgen.Regolith: TerrainRegolith,
+		mapgen.Rock:     TerrainRock,
+		mapgen.Crater:   TerrainCrater,
+		mapgen.Ice:      TerrainIce,
+		mapgen.Slope:    TerrainSlope,
+	}
+	for code, typ := range pairs {
+		if TerrainType(code) != typ {
+			t.Fatalf("mapgen terrain %d does not match %s", code, typ)
+		}
+	}
+}
+
+func TestSuggestPlacementRanksSites(t *testing.T) {
+	terrain := NewTerrainLayer(20, 12)
+	terrain.Paint([4]int{14, 0, 6, 12}, TerrainSlope)
+	terrain.Paint([4]int{2, 9, 2, 2}, TerrainIce)
+	terrain.Paint([4]int{9, 3, 2, 2}, TerrainCrater)
+	svc := &Service{scene: Scene{
+		Dimensions: SceneDims{Width: 20, Height: 12},
+		Terrain:    terrain,
+		Buildings: []SceneBuilding{
+			{ID: "dome", TemplateID: "central_dome", Rect: []int{5, 4, 3, 3}, Energy: &SceneEnergy{Type: "consumer"}},
+		},
+		Agents: []SceneAgent{{ID: "ares-01", Position: []float64{12, 6}}},
+		BuildingTemplates: []BuildingTemplate{
+			{ID: "solar_array", Size: []int{2, 2}},
+			{ID: "ice_extractor", Size: []int{2, 2}, RequiredTerrain: "ice"},
+			{ID: "legacy", Deprecated: true},
+		},
+	}}
+
+	plan, err := svc.SuggestPlacement(PlacementQuery{TemplateID: "solar_array", AgentID: "ares-01", Limit: 4, Clearance: 1})
+	if err != nil {
+		t.Fatalf("suggest: %v", err)
+	}
+	if plan.Size != [2]int{2, 2} || len(plan.Suggestions) != 4 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	for i, suggestion := range plan.Suggestions {
+		rect := suggestion.Rect
+		if i > 0 && suggestion.Score < plan.Suggestions[i-1].Score {
+			t.Fatalf("suggestions not sorted by score: %+v", plan.Suggestions)
+		}
+		if !svc.spatialIndex().AreaIsFree(rect[0]-1, rect[1]-1, rect[2]+2, rect[3]+2) {
+			t.Fatalf("suggestion %v violates clearance", rect)
+		}
+		if checkTerrainPlacement(terrain, rect, "") != nil || suggestion.TerrainCost != 1 || suggestion.AgentDistance == nil {
+			t.Fatalf("unexpected suggestion %+v", suggestion)
+		}
+		if rectCoversTile(rect, [][2]int{{12, 6}}) {
+			t.Fatalf("suggestion %v covers the agent", rect)
+		}
+		for _, other := range plan.Suggestions[i+1:] {
+			if rectanglesOverlap(rect[0], rect[1], rect[2], rect[3], other.Rect[0], other.Rect[1], other.Rect[2], other.Rect[3]) {
+				t.Fatalf("suggestions %v and %v overlap", rect, other.Rect)
+			}
+		}
+	}
+
+	ice, err := svc.SuggestPlacement(PlacementQuery{TemplateID: "ice_extractor", Limit: 50})
+	if err != nil {
+		t.Fatalf("suggest ice: %v", err)
+	}
+	if len(ice.Suggestions) == 0 || ice.Suggestions[0].ConsumerDistance == 0 {
+		t.Fatalf("expected ice suggestions away from the dome, got %+v", ice.Suggestions)
+	}
+	for _, suggestion := range ice.Suggestions {
+		if err := checkTerrainPlacement(terrain, suggestion.Rect, "ice"); err != nil {
+			t.Fatalf("ice suggestion %v: %v", suggestion.Rect, err)
+		}
+	}
+
+	if _, err := svc.SuggestPlacement(PlacementQuery{TemplateID: "legacy"}); !errors.Is(err, ErrTemplateDeprecated) {
+		t.Fatalf("expected ErrTemplateDeprecated, got %v", err)
+	}
+	if _, err := svc.SuggestPlacement(PlacementQuery{TemplateID: "solar_array", AgentID: "ghost"}); !errors.Is(err, ErrInvalidSceneEntity) {
+		t.Fatalf("expected ErrInvalidSceneEntity, got %v", err)
+	}
+}
+
+func TestSetScenePublishesLatestChange(t *testing.T) {
+	svc := &Service{}
+	changes, cancel := svc.SubscribeSceneChanges()
+
+	svc.setScene(Scene{ID: "first"})
+	svc.setScene(Scene{ID: "second"})
+	if scene := <-changes; scene.ID != "second" {
+		t.Fatalf("expected only the latest scene, got %q", scene.ID)
+	}
+
+	svc.simulating.Store(true)
+	svc.setScene(Scene{ID: "mid-tick"})
+	svc.simulating.Store(false)
+	select {
+	case scene := <-changes:
+		t.Fatalf("expected no publish during a tick, got %q", scene.ID)
+	default:
+	}
+
+	cancel()
+	svc.setScene(Scene{ID: "after-cancel"})
+	select {
+	case scene := <-changes:
+		t.Fatalf("expected no publish after cancel, got %q", scene.ID)
+	default:
+	}
+}
+
+// fakeDB 为测试用的 database/sql 驱动：记录执行的语句与事务提交/回滚，查询结果由 rows 提供，缺省为空。
+// failOn 非空时，包含该片段的写语句返回错误。
+type fakeDB struct {
+	mu     sync.Mutex
+	execs  []fakeExec
+	rows   func(query string, args []driver.Value) [][]driver.Value
+	failOn string
+}
+
+func (f *fakeDB) open() *sql.DB { return sql.OpenDB(f) }
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+
+func (f *fakeDB) Driver() driver.Driver { return f }
+
+func (f *fakeDB) Open(string) (driver.Conn, error) { return fakeConn{f}, nil }
+
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+func (f *fakeDB) record(statement string, args []driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, fakeExec{query: strings.Join(strings.Fields(statement), " "), args: args})
+}
+
+// statements 返回已执行的语句。
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	statements := make([]string, len(f.execs))
+	for i, exec := range f.execs {
+		statements[i] = exec.query
+	}
+	return statements
+}
+
+// argsOf 返回第一条包含 fragment 的语句的参数。
+func (f *fakeDB) argsOf(fragment string) ([]driver.Value, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, exec := range f.execs {
+		if strings.Contains(exec.query, fragment) {
+			return exec.args, true
+		}
+	}
+	return nil, false
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{c.db}, nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.db.record("COMMIT", nil); return nil }
+func (tx fakeTx) Rollback() error { tx.db.record("ROLLBACK", nil); return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.record(s.query, args)
+	if s.db.failOn != "" && strings.Contains(s.query, s.db.failOn) {
+		return nil, errors.New("fake exec failure")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var values [][]driver.Value
+	if s.db.rows != nil {
+		values = s.db.rows(s.query, args)
+	}
+	return &fakeRows{values: values}, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return nil
+	}
+	columns := make([]string, len(r.values[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("c%d", i)
+	}
+	return columns
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+// simulationScene 返回带储能、耗能建筑与一个移动中 Agent 的场景，每次调用都是新的副本，模拟重新加载。
+func simulationScene() Scene {
+	return Scene{
+		ID:         "s",
+		Dimensions: SceneDims{Width: 20, Height: 20},
+		Buildings: []SceneBuilding{
+			{ID: "battery", Rect: []int{0, 0, 2, 2}, Energy: &SceneEnergy{Type: "storage", Capacity: 1000, Current: 500}},
+			{ID: "habitat", Rect: []int{10, 10, 2, 2}, Energy: &SceneEnergy{Type: "consumer", Rate: 5}},
+		},
+		Agents: []SceneAgent{{ID: "ares-01", Position: []float64{5, 5}, Speed: 1, Path: [][2]int{{6, 5}, {7, 5}}, Actions: []string{"move_right"}}},
+	}
+}
+
+func TestServiceSerializesCommandsWithSimulation(t *testing.T) {
+	original := sceneLoader
+	t.Cleanup(func() { sceneLoader = original })
+	sceneLoader = func(*sql.DB, string) (Scene, error) { return simulationScene(), nil }
+
+	svc, err := New((&fakeDB{}).open(), "s")
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+	ctx := context.Background()
+	const rounds = 30
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := svc.AdvanceSimulation(ctx, 1, DefaultDrainFactor); err != nil {
+				t.Errorf("advance simulation: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := svc.SetAgentPath(ctx, SetAgentPathInput{AgentID: "ares-01", Destination: &[2]int{8, 5}}); err != nil {
+				t.Errorf("set agent path: %v", err)
+			}
+			if _, err := svc.UpdateBuildingEnergyCurrent(ctx, "battery", float64(i)); err != nil {
+				t.Errorf("update energy: %v", err)
+			}
+			if _, err := svc.ExecuteAgentAction(ctx, ExecuteAgentActionInput{AgentID: "ares-01", ActionType: "move_right"}); err != nil {
+				t.Errorf("execute action: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			for _, building := range svc.Scene().Buildings {
+				if building.Energy != nil {
+					_ = building.Energy.Current
+				}
+			}
+			_ = svc.Snapshot()
+			if _, err := svc.FindPath(FindPathInput{From: [2]int{5, 5}, To: [2]int{15, 15}}); err != nil {
+				t.Errorf("find path: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+}
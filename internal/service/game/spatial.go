@@ -0,0 +1,282 @@
+package game
+
+import (
+	"math"
+	"sort"
+)
+
+// spatialIndexSlack 为索引中允许保留的已移除条目数，超出时在下次使用时重建。
+const spatialIndexSlack = 64
+
+// SpatialIndex 以格子位图记录建筑占地，替代对 scene.Buildings 的线性扫描。
+// 超出场景范围或与已有建筑重叠的建筑放入溢出列表，查询时逐个检查。
+type SpatialIndex struct {
+	dims     SceneDims
+	cells    []int32
+	entries  []spatialEntry
+	ids      map[string]int
+	overflow map[int]struct{}
+}
+
+type spatialEntry struct {
+	building SceneBuilding
+	live     bool
+}
+
+// NewSpatialIndex 根据场景尺寸与建筑列表构建索引。
+func NewSpatialIndex(dims SceneDims, buildings []SceneBuilding) *SpatialIndex {
+	width, height := max(dims.Width, 0), max(dims.Height, 0)
+	ix := &SpatialIndex{
+		dims:     SceneDims{Width: width, Height: height},
+		cells:    make([]int32, width*height),
+		entries:  make([]spatialEntry, 0, len(buildings)),
+		ids:      make(map[string]int, len(buildings)),
+		overflow: make(map[int]struct{}),
+	}
+	for _, building := range buildings {
+		ix.Add(building)
+	}
+	return ix
+}
+
+// Add 加入建筑，相同 ID 的建筑会先被移除。
+func (ix *SpatialIndex) Add(building SceneBuilding) {
+	if len(building.Rect) != 4 {
+		return
+	}
+	ix.Remove(building.ID)
+
+	slot := len(ix.entries)
+	ix.entries = append(ix.entries, spatialEntry{building: building, live: true})
+	ix.ids[building.ID] = slot
+
+	x, y, w, h := building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]
+	if !ix.contains(x, y, w, h) || !ix.cellsFree(x, y, w, h) {
+		ix.overflow[slot] = struct{}{}
+		return
+	}
+	ix.fill(x, y, w, h, int32(slot+1))
+}
+
+// Remove 移除建筑，建筑不存在时不做任何事。
+func (ix *SpatialIndex) Remove(buildingID string) {
+	slot, ok := ix.ids[buildingID]
+	if !ok {
+		return
+	}
+	delete(ix.ids, buildingID)
+	ix.entries[slot].live = false
+	if _, ok := ix.overflow[slot]; ok {
+		delete(ix.overflow, slot)
+		return
+	}
+	rect := ix.entries[slot].building.Rect
+	ix.fill(rect[0], rect[1], rect[2], rect[3], 0)
+}
+
+// Sync 将索引从 old 更新为 buildings：跳过首尾 ID 与占地一致的建筑，只对中间变化的部分执行 Remove/Add。
+// old 须为索引当前对应的建筑列表；未变化建筑的其余属性保持加入索引时的值。
+func (ix *SpatialIndex) Sync(old, buildings []SceneBuilding) {
+	start := 0
+	for start < len(old) && start < len(buildings) && sameFootprint(old[start], buildings[start]) {
+		start++
+	}
+	endOld, endNew := len(old), len(buildings)
+	for endOld > start && endNew > start && sameFootprint(old[endOld-1], buildings[endNew-1]) {
+		endOld--
+		endNew--
+	}
+	for _, building := range old[start:endOld] {
+		ix.Remove(building.ID)
+	}
+	for _, building := range buildings[start:endNew] {
+		ix.Add(building)
+	}
+}
+
+// Buildings 返回索引中的全部建筑，按加入顺序排列。
+func (ix *SpatialIndex) Buildings() []SceneBuilding {
+	buildings := make([]SceneBuilding, 0, len(ix.ids))
+	for _, entry := range ix.entries {
+		if entry.live {
+			buildings = append(buildings, entry.building)
+		}
+	}
+	return buildings
+}
+
+// BuildingAt 返回占据指定格子的建筑。
+func (ix *SpatialIndex) BuildingAt(x, y int) (SceneBuilding, bool) {
+	if x >= 0 && y >= 0 && x < ix.dims.Width && y < ix.dims.Height {
+		if slot := ix.cells[y*ix.dims.Width+x]; slot != 0 {
+			return ix.entries[slot-1].building, true
+		}
+	}
+	for _, slot := range ix.overflowSlots() {
+		rect := ix.entries[slot].building.Rect
+		if rectanglesOverlap(x, y, 1, 1, rect[0], rect[1], rect[2], rect[3]) {
+			return ix.entries[slot].building, true
+		}
+	}
+	return SceneBuilding{}, false
+}
+
+// AreaIsFree 判断矩形区域内是否没有任何建筑。
+func (ix *SpatialIndex) AreaIsFree(x, y, width, height int) bool {
+	if !ix.cellsFree(x, y, width, height) {
+		return false
+	}
+	for slot := range ix.overflow {
+		rect := ix.entries[slot].building.Rect
+		if rectanglesOverlap(x, y, width, height, rect[0], rect[1], rect[2], rect[3]) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildingsInRect 返回与矩形区域重叠的建筑，按 ID 排序。
+func (ix *SpatialIndex) BuildingsInRect(x, y, width, height int) []SceneBuilding {
+	seen := make(map[int32]struct{})
+	x0, y0, x1, y1 := ix.clip(x, y, width, height)
+	for yy := y0; yy < y1; yy++ {
+		for xx := x0; xx < x1; xx++ {
+			if slot := ix.cells[yy*ix.dims.Width+xx]; slot != 0 {
+				seen[slot-1] = struct{}{}
+			}
+		}
+	}
+	for slot := range ix.overflow {
+		rect := ix.entries[slot].building.Rect
+		if rectanglesOverlap(x, y, width, height, rect[0], rect[1], rect[2], rect[3]) {
+			seen[int32(slot)] = struct{}{}
+		}
+	}
+	return ix.collect(seen)
+}
+
+// BuildingsWithinRadius 返回占地与圆心 (cx, cy) 的距离不超过 radius 的建筑，按 ID 排序。
+func (ix *SpatialIndex) BuildingsWithinRadius(cx, cy, radius float64) []SceneBuilding {
+	if radius < 0 {
+		return nil
+	}
+	x := int(math.Floor(cx - radius))
+	y := int(math.Floor(cy - radius))
+	size := int(math.Ceil(2*radius)) + 2
+	seen := make(map[int32]struct{})
+	for _, building := range ix.BuildingsInRect(x, y, size, size) {
+		rect := building.Rect
+		nx := math.Max(float64(rect[0]), math.Min(cx, float64(rect[0]+rect[2])))
+		ny := math.Max(float64(rect[1]), math.Min(cy, float64(rect[1]+rect[3])))
+		if math.Hypot(cx-nx, cy-ny) <= radius {
+			seen[int32(ix.ids[building.ID])] = struct{}{}
+		}
+	}
+	return ix.collect(seen)
+}
+
+// NearestFreeArea 返回左上角距 from 最近（切比雪夫距离逐圈向外）且位于场景内的 width×height 空闲区域。
+func (ix *SpatialIndex) NearestFreeArea(from [2]int, width, height int) ([2]int, bool) {
+	if width <= 0 || height <= 0 || width > ix.dims.Width || height > ix.dims.Height {
+		return [2]int{}, false
+	}
+	maxX, maxY := ix.dims.Width-width, ix.dims.Height-height
+	fx := min(max(from[0], 0), maxX)
+	fy := min(max(from[1], 0), maxY)
+	limit := max(max(fx, maxX-fx), max(fy, maxY-fy))
+
+	for ring := 0; ring <= limit; ring++ {
+		for y := fy - ring; y <= fy+ring; y++ {
+			if y < 0 || y > maxY {
+				continue
+			}
+			step := 2 * ring
+			if y == fy-ring || y == fy+ring || step == 0 {
+				step = 1
+			}
+			for x := fx - ring; x <= fx+ring; x += step {
+				if x >= 0 && x <= maxX && ix.AreaIsFree(x, y, width, height) {
+					return [2]int{x, y}, true
+				}
+			}
+		}
+	}
+	return [2]int{}, false
+}
+
+// cellsFree 只检查位图，超出场景的部分视为空闲。
+func (ix *SpatialIndex) cellsFree(x, y, width, height int) bool {
+	x0, y0, x1, y1 := ix.clip(x, y, width, height)
+	for yy := y0; yy < y1; yy++ {
+		row := ix.cells[yy*ix.dims.Width : (yy+1)*ix.dims.Width]
+		for xx := x0; xx < x1; xx++ {
+			if row[xx] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (ix *SpatialIndex) fill(x, y, width, height int, value int32) {
+	x0, y0, x1, y1 := ix.clip(x, y, width, height)
+	for yy := y0; yy < y1; yy++ {
+		for xx := x0; xx < x1; xx++ {
+			ix.cells[yy*ix.dims.Width+xx] = value
+		}
+	}
+}
+
+func (ix *SpatialIndex) contains(x, y, width, height int) bool {
+	return x >= 0 && y >= 0 && width > 0 && height > 0 && x+width <= ix.dims.Width && y+height <= ix.dims.Height
+}
+
+func (ix *SpatialIndex) clip(x, y, width, height int) (int, int, int, int) {
+	return max(x, 0), max(y, 0), min(x+width, ix.dims.Width), min(y+height, ix.dims.Height)
+}
+
+func (ix *SpatialIndex) overflowSlots() []int {
+	slots := make([]int, 0, len(ix.overflow))
+	for slot := range ix.overflow {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+func (ix *SpatialIndex) collect(slots map[int32]struct{}) []SceneBuilding {
+	buildings := make([]SceneBuilding, 0, len(slots))
+	for slot := range slots {
+		buildings = append(buildings, ix.entries[slot].building)
+	}
+	sort.Slice(buildings, func(i, j int) bool { return buildings[i].ID < buildings[j].ID })
+	return buildings
+}
+
+// spatialIndex 返回与当前场景建筑占地一致的索引，setScene 随建筑变化增量维护，丢弃后按需重建。
+func (s *Service) spatialIndex() *SpatialIndex {
+	if s.index == nil {
+		s.index = NewSpatialIndex(s.scene.Dimensions, s.scene.Buildings)
+	}
+	return s.index
+}
+
+// setScene 替换当前场景并向订阅者发布变化。
+// 尺寸、地形或建筑占地变化时递增布局版本，寻路缓存据此重建占用网格，空间索引只增删变化的建筑；
+// 尺寸变化或索引中已移除的建筑过多时丢弃索引，下次使用时重建。
+func (s *Service) setScene(scene Scene) {
+	if !sameLayout(s.scene, scene) {
+		s.layoutVersion++
+		if s.index != nil {
+			if s.scene.Dimensions != scene.Dimensions || len(s.index.entries) > 2*len(scene.Buildings)+spatialIndexSlack {
+				s.index = nil
+			} else {
+				s.index.Sync(s.scene.Buildings, scene.Buildings)
+			}
+		}
+	}
+	s.scene = scene
+	if !s.simulating.Load() {
+		s.changes.Publish(scene)
+	}
+}
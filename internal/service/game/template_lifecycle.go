@@ -0,0 +1,328 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	actionservice "eeo/backend/internal/service/action"
+)
+
+const (
+	TemplateDeleteBlock    = "block"
+	TemplateDeleteReassign = "reassign"
+	TemplateDeleteCascade  = "cascade"
+
+	templateKindBuilding = "building"
+	templateKindAgent    = "agent"
+)
+
+// DeleteTemplateInput 表示删除模板所需的参数。
+type DeleteTemplateInput struct {
+	ID         string
+	Mode       string
+	ReassignTo string
+	DryRun     bool
+}
+
+// TemplateDependent 描述引用模板的场景实例。
+type TemplateDependent struct {
+	ID      string `json:"id"`
+	SceneID string `json:"sceneId"`
+	Label   string `json:"label"`
+}
+
+// TemplateDeletionResult 描述模板删除（或演练）的结果。
+type TemplateDeletionResult struct {
+	TemplateID string              `json:"templateId"`
+	Kind       string              `json:"kind"`
+	Mode       string              `json:"mode"`
+	DryRun     bool                `json:"dryRun"`
+	ReassignTo string              `json:"reassignTo,omitempty"`
+	Dependents []TemplateDependent `json:"dependents"`
+	Blocked    bool                `json:"blocked"`
+	Deleted    bool                `json:"deleted"`
+	Snapshot   *Snapshot           `json:"snapshot,omitempty"`
+}
+
+// templateTables 描述一类模板及其实例所在的表。
+type templateTables struct {
+	kind          string
+	templateTable string
+	instanceTable string
+}
+
+var (
+	buildingTemplateTables = templateTables{
+		kind:          templateKindBuilding,
+		templateTable: "system_template_buildings",
+		instanceTable: "system_scene_buildings",
+	}
+	agentTemplateTables = templateTables{
+		kind:          templateKindAgent,
+		templateTable: "system_template_agents",
+		instanceTable: "system_scene_agents",
+	}
+)
+
+// DeleteBuildingTemplate 删除建筑模板，按模式阻止、重新指派或级联删除引用它的建筑。
+func (s *Service) DeleteBuildingTemplate(ctx context.Context, in DeleteTemplateInput) (TemplateDeletionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if target := strings.TrimSpace(in.ReassignTo); target != "" {
+		tpl, ok := findBuildingTemplate(s.scene, target)
+		if !ok {
+			return TemplateDeletionResult{}, fmt.Errorf("%w: reassign template %s not found", ErrInvalidTemplate, target)
+		}
+		if tpl.Deprecated {
+			return TemplateDeletionResult{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, target)
+		}
+	}
+	return s.deleteTemplate(ctx, buildingTemplateTables, in)
+}
+
+// DeleteAgentTemplate 删除 Agent 模板，按模式阻止、重新指派或级联删除引用它的 Agent。
+func (s *Service) DeleteAgentTemplate(ctx context.Context, in DeleteTemplateInput) (TemplateDeletionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if target := strings.TrimSpace(in.ReassignTo); target != "" {
+		tpl, ok := findAgentTemplate(s.scene, target)
+		if !ok {
+			return TemplateDeletionResult{}, fmt.Errorf("%w: reassign template %s not found", ErrInvalidTemplate, target)
+		}
+		if tpl.Deprecated {
+			return TemplateDeletionResult{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, target)
+		}
+	}
+	return s.deleteTemplate(ctx, agentTemplateTables, in)
+}
+
+func (s *Service) deleteTemplate(ctx context.Context, tables templateTables, in DeleteTemplateInput) (TemplateDeletionResult, error) {
+	id := strings.TrimSpace(in.ID)
+	if id == "" {
+		return TemplateDeletionResult{}, fmt.Errorf("%w: id required", ErrInvalidTemplate)
+	}
+
+	mode, err := normalizeTemplateDeleteMode(in.Mode, in.ReassignTo)
+	if err != nil {
+		return TemplateDeletionResult{}, err
+	}
+	reassignTo := strings.TrimSpace(in.ReassignTo)
+	if mode == TemplateDeleteReassign && reassignTo == id {
+		return TemplateDeletionResult{}, fmt.Errorf("%w: cannot reassign to the template being deleted", ErrInvalidTemplate)
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+tables.templateTable+` WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return TemplateDeletionResult{}, err
+	}
+	if !exists {
+		return TemplateDeletionResult{}, fmt.Errorf("%w: template %s not found", ErrInvalidTemplate, id)
+	}
+
+	dependents, err := s.listTemplateDependents(ctx, tables, id)
+	if err != nil {
+		return TemplateDeletionResult{}, err
+	}
+
+	result := TemplateDeletionResult{
+		TemplateID: id,
+		Kind:       tables.kind,
+		Mode:       mode,
+		DryRun:     in.DryRun,
+		Dependents: dependents,
+		Blocked:    mode == TemplateDeleteBlock && len(dependents) > 0,
+	}
+	if mode == TemplateDeleteReassign {
+		result.ReassignTo = reassignTo
+		if tables.kind == templateKindBuilding {
+			if err := s.checkReassignedBuildings(dependents, reassignTo); err != nil {
+				return TemplateDeletionResult{}, err
+			}
+		}
+	}
+
+	if in.DryRun {
+		return result, nil
+	}
+	if result.Blocked {
+		return result, fmt.Errorf("%w: %s %s is referenced by %d instance(s)", ErrTemplateInUse, tables.kind, id, len(dependents))
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TemplateDeletionResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	switch mode {
+	case TemplateDeleteReassign:
+		_, err = tx.ExecContext(ctx, `UPDATE `+tables.instanceTable+` SET template_id = $1 WHERE template_id = $2`, reassignTo, id)
+	case TemplateDeleteCascade:
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+tables.instanceTable+` WHERE template_id = $1`, id)
+	}
+	if err != nil {
+		return TemplateDeletionResult{}, err
+	}
+	// 级联删除的 Agent 不再存在，其行为历史随实例在同一事务中清除。
+	if mode == TemplateDeleteCascade && tables.kind == templateKindAgent {
+		for _, dep := range dependents {
+			if err = actionservice.PurgeHistory(ctx, tx, dep.ID); err != nil {
+				return TemplateDeletionResult{}, err
+			}
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM `+tables.templateTable+` WHERE id = $1`, id); err != nil {
+		return TemplateDeletionResult{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return TemplateDeletionResult{}, err
+	}
+
+	if err = s.reloadScene(); err != nil {
+		return TemplateDeletionResult{}, err
+	}
+
+	snapshot := s.snapshot()
+	result.Deleted = true
+	result.Snapshot = &snapshot
+	return result, nil
+}
+
+// checkReassignedBuildings 校验改用 templateID 后每座依赖建筑的占地不与其他建筑重叠且满足模板的地形要求，
+// 其他场景的建筑按所在场景校验。
+func (s *Service) checkReassignedBuildings(dependents []TemplateDependent, templateID string) error {
+	tpl, ok := findBuildingTemplate(s.scene, templateID)
+	if !ok {
+		return fmt.Errorf("%w: reassign template %s not found", ErrInvalidTemplate, templateID)
+	}
+	scenes := map[string]Scene{s.scene.ID: s.scene}
+	indexes := map[string]*SpatialIndex{s.scene.ID: s.spatialIndex()}
+	for _, dep := range dependents {
+		scene, ok := scenes[dep.SceneID]
+		if !ok {
+			loaded, err := sceneLoader(s.db, dep.SceneID)
+			if err != nil {
+				return err
+			}
+			scene = loaded
+			scenes[dep.SceneID] = scene
+			indexes[dep.SceneID] = NewSpatialIndex(scene.Dimensions, scene.Buildings)
+		}
+		building, ok := findBuilding(scene, dep.ID)
+		if !ok || len(building.Rect) != 4 {
+			continue
+		}
+		rect := [4]int{building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]}
+		if err := checkBuildingPlacement(indexes[dep.SceneID], building.ID, rect); err != nil {
+			return fmt.Errorf("%w: building %s cannot use %s: %w", ErrTemplateInUse, building.ID, tpl.ID, err)
+		}
+		if err := checkTerrainPlacement(scene.Terrain, rect, tpl.RequiredTerrain); err != nil {
+			return fmt.Errorf("%w: building %s cannot use %s: %w", ErrTemplateInUse, building.ID, tpl.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) listTemplateDependents(ctx context.Context, tables templateTables, templateID string) ([]TemplateDependent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, scene_id, label
+		  FROM `+tables.instanceTable+`
+		 WHERE template_id = $1
+		 ORDER BY scene_id, id
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dependents := []TemplateDependent{}
+	for rows.Next() {
+		var (
+			dep   TemplateDependent
+			label sql.NullString
+		)
+		if err := rows.Scan(&dep.ID, &dep.SceneID, &label); err != nil {
+			return nil, err
+		}
+		dep.Label = label.String
+		dependents = append(dependents, dep)
+	}
+	return dependents, rows.Err()
+}
+
+func normalizeTemplateDeleteMode(mode, reassignTo string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = TemplateDeleteBlock
+	}
+	switch mode {
+	case TemplateDeleteBlock, TemplateDeleteCascade:
+		return mode, nil
+	case TemplateDeleteReassign:
+		if strings.TrimSpace(reassignTo) == "" {
+			return "", fmt.Errorf("%w: reassignTo required for reassign mode", ErrInvalidTemplate)
+		}
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: mode must be block, reassign or cascade", ErrInvalidTemplate)
+	}
+}
+
+// ensureBuildingTemplatePlaceable 禁止新建或改用已弃用的建筑模板，已存在的实例不受影响。
+func (s *Service) ensureBuildingTemplatePlaceable(buildingID string, templateID sql.NullString) error {
+	if !templateID.Valid {
+		return nil
+	}
+	tpl, ok := findBuildingTemplate(s.scene, templateID.String)
+	if !ok || !tpl.Deprecated {
+		return nil
+	}
+	if existing, ok := findBuilding(s.scene, buildingID); ok && existing.TemplateID == tpl.ID {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+}
+
+// ensureAgentTemplatePlaceable 禁止新建或改用已弃用的 Agent 模板，已存在的实例不受影响。
+func (s *Service) ensureAgentTemplatePlaceable(agentID string, templateID sql.NullString) error {
+	if !templateID.Valid {
+		return nil
+	}
+	tpl, ok := findAgentTemplate(s.scene, templateID.String)
+	if !ok || !tpl.Deprecated {
+		return nil
+	}
+	if existing, ok := findAgent(s.scene, agentID); ok && existing.TemplateID == tpl.ID {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrTemplateDeprecated, tpl.ID)
+}
+
+func findAgentTemplate(scene Scene, templateID string) (*AgentTemplate, bool) {
+	if templateID == "" {
+		return nil, false
+	}
+	for i := range scene.AgentTemplates {
+		if scene.AgentTemplates[i].ID == templateID {
+			return &scene.AgentTemplates[i], true
+		}
+	}
+	return nil, false
+}
+
+func findAgent(scene Scene, agentID string) (SceneAgent, bool) {
+	for _, agent := range scene.Agents {
+		if agent.ID == agentID {
+			return agent, true
+		}
+	}
+	return SceneAgent{}, false
+}
@@ -0,0 +1,296 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TerrainType 表示单个格子的地形。
+type TerrainType uint8
+
+const (
+	TerrainRegolith TerrainType = iota
+	TerrainRock
+	TerrainCrater
+	TerrainIce
+	TerrainSlope
+)
+
+// terrainLegend 为地形编码到名称的映射，编码写入数据库，顺序不可调整。
+var terrainLegend = []string{"regolith", "rock", "crater", "ice", "slope"}
+
+// terrainMoveCost 为进入各地形格子的寻路代价，0 表示不可通行。
+var terrainMoveCost = []float64{1, 2, 0, 1.5, 3}
+
+// String 返回地形名称。
+func (t TerrainType) String() string {
+	if int(t) < len(terrainLegend) {
+		return terrainLegend[t]
+	}
+	return fmt.Sprintf("terrain(%d)", t)
+}
+
+// MoveCost 返回进入该地形格子的寻路代价，0 表示不可通行。
+func (t TerrainType) MoveCost() float64 {
+	if int(t) < len(terrainMoveCost) {
+		return terrainMoveCost[t]
+	}
+	return 1
+}
+
+// ParseTerrainType 按名称解析地形，忽略大小写与首尾空白。
+func ParseTerrainType(name string) (TerrainType, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for code, label := range terrainLegend {
+		if label == name {
+			return TerrainType(code), true
+		}
+	}
+	return 0, false
+}
+
+// TerrainLayer 为场景的地形层，按行优先以游程编码 [编码, 连续格数] 传输与存储。
+type TerrainLayer struct {
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Legend []string `json:"legend"`
+	Runs   [][2]int `json:"runs"`
+
+	tiles []TerrainType
+}
+
+// TerrainPaint 表示将矩形区域涂成指定地形。
+type TerrainPaint struct {
+	Type string
+	Rect [4]int
+}
+
+// NewTerrainLayer 返回全部为风化层的地形。
+func NewTerrainLayer(width, height int) *TerrainLayer {
+	width, height = max(width, 0), max(height, 0)
+	layer := &TerrainLayer{Width: width, Height: height, tiles: make([]TerrainType, width*height)}
+	layer.encode()
+	return layer
+}
+
+// newTerrainFromTiles 以行优先的格子地形构造地形层，tiles 长度需为 width×height。
+func newTerrainFromTiles(width, height int, tiles []TerrainType) *TerrainLayer {
+	layer := &TerrainLayer{Width: width, Height: height, tiles: tiles}
+	layer.encode()
+	return layer
+}
+
+// DecodeTerrainRuns 校验游程并还原为地形层，游程总长必须等于 width×height。
+func DecodeTerrainRuns(width, height int, runs [][2]int) (*TerrainLayer, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("%w: dimensions must be non-negative", ErrInvalidTerrain)
+	}
+	total := width * height
+	tiles := make([]TerrainType, 0, total)
+	for i, run := range runs {
+		code, count := run[0], run[1]
+		if code < 0 || code >= len(terrainLegend) {
+			return nil, fmt.Errorf("%w: run %d has unknown terrain code %d", ErrInvalidTerrain, i, code)
+		}
+		if count <= 0 {
+			return nil, fmt.Errorf("%w: run %d length must be positive", ErrInvalidTerrain, i)
+		}
+		if len(tiles)+count > total {
+			return nil, fmt.Errorf("%w: runs cover more than %d tiles", ErrInvalidTerrain, total)
+		}
+		for j := 0; j < count; j++ {
+			tiles = append(tiles, TerrainType(code))
+		}
+	}
+	if len(tiles) != total {
+		return nil, fmt.Errorf("%w: runs cover %d of %d tiles", ErrInvalidTerrain, len(tiles), total)
+	}
+	layer := &TerrainLayer{Width: width, Height: height, tiles: tiles}
+	layer.encode()
+	return layer, nil
+}
+
+// At 返回格子的地形，越界或地形层为空时视为风化层。
+func (t *TerrainLayer) At(x, y int) TerrainType {
+	if t == nil || x < 0 || y < 0 || x >= t.Width || y >= t.Height {
+		return TerrainRegolith
+	}
+	return t.tiles[y*t.Width+x]
+}
+
+// Paint 将矩形区域涂成指定地形，超出地形层的部分会被忽略。
+func (t *TerrainLayer) Paint(rect [4]int, typ TerrainType) {
+	for y := max(rect[1], 0); y < min(rect[1]+rect[3], t.Height); y++ {
+		for x := max(rect[0], 0); x < min(rect[0]+rect[2], t.Width); x++ {
+			t.tiles[y*t.Width+x] = typ
+		}
+	}
+	t.encode()
+}
+
+// Resize 返回调整到新尺寸的副本，重叠部分保留原地形，新增部分为风化层。
+func (t *TerrainLayer) Resize(width, height int) *TerrainLayer {
+	resized := NewTerrainLayer(width, height)
+	for y := 0; y < min(t.Height, resized.Height); y++ {
+		copy(resized.tiles[y*resized.Width:y*resized.Width+min(t.Width, resized.Width)], t.tiles[y*t.Width:])
+	}
+	resized.encode()
+	return resized
+}
+
+// Clone 返回地形层的深拷贝。
+func (t *TerrainLayer) Clone() *TerrainLayer {
+	clone := *t
+	clone.tiles = append([]TerrainType(nil), t.tiles...)
+	clone.Runs = append([][2]int(nil), t.Runs...)
+	return &clone
+}
+
+// encode 根据格子重新计算游程。
+func (t *TerrainLayer) encode() {
+	t.Legend = terrainLegend
+	t.Runs = t.Runs[:0]
+	for i, tile := range t.tiles {
+		if i > 0 && TerrainType(t.Runs[len(t.Runs)-1][0]) == tile {
+			t.Runs[len(t.Runs)-1][1]++
+			continue
+		}
+		t.Runs = append(t.Runs, [2]int{int(tile), 1})
+	}
+	if t.Runs == nil {
+		t.Runs = [][2]int{}
+	}
+}
+
+// checkTerrainPlacement 校验矩形区域的地形：任何建筑都不能压在陨石坑上，
+// 模板要求特定地形时占地内至少有一格为该地形。
+func checkTerrainPlacement(terrain *TerrainLayer, rect [4]int, required string) error {
+	want, hasRequirement := ParseTerrainType(required)
+	found := !hasRequirement
+	for y := rect[1]; y < rect[1]+rect[3]; y++ {
+		for x := rect[0]; x < rect[0]+rect[2]; x++ {
+			tile := terrain.At(x, y)
+			if tile == TerrainCrater {
+				return fmt.Errorf("%w: tile (%d,%d) is a crater", ErrTerrainUnsuitable, x, y)
+			}
+			if tile == want {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: footprint %v has no %s tile", ErrTerrainUnsuitable, rect, required)
+	}
+	return nil
+}
+
+// ensureTerrainPlacement 按模板的地形要求校验建筑占地。
+func (s *Service) ensureTerrainPlacement(rect [4]int, templateID string) error {
+	var required string
+	if tpl, ok := findBuildingTemplate(s.scene, templateID); ok {
+		required = tpl.RequiredTerrain
+	}
+	return checkTerrainPlacement(s.scene.Terrain, rect, required)
+}
+
+// Terrain 返回当前场景的地形层，未配置时返回全部为风化层的地形。
+func (s *Service) Terrain() TerrainLayer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.terrain()
+}
+
+func (s *Service) terrain() TerrainLayer {
+	if s.scene.Terrain == nil {
+		return *NewTerrainLayer(s.scene.Dimensions.Width, s.scene.Dimensions.Height)
+	}
+	return *s.scene.Terrain
+}
+
+// UpdateSceneTerrain 以游程整体替换场景地形，游程需覆盖当前场景尺寸。
+func (s *Service) UpdateSceneTerrain(ctx context.Context, runs [][2]int) (TerrainLayer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	layer, err := DecodeTerrainRuns(s.scene.Dimensions.Width, s.scene.Dimensions.Height, runs)
+	if err != nil {
+		return TerrainLayer{}, err
+	}
+	return s.saveTerrain(ctx, layer)
+}
+
+// PaintSceneTerrain 依次将矩形区域涂成指定地形。已有建筑不受影响，只约束之后的放置。
+func (s *Service) PaintSceneTerrain(ctx context.Context, paints []TerrainPaint) (TerrainLayer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(paints) == 0 {
+		return TerrainLayer{}, fmt.Errorf("%w: at least one paint required", ErrInvalidTerrain)
+	}
+	current := s.terrain()
+	layer := current.Clone()
+	for i, paint := range paints {
+		typ, ok := ParseTerrainType(paint.Type)
+		if !ok {
+			return TerrainLayer{}, fmt.Errorf("%w: paint %d has unknown terrain %q", ErrInvalidTerrain, i, paint.Type)
+		}
+		if paint.Rect[2] <= 0 || paint.Rect[3] <= 0 {
+			return TerrainLayer{}, fmt.Errorf("%w: paint %d width/height must be positive", ErrInvalidTerrain, i)
+		}
+		layer.Paint(paint.Rect, typ)
+	}
+	return s.saveTerrain(ctx, layer)
+}
+
+func (s *Service) saveTerrain(ctx context.Context, layer *TerrainLayer) (TerrainLayer, error) {
+	runs, err := json.Marshal(layer.Runs)
+	if err != nil {
+		return TerrainLayer{}, err
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO system_scene_terrain (scene_id, width, height, runs, updated_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (scene_id)
+		DO UPDATE SET width = EXCLUDED.width,
+		              height = EXCLUDED.height,
+		              runs = EXCLUDED.runs,
+		              updated_at = EXCLUDED.updated_at
+	`, s.scene.ID, layer.Width, layer.Height, string(runs)); err != nil {
+		return TerrainLayer{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return TerrainLayer{}, err
+	}
+	return s.terrain(), nil
+}
+
+// loadSceneTerrain 读取场景地形并调整到场景尺寸，未配置时返回 nil。
+func loadSceneTerrain(ctx context.Context, db *sql.DB, sceneID string, dims SceneDims) (*TerrainLayer, error) {
+	var (
+		width, height int
+		raw           []byte
+	)
+	err := db.QueryRowContext(ctx, `SELECT width, height, runs FROM system_scene_terrain WHERE scene_id = $1`, sceneID).
+		Scan(&width, &height, &raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runs [][2]int
+	if err := json.Unmarshal(raw, &runs); err != nil {
+		return nil, fmt.Errorf("decode terrain for scene %s: %w", sceneID, err)
+	}
+	layer, err := DecodeTerrainRuns(width, height, runs)
+	if err != nil {
+		return nil, fmt.Errorf("decode terrain for scene %s: %w", sceneID, err)
+	}
+	if width != dims.Width || height != dims.Height {
+		layer = layer.Resize(dims.Width, dims.Height)
+	}
+	return layer, nil
+}
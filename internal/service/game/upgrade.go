@@ -0,0 +1,390 @@
+package game
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	footprintRuleAnchor = "anchor"
+	footprintRuleCenter = "center"
+)
+
+// UpgradeSceneBuildingInput 表示升级场景建筑所需的数据。
+type UpgradeSceneBuildingInput struct {
+	BuildingID       string
+	TargetTemplateID string
+}
+
+// UpgradeBuildingResult 描述一次建筑升级的结果。
+type UpgradeBuildingResult struct {
+	Scene          Scene         `json:"scene"`
+	Building       SceneBuilding `json:"building"`
+	FromTemplateID string        `json:"fromTemplateId,omitempty"`
+	ToTemplateID   string        `json:"toTemplateId"`
+	EnergyCost     int           `json:"energyCost"`
+}
+
+// UpdateBuildingUpgradeInput 表示创建或更新模板升级路径所需的数据。
+type UpdateBuildingUpgradeInput struct {
+	FromTemplateID string
+	ToTemplateID   string
+	EnergyCost     int
+	FootprintRule  string
+}
+
+// BuildingUpgradeEvent 记录建筑的一次升级历史。
+type BuildingUpgradeEvent struct {
+	ID             int64     `json:"id"`
+	BuildingID     string    `json:"buildingId"`
+	FromTemplateID string    `json:"fromTemplateId,omitempty"`
+	ToTemplateID   string    `json:"toTemplateId"`
+	EnergyCost     int       `json:"energyCost"`
+	RectBefore     []int     `json:"rectBefore"`
+	RectAfter      []int     `json:"rectAfter"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// BuildingUpgradeOverview 汇总建筑当前可用的升级路径与历史记录。
+type BuildingUpgradeOverview struct {
+	BuildingID string                 `json:"buildingId"`
+	TemplateID string                 `json:"templateId,omitempty"`
+	Available  []BuildingUpgrade      `json:"available"`
+	History    []BuildingUpgradeEvent `json:"history"`
+}
+
+// UpgradeSceneBuilding 将建筑升级为目标模板，保留建筑 ID 与历史，并扣除储能作为升级成本。
+func (s *Service) UpgradeSceneBuilding(ctx context.Context, in UpgradeSceneBuildingInput) (UpgradeBuildingResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buildingID := strings.TrimSpace(in.BuildingID)
+	targetID := strings.TrimSpace(in.TargetTemplateID)
+	if buildingID == "" {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: building id required", ErrInvalidSceneEntity)
+	}
+	if targetID == "" {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: target template id required", ErrInvalidSceneEntity)
+	}
+
+	building, ok := findBuilding(s.scene, buildingID)
+	if !ok || len(building.Rect) != 4 {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: building %s not found", ErrInvalidSceneEntity, buildingID)
+	}
+
+	path, ok := findUpgradePath(s.scene, building.TemplateID, targetID)
+	if !ok {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: no upgrade path from %q to %q", ErrUpgradeUnavailable, building.TemplateID, targetID)
+	}
+
+	target, ok := findBuildingTemplate(s.scene, targetID)
+	if !ok {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: template %s not found", ErrUpgradeUnavailable, targetID)
+	}
+	if target.Deprecated {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: %s", ErrTemplateDeprecated, targetID)
+	}
+
+	before := [4]int{building.Rect[0], building.Rect[1], building.Rect[2], building.Rect[3]}
+	after := resolveUpgradeFootprint(before, target.Size, path.FootprintRule)
+	if !rectWithinScene(after, s.scene.Dimensions) {
+		return UpgradeBuildingResult{}, fmt.Errorf("%w: upgraded footprint exceeds scene bounds", ErrInvalidSceneEntity)
+	}
+	if err := s.ensureBuildingPlacement(buildingID, after); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+	if err := s.ensureTerrainPlacement(after, targetID); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	deductions, err := planEnergyDeduction(computeEnergyBalance(s.scene).storage, path.EnergyCost)
+	if err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for id, current := range deductions {
+		if _, err = tx.ExecContext(ctx, `UPDATE system_scene_buildings SET energy_current = $1 WHERE id = $2`, current, id); err != nil {
+			return UpgradeBuildingResult{}, err
+		}
+	}
+
+	// 升级后能量参数回落到新模板，仅保留实例的当前储能。
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE system_scene_buildings
+		   SET template_id = $1,
+		       position_x = $2,
+		       position_y = $3,
+		       size_width = $4,
+		       size_height = $5,
+		       energy_type = NULL,
+		       energy_capacity = NULL,
+		       energy_output = NULL,
+		       energy_rate = NULL
+		 WHERE id = $6 AND scene_id = $7
+	`, targetID, after[0], after[1], after[2], after[3], buildingID, s.scene.ID); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO system_scene_building_upgrade_events (building_id, from_template_id, to_template_id, energy_cost, rect_before, rect_after)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, buildingID, nullTrimmedString(&building.TemplateID), targetID, path.EnergyCost, pq.Array(before[:]), pq.Array(after[:])); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	if err = s.reloadScene(); err != nil {
+		return UpgradeBuildingResult{}, err
+	}
+
+	upgraded, _ := findBuilding(s.scene, buildingID)
+	return UpgradeBuildingResult{
+		Scene:          s.scene,
+		Building:       upgraded,
+		FromTemplateID: building.TemplateID,
+		ToTemplateID:   targetID,
+		EnergyCost:     path.EnergyCost,
+	}, nil
+}
+
+// BuildingUpgrades 返回建筑可用的升级路径以及升级历史。
+func (s *Service) BuildingUpgrades(ctx context.Context, buildingID string) (BuildingUpgradeOverview, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buildingID = strings.TrimSpace(buildingID)
+	building, ok := findBuilding(s.scene, buildingID)
+	if !ok {
+		return BuildingUpgradeOverview{}, fmt.Errorf("%w: building %s not found", ErrInvalidSceneEntity, buildingID)
+	}
+
+	overview := BuildingUpgradeOverview{
+		BuildingID: building.ID,
+		TemplateID: building.TemplateID,
+		Available:  []BuildingUpgrade{},
+		History:    []BuildingUpgradeEvent{},
+	}
+	if tpl, ok := findBuildingTemplate(s.scene, building.TemplateID); ok {
+		overview.Available = append(overview.Available, tpl.Upgrades...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, building_id, from_template_id, to_template_id, energy_cost, rect_before, rect_after, created_at
+		  FROM system_scene_building_upgrade_events
+		 WHERE building_id = $1
+		 ORDER BY created_at DESC
+	`, buildingID)
+	if err != nil {
+		return BuildingUpgradeOverview{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			evt          BuildingUpgradeEvent
+			fromID       sql.NullString
+			before, post pq.Int64Array
+		)
+		if err := rows.Scan(&evt.ID, &evt.BuildingID, &fromID, &evt.ToTemplateID, &evt.EnergyCost, &before, &post, &evt.CreatedAt); err != nil {
+			return BuildingUpgradeOverview{}, err
+		}
+		if fromID.Valid {
+			evt.FromTemplateID = fromID.String
+		}
+		evt.RectBefore = int64sToInts(before)
+		evt.RectAfter = int64sToInts(post)
+		overview.History = append(overview.History, evt)
+	}
+	return overview, rows.Err()
+}
+
+// UpdateBuildingUpgrade 创建或更新模板之间的升级路径。
+func (s *Service) UpdateBuildingUpgrade(ctx context.Context, in UpdateBuildingUpgradeInput) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fromID := strings.TrimSpace(in.FromTemplateID)
+	toID := strings.TrimSpace(in.ToTemplateID)
+	if fromID == "" || toID == "" {
+		return Snapshot{}, fmt.Errorf("%w: from and to template ids required", ErrInvalidTemplate)
+	}
+	if fromID == toID {
+		return Snapshot{}, fmt.Errorf("%w: template cannot upgrade to itself", ErrInvalidTemplate)
+	}
+	if in.EnergyCost < 0 {
+		return Snapshot{}, fmt.Errorf("%w: energyCost must not be negative", ErrInvalidTemplate)
+	}
+
+	rule := strings.ToLower(strings.TrimSpace(in.FootprintRule))
+	if rule == "" {
+		rule = footprintRuleAnchor
+	}
+	if rule != footprintRuleAnchor && rule != footprintRuleCenter {
+		return Snapshot{}, fmt.Errorf("%w: footprintRule must be anchor or center", ErrInvalidTemplate)
+	}
+
+	if _, ok := findBuildingTemplate(s.scene, fromID); !ok {
+		return Snapshot{}, fmt.Errorf("%w: template %s not found", ErrInvalidTemplate, fromID)
+	}
+	if _, ok := findBuildingTemplate(s.scene, toID); !ok {
+		return Snapshot{}, fmt.Errorf("%w: template %s not found", ErrInvalidTemplate, toID)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO system_template_building_upgrades (from_template_id, to_template_id, energy_cost, footprint_rule)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (from_template_id, to_template_id)
+		DO UPDATE SET energy_cost = EXCLUDED.energy_cost,
+		              footprint_rule = EXCLUDED.footprint_rule
+	`, fromID, toID, in.EnergyCost, rule); err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return Snapshot{}, err
+	}
+
+	return s.snapshot(), nil
+}
+
+// DeleteBuildingUpgrade 删除模板之间的升级路径。
+func (s *Service) DeleteBuildingUpgrade(ctx context.Context, fromTemplateID, toTemplateID string) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fromID := strings.TrimSpace(fromTemplateID)
+	toID := strings.TrimSpace(toTemplateID)
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM system_template_building_upgrades
+		 WHERE from_template_id = $1 AND to_template_id = $2
+	`, fromID, toID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if rows, errRows := res.RowsAffected(); errRows == nil && rows == 0 {
+		return Snapshot{}, fmt.Errorf("%w: upgrade %s -> %s not found", ErrInvalidTemplate, fromID, toID)
+	}
+
+	if err := s.reloadScene(); err != nil {
+		return Snapshot{}, err
+	}
+
+	return s.snapshot(), nil
+}
+
+// resolveUpgradeFootprint 根据目标模板尺寸与占地规则计算升级后的矩形。
+func resolveUpgradeFootprint(rect [4]int, size []int, rule string) [4]int {
+	if len(size) != 2 || size[0] <= 0 || size[1] <= 0 {
+		return rect
+	}
+	width, height := size[0], size[1]
+	if rule == footprintRuleCenter {
+		x := rect[0] + (rect[2]-width)/2
+		y := rect[1] + (rect[3]-height)/2
+		return [4]int{x, y, width, height}
+	}
+	return [4]int{rect[0], rect[1], width, height}
+}
+
+// planEnergyDeduction 依次从储能建筑中扣除成本，返回各建筑扣除后的当前储能。
+func planEnergyDeduction(storage []SceneBuilding, cost int) (map[string]int, error) {
+	updates := make(map[string]int)
+	if cost <= 0 {
+		return updates, nil
+	}
+
+	available := 0
+	for _, building := range storage {
+		available += building.Energy.Current
+	}
+	if available < cost {
+		return nil, fmt.Errorf("%w: need %d, stored %d", ErrInsufficientEnergy, cost, available)
+	}
+
+	remaining := cost
+	for _, building := range storage {
+		if remaining == 0 {
+			break
+		}
+		take := building.Energy.Current
+		if take > remaining {
+			take = remaining
+		}
+		if take == 0 {
+			continue
+		}
+		updates[building.ID] = building.Energy.Current - take
+		remaining -= take
+	}
+	return updates, nil
+}
+
+func rectWithinScene(rect [4]int, dims SceneDims) bool {
+	if rect[0] < 0 || rect[1] < 0 {
+		return false
+	}
+	if dims.Width > 0 && rect[0]+rect[2] > dims.Width {
+		return false
+	}
+	if dims.Height > 0 && rect[1]+rect[3] > dims.Height {
+		return false
+	}
+	return true
+}
+
+func findUpgradePath(scene Scene, fromTemplateID, toTemplateID string) (BuildingUpgrade, bool) {
+	tpl, ok := findBuildingTemplate(scene, fromTemplateID)
+	if !ok {
+		return BuildingUpgrade{}, false
+	}
+	for _, path := range tpl.Upgrades {
+		if path.TargetTemplateID == toTemplateID {
+			return path, true
+		}
+	}
+	return BuildingUpgrade{}, false
+}
+
+func findBuilding(scene Scene, buildingID string) (SceneBuilding, bool) {
+	for _, building := range scene.Buildings {
+		if building.ID == buildingID {
+			return building, true
+		}
+	}
+	return SceneBuilding{}, false
+}
+
+func findBuildingTemplate(scene Scene, templateID string) (*BuildingTemplate, bool) {
+	if templateID == "" {
+		return nil, false
+	}
+	for i := range scene.BuildingTemplates {
+		if scene.BuildingTemplates[i].ID == templateID {
+			return &scene.BuildingTemplates[i], true
+		}
+	}
+	return nil, false
+}
+
+func int64sToInts(values []int64) []int {
+	result := make([]int, len(values))
+	for i, v := range values {
+		result[i] = int(v)
+	}
+	return result
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS system_scene_building_upgrade_events;
+DROP TABLE IF EXISTS system_template_building_upgrades;
+
+UPDATE system_scene_buildings
+   SET template_id = 'solar_tower'
+ WHERE template_id = 'solar_tower_mk2';
+
+DELETE FROM system_template_buildings WHERE id = 'solar_tower_mk2';
+
+ALTER TABLE system_template_buildings
+    DROP COLUMN IF EXISTS size_height,
+    DROP COLUMN IF EXISTS size_width,
+    DROP COLUMN IF EXISTS tier;
@@ -0,0 +1,49 @@
+ALTER TABLE system_template_buildings
+    ADD COLUMN IF NOT EXISTS tier INT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS size_width INT,
+    ADD COLUMN IF NOT EXISTS size_height INT;
+
+CREATE TABLE IF NOT EXISTS system_template_building_upgrades (
+    from_template_id TEXT NOT NULL REFERENCES system_template_buildings(id) ON DELETE CASCADE,
+    to_template_id   TEXT NOT NULL REFERENCES system_template_buildings(id) ON DELETE CASCADE,
+    energy_cost      INT  NOT NULL DEFAULT 0,
+    footprint_rule   TEXT NOT NULL DEFAULT 'anchor',
+    PRIMARY KEY (from_template_id, to_template_id),
+    CHECK (from_template_id <> to_template_id),
+    CHECK (footprint_rule IN ('anchor', 'center'))
+);
+
+CREATE TABLE IF NOT EXISTS system_scene_building_upgrade_events (
+    id               BIGSERIAL PRIMARY KEY,
+    building_id      TEXT NOT NULL REFERENCES system_scene_buildings(id) ON DELETE CASCADE,
+    from_template_id TEXT,
+    to_template_id   TEXT NOT NULL,
+    energy_cost      INT  NOT NULL DEFAULT 0,
+    rect_before      INT[] NOT NULL,
+    rect_after       INT[] NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_building_upgrade_events_building_time
+    ON system_scene_building_upgrade_events (building_id, created_at DESC);
+
+UPDATE system_template_buildings
+   SET size_width = 4,
+       size_height = 4
+ WHERE id = 'solar_tower';
+
+INSERT INTO system_template_buildings (id, label, tier, size_width, size_height, energy_type, energy_output)
+VALUES ('solar_tower_mk2', '太阳能塔 Mk2', 2, 5, 5, 'storage', 400)
+ON CONFLICT (id) DO UPDATE
+    SET label = EXCLUDED.label,
+        tier = EXCLUDED.tier,
+        size_width = EXCLUDED.size_width,
+        size_height = EXCLUDED.size_height,
+        energy_type = EXCLUDED.energy_type,
+        energy_output = EXCLUDED.energy_output;
+
+INSERT INTO system_template_building_upgrades (from_template_id, to_template_id, energy_cost, footprint_rule)
+VALUES ('solar_tower', 'solar_tower_mk2', 120, 'anchor')
+ON CONFLICT (from_template_id, to_template_id) DO UPDATE
+    SET energy_cost = EXCLUDED.energy_cost,
+        footprint_rule = EXCLUDED.footprint_rule;
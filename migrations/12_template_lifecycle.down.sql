@@ -0,0 +1,5 @@
+ALTER TABLE system_template_agents
+    DROP COLUMN IF EXISTS deprecated;
+
+ALTER TABLE system_template_buildings
+    DROP COLUMN IF EXISTS deprecated;
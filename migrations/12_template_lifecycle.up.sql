@@ -0,0 +1,5 @@
+ALTER TABLE system_template_buildings
+    ADD COLUMN IF NOT EXISTS deprecated BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE system_template_agents
+    ADD COLUMN IF NOT EXISTS deprecated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS agent_goals (
+    id                BIGSERIAL PRIMARY KEY,
+    agent_id          TEXT NOT NULL REFERENCES system_scene_agents(id) ON DELETE CASCADE,
+    kind              TEXT NOT NULL CHECK (kind IN ('net-flow', 'storage-level', 'patrol')),
+    params            JSONB NOT NULL DEFAULT '{}'::jsonb,
+    status            TEXT NOT NULL DEFAULT 'pending',
+    cursor            INT NOT NULL DEFAULT 0,
+    failures          INT NOT NULL DEFAULT 0,
+    last_message      TEXT,
+    last_evaluated_at TIMESTAMPTZ,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_goals_agent
+    ON agent_goals (agent_id, id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS scene_jobs;
+
+ALTER TABLE system_template_agents
+    DROP COLUMN IF EXISTS skills;
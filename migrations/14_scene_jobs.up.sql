@@ -0,0 +1,31 @@
+ALTER TABLE system_template_agents
+    ADD COLUMN IF NOT EXISTS skills TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[];
+
+UPDATE system_template_agents SET skills = ARRAY['build', 'repair', 'explore'] WHERE id = 'ares';
+UPDATE system_template_agents SET skills = ARRAY['haul', 'repair'] WHERE id = 'support';
+
+CREATE TABLE IF NOT EXISTS scene_jobs (
+    id                BIGSERIAL PRIMARY KEY,
+    scene_id          TEXT NOT NULL REFERENCES system_scenes(id) ON DELETE CASCADE,
+    kind              TEXT NOT NULL CHECK (kind IN ('build', 'repair', 'haul', 'explore')),
+    status            TEXT NOT NULL DEFAULT 'queued'
+                      CHECK (status IN ('queued', 'assigned', 'in-progress', 'done', 'failed')),
+    priority          INT NOT NULL DEFAULT 0,
+    target_x          INT NOT NULL,
+    target_y          INT NOT NULL,
+    building_id       TEXT,
+    template_id       TEXT,
+    assigned_agent_id TEXT REFERENCES system_scene_agents(id) ON DELETE SET NULL,
+    progress          DOUBLE PRECISION NOT NULL DEFAULT 0,
+    source            TEXT NOT NULL DEFAULT 'api',
+    message           TEXT,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scene_jobs_queue
+    ON scene_jobs (scene_id, status, priority DESC, id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scene_jobs_active_agent
+    ON scene_jobs (assigned_agent_id)
+    WHERE status IN ('assigned', 'in-progress');
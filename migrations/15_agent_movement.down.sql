@@ -0,0 +1,5 @@
+ALTER TABLE agent_runtime_state
+    DROP COLUMN IF EXISTS path;
+
+ALTER TABLE system_template_agents
+    DROP COLUMN IF EXISTS speed;
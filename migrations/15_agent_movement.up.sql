@@ -0,0 +1,7 @@
+ALTER TABLE system_template_agents
+    ADD COLUMN IF NOT EXISTS speed DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (speed > 0);
+
+UPDATE system_template_agents SET speed = 2 WHERE id = 'ares';
+
+ALTER TABLE agent_runtime_state
+    ADD COLUMN IF NOT EXISTS path JSONB;
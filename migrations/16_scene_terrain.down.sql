@@ -0,0 +1,7 @@
+DELETE FROM system_scene_buildings WHERE template_id = 'ice_extractor';
+DELETE FROM system_template_buildings WHERE id = 'ice_extractor';
+
+ALTER TABLE system_template_buildings
+    DROP COLUMN IF EXISTS required_terrain;
+
+DROP TABLE IF EXISTS system_scene_terrain;
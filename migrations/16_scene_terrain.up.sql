@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS system_scene_terrain (
+    scene_id   TEXT PRIMARY KEY REFERENCES system_scenes(id) ON DELETE CASCADE,
+    width      INT NOT NULL CHECK (width >= 0),
+    height     INT NOT NULL CHECK (height >= 0),
+    runs       JSONB NOT NULL DEFAULT '[]'::JSONB,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE system_template_buildings
+    ADD COLUMN IF NOT EXISTS required_terrain TEXT
+    CHECK (required_terrain IN ('regolith', 'rock', 'ice', 'slope'));
+
+INSERT INTO system_template_buildings (id, label, tier, size_width, size_height, energy_type, energy_rate, required_terrain)
+VALUES ('ice_extractor', '冰层提取站', 1, 2, 2, 'consumer', 60, 'ice')
+ON CONFLICT (id) DO UPDATE
+    SET label = EXCLUDED.label,
+        tier = EXCLUDED.tier,
+        size_width = EXCLUDED.size_width,
+        size_height = EXCLUDED.size_height,
+        energy_type = EXCLUDED.energy_type,
+        energy_rate = EXCLUDED.energy_rate,
+        required_terrain = EXCLUDED.required_terrain;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS system_scene_deposits (
+    id         BIGSERIAL PRIMARY KEY,
+    scene_id   TEXT NOT NULL REFERENCES system_scenes(id) ON DELETE CASCADE,
+    kind       TEXT NOT NULL CHECK (kind IN ('ice', 'metal')),
+    position_x INT  NOT NULL,
+    position_y INT  NOT NULL,
+    amount     INT  NOT NULL DEFAULT 0 CHECK (amount >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_scene_deposits_scene
+    ON system_scene_deposits (scene_id);
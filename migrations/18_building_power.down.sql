@@ -0,0 +1,2 @@
+ALTER TABLE system_scene_buildings
+    DROP COLUMN IF EXISTS powered;
@@ -0,0 +1,2 @@
+ALTER TABLE system_scene_buildings
+    ADD COLUMN IF NOT EXISTS powered BOOLEAN NOT NULL DEFAULT TRUE;